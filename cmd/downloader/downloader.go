@@ -1,205 +1,321 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"os"
-	"sync"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
 )
 
-// Structure pour la progression du téléchargement
-type Progress struct {
-	Downloaded int64
-	Total      int64
-	Percent    float64
-	Speed      float64
-	Remaining  time.Duration
+const usage = `Usage: downloader [options] <url>...
+       downloader [options] -i <file>
+
+Downloads files over several connections with the downloader of qbrdt, to test
+Real-Debrid links outside of the daemon. An input file has one URL per line,
+optionally followed by the expected SHA-256 of the file. Options:
+`
+
+// job is a file to download
+type job struct {
+	url      string
+	filename string
+	sha256   string
 }
 
-// Fonction pour télécharger un chunk spécifique
-func downloadChunk(url, filePath string, start, end int64, maxSpeedKBps int, progressChan chan<- Progress, wg *sync.WaitGroup) error {
+// resumeState is saved next to an interrupted download to resume it on the next run
+type resumeState struct {
+	Url      string               `json:"url"`
+	Size     int64                `json:"size"`
+	Segments []downloader.Segment `json:"segments"`
+}
 
-	defer wg.Done()
-	// Créer une requête HTTP GET avec un en-tête Range pour télécharger une portion du fichier
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+type options struct {
+	output      string
+	connections int
+	limit       int
+	resume      bool
+	retries     int
+	json        bool
+}
+
+func main() {
+	var opts options
+	var input, checksum string
+	var verbose bool
+
+	flag.StringVar(&opts.output, "o", "", "file to save a single URL to, or folder of the files (current folder by default)")
+	flag.IntVar(&opts.connections, "c", 4, "connections per file")
+	flag.IntVar(&opts.connections, "connections", 4, "connections per file")
+	flag.IntVar(&opts.limit, "limit", 0, "speed limit of a file in KB/s, 0 for unlimited")
+	flag.BoolVar(&opts.resume, "resume", true, "resume the interrupted downloads")
+	flag.IntVar(&opts.retries, "retries", 3, "attempts after a failure")
+	flag.StringVar(&checksum, "sha256", "", "expected SHA-256 of the file, with a single URL")
+	flag.BoolVar(&opts.json, "json", false, "print the progress as JSON lines")
+	flag.StringVar(&input, "i", "", "file with the URLs to download, - for stdin")
+	flag.BoolVar(&verbose, "v", false, "log the details of the downloads")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
 	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	flag.Parse()
 
-	resp, err := http.DefaultClient.Do(req)
+	jobs, err := readJobs(flag.Args(), input)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "downloader: %s\n", err)
+		os.Exit(2)
+	}
+	if len(jobs) == 0 || (checksum != "" && len(jobs) > 1) {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if checksum != "" {
+		jobs[0].sha256 = checksum
 	}
-	defer resp.Body.Close()
 
-	// Créer le fichier chunk
-	chunkFile, err := os.Create(filePath)
-	if err != nil {
-		return err
+	if err := placeJobs(jobs, opts.output); err != nil {
+		fmt.Fprintf(os.Stderr, "downloader: %s\n", err)
+		os.Exit(2)
 	}
-	defer chunkFile.Close()
-
-	// Variables pour le suivi du téléchargement
-	var downloadedSize int64
-	startTime := time.Now()
-	maxSpeed := int64(maxSpeedKBps * 1024)
-	buffer := make([]byte, 32*1024) // 32 KB
-
-	for {
-		// Lire un morceau de données
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			// Écrire les données dans le fichier chunk
-			chunkFile.Write(buffer[:n])
-
-			// Mettre à jour la taille téléchargée
-			downloadedSize += int64(n)
-
-			// Calculer la vitesse de téléchargement et le temps restant
-			elapsedTime := time.Since(startTime).Seconds()
-			speed := float64(downloadedSize) / elapsedTime
-			remaining := time.Duration(float64(end-start-downloadedSize)/speed) * time.Second
-
-			// Envoyer la progression sur le canal
-			progressChan <- Progress{
-				Downloaded: downloadedSize,
-				Total:      end - start + 1,
-				Percent:    float64(downloadedSize) / float64(end-start+1) * 100,
-				Speed:      speed,
-				Remaining:  remaining,
-			}
-
-			// Limiter la vitesse si nécessaire, sauf si maxSpeedKBps est à 0
-			if maxSpeedKBps > 0 && speed > float64(maxSpeed) {
-				sleepDuration := time.Duration(float64(n)/float64(maxSpeed)*1000) * time.Millisecond
-				time.Sleep(sleepDuration)
-			}
-		}
 
-		// Si la lecture est terminée, quitter la boucle
-		if err == io.EOF {
-			break
-		}
+	var log logger.Interface = discard{}
+	if verbose {
+		log = logger.New("debug")
+	}
+
+	connections := max(opts.connections, 1)
+	limit := 0
+	if opts.limit > 0 {
+		// the limit of the downloader applies to each connection
+		limit = max(opts.limit/connections, 1)
+	}
+
+	d := downloader.NewDownloader(downloader.Config{
+		MaxChunks:    connections,
+		MinChunkSize: 1024 * 1024,
+		SpeedLimit:   limit,
+	}, log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		d.Stop()
+	}()
+
+	var reporter reporter = newBar()
+	if opts.json {
+		reporter = jsonReporter{encoder: json.NewEncoder(os.Stdout)}
+	}
 
-		// Gérer les autres erreurs possibles
+	failed := 0
+	for _, job := range jobs {
+		err := download(ctx, d, job, opts, reporter)
+		if errors.Is(err, downloader.ErrStopped) {
+			os.Exit(130)
+		}
 		if err != nil {
-			return err
+			failed++
 		}
 	}
 
-	return nil
+	if failed > 0 {
+		os.Exit(1)
+	}
 }
 
-// Fonction pour télécharger le fichier en plusieurs chunks
-func downloadFile(url, filePath string, numChunks int, maxSpeedKBps int, progressChan chan<- Progress) error {
-	// Faire une requête HEAD pour obtenir la taille du fichier
-	resp, err := http.Head(url)
-	if err != nil {
-		return err
+// readJobs returns the URLs of the arguments and of the input file
+func readJobs(args []string, input string) ([]*job, error) {
+	var jobs []*job
+	for _, arg := range args {
+		jobs = append(jobs, &job{url: arg})
 	}
 
-	// Vérifier si la requête a réussi
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("échec de la requête : %s", resp.Status)
+	if input == "" {
+		return jobs, nil
 	}
 
-	// Obtenir la taille totale du fichier
-	totalSize := resp.ContentLength
-	chunkSize := totalSize / int64(numChunks)
-
-	wg := sync.WaitGroup{}
-
-	for i := 0; i < numChunks; i++ {
-		// Calculer la plage de bytes pour ce chunk
-		start := int64(i) * chunkSize
-		end := start + chunkSize - 1
-		if i == numChunks-1 {
-			end = totalSize - 1 // Le dernier chunk peut être plus grand
+	var reader io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			return nil, err
 		}
+		defer file.Close()
+		reader = file
+	}
 
-		// Télécharger ce chunk
-		chunkFileName := fmt.Sprintf("%s.part%d", filePath, i)
-		wg.Add(1)
-		go func() {
-			err := downloadChunk(url, chunkFileName, start, end, maxSpeedKBps, progressChan, &wg)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		job := &job{url: fields[0]}
+		if len(fields) > 1 {
+			job.sha256 = fields[1]
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
 
-			if err != nil {
-				fmt.Printf("Erreur lors du téléchargement du chunk %d : %v\n", i, err)
-			}
-		}()
+// placeJobs sets the file of each job, output is the file of a single URL unless it is a folder
+func placeJobs(jobs []*job, output string) error {
+	for _, job := range jobs {
+		u, err := url.Parse(job.url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid URL %s", job.url)
+		}
 
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = "download"
+		}
+		job.filename = name
 	}
-	wg.Wait()
-	// Fusionner les fichiers chunks en un seul fichier final
-	if err := mergeChunks(filePath, numChunks); err != nil {
-		return err
+
+	if output == "" {
+		return nil
 	}
 
+	stat, err := os.Stat(output)
+	folder := err == nil && stat.IsDir() || strings.HasSuffix(output, string(os.PathSeparator)) || len(jobs) > 1
+	for _, job := range jobs {
+		if folder {
+			job.filename = filepath.Join(output, job.filename)
+		} else {
+			job.filename = output
+		}
+	}
 	return nil
 }
 
-// Fonction pour fusionner les chunks en un seul fichier
-func mergeChunks(filePath string, numChunks int) error {
-	out, err := os.Create(filePath)
-	if err != nil {
-		return err
+// download downloads a job, retrying and resuming it, and checks its SHA-256
+func download(ctx context.Context, d *downloader.Downloader, job *job, opts options, reporter reporter) error {
+	resumeFile := job.filename + ".resume"
+
+	dl := &downloader.Download{
+		Url:      job.url,
+		FileName: filepath.Base(job.filename),
+		SavePath: filepath.Dir(job.filename),
 	}
-	defer out.Close()
 
-	for i := 0; i < numChunks; i++ {
-		chunkFileName := fmt.Sprintf("%s.part%d", filePath, i)
-		chunkFile, err := os.Open(chunkFileName)
-		if err != nil {
-			return err
+	if opts.resume {
+		if state := loadResume(resumeFile, job); state != nil {
+			dl.FileSize = state.Size
+			dl.Segments = state.Segments
 		}
+	}
 
-		_, err = io.Copy(out, chunkFile)
-		if err != nil {
-			chunkFile.Close()
-			return err
+	started := time.Now()
+	reporter.started(job, dl)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		dl.Downloaded = 0
+		done, reported := make(chan struct{}), make(chan struct{})
+		go func() {
+			reporter.progress(job, dl, done)
+			close(reported)
+		}()
+		d.AddDownload(dl)
+		close(done)
+		<-reported
+
+		err = dl.Err
+		if len(dl.Segments) > 0 {
+			saveResume(resumeFile, job, dl)
+		} else {
+			os.Remove(resumeFile)
 		}
-		chunkFile.Close()
 
-		// Supprimer le fichier chunk après la fusion
-		os.Remove(chunkFileName)
-	}
+		if err == nil || errors.Is(err, downloader.ErrStopped) || attempt >= opts.retries {
+			break
+		}
 
-	return nil
-}
+		reporter.retrying(job, attempt+1, err)
+		select {
+		case <-time.After(time.Duration(attempt+1) * 2 * time.Second):
+		case <-ctx.Done():
+			err = downloader.ErrStopped
+		}
+		if errors.Is(err, downloader.ErrStopped) {
+			break
+		}
+	}
 
-func main() {
-	// URL du fichier à télécharger
-	url := ""
+	if err == nil && job.sha256 != "" {
+		err = checkSha256(job.filename, job.sha256)
+	}
 
-	// Chemin où enregistrer le fichier téléchargé
-	filePath := os.Getenv("URL")
+	reporter.finished(job, dl, time.Since(started), err)
+	return err
+}
 
-	// Nombre de chunks
-	numChunks := 10
+// loadResume returns the state saved by an interrupted download of job, nil if there is none
+func loadResume(resumeFile string, job *job) *resumeState {
+	data, err := os.ReadFile(resumeFile)
+	if err != nil {
+		return nil
+	}
 
-	// Limite de la bande passante en KB/s (par exemple, 500 KB/s, 0 pour illimité)
-	maxSpeedKBps := 0
+	var state resumeState
+	if json.Unmarshal(data, &state) != nil || state.Url != job.url {
+		// left by another download to the same file, its segments would corrupt this one
+		os.Remove(resumeFile)
+		return nil
+	}
+	return &state
+}
 
-	// Créer un canal pour la progression
-	progressChan := make(chan Progress)
+func saveResume(resumeFile string, job *job, dl *downloader.Download) {
+	data, err := json.Marshal(resumeState{Url: job.url, Size: dl.FileSize, Segments: dl.Segments})
+	if err == nil {
+		err = os.WriteFile(resumeFile, data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "downloader: cannot save %s: %s\n", resumeFile, err)
+	}
+}
 
-	// Lancer le téléchargement dans une goroutine
-	go func() {
-		err := downloadFile(url, filePath, numChunks, maxSpeedKBps, progressChan)
-		if err != nil {
-			fmt.Printf("Erreur lors du téléchargement : %v\n", err)
-			close(progressChan)
-		}
-	}()
+func checkSha256(filename, expected string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	// Afficher la progression au fur et à mesure sur la même ligne
-	for progress := range progressChan {
-		fmt.Printf("\rTéléchargé %d/%d octets (%.2f%%) à %.2f KB/s, temps restant estimé : %v",
-			progress.Downloaded, progress.Total, progress.Percent, progress.Speed/1024, progress.Remaining)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
 	}
 
-	fmt.Println("\nTéléchargement terminé avec succès !")
+	if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, expected) {
+		return fmt.Errorf("SHA-256 is %s, expected %s", sum, expected)
+	}
+	return nil
 }
+
+// discard drops the logs of the downloader, the reporter shows what matters
+type discard struct{}
+
+func (discard) Debug(format interface{}, args ...interface{}) {}
+func (discard) Info(format string, args ...interface{})       {}
+func (discard) Warn(format string, args ...interface{})       {}
+func (discard) Error(format interface{}, args ...interface{}) {}
+func (discard) Fatal(format interface{}, args ...interface{}) { os.Exit(1) }
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+)
+
+// jobsOf returns the url and sha256 of each job to compare them
+func jobsOf(jobs []*job) [][2]string {
+	var got [][2]string
+	for _, job := range jobs {
+		got = append(got, [2]string{job.url, job.sha256})
+	}
+	return got
+}
+
+func TestReadJobs(t *testing.T) {
+	const list = `# links of the week
+http://host/a.mkv 5d41402abc4b2a76b9719d911017c592
+
+  http://host/b.mkv
+	# indented comment
+http://host/c.mkv	e59ff97941044f85df5297e1c302d260  trailing words
+`
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "links.txt")
+	if err := os.WriteFile(input, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromList := [][2]string{
+		{"http://host/a.mkv", "5d41402abc4b2a76b9719d911017c592"},
+		{"http://host/b.mkv", ""},
+		{"http://host/c.mkv", "e59ff97941044f85df5297e1c302d260"},
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		input string
+		want  [][2]string
+	}{
+		{"arguments", []string{"http://host/x", "http://host/y"}, "", [][2]string{{"http://host/x", ""}, {"http://host/y", ""}}},
+		{"input file", nil, input, fromList},
+		{"arguments then input file", []string{"http://host/x"}, input, append([][2]string{{"http://host/x", ""}}, fromList...)},
+		{"stdin", nil, "-", fromList},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.input == "-" {
+				stdin, err := os.Open(input)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer stdin.Close()
+
+				saved := os.Stdin
+				os.Stdin = stdin
+				defer func() { os.Stdin = saved }()
+			}
+
+			jobs, err := readJobs(tt.args, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := jobsOf(jobs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readJobs(nil, filepath.Join(dir, "missing.txt")); err == nil {
+		t.Error("expected a missing input file to be refused")
+	}
+}
+
+func TestPlaceJobs(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		urls   []string
+		output string
+		want   []string
+	}{
+		{"current folder", []string{"http://host/files/a.mkv?token=1"}, "", []string{"a.mkv"}},
+		{"URL without file name", []string{"https://host/"}, "", []string{"download"}},
+		{"single file", []string{"http://host/a.mkv"}, filepath.Join(dir, "movie.mkv"), []string{filepath.Join(dir, "movie.mkv")}},
+		{"existing folder", []string{"http://host/a.mkv"}, dir, []string{filepath.Join(dir, "a.mkv")}},
+		{"new folder", []string{"http://host/a.mkv"}, filepath.Join(dir, "new") + string(os.PathSeparator), []string{filepath.Join(dir, "new", "a.mkv")}},
+		{"several files", []string{"http://host/a.mkv", "http://host/b.mkv"}, filepath.Join(dir, "all"),
+			[]string{filepath.Join(dir, "all", "a.mkv"), filepath.Join(dir, "all", "b.mkv")}},
+		{"not a URL", []string{"host/a.mkv"}, "", nil},
+		{"other scheme", []string{"ftp://host/a.mkv"}, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jobs []*job
+			for _, u := range tt.urls {
+				jobs = append(jobs, &job{url: u})
+			}
+
+			err := placeJobs(jobs, tt.output)
+			if tt.want == nil {
+				if err == nil {
+					t.Error("expected the URL to be refused")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, job := range jobs {
+				got = append(got, job.filename)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadResume(t *testing.T) {
+	const url = "http://host/a.mkv"
+	segments := []downloader.Segment{{Index: 0, Start: 0, End: 99, Written: 50}}
+
+	tests := []struct {
+		name  string
+		saved string
+		// the state is used, otherwise the file is removed
+		want bool
+	}{
+		{"same URL", `{"url":"http://host/a.mkv","size":100,"segments":[{"index":0,"start":0,"end":99,"written":50}]}`, true},
+		{"other URL", `{"url":"http://host/b.mkv","size":100,"segments":[{"index":0,"start":0,"end":99,"written":50}]}`, false},
+		{"corrupt", `{"url":`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumeFile := filepath.Join(t.TempDir(), "a.mkv.resume")
+			if err := os.WriteFile(resumeFile, []byte(tt.saved), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			state := loadResume(resumeFile, &job{url: url})
+			if tt.want {
+				if state == nil || state.Size != 100 || !reflect.DeepEqual(state.Segments, segments) {
+					t.Errorf("expected the saved state, got %+v", state)
+				}
+				return
+			}
+
+			if state != nil {
+				t.Errorf("expected no state, got %+v", state)
+			}
+			if _, err := os.Stat(resumeFile); !os.IsNotExist(err) {
+				t.Errorf("expected the resume file to be removed, got %v", err)
+			}
+		})
+	}
+
+	if state := loadResume(filepath.Join(t.TempDir(), "missing.resume"), &job{url: url}); state != nil {
+		t.Errorf("expected no state without resume file, got %+v", state)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/bytesize"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+)
+
+// reporter shows the progress of the downloads
+type reporter interface {
+	started(job *job, dl *downloader.Download)
+	// progress reports dl until done is closed
+	progress(job *job, dl *downloader.Download, done <-chan struct{})
+	retrying(job *job, attempt int, err error)
+	finished(job *job, dl *downloader.Download, elapsed time.Duration, err error)
+}
+
+// bar draws a progress bar on stderr, redrawn in place on a terminal
+type bar struct {
+	terminal bool
+}
+
+func newBar() *bar {
+	stat, _ := os.Stderr.Stat()
+	return &bar{terminal: stat != nil && stat.Mode()&os.ModeCharDevice != 0}
+}
+
+func (b *bar) started(job *job, dl *downloader.Download) {
+	if len(dl.Segments) > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming %s\n", job.filename)
+	}
+}
+
+func (b *bar) progress(job *job, dl *downloader.Download, done <-chan struct{}) {
+	// without a terminal, a line every 10 seconds
+	interval := 10 * time.Second
+	if b.terminal {
+		interval = 200 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			if b.terminal {
+				fmt.Fprint(os.Stderr, "\r\033[K")
+			}
+			return
+		case <-ticker.C:
+			line := b.line(job, dl.Status())
+			if b.terminal {
+				fmt.Fprint(os.Stderr, "\r\033[K"+line)
+			} else {
+				fmt.Fprintln(os.Stderr, line)
+			}
+		}
+	}
+}
+
+func (b *bar) line(job *job, status downloader.Progress) string {
+	const width = 30
+
+	name := []rune(job.filename)
+	if len(name) > 30 {
+		name = append([]rune("…"), name[len(name)-29:]...)
+	}
+
+	filled := int(status.Percent * width)
+	drawn := strings.Repeat("=", filled)
+	if filled < width {
+		drawn += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	eta := ""
+	if status.Remaining > 0 {
+		eta = "  ETA " + status.Remaining.String()
+	}
+
+	return fmt.Sprintf("%s [%s] %5.1f%%  %s/%s  %s/s%s",
+		string(name), drawn, status.Percent*100, bytesize.Format(status.Downloaded), bytesize.Format(status.Total), bytesize.Format(int64(status.Speed)), eta)
+}
+
+func (b *bar) retrying(job *job, attempt int, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %s, retrying (%d)\n", job.filename, err, attempt)
+}
+
+func (b *bar) finished(job *job, dl *downloader.Download, elapsed time.Duration, err error) {
+	switch {
+	case errors.Is(err, downloader.ErrStopped) && len(dl.Segments) > 0:
+		fmt.Fprintf(os.Stderr, "%s: interrupted, run again to resume\n", job.filename)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", job.filename, err)
+	default:
+		elapsed = elapsed.Round(100 * time.Millisecond)
+		fmt.Fprintf(os.Stderr, "%s: %s in %s (%s/s)\n",
+			job.filename, bytesize.Format(dl.FileSize), elapsed, bytesize.Format(int64(float64(dl.FileSize)/max(elapsed.Seconds(), 0.1))))
+	}
+}
+
+// jsonReporter prints an event per line on stdout, and the progress every second
+type jsonReporter struct {
+	encoder *json.Encoder
+}
+
+type jsonEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	Url        string    `json:"url"`
+	File       string    `json:"file"`
+	Size       int64     `json:"size,omitempty"`
+	Downloaded int64     `json:"downloaded,omitempty"`
+	Progress   float64   `json:"progress,omitempty"`
+	// bytes/s
+	Speed int64 `json:"speed,omitempty"`
+	// seconds
+	Remaining float64 `json:"remaining,omitempty"`
+	Elapsed   float64 `json:"elapsed,omitempty"`
+	Attempt   int     `json:"attempt,omitempty"`
+	Resumed   bool    `json:"resumed,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func (r jsonReporter) print(event jsonEvent) {
+	event.Time = time.Now()
+	r.encoder.Encode(event)
+}
+
+func (r jsonReporter) started(job *job, dl *downloader.Download) {
+	r.print(jsonEvent{Event: "started", Url: job.url, File: job.filename, Resumed: len(dl.Segments) > 0})
+}
+
+func (r jsonReporter) progress(job *job, dl *downloader.Download, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			status := dl.Status()
+			r.print(jsonEvent{
+				Event:      "progress",
+				Url:        job.url,
+				File:       job.filename,
+				Size:       status.Total,
+				Downloaded: status.Downloaded,
+				Progress:   status.Percent,
+				Speed:      int64(status.Speed),
+				Remaining:  status.Remaining.Seconds(),
+			})
+		}
+	}
+}
+
+func (r jsonReporter) retrying(job *job, attempt int, err error) {
+	r.print(jsonEvent{Event: "retrying", Url: job.url, File: job.filename, Attempt: attempt, Error: err.Error()})
+}
+
+func (r jsonReporter) finished(job *job, dl *downloader.Download, elapsed time.Duration, err error) {
+	event := jsonEvent{Event: "finished", Url: job.url, File: job.filename, Size: dl.FileSize, Elapsed: elapsed.Seconds()}
+	switch {
+	case errors.Is(err, downloader.ErrStopped):
+		event.Event = "interrupted"
+	case err != nil:
+		event.Event = "failed"
+		event.Error = err.Error()
+	default:
+		event.Downloaded = dl.FileSize
+		event.Progress = 1
+	}
+	r.print(event)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/bytesize"
+	"github.com/TOomaAh/qbrdt/pkg/qbrdtclient"
+)
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+func add(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("add")
+	category := flags.String("category", "", "category of the torrents")
+	name := flags.String("name", "", "name of the torrent of the hoster links, the first file if empty")
+	paused := flags.Bool("paused", false, "add the torrents stopped")
+	args = parse(flags, args)
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	request := qbrdtclient.AddRequest{Category: *category, Name: *name, Paused: *paused}
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+		if strings.HasPrefix(lower, "magnet:") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+			request.Urls = append(request.Urls, arg)
+			continue
+		}
+
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return err
+		}
+		request.Torrents = append(request.Torrents, qbrdtclient.TorrentFile{Name: filepath.Base(arg), Data: data})
+	}
+
+	result, err := client.Add(request)
+	if result == nil {
+		return err
+	}
+
+	for _, hash := range result.Added {
+		fmt.Println(hash)
+	}
+	for _, message := range result.Errors {
+		fmt.Fprintf(os.Stderr, "qbrdtctl: %s\n", message)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d of the torrents were not added", len(result.Errors))
+	}
+	return nil
+}
+
+func list(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("list")
+	category := flags.String("category", "", "only the torrents of this category")
+	state := flags.String("state", "", "only the torrents in this qBittorrent state, like downloading or pausedUP")
+	failed := flags.Bool("failed", false, "only the failed torrents")
+	search := flags.String("search", "", "only the torrents with this text in their name")
+	asJSON := flags.Bool("json", false, "print the torrents as JSON")
+	if len(parse(flags, args)) > 0 {
+		return errUsage
+	}
+
+	torrents, err := client.Torrents(*category)
+	if err != nil {
+		return err
+	}
+
+	filtered := make([]qbrdtclient.Torrent, 0, len(torrents))
+	for _, torrent := range torrents {
+		if *state != "" && !strings.EqualFold(torrent.State, *state) {
+			continue
+		}
+		if *failed && torrent.Error == "" {
+			continue
+		}
+		if *search != "" && !strings.Contains(strings.ToLower(torrent.Name), strings.ToLower(*search)) {
+			continue
+		}
+		filtered = append(filtered, torrent)
+	}
+
+	if *asJSON {
+		return printJSON(filtered)
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "HASH\tNAME\tCATEGORY\tSTATE\tCLOUD\tLOCAL\tSPEED\tSIZE")
+	for _, torrent := range filtered {
+		hash, state := torrent.Hash, torrent.State
+		if len(hash) > 8 {
+			hash = hash[:8]
+		}
+		if torrent.Paused {
+			state += " (paused)"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			hash, shorten(torrent.Name, 50), torrent.Category, state,
+			percent(torrent.CloudProgress), percent(torrent.LocalProgress), speed(torrent.Speed), bytesize.Format(torrent.Size))
+	}
+	return table.Flush()
+}
+
+func info(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("info")
+	asJSON := flags.Bool("json", false, "print the torrent as JSON")
+	args = parse(flags, args)
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	hashes, err := resolve(client, args)
+	if err != nil {
+		return err
+	}
+	torrent, err := client.Torrent(hashes[0])
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(torrent)
+	}
+
+	table := newTable()
+	fmt.Fprintf(table, "Name:\t%s\n", torrent.Name)
+	fmt.Fprintf(table, "Hash:\t%s\n", torrent.Hash)
+	fmt.Fprintf(table, "Category:\t%s\n", torrent.Category)
+	fmt.Fprintf(table, "State:\t%s (Real-Debrid: %s, local: %s)\n", torrent.State, torrent.RDStatus, torrent.InternalStatus)
+	fmt.Fprintf(table, "Paused:\t%t\n", torrent.Paused)
+	fmt.Fprintf(table, "Added:\t%s by %s, %s\n", torrent.AddedOn.Local().Format(time.DateTime), torrent.AddedBy, torrent.Type)
+	fmt.Fprintf(table, "Size:\t%s\n", bytesize.Format(torrent.Size))
+	fmt.Fprintf(table, "Real-Debrid:\t%s %s, %d seeders\n", percent(torrent.CloudProgress), speed(torrent.CloudSpeed), torrent.Seeders)
+	fmt.Fprintf(table, "Local:\t%s %s\n", percent(torrent.LocalProgress), speed(torrent.Speed))
+	if torrent.Priority > 0 {
+		fmt.Fprintf(table, "Queue:\t#%d\n", torrent.Priority)
+	}
+	if torrent.Error != "" {
+		fmt.Fprintf(table, "Error:\t%s\n", torrent.Error)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(torrent.Files) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	table = newTable()
+	fmt.Fprintln(table, "FILE\tSIZE\tPROGRESS\tSPEED\tRETRIES\tERROR")
+	for _, file := range torrent.Files {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%s\n",
+			file.Name, bytesize.Format(file.Size), percent(file.Progress), speed(file.Speed), file.Repairs, file.Error)
+	}
+	return table.Flush()
+}
+
+// eachTorrent returns a command calling action with each torrent of its arguments
+func eachTorrent(name string, action func(client *qbrdtclient.Client, hash string) error) func(*qbrdtclient.Client, []string) error {
+	return func(client *qbrdtclient.Client, args []string) error {
+		args = parse(newFlags(name), args)
+		if len(args) == 0 {
+			return errUsage
+		}
+
+		hashes, err := resolve(client, args)
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range hashes {
+			if err := action(client, hash); err != nil {
+				return fmt.Errorf("%s: %w", hash, err)
+			}
+		}
+		return nil
+	}
+}
+
+func remove(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("delete")
+	files := flags.Bool("files", false, "delete the files of the torrents too")
+	args = parse(flags, args)
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	return eachTorrent("delete", func(client *qbrdtclient.Client, hash string) error {
+		return client.Delete(hash, *files)
+	})(client, args)
+}
+
+func categories(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("categories")
+	asJSON := flags.Bool("json", false, "print the categories as JSON")
+	if len(parse(flags, args)) > 0 {
+		return errUsage
+	}
+
+	categories, err := client.Categories()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(categories)
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "NAME\tPRIORITY\tSAVE PATH")
+	for _, category := range categories {
+		fmt.Fprintf(table, "%s\t%d\t%s\n", category.Name, category.Priority, category.SavePath)
+	}
+	return table.Flush()
+}
+
+func account(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("account")
+	asJSON := flags.Bool("json", false, "print the account as JSON")
+	if len(parse(flags, args)) > 0 {
+		return errUsage
+	}
+
+	account, err := client.Account()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(account)
+	}
+
+	table := newTable()
+	fmt.Fprintf(table, "Username:\t%s\n", account.Username)
+	fmt.Fprintf(table, "Type:\t%s\n", account.Type)
+	if account.Premium {
+		expiring := ""
+		if account.Expiring {
+			expiring = ", expiring soon"
+		}
+		fmt.Fprintf(table, "Premium:\tuntil %s (%d days left%s)\n",
+			account.Expiration.Local().Format(time.DateOnly), account.PremiumLeft/86400, expiring)
+	} else {
+		fmt.Fprintf(table, "Premium:\tno\n")
+	}
+	fmt.Fprintf(table, "Points:\t%d\n", account.Points)
+	fmt.Fprintf(table, "Checked:\t%s\n", account.CheckedAt.Local().Format(time.DateTime))
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	if len(account.Traffic) == 0 {
+		return nil
+	}
+
+	hosts := make([]string, 0, len(account.Traffic))
+	for host := range account.Traffic {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	fmt.Println()
+	table = newTable()
+	fmt.Fprintln(table, "HOST\tLEFT\tRESET")
+	for _, host := range hosts {
+		traffic := account.Traffic[host]
+		left := bytesize.Format(traffic.Left)
+		if traffic.Type == "links" {
+			left = fmt.Sprintf("%d links", traffic.Left)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\n", host, left, traffic.Reset)
+	}
+	return table.Flush()
+}
+
+func watch(client *qbrdtclient.Client, args []string) error {
+	flags := newFlags("watch")
+	asJSON := flags.Bool("json", false, "print the events as JSON, one per line")
+	prefixes := parse(flags, args)
+	for i, prefix := range prefixes {
+		prefixes[i] = strings.ToLower(prefix)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// on a terminal, the progress of a file is updated in place
+	stat, _ := os.Stdout.Stat()
+	terminal := stat != nil && stat.Mode()&os.ModeCharDevice != 0
+	progressLine := ""
+
+	err := client.Events(ctx, func(event qbrdtclient.Event) {
+		if !watched(event.Hash, prefixes) {
+			return
+		}
+
+		if *asJSON {
+			data, _ := json.Marshal(event)
+			fmt.Println(string(data))
+			return
+		}
+
+		line := fmt.Sprintf("%s  %s  %s", event.Time.Local().Format(time.TimeOnly), shorten(event.Name, 50), describe(event))
+		if !terminal {
+			fmt.Println(line)
+			return
+		}
+
+		key := ""
+		if event.Type == qbrdtclient.DownloadProgress {
+			data, _ := event.Download()
+			key = event.Hash + "/" + data.File
+		}
+
+		switch {
+		case key != "" && key == progressLine:
+			fmt.Print("\r\033[K" + line)
+		case progressLine != "":
+			fmt.Print("\n" + line)
+		default:
+			fmt.Print(line)
+		}
+		if key == "" {
+			fmt.Println()
+		}
+		progressLine = key
+	})
+
+	if progressLine != "" {
+		fmt.Println()
+	}
+	return err
+}
+
+func watched(hash string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns what happened in event
+func describe(event qbrdtclient.Event) string {
+	switch event.Type {
+	case qbrdtclient.TorrentAdded, qbrdtclient.TorrentChanged:
+		data, err := event.Torrent()
+		if err != nil {
+			return event.Type
+		}
+		verb := "added"
+		if event.Type == qbrdtclient.TorrentChanged {
+			verb = "changed"
+		}
+		paused := ""
+		if data.Paused {
+			paused = ", paused"
+		}
+		return fmt.Sprintf("%s: Real-Debrid %s %s, local %s%s", verb, data.RDStatus, percent(data.CloudProgress), data.InternalStatus, paused)
+	case qbrdtclient.TorrentRemoved:
+		return "removed"
+	case qbrdtclient.AccountExpiring:
+		data, err := event.Account()
+		if err != nil {
+			return event.Type
+		}
+		return fmt.Sprintf("Real-Debrid premium ends in %d days, on %s", data.DaysLeft, data.Expiration.Local().Format(time.DateOnly))
+	}
+
+	data, err := event.Download()
+	if err != nil {
+		return event.Type
+	}
+	switch event.Type {
+	case qbrdtclient.DownloadStarted:
+		return fmt.Sprintf("%s: started, %s", data.File, bytesize.Format(data.Size))
+	case qbrdtclient.DownloadProgress:
+		return fmt.Sprintf("%s: %s of %s, %s", data.File, percent(data.Progress), bytesize.Format(data.Size), speed(data.Speed))
+	case qbrdtclient.DownloadFinished:
+		return fmt.Sprintf("%s: finished", data.File)
+	case qbrdtclient.DownloadFailed:
+		return fmt.Sprintf("%s: failed, %s", data.File, data.Error)
+	}
+	return event.Type
+}
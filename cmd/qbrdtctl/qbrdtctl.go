@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/TOomaAh/qbrdt/pkg/bytesize"
+	"github.com/TOomaAh/qbrdt/pkg/qbrdtclient"
+)
+
+const usage = `Usage: qbrdtctl [options] <command> [arguments]
+
+Commands:
+  add <magnet|file|url>...   add magnets, .torrent files or hoster links
+                             (-category, -name of the hoster links, -paused)
+  list                       list the torrents (-category, -state, -failed, -search, -json)
+  info <hash>                show a torrent and its files (-json)
+  pause <hash>...            stop torrents
+  resume <hash>...           start stopped torrents
+  retry <hash>...            start failed torrents again
+  delete <hash>...           delete torrents, from Real-Debrid too (-files to delete their files)
+  categories                 list the categories (-json)
+  account                    show the Real-Debrid account (-json)
+  watch [hash]...            follow the torrents and downloads live (-json)
+
+A hash can be shortened to any unique prefix. Options:
+`
+
+// errUsage is returned for a wrong command line, the usage is printed instead of the error
+var errUsage = errors.New("usage")
+
+var commands = map[string]func(client *qbrdtclient.Client, args []string) error{
+	"add":        add,
+	"list":       list,
+	"info":       info,
+	"pause":      eachTorrent("pause", (*qbrdtclient.Client).Pause),
+	"resume":     eachTorrent("resume", (*qbrdtclient.Client).Resume),
+	"retry":      eachTorrent("retry", (*qbrdtclient.Client).Retry),
+	"delete":     remove,
+	"categories": categories,
+	"account":    account,
+	"watch":      watch,
+}
+
+func main() {
+	port := os.Getenv("QB_PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	url := flag.String("url", env("QBRDT_URL", "http://localhost:"+port), "address of qbrdt, $QBRDT_URL")
+	username := flag.String("username", os.Getenv("QB_USERNAME"), "username of the qBittorrent API, $QB_USERNAME")
+	password := flag.String("password", os.Getenv("QB_PASSWORD"), "password of the qBittorrent API, $QB_PASSWORD")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := qbrdtclient.NewClient(*url, *username, *password)
+	if err := run(client, flag.Args()[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "qbrdtctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func env(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// parse parses the flags of a command wherever they are, and returns the other arguments
+func parse(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func newFlags(name string) *flag.FlagSet {
+	return flag.NewFlagSet("qbrdtctl "+name, flag.ExitOnError)
+}
+
+// resolve returns the full hashes of the torrents matching the prefixes, a prefix matching
+// several torrents is refused
+func resolve(client *qbrdtclient.Client, prefixes []string) ([]string, error) {
+	var torrents []qbrdtclient.Torrent
+	hashes := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = strings.ToLower(prefix)
+		if len(prefix) == 40 {
+			hashes = append(hashes, prefix)
+			continue
+		}
+
+		if torrents == nil {
+			var err error
+			if torrents, err = client.Torrents(""); err != nil {
+				return nil, err
+			}
+		}
+
+		var matches []string
+		for _, torrent := range torrents {
+			if strings.HasPrefix(torrent.Hash, prefix) {
+				matches = append(matches, torrent.Hash)
+			}
+		}
+
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no torrent with the hash %s", prefix)
+		case 1:
+			hashes = append(hashes, matches[0])
+		default:
+			return nil, fmt.Errorf("%s matches %d torrents", prefix, len(matches))
+		}
+	}
+	return hashes, nil
+}
+
+func speed(bytes int64) string {
+	if bytes == 0 {
+		return ""
+	}
+	return bytesize.Format(bytes) + "/s"
+}
+
+func percent(progress float64) string {
+	return fmt.Sprintf("%.1f%%", progress*100)
+}
+
+// shorten cuts s to n runes
+func shorten(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
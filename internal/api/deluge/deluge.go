@@ -0,0 +1,281 @@
+package deluge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/labstack/echo/v4"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	sessionCookie  = "_session_id"
+	sessionTimeout = 3600 * time.Second
+	// Version of Deluge the clients see
+	version = "2.1.1"
+)
+
+// Error codes of the Deluge web API
+const (
+	errorAuth          = 1
+	errorUnknownMethod = 2
+	errorGeneric       = 3
+)
+
+var errUnknownMethod = errors.New("Unknown method")
+
+// DelugeApi serves the JSON-RPC of the Deluge web UI on the same torrents as the qBittorrent API.
+// Labels are the categories of qbrdt.
+type DelugeApi struct {
+	auth        *qbittorrent.Authenticator
+	sessions    *cache.Cache
+	preferences *database.PreferencesRepository
+	categories  *database.CategoryRepository
+	torrents    *database.TorrentRepository
+	client      *realdebrid.Client
+	rdCache     *realdebrid.Cache
+	admission   *jobs.TorrentAdmission
+	queue       *queue.DownloadQueue
+	logger      logger.Interface
+}
+
+type rpcRequest struct {
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Id     json.RawMessage   `json:"id"`
+}
+
+type rpcError struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+type rpcResponse struct {
+	Id     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// NewDelugeApi serves the JSON-RPC on /json, logged in with the password of the qBittorrent API
+func NewDelugeApi(e *echo.Echo,
+	auth *qbittorrent.Authenticator,
+	l logger.Interface,
+	preferences *database.PreferencesRepository,
+	categories *database.CategoryRepository,
+	torrents *database.TorrentRepository,
+	client *realdebrid.Client,
+	rdCache *realdebrid.Cache,
+	admission *jobs.TorrentAdmission,
+	queue *queue.DownloadQueue,
+) *DelugeApi {
+	delugeApi := &DelugeApi{
+		auth:        auth,
+		sessions:    cache.New(sessionTimeout, 10*time.Minute),
+		preferences: preferences,
+		categories:  categories,
+		torrents:    torrents,
+		client:      client,
+		rdCache:     rdCache,
+		admission:   admission,
+		queue:       queue,
+		logger:      l,
+	}
+
+	e.POST("/json", delugeApi.rpc)
+
+	return delugeApi
+}
+
+func (d *DelugeApi) rpc(c echo.Context) error {
+	var request rpcRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.String(http.StatusBadRequest, "")
+	}
+
+	response := rpcResponse{Id: request.Id}
+
+	switch {
+	case request.Method == "auth.login":
+		response.Result = d.login(c, request.Params)
+	case !d.authenticated(c):
+		response.Error = &rpcError{Message: "Not authenticated", Code: errorAuth}
+	default:
+		result, err := d.call(c, request.Method, request.Params)
+		if errors.Is(err, errUnknownMethod) {
+			response.Error = &rpcError{Message: err.Error(), Code: errorUnknownMethod}
+		} else if err != nil {
+			d.logger.Debug("Deluge %s failed: %s", request.Method, err)
+			response.Error = &rpcError{Message: err.Error(), Code: errorGeneric}
+		} else {
+			response.Result = result
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (d *DelugeApi) login(c echo.Context, params []json.RawMessage) bool {
+	if d.auth.Banned(c) {
+		return false
+	}
+
+	var password string
+	if err := param(params, 0, &password); err != nil || !d.auth.AuthenticatePassword(c, password) {
+		return false
+	}
+
+	sid := make([]byte, 16)
+	if _, err := rand.Read(sid); err != nil {
+		return false
+	}
+
+	session := hex.EncodeToString(sid)
+	d.sessions.Set(session, true, cache.DefaultExpiration)
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/json",
+		HttpOnly: true,
+	})
+
+	return true
+}
+
+func (d *DelugeApi) authenticated(c echo.Context) bool {
+	cookie, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := d.sessions.Get(cookie.Value); !ok {
+		return false
+	}
+
+	// sliding expiration
+	d.sessions.Set(cookie.Value, true, cache.DefaultExpiration)
+	return true
+}
+
+func (d *DelugeApi) call(c echo.Context, method string, params []json.RawMessage) (interface{}, error) {
+	switch method {
+	case "auth.check_session":
+		return true, nil
+	case "auth.delete_session":
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			d.sessions.Delete(cookie.Value)
+		}
+		return true, nil
+
+	// qbrdt is its own daemon, the web UI is always connected to it
+	case "web.connected":
+		return true, nil
+	case "web.get_hosts":
+		return [][]interface{}{{"qbrdt", "127.0.0.1", 58846, "localclient"}}, nil
+	case "web.get_host_status":
+		return []interface{}{"qbrdt", "Connected", version}, nil
+	case "web.connect":
+		return []string{}, nil
+	case "daemon.info", "daemon.get_version", "core.get_libtorrent_version":
+		return version, nil
+
+	case "core.get_config":
+		return d.config(), nil
+	case "core.get_config_value":
+		var key string
+		if err := param(params, 0, &key); err != nil {
+			return nil, err
+		}
+		return d.config()[key], nil
+	case "core.get_enabled_plugins", "core.get_available_plugins":
+		return []string{"Label"}, nil
+	case "core.enable_plugin", "core.disable_plugin":
+		return true, nil
+	case "core.set_torrent_options", "label.set_options":
+		return nil, nil
+
+	case "core.add_torrent_magnet":
+		return d.addMagnet(params)
+	case "core.add_torrent_file":
+		return d.addFile(params)
+	case "core.get_torrent_status":
+		return d.torrentStatus(params)
+	case "core.get_torrents_status":
+		return d.torrentsStatus(params)
+	case "web.update_ui":
+		return d.updateUi(params)
+	case "core.remove_torrent":
+		return d.removeTorrent(params)
+	case "core.remove_torrents":
+		return d.removeTorrents(params)
+	case "core.pause_torrent", "core.pause_torrents":
+		return nil, d.pause(params, true)
+	case "core.resume_torrent", "core.resume_torrents":
+		return nil, d.pause(params, false)
+	case "core.queue_top":
+		return nil, d.reorder(params, d.queue.TopPriority)
+	case "core.queue_bottom":
+		return nil, d.reorder(params, d.queue.BottomPriority)
+	case "core.queue_up":
+		return nil, d.reorder(params, d.queue.IncreasePriority)
+	case "core.queue_down":
+		return nil, d.reorder(params, d.queue.DecreasePriority)
+
+	case "label.get_labels":
+		return d.categories.GetTorrentCategoriesDistinct(), nil
+	case "label.add":
+		return nil, d.addLabel(params)
+	case "label.remove":
+		return nil, d.removeLabel(params)
+	case "label.set_torrent":
+		return nil, d.setLabel(params)
+	case "label.get_config":
+		return map[string]interface{}{}, nil
+	}
+
+	return nil, errUnknownMethod
+}
+
+// param decodes the parameter at index, a missing one is an error
+func param(params []json.RawMessage, index int, value interface{}) error {
+	if index >= len(params) {
+		return errors.New("missing parameter")
+	}
+	return json.Unmarshal(params[index], value)
+}
+
+func (d *DelugeApi) config() map[string]interface{} {
+	savePath := d.preferences.GetSavePath()
+
+	return map[string]interface{}{
+		"download_location":        savePath,
+		"move_completed":           false,
+		"move_completed_path":      savePath,
+		"add_paused":               false,
+		"queue_new_to_top":         false,
+		"max_active_downloading":   -1,
+		"max_active_seeding":       -1,
+		"max_active_limit":         -1,
+		"stop_seed_at_ratio":       false,
+		"stop_seed_ratio":          0,
+		"remove_seed_at_ratio":     false,
+		"max_download_speed":       -1,
+		"max_upload_speed":         -1,
+		"pre_allocate_storage":     false,
+		"copy_torrent_file":        false,
+		"del_copy_torrent_file":    false,
+		"torrentfiles_location":    "",
+		"allow_remote":             true,
+		"dont_count_slow_torrents": false,
+	}
+}
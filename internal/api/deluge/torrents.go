@@ -0,0 +1,570 @@
+package deluge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+)
+
+var (
+	errTorrentNotFound = errors.New("Torrent not found")
+	errInvalidLabel    = errors.New("Invalid label name")
+)
+
+// addOptions are the options of core.add_torrent_* used by qbrdt
+type addOptions struct {
+	DownloadLocation string `json:"download_location"`
+	AddPaused        bool   `json:"add_paused"`
+}
+
+func (d *DelugeApi) addMagnet(params []json.RawMessage) (interface{}, error) {
+	var magnet string
+	if err := param(params, 0, &magnet); err != nil {
+		return nil, err
+	}
+
+	options := d.options(params, 1)
+	torrent, err := d.admission.AddMagnet(magnet, d.category(options), database.Deluge)
+	return d.added(torrent, options, err)
+}
+
+func (d *DelugeApi) addFile(params []json.RawMessage) (interface{}, error) {
+	var filedump string
+	if err := param(params, 1, &filedump); err != nil {
+		return nil, err
+	}
+
+	torrentFile, err := base64.StdEncoding.DecodeString(filedump)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", jobs.ErrInvalidTorrent, err)
+	}
+
+	options := d.options(params, 2)
+	torrent, err := d.admission.AddTorrent(torrentFile, d.category(options), database.Deluge)
+	return d.added(torrent, options, err)
+}
+
+func (d *DelugeApi) options(params []json.RawMessage, index int) addOptions {
+	var options addOptions
+	param(params, index, &options)
+	return options
+}
+
+// category is the folder of download_location under the save path, the label is usually set after the add
+func (d *DelugeApi) category(options addOptions) string {
+	if options.DownloadLocation == "" {
+		return ""
+	}
+
+	rel, err := filepath.Rel(d.preferences.GetSavePath(), options.DownloadLocation)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	category := filepath.ToSlash(rel)
+	if !d.categories.Exist(category) {
+		d.categories.Create(database.NewCategory(category))
+	}
+	return category
+}
+
+// added answers the hash of the added torrent, like Deluge
+func (d *DelugeApi) added(torrent *database.Torrent, options addOptions, err error) (interface{}, error) {
+	if errors.Is(err, jobs.ErrTorrentExists) {
+		return nil, fmt.Errorf("Torrent already in session: %s", err)
+	}
+
+	if err != nil {
+		d.logger.Error("Failed to add torrent %s", err)
+		return nil, err
+	}
+
+	if options.AddPaused {
+		if err := d.torrents.SetPaused([]uint{torrent.ID}, true); err != nil {
+			return nil, err
+		}
+	}
+
+	go d.admission.Run()
+
+	return torrent.RDHash, nil
+}
+
+// visibleTorrents returns the torrents of the API, the jobs of the SABnzbd API are left out
+func (d *DelugeApi) visibleTorrents() ([]database.Torrent, error) {
+	torrents, err := d.torrents.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := torrents[:0]
+	for _, torrent := range torrents {
+		if torrent.AddedBy != database.Sabnzbd {
+			visible = append(visible, torrent)
+		}
+	}
+	return visible, nil
+}
+
+func (d *DelugeApi) findByHash(hash string) (*database.Torrent, error) {
+	torrents, err := d.findByHashes([]string{hash})
+	if err != nil {
+		return nil, err
+	}
+	if len(torrents) == 0 {
+		return nil, errTorrentNotFound
+	}
+	return &torrents[0], nil
+}
+
+func (d *DelugeApi) findByHashes(hashes []string) ([]database.Torrent, error) {
+	torrents, err := d.visibleTorrents()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []database.Torrent
+	for _, torrent := range torrents {
+		for _, hash := range hashes {
+			if strings.EqualFold(hash, torrent.RDHash) {
+				found = append(found, torrent)
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// hashes reads a parameter given as a hash or a list of hashes
+func hashes(params []json.RawMessage, index int) ([]string, error) {
+	var list []string
+	if err := param(params, index, &list); err == nil {
+		return list, nil
+	}
+
+	var hash string
+	if err := param(params, index, &hash); err != nil {
+		return nil, err
+	}
+	return []string{hash}, nil
+}
+
+// state maps the qBittorrent state of a torrent to a Deluge state
+func state(torrent database.Torrent) string {
+	switch qbittorrent.TorrentState(torrent) {
+	case "queuedDL":
+		return "Queued"
+	case "checkingUP":
+		return "Checking"
+	case "downloading", "stalledDL":
+		return "Downloading"
+	case "error", "missingFiles":
+		return "Error"
+	}
+	return "Paused"
+}
+
+// status returns the keys of core.get_torrent_status the clients use, all of them if keys is empty
+func (d *DelugeApi) status(torrent database.Torrent, keys []string) map[string]interface{} {
+	qbState := qbittorrent.TorrentState(torrent)
+	finished := qbState == "pausedUP"
+	left := int64(float64(torrent.RDSize) * (100 - torrent.RDProgress) / 100)
+
+	message := "OK"
+	switch qbState {
+	case "error":
+		message = "Error: Download failed"
+	case "missingFiles":
+		message = "Error: Torrent removed from Real-Debrid"
+	}
+
+	files := []map[string]interface{}{}
+	if downloads, err := d.torrents.FindAllDownloadByRdId(torrent.ID); err == nil {
+		for i, download := range downloads {
+			files = append(files, map[string]interface{}{
+				"index":  i,
+				"path":   torrent.RDName + "/" + download.FileName,
+				"size":   download.FileSize,
+				"offset": 0,
+			})
+		}
+	}
+
+	status := map[string]interface{}{
+		"hash":                  torrent.RDHash,
+		"name":                  torrent.RDName,
+		"state":                 state(torrent),
+		"message":               message,
+		"progress":              torrent.RDProgress,
+		"is_finished":           finished,
+		"is_seed":               finished,
+		"paused":                torrent.Paused,
+		"save_path":             d.preferences.CategorySavePath(torrent.Category),
+		"download_location":     d.preferences.CategorySavePath(torrent.Category),
+		"move_completed":        false,
+		"label":                 torrent.Category,
+		"total_size":            torrent.RDSize,
+		"total_wanted":          torrent.RDSize,
+		"total_done":            int64(torrent.RDSize) - left,
+		"total_uploaded":        0,
+		"ratio":                 0,
+		"eta":                   0,
+		"time_added":            torrent.CreatedAt.Unix(),
+		"completed_time":        0,
+		"active_time":           0,
+		"seeding_time":          0,
+		"is_auto_managed":       true,
+		"stop_at_ratio":         false,
+		"stop_ratio":            0,
+		"remove_at_ratio":       false,
+		"queue":                 torrent.Priority - 1,
+		"download_payload_rate": 0,
+		"upload_payload_rate":   0,
+		"num_seeds":             torrent.RDSeeders,
+		"total_seeds":           torrent.RDSeeders,
+		"num_peers":             0,
+		"tracker_host":          "",
+		"num_files":             len(files),
+		"files":                 files,
+	}
+
+	if finished {
+		status["completed_time"] = torrent.UpdatedAt.Unix()
+	}
+
+	if len(keys) == 0 {
+		return status
+	}
+
+	selected := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		if value, ok := status[key]; ok {
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+func (d *DelugeApi) torrentStatus(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := param(params, 0, &hash); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	param(params, 1, &keys)
+
+	torrent, err := d.findByHash(hash)
+	if err != nil {
+		// Deluge answers an empty status for an unknown torrent
+		return map[string]interface{}{}, nil
+	}
+	return d.status(*torrent, keys), nil
+}
+
+// filters of core.get_torrents_status, the id and label filters take a value or a list
+type filters struct {
+	Id    json.RawMessage `json:"id"`
+	Label json.RawMessage `json:"label"`
+	State json.RawMessage `json:"state"`
+}
+
+func (d *DelugeApi) torrentsStatus(params []json.RawMessage) (interface{}, error) {
+	var filter filters
+	param(params, 0, &filter)
+
+	var keys []string
+	param(params, 1, &keys)
+
+	return d.filteredStatus(filter, keys)
+}
+
+func (d *DelugeApi) filteredStatus(filter filters, keys []string) (map[string]interface{}, error) {
+	torrents, err := d.visibleTorrents()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := filterValues(filter.Id)
+	labels := filterValues(filter.Label)
+	states := filterValues(filter.State)
+
+	result := make(map[string]interface{})
+	for _, torrent := range torrents {
+		if ids != nil && !containsFold(ids, torrent.RDHash) {
+			continue
+		}
+		if labels != nil && !containsFold(labels, torrent.Category) {
+			continue
+		}
+		if states != nil && !containsFold(states, "All") && !containsFold(states, state(torrent)) {
+			continue
+		}
+
+		result[torrent.RDHash] = d.status(torrent, keys)
+	}
+	return result, nil
+}
+
+// filterValues reads a filter given as a value or a list, nil if it is absent
+func filterValues(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return []string{value}
+	}
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateUi is the call of the web UI refreshing its torrent list
+func (d *DelugeApi) updateUi(params []json.RawMessage) (interface{}, error) {
+	var keys []string
+	param(params, 0, &keys)
+
+	var filter filters
+	param(params, 1, &filter)
+
+	torrents, err := d.filteredStatus(filter, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	states := map[string]int{"All": len(torrents)}
+	labels := map[string]int{}
+	all, _ := d.visibleTorrents()
+	for _, torrent := range all {
+		states[state(torrent)]++
+		labels[torrent.Category]++
+	}
+
+	return map[string]interface{}{
+		"connected": true,
+		"torrents":  torrents,
+		"filters": map[string]interface{}{
+			"state": counts(states),
+			"label": counts(labels),
+		},
+		"stats": map[string]interface{}{
+			"num_connections":          0,
+			"upload_rate":              0,
+			"download_rate":            0,
+			"max_download":             -1,
+			"max_upload":               -1,
+			"free_space":               -1,
+			"has_incoming_connections": false,
+		},
+	}, nil
+}
+
+// counts lists the filters of web.update_ui as [name, count] pairs
+func counts(values map[string]int) [][]interface{} {
+	list := make([][]interface{}, 0, len(values))
+	for name, count := range values {
+		list = append(list, []interface{}{name, count})
+	}
+	return list
+}
+
+func (d *DelugeApi) removeTorrent(params []json.RawMessage) (interface{}, error) {
+	var hash string
+	if err := param(params, 0, &hash); err != nil {
+		return nil, err
+	}
+
+	var removeData bool
+	param(params, 1, &removeData)
+
+	torrent, err := d.findByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := jobs.DeleteTorrent(d.torrents, d.client, d.rdCache, d.preferences.GetSavePath(), torrent, removeData, d.logger); err != nil {
+		return nil, err
+	}
+	return true, nil
+}
+
+// removeTorrents answers the errors of each torrent, like Deluge
+func (d *DelugeApi) removeTorrents(params []json.RawMessage) (interface{}, error) {
+	var hashes []string
+	if err := param(params, 0, &hashes); err != nil {
+		return nil, err
+	}
+
+	var removeData bool
+	param(params, 1, &removeData)
+
+	torrents, err := d.findByHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := [][]string{}
+	for _, torrent := range torrents {
+		if err := jobs.DeleteTorrent(d.torrents, d.client, d.rdCache, d.preferences.GetSavePath(), &torrent, removeData, d.logger); err != nil {
+			errs = append(errs, []string{torrent.RDHash, err.Error()})
+		}
+	}
+	return errs, nil
+}
+
+func (d *DelugeApi) ids(params []json.RawMessage) ([]uint, error) {
+	hashes, err := hashes(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	torrents, err := d.findByHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(torrents))
+	for i, torrent := range torrents {
+		ids[i] = torrent.ID
+	}
+	return ids, nil
+}
+
+// pause stops or starts torrents, like the torrent-stop of the Transmission RPC
+func (d *DelugeApi) pause(params []json.RawMessage, paused bool) error {
+	ids, err := d.ids(params)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	if err := d.torrents.SetPaused(ids, paused); err != nil {
+		return err
+	}
+
+	if !paused {
+		d.queue.Wake()
+		go d.admission.Run()
+	}
+	return nil
+}
+
+func (d *DelugeApi) reorder(params []json.RawMessage, move func(ids []uint) error) error {
+	ids, err := d.ids(params)
+	if err != nil {
+		return err
+	}
+	return move(ids)
+}
+
+func (d *DelugeApi) addLabel(params []json.RawMessage) error {
+	var label string
+	if err := param(params, 0, &label); err != nil {
+		return err
+	}
+
+	if !validLabel(label) {
+		return errInvalidLabel
+	}
+
+	if d.categories.Exist(label) {
+		return errors.New("Label already exists")
+	}
+
+	if err := d.categories.Create(database.NewCategory(label)); err != nil {
+		return err
+	}
+
+	return os.MkdirAll(d.preferences.CategorySavePath(label), os.ModePerm)
+}
+
+// removeLabel deletes a label. Like the Label plugin, its torrents are left without one
+// and their files are moved out of its folder.
+func (d *DelugeApi) removeLabel(params []json.RawMessage) error {
+	var label string
+	if err := param(params, 0, &label); err != nil {
+		return err
+	}
+
+	if !d.categories.Exist(label) {
+		return errors.New("Unknown label")
+	}
+
+	torrents, err := d.torrents.FindByCategory(label)
+	if err != nil {
+		return err
+	}
+	for i := range torrents {
+		err := jobs.SetCategory(d.torrents, d.preferences.GetSavePath(), &torrents[i], "")
+		if errors.Is(err, jobs.ErrTorrentDownloading) {
+			return errors.New("A torrent of the label is downloading, the label can't be removed")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.categories.Delete(label)
+}
+
+// validLabel follows the rules of the Label plugin
+func validLabel(label string) bool {
+	if label == "" {
+		return false
+	}
+
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// setLabel moves a torrent to the category of label, an empty label removes its category.
+// The files already downloaded are moved to the folder of the new category.
+func (d *DelugeApi) setLabel(params []json.RawMessage) error {
+	var hash, label string
+	if err := param(params, 0, &hash); err != nil {
+		return err
+	}
+	if err := param(params, 1, &label); err != nil {
+		return err
+	}
+
+	torrent, err := d.findByHash(hash)
+	if err != nil {
+		return err
+	}
+
+	if label != "" && !d.categories.Exist(label) {
+		return errors.New("Unknown label")
+	}
+
+	err = jobs.SetCategory(d.torrents, d.preferences.GetSavePath(), torrent, label)
+	if errors.Is(err, jobs.ErrTorrentDownloading) {
+		return errors.New("Torrent is downloading, the label can't be changed")
+	}
+	return err
+}
@@ -1,173 +1,222 @@
 package qbittorrent
 
 import (
+	"os"
+
 	"github.com/TOomaAh/qbrdt/internal/database"
 	"github.com/labstack/echo/v4"
 )
 
 type QbittorrentAppApi struct {
-	p *database.PreferencesRepository
+	p                  *database.PreferencesRepository
+	maxActiveDownloads int
+	maxActiveTorrents  int
+	// category of the torrents of each watched folder
+	watchDirs map[string]string
 }
 
 type AppPreferences struct {
-	AddTrackers                        string            `json:"add_trackers"`
-	AddTrackersEnabled                 bool              `json:"add_trackers_enabled"`
-	AltDlLimit                         int               `json:"alt_dl_limit"`
-	AltUpLimit                         int               `json:"alt_up_limit"`
-	AlternativeWebuiEnabled            bool              `json:"alternative_webui_enabled"`
-	AlternativeWebuiPath               string            `json:"alternative_webui_path"`
-	AnnounceIp                         string            `json:"announce_ip"`
-	AnnounceToAllTiers                 bool              `json:"announce_to_all_tiers"`
-	AnnounceToAllTrackers              bool              `json:"announce_to_all_trackers"`
-	AnonymousMode                      bool              `json:"anonymous_mode"`
-	AsyncIoThreads                     int               `json:"async_io_threads"`
-	AutoDeleteMode                     int               `json:"auto_delete_mode"`
-	AutoTmmEnabled                     bool              `json:"auto_tmm_enabled"`
-	AutorunEnabled                     bool              `json:"autorun_enabled"`
-	AutorunProgram                     string            `json:"autorun_program"`
-	BannedIPs                          string            `json:"banned_ips"`
-	BittorrentProtocol                 int               `json:"bittorrent_protocol"`
-	BypassAuthSubnetWhitelist          string            `json:"bypass_auth_subnet_whitelist"`
-	BypassAuthSubnetWhitelistEnabled   bool              `json:"bypass_auth_subnet_whitelist_enabled"`
-	BypassLocalAuth                    bool              `json:"bypass_local_auth"`
-	CategoryChangedTmmEnabled          bool              `json:"category_changed_tmm_enabled"`
-	CheckingMemoryUse                  int               `json:"checking_memory_use"`
-	CreateSubfolderEnabled             bool              `json:"create_subfolder_enabled"`
-	CurrentInterfaceAddress            string            `json:"current_interface_address"`
-	CurrentNetworkInterface            string            `json:"current_network_interface"`
-	Dht                                bool              `json:"dht"`
-	DiskCache                          int               `json:"disk_cache"`
-	DiskCacheTtl                       int               `json:"disk_cache_ttl"`
-	DlLimit                            int               `json:"dl_limit"`
-	DontCountSlowTorrents              bool              `json:"dont_count_slow_torrents"`
-	DyndnsDomain                       string            `json:"dyndns_domain"`
-	DyndnsEnabled                      bool              `json:"dyndns_enabled"`
-	DyndnsPassword                     string            `json:"dyndns_password"`
-	DyndnsService                      int               `json:"dyndns_service"`
-	DyndnsUsername                     string            `json:"dyndns_username"`
-	EmbeddedTrackerPort                int               `json:"embedded_tracker_port"`
-	EnableCoalesceReadWrite            bool              `json:"enable_coalesce_read_write"`
-	EnableEmbeddedTracker              bool              `json:"enable_embedded_tracker"`
-	EnableMultiConnectionsFromSameIp   bool              `json:"enable_multi_connections_from_same_ip"`
-	EnableOsCache                      bool              `json:"enable_os_cache"`
-	EnablePieceExtentAffinity          bool              `json:"enable_piece_extent_affinity"`
-	EnableSuperSeeding                 bool              `json:"enable_super_seeding"`
-	EnableUploadSuggestions            bool              `json:"enable_upload_suggestions"`
-	Encryption                         int               `json:"encryption"`
-	ExportDir                          string            `json:"export_dir"`
-	ExportDirFin                       string            `json:"export_dir_fin"`
-	FilePoolSize                       int               `json:"file_pool_size"`
-	IncompleteFilesExt                 bool              `json:"incomplete_files_ext"`
-	IpFilterEnabled                    bool              `json:"ip_filter_enabled"`
-	IpFilterPath                       string            `json:"ip_filter_path"`
-	IpFilterTrackers                   bool              `json:"ip_filter_trackers"`
-	LimitLanPeers                      bool              `json:"limit_lan_peers"`
-	LimitTcpOverhead                   bool              `json:"limit_tcp_overhead"`
-	LimitUtpRate                       bool              `json:"limit_utp_rate"`
-	ListenPort                         int               `json:"listen_port"`
-	Locale                             string            `json:"locale"`
-	Lsd                                bool              `json:"lsd"`
-	MailNotificationAuthEnabled        bool              `json:"mail_notification_auth_enabled"`
-	MailNotificationEmail              string            `json:"mail_notification_email"`
-	MailNotificationEnabled            bool              `json:"mail_notification_enabled"`
-	MailNotificationPassword           string            `json:"mail_notification_password"`
-	MailNotificationSender             string            `json:"mail_notification_sender"`
-	MailNotificationSmtp               string            `json:"mail_notification_smtp"`
-	MailNotificationSslEnabled         bool              `json:"mail_notification_ssl_enabled"`
-	MailNotificationUsername           string            `json:"mail_notification_username"`
-	MaxActiveDownloads                 int               `json:"max_active_downloads"`
-	MaxActiveTorrents                  int               `json:"max_active_torrents"`
-	MaxActiveUploads                   int               `json:"max_active_uploads"`
-	MaxConnec                          int               `json:"max_connec"`
-	MaxConnecPerTorrent                int               `json:"max_connec_per_torrent"`
-	MaxRatio                           int               `json:"max_ratio"`
-	MaxRatioAct                        int               `json:"max_ratio_act"`
-	MaxRatioEnabled                    bool              `json:"max_ratio_enabled"`
-	MaxSeedingTime                     int               `json:"max_seeding_time"`
-	MaxSeedingTimeEnabled              bool              `json:"max_seeding_time_enabled"`
-	MaxUploads                         int               `json:"max_uploads"`
-	MaxUploadsPerTorrent               int               `json:"max_uploads_per_torrent"`
-	OutgoingPortsMax                   int               `json:"outgoing_ports_max"`
-	OutgoingPortsMin                   int               `json:"outgoing_ports_min"`
-	Pex                                bool              `json:"pex"`
-	PreallocateAll                     bool              `json:"preallocate_all"`
-	ProxyAuthEnabled                   bool              `json:"proxy_auth_enabled"`
-	ProxyIp                            string            `json:"proxy_ip"`
-	ProxyPassword                      string            `json:"proxy_password"`
-	ProxyPeerConnections               bool              `json:"proxy_peer_connections"`
-	ProxyPort                          int               `json:"proxy_port"`
-	ProxyTorrentsOnly                  bool              `json:"proxy_torrents_only"`
-	ProxyType                          int               `json:"proxy_type"`
-	ProxyUsername                      string            `json:"proxy_username"`
-	QueueingEnabled                    bool              `json:"queueing_enabled"`
-	RandomPort                         bool              `json:"random_port"`
-	RecheckCompletedTorrents           bool              `json:"recheck_completed_torrents"`
-	ResolvePeerCountries               bool              `json:"resolve_peer_countries"`
-	RssAutoDownloadingEnabled          bool              `json:"rss_auto_downloading_enabled"`
-	RssMaxArticlesPerFeed              int               `json:"rss_max_articles_per_feed"`
-	RssProcessingEnabled               bool              `json:"rss_processing_enabled"`
-	RssRefreshInterval                 int               `json:"rss_refresh_interval"`
-	SavePath                           string            `json:"save_path"`
-	SavePathChangedTmmEnabled          bool              `json:"save_path_changed_tmm_enabled"`
-	SaveResumeDataInterval             int               `json:"save_resume_data_interval"`
-	ScanDirs                           map[string]string `json:"scan_dirs"`
-	ScheduleFromHour                   int               `json:"schedule_from_hour"`
-	ScheduleFromMin                    int               `json:"schedule_from_min"`
-	ScheduleToHour                     int               `json:"schedule_to_hour"`
-	ScheduleToMin                      int               `json:"schedule_to_min"`
-	SchedulerDays                      int               `json:"scheduler_days"`
-	SchedulerEnabled                   bool              `json:"scheduler_enabled"`
-	SendBufferLowWatermark             int               `json:"send_buffer_low_watermark"`
-	SendBufferWatermark                int               `json:"send_buffer_watermark"`
-	SendBufferWatermarkFactor          int               `json:"send_buffer_watermark_factor"`
-	SlowTorrentDlRateThreshold         int               `json:"slow_torrent_dl_rate_threshold"`
-	SlowTorrentInactiveTimer           int               `json:"slow_torrent_inactive_timer"`
-	SlowTorrentUlRateThreshold         int               `json:"slow_torrent_ul_rate_threshold"`
-	SocketBacklogSize                  int               `json:"socket_backlog_size"`
-	StartPausedEnabled                 bool              `json:"start_paused_enabled"`
-	StopTrackerTimeout                 int               `json:"stop_tracker_timeout"`
-	TempPath                           string            `json:"temp_path"`
-	TempPathEnabled                    bool              `json:"temp_path_enabled"`
-	TorrentChangedTmmEnabled           bool              `json:"torrent_changed_tmm_enabled"`
-	UpLimit                            int               `json:"up_limit"`
-	UploadChokingAlgorithm             int               `json:"upload_choking_algorithm"`
-	UploadSlotsBehavior                int               `json:"upload_slots_behavior"`
-	Upnp                               bool              `json:"upnp"`
-	UpnpLeaseDuration                  int               `json:"upnp_lease_duration"`
-	UseHttps                           bool              `json:"use_https"`
-	UtpTcpMixedMode                    int               `json:"utp_tcp_mixed_mode"`
-	WebUiAddress                       string            `json:"web_ui_address"`
-	WebUiBanDuration                   int               `json:"web_ui_ban_duration"`
-	WebUiClickjackingProtectionEnabled bool              `json:"web_ui_clickjacking_protection_enabled"`
-	WebUiCsrfProtectionEnabled         bool              `json:"web_ui_csrf_protection_enabled"`
-	WebUiDomainList                    string            `json:"web_ui_domain_list"`
-	WebUiHostHeaderValidationEnabled   bool              `json:"web_ui_host_header_validation_enabled"`
-	WebUiHttpsCertPath                 string            `json:"web_ui_https_cert_path"`
-	WebUiHttpsKeyPath                  string            `json:"web_ui_https_key_path"`
-	WebUiMaxAuthFailCount              int               `json:"web_ui_max_auth_fail_count"`
-	WebUiPort                          int               `json:"web_ui_port"`
-	WebUiSecureCookieEnabled           bool              `json:"web_ui_secure_cookie_enabled"`
-	WebUiSessionTimeout                int               `json:"web_ui_session_timeout"`
-	WebUiUpnp                          bool              `json:"web_ui_upnp"`
-	WebUiUsername                      string            `json:"web_ui_username"`
+	AddTrackers                        string                 `json:"add_trackers"`
+	AddTrackersEnabled                 bool                   `json:"add_trackers_enabled"`
+	AltDlLimit                         int                    `json:"alt_dl_limit"`
+	AltUpLimit                         int                    `json:"alt_up_limit"`
+	AlternativeWebuiEnabled            bool                   `json:"alternative_webui_enabled"`
+	AlternativeWebuiPath               string                 `json:"alternative_webui_path"`
+	AnnounceIp                         string                 `json:"announce_ip"`
+	AnnounceToAllTiers                 bool                   `json:"announce_to_all_tiers"`
+	AnnounceToAllTrackers              bool                   `json:"announce_to_all_trackers"`
+	AnonymousMode                      bool                   `json:"anonymous_mode"`
+	AsyncIoThreads                     int                    `json:"async_io_threads"`
+	AutoDeleteMode                     int                    `json:"auto_delete_mode"`
+	AutoTmmEnabled                     bool                   `json:"auto_tmm_enabled"`
+	AutorunEnabled                     bool                   `json:"autorun_enabled"`
+	AutorunProgram                     string                 `json:"autorun_program"`
+	BannedIPs                          string                 `json:"banned_ips"`
+	BittorrentProtocol                 int                    `json:"bittorrent_protocol"`
+	BypassAuthSubnetWhitelist          string                 `json:"bypass_auth_subnet_whitelist"`
+	BypassAuthSubnetWhitelistEnabled   bool                   `json:"bypass_auth_subnet_whitelist_enabled"`
+	BypassLocalAuth                    bool                   `json:"bypass_local_auth"`
+	CategoryChangedTmmEnabled          bool                   `json:"category_changed_tmm_enabled"`
+	CheckingMemoryUse                  int                    `json:"checking_memory_use"`
+	CreateSubfolderEnabled             bool                   `json:"create_subfolder_enabled"`
+	CurrentInterfaceAddress            string                 `json:"current_interface_address"`
+	CurrentNetworkInterface            string                 `json:"current_network_interface"`
+	Dht                                bool                   `json:"dht"`
+	DiskCache                          int                    `json:"disk_cache"`
+	DiskCacheTtl                       int                    `json:"disk_cache_ttl"`
+	DlLimit                            int                    `json:"dl_limit"`
+	DontCountSlowTorrents              bool                   `json:"dont_count_slow_torrents"`
+	DyndnsDomain                       string                 `json:"dyndns_domain"`
+	DyndnsEnabled                      bool                   `json:"dyndns_enabled"`
+	DyndnsPassword                     string                 `json:"dyndns_password"`
+	DyndnsService                      int                    `json:"dyndns_service"`
+	DyndnsUsername                     string                 `json:"dyndns_username"`
+	EmbeddedTrackerPort                int                    `json:"embedded_tracker_port"`
+	EnableCoalesceReadWrite            bool                   `json:"enable_coalesce_read_write"`
+	EnableEmbeddedTracker              bool                   `json:"enable_embedded_tracker"`
+	EnableMultiConnectionsFromSameIp   bool                   `json:"enable_multi_connections_from_same_ip"`
+	EnableOsCache                      bool                   `json:"enable_os_cache"`
+	EnablePieceExtentAffinity          bool                   `json:"enable_piece_extent_affinity"`
+	EnableSuperSeeding                 bool                   `json:"enable_super_seeding"`
+	EnableUploadSuggestions            bool                   `json:"enable_upload_suggestions"`
+	Encryption                         int                    `json:"encryption"`
+	ExportDir                          string                 `json:"export_dir"`
+	ExportDirFin                       string                 `json:"export_dir_fin"`
+	FilePoolSize                       int                    `json:"file_pool_size"`
+	IncompleteFilesExt                 bool                   `json:"incomplete_files_ext"`
+	IpFilterEnabled                    bool                   `json:"ip_filter_enabled"`
+	IpFilterPath                       string                 `json:"ip_filter_path"`
+	IpFilterTrackers                   bool                   `json:"ip_filter_trackers"`
+	LimitLanPeers                      bool                   `json:"limit_lan_peers"`
+	LimitTcpOverhead                   bool                   `json:"limit_tcp_overhead"`
+	LimitUtpRate                       bool                   `json:"limit_utp_rate"`
+	ListenPort                         int                    `json:"listen_port"`
+	Locale                             string                 `json:"locale"`
+	Lsd                                bool                   `json:"lsd"`
+	MailNotificationAuthEnabled        bool                   `json:"mail_notification_auth_enabled"`
+	MailNotificationEmail              string                 `json:"mail_notification_email"`
+	MailNotificationEnabled            bool                   `json:"mail_notification_enabled"`
+	MailNotificationPassword           string                 `json:"mail_notification_password"`
+	MailNotificationSender             string                 `json:"mail_notification_sender"`
+	MailNotificationSmtp               string                 `json:"mail_notification_smtp"`
+	MailNotificationSslEnabled         bool                   `json:"mail_notification_ssl_enabled"`
+	MailNotificationUsername           string                 `json:"mail_notification_username"`
+	MaxActiveDownloads                 int                    `json:"max_active_downloads"`
+	MaxActiveTorrents                  int                    `json:"max_active_torrents"`
+	MaxActiveUploads                   int                    `json:"max_active_uploads"`
+	MaxConnec                          int                    `json:"max_connec"`
+	MaxConnecPerTorrent                int                    `json:"max_connec_per_torrent"`
+	MaxRatio                           int                    `json:"max_ratio"`
+	MaxRatioAct                        int                    `json:"max_ratio_act"`
+	MaxRatioEnabled                    bool                   `json:"max_ratio_enabled"`
+	MaxSeedingTime                     int                    `json:"max_seeding_time"`
+	MaxSeedingTimeEnabled              bool                   `json:"max_seeding_time_enabled"`
+	MaxUploads                         int                    `json:"max_uploads"`
+	MaxUploadsPerTorrent               int                    `json:"max_uploads_per_torrent"`
+	OutgoingPortsMax                   int                    `json:"outgoing_ports_max"`
+	OutgoingPortsMin                   int                    `json:"outgoing_ports_min"`
+	Pex                                bool                   `json:"pex"`
+	PreallocateAll                     bool                   `json:"preallocate_all"`
+	ProxyAuthEnabled                   bool                   `json:"proxy_auth_enabled"`
+	ProxyIp                            string                 `json:"proxy_ip"`
+	ProxyPassword                      string                 `json:"proxy_password"`
+	ProxyPeerConnections               bool                   `json:"proxy_peer_connections"`
+	ProxyPort                          int                    `json:"proxy_port"`
+	ProxyTorrentsOnly                  bool                   `json:"proxy_torrents_only"`
+	ProxyType                          int                    `json:"proxy_type"`
+	ProxyUsername                      string                 `json:"proxy_username"`
+	QueueingEnabled                    bool                   `json:"queueing_enabled"`
+	RandomPort                         bool                   `json:"random_port"`
+	RecheckCompletedTorrents           bool                   `json:"recheck_completed_torrents"`
+	ResolvePeerCountries               bool                   `json:"resolve_peer_countries"`
+	RssAutoDownloadingEnabled          bool                   `json:"rss_auto_downloading_enabled"`
+	RssMaxArticlesPerFeed              int                    `json:"rss_max_articles_per_feed"`
+	RssProcessingEnabled               bool                   `json:"rss_processing_enabled"`
+	RssRefreshInterval                 int                    `json:"rss_refresh_interval"`
+	SavePath                           string                 `json:"save_path"`
+	SavePathChangedTmmEnabled          bool                   `json:"save_path_changed_tmm_enabled"`
+	SaveResumeDataInterval             int                    `json:"save_resume_data_interval"`
+	ScanDirs                           map[string]interface{} `json:"scan_dirs"`
+	ScheduleFromHour                   int                    `json:"schedule_from_hour"`
+	ScheduleFromMin                    int                    `json:"schedule_from_min"`
+	ScheduleToHour                     int                    `json:"schedule_to_hour"`
+	ScheduleToMin                      int                    `json:"schedule_to_min"`
+	SchedulerDays                      int                    `json:"scheduler_days"`
+	SchedulerEnabled                   bool                   `json:"scheduler_enabled"`
+	SendBufferLowWatermark             int                    `json:"send_buffer_low_watermark"`
+	SendBufferWatermark                int                    `json:"send_buffer_watermark"`
+	SendBufferWatermarkFactor          int                    `json:"send_buffer_watermark_factor"`
+	SlowTorrentDlRateThreshold         int                    `json:"slow_torrent_dl_rate_threshold"`
+	SlowTorrentInactiveTimer           int                    `json:"slow_torrent_inactive_timer"`
+	SlowTorrentUlRateThreshold         int                    `json:"slow_torrent_ul_rate_threshold"`
+	SocketBacklogSize                  int                    `json:"socket_backlog_size"`
+	StartPausedEnabled                 bool                   `json:"start_paused_enabled"`
+	StopTrackerTimeout                 int                    `json:"stop_tracker_timeout"`
+	TempPath                           string                 `json:"temp_path"`
+	TempPathEnabled                    bool                   `json:"temp_path_enabled"`
+	TorrentChangedTmmEnabled           bool                   `json:"torrent_changed_tmm_enabled"`
+	UpLimit                            int                    `json:"up_limit"`
+	UploadChokingAlgorithm             int                    `json:"upload_choking_algorithm"`
+	UploadSlotsBehavior                int                    `json:"upload_slots_behavior"`
+	Upnp                               bool                   `json:"upnp"`
+	UpnpLeaseDuration                  int                    `json:"upnp_lease_duration"`
+	UseHttps                           bool                   `json:"use_https"`
+	UtpTcpMixedMode                    int                    `json:"utp_tcp_mixed_mode"`
+	WebUiAddress                       string                 `json:"web_ui_address"`
+	WebUiBanDuration                   int                    `json:"web_ui_ban_duration"`
+	WebUiClickjackingProtectionEnabled bool                   `json:"web_ui_clickjacking_protection_enabled"`
+	WebUiCsrfProtectionEnabled         bool                   `json:"web_ui_csrf_protection_enabled"`
+	WebUiDomainList                    string                 `json:"web_ui_domain_list"`
+	WebUiHostHeaderValidationEnabled   bool                   `json:"web_ui_host_header_validation_enabled"`
+	WebUiHttpsCertPath                 string                 `json:"web_ui_https_cert_path"`
+	WebUiHttpsKeyPath                  string                 `json:"web_ui_https_key_path"`
+	WebUiMaxAuthFailCount              int                    `json:"web_ui_max_auth_fail_count"`
+	WebUiPort                          int                    `json:"web_ui_port"`
+	WebUiSecureCookieEnabled           bool                   `json:"web_ui_secure_cookie_enabled"`
+	WebUiSessionTimeout                int                    `json:"web_ui_session_timeout"`
+	WebUiUpnp                          bool                   `json:"web_ui_upnp"`
+	WebUiUsername                      string                 `json:"web_ui_username"`
 }
 
-func NewQbittorrentAppApi(e *echo.Group, p *database.PreferencesRepository) *QbittorrentAppApi {
+func NewQbittorrentAppApi(e *echo.Group, p *database.PreferencesRepository, maxActiveDownloads, maxActiveTorrents int, watchDirs map[string]string) *QbittorrentAppApi {
 	versionApi := &QbittorrentAppApi{
-		p: p,
+		p:                  p,
+		maxActiveDownloads: maxActiveDownloads,
+		maxActiveTorrents:  maxActiveTorrents,
+		watchDirs:          watchDirs,
 	}
 
 	g := e.Group("/app")
+	g.GET("/version", versionApi.version)
+	g.POST("/version", versionApi.version)
 	g.GET("/webapiVersion", versionApi.webApiVersion)
 	g.POST("/webapiVersion", versionApi.webApiVersion)
+	g.GET("/buildInfo", versionApi.buildInfo)
+	g.POST("/buildInfo", versionApi.buildInfo)
+	g.GET("/defaultSavePath", versionApi.defaultSavePath)
+	g.POST("/defaultSavePath", versionApi.defaultSavePath)
 	g.GET("/preferences", versionApi.preferences)
 	g.POST("/preferences", versionApi.preferences)
 
 	return versionApi
 }
 
+// Version of qBittorrent and of its Web API the clients see
+const (
+	appVersion    = "v4.6.7"
+	webApiVersion = "2.9.3"
+)
+
+type BuildInfo struct {
+	Qt         string `json:"qt"`
+	Libtorrent string `json:"libtorrent"`
+	Boost      string `json:"boost"`
+	Openssl    string `json:"openssl"`
+	Zlib       string `json:"zlib"`
+	Bitness    int    `json:"bitness"`
+}
+
+func (*QbittorrentAppApi) version(c echo.Context) error {
+	return OkBody(appVersion, c)
+}
+
 func (*QbittorrentAppApi) webApiVersion(c echo.Context) error {
-	return OkBody("2.7.0", c)
+	return OkBody(webApiVersion, c)
+}
+
+func (*QbittorrentAppApi) buildInfo(c echo.Context) error {
+	return c.JSON(200, BuildInfo{
+		Qt:         "6.4.3",
+		Libtorrent: "2.0.10.0",
+		Boost:      "1.83.0",
+		Openssl:    "3.1.5",
+		Zlib:       "1.3.1",
+		Bitness:    64,
+	})
+}
+
+func (q *QbittorrentAppApi) defaultSavePath(c echo.Context) error {
+	return OkBody(q.p.GetSavePath(), c)
 }
 
 func (q *QbittorrentAppApi) preferences(c echo.Context) error {
@@ -237,8 +286,8 @@ func (q *QbittorrentAppApi) preferences(c echo.Context) error {
 		MailNotificationSmtp:               "smtp.changeme.com",
 		MailNotificationSslEnabled:         false,
 		MailNotificationUsername:           "",
-		MaxActiveDownloads:                 3,
-		MaxActiveTorrents:                  5,
+		MaxActiveDownloads:                 limitPreference(q.maxActiveDownloads),
+		MaxActiveTorrents:                  limitPreference(q.maxActiveTorrents),
 		MaxActiveUploads:                   3,
 		MaxConnec:                          500,
 		MaxConnecPerTorrent:                100,
@@ -261,7 +310,7 @@ func (q *QbittorrentAppApi) preferences(c echo.Context) error {
 		ProxyTorrentsOnly:                  false,
 		ProxyType:                          0,
 		ProxyUsername:                      "",
-		QueueingEnabled:                    false,
+		QueueingEnabled:                    true,
 		RandomPort:                         false,
 		RecheckCompletedTorrents:           false,
 		ResolvePeerCountries:               true,
@@ -272,7 +321,7 @@ func (q *QbittorrentAppApi) preferences(c echo.Context) error {
 		SavePath:                           q.p.GetSavePath(),
 		SavePathChangedTmmEnabled:          false,
 		SaveResumeDataInterval:             60,
-		ScanDirs:                           map[string]string{},
+		ScanDirs:                           q.scanDirs(),
 		ScheduleFromHour:                   8,
 		ScheduleFromMin:                    0,
 		ScheduleToHour:                     20,
@@ -317,3 +366,25 @@ func (q *QbittorrentAppApi) preferences(c echo.Context) error {
 	return c.JSON(200, preferences)
 
 }
+
+// limitPreference converts a limit where 0 means unlimited to qBittorrent's -1
+func limitPreference(limit int) int {
+	if limit <= 0 {
+		return -1
+	}
+	return limit
+}
+
+// scanDirs reports the watched folders like qBittorrent: 1 for the default
+// save path, else the save path of their category
+func (q *QbittorrentAppApi) scanDirs() map[string]interface{} {
+	dirs := make(map[string]interface{}, len(q.watchDirs))
+	for dir, category := range q.watchDirs {
+		if category == "" {
+			dirs[dir] = 1
+		} else {
+			dirs[dir] = q.p.GetSavePath() + string(os.PathSeparator) + category
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,262 @@
+package qbittorrent_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/events"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/internal/verifier"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid/realdebridtest"
+	"github.com/labstack/echo/v4"
+)
+
+// go test ./internal/api/qbittorrent -update rewrites the golden files
+var update = flag.Bool("update", false, "rewrite the golden files of the contract tests")
+
+// Fields changing on every run, masked in the golden files
+var volatileFields = map[string]bool{
+	"added_on":       true,
+	"last_activity":  true,
+	"completion_on":  true,
+	"additionDate":   true,
+	"completionDate": true,
+	"creationDate":   true,
+	"lastSeen":       true,
+	"timeElapsed":    true,
+}
+
+var sidRegexp = regexp.MustCompile(`SID=[0-9a-f]+`)
+
+type contract struct {
+	t        *testing.T
+	e        *echo.Echo
+	savePath string
+	torrent  []byte
+	hash     string
+	session  string
+}
+
+// newContract serves the qBittorrent API like qbrdt does. Real-Debrid refuses
+// the token so that added torrents stay queued and the answers are stable.
+func newContract(t *testing.T) *contract {
+	dir := t.TempDir()
+	t.Setenv("QBRDT_DB", filepath.Join(dir, "qbrdt.db"))
+
+	rd := realdebridtest.NewServer()
+	rd.Token = "valid"
+	t.Cleanup(rd.Close)
+
+	l := logger.New("error")
+	savePath := filepath.Join(dir, "downloads")
+
+	db := database.NewDatabase(l)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	preferences := database.NewPreferencesRepository(db, savePath)
+	categories := database.NewCategoryRepository(db)
+	torrents := database.NewTorrentRepository(db)
+	downloads := database.NewDownloadRepository(db)
+	client := realdebrid.NewClient("invalid", rd.ApiUrl())
+	rdCache := realdebrid.NewCache(client, time.Second)
+
+	q := queue.NewDownloadQueue(torrents, downloads, downloader.NewDownloader(downloader.Config{}, l), 1, l)
+	v := verifier.NewVerifier(torrents, downloads, q, l)
+	breaker := jobs.NewCircuitBreaker(l)
+	// never checked, so considered premium
+	account := jobs.NewAccountMonitor(client, breaker, events.NewBus(), 0, l)
+	availability := jobs.NewAvailability(client, jobs.AvailabilityPolicy{}, nil, l)
+	admission := jobs.NewTorrentAdmission(client, rdCache, breaker, account, availability, torrents, 0, 0, 0, l)
+	t.Cleanup(admission.Stop)
+
+	e := echo.New()
+
+	noAuthApi := e.Group("/api/v2")
+	loginApi := qbittorrent.NewQbittorrentAuthenticationApi(noAuthApi, qbittorrent.NewAuthenticator("admin", "adminadmin"))
+
+	authApi := e.Group("/api/v2")
+	authApi.Use(loginApi.RequireAuth)
+
+	qbittorrent.NewQbittorrentAppApi(authApi, preferences, 0, 0, nil)
+	qbittorrent.NewQbittorrentTorrentApi(l, authApi, preferences, categories, torrents, client, rdCache, v, q, admission, account)
+
+	content := make([]byte, 100*1024)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	torrent := rd.NewTorrent("Big.Buck.Bunny.2008.1080p", 16*1024,
+		realdebridtest.File{Path: "Big.Buck.Bunny.2008.1080p.mkv", Content: content},
+	)
+
+	meta, err := metainfo.Parse(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &contract{
+		t:        t,
+		e:        e,
+		savePath: savePath,
+		torrent:  torrent,
+		hash:     meta.InfoHash,
+	}
+}
+
+// request reads a recorded request. {{torrent}} and {{hash}} are replaced by
+// the test torrent and its info hash.
+func (c *contract) request(path string) *http.Request {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	head, body, _ := strings.Cut(string(data), "\n\n")
+	body = strings.TrimSuffix(body, "\n")
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head + "\n\n")))
+	if err != nil {
+		c.t.Fatalf("%s: %s", path, err)
+	}
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/") {
+		body = strings.ReplaceAll(body, "\n", "\r\n")
+	}
+
+	payload := []byte(strings.ReplaceAll(body, "{{hash}}", c.hash))
+	payload = bytes.ReplaceAll(payload, []byte("{{torrent}}"), c.torrent)
+
+	req.RequestURI = ""
+	req.URL.RawQuery = strings.ReplaceAll(req.URL.RawQuery, "%7B%7Bhash%7D%7D", c.hash)
+	req.URL.RawQuery = strings.ReplaceAll(req.URL.RawQuery, "{{hash}}", c.hash)
+	req.Body = io.NopCloser(bytes.NewReader(payload))
+	req.ContentLength = int64(len(payload))
+	req.RemoteAddr = "192.0.2.1:41234"
+
+	if c.session != "" && req.Header.Get("Cookie") == "" {
+		req.Header.Set("Cookie", "SID="+c.session)
+	}
+
+	return req
+}
+
+// response renders the answer as it is stored in the golden files
+func (c *contract) response(rec *httptest.ResponseRecorder) []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "HTTP %d\n", rec.Code)
+
+	for _, name := range []string{"Content-Type", "Set-Cookie"} {
+		for _, value := range rec.Header().Values(name) {
+			fmt.Fprintf(&out, "%s: %s\n", name, sidRegexp.ReplaceAllString(value, "SID={{sid}}"))
+		}
+	}
+	out.WriteString("\n")
+
+	body := rec.Body.Bytes()
+	if strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			c.t.Fatalf("invalid JSON answer: %s", err)
+		}
+		body, _ = json.MarshalIndent(mask(v), "", "  ")
+	}
+
+	body = bytes.ReplaceAll(body, []byte(c.savePath), []byte("{{save_path}}"))
+	body = bytes.ReplaceAll(body, []byte(c.hash), []byte("{{hash}}"))
+	out.Write(body)
+	out.WriteString("\n")
+
+	return out.Bytes()
+}
+
+func mask(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if volatileFields[k] {
+				v[k] = "*"
+			} else {
+				v[k] = mask(value)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = mask(v[i])
+		}
+	}
+	return v
+}
+
+// TestContract replays the requests recorded from each client in
+// testdata/contract/<client>, in order, and compares the answers to the golden files
+func TestContract(t *testing.T) {
+	clients, err := os.ReadDir("testdata/contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, client := range clients {
+		if !client.IsDir() {
+			continue
+		}
+
+		t.Run(client.Name(), func(t *testing.T) {
+			dir := filepath.Join("testdata/contract", client.Name())
+			requests, _ := filepath.Glob(filepath.Join(dir, "*.http"))
+			sort.Strings(requests)
+
+			c := newContract(t)
+
+			for _, path := range requests {
+				rec := httptest.NewRecorder()
+				c.e.ServeHTTP(rec, c.request(path))
+
+				if match := sidRegexp.FindString(rec.Header().Get("Set-Cookie")); match != "" {
+					c.session = strings.TrimPrefix(match, "SID=")
+				}
+
+				got := c.response(rec)
+				golden := strings.TrimSuffix(path, ".http") + ".golden"
+
+				if *update {
+					if err := os.WriteFile(golden, got, 0644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%s: %s, run with -update to create it", filepath.Base(path), err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Errorf("%s: answer differs from %s\n--- got\n%s\n--- want\n%s", filepath.Base(path), filepath.Base(golden), got, want)
+				}
+			}
+		})
+	}
+}
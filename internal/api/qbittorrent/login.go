@@ -1,92 +1,163 @@
 package qbittorrent
 
 import (
-	"io"
-	"strings"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/patrickmn/go-cache"
 )
 
-type QbittorrentAuthentication struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required"`
-}
+const (
+	sessionCookie  = "SID"
+	sessionTimeout = 3600 * time.Second
+	// Failed logins allowed before the IP is banned, like qBittorrent's defaults
+	maxAuthFailCount = 5
+	banDuration      = 3600 * time.Second
+)
 
-type QbittorrentAuthenticationApi struct {
-	cache    *cache.Cache
+// Authenticator checks the credentials of the qBittorrent API for every API using them,
+// so that the failed logins of an IP are counted and banned whatever the API
+type Authenticator struct {
+	// failed logins per IP
+	failures *cache.Cache
 	username string
 	password string
 }
 
-func NewQbittorrentAuthenticationApi(e *echo.Group, username, password string) *QbittorrentAuthenticationApi {
-	loginApi := &QbittorrentAuthenticationApi{
-		cache:    cache.New(cache.NoExpiration, cache.NoExpiration),
+func NewAuthenticator(username, password string) *Authenticator {
+	return &Authenticator{
+		failures: cache.New(banDuration, 10*time.Minute),
 		username: username,
 		password: password,
 	}
+}
+
+// Banned reports if the IP of the request failed to log in too many times.
+// The IP is the one of the connection, see the IPExtractor of the server.
+func (a *Authenticator) Banned(c echo.Context) bool {
+	failures, ok := a.failures.Get(c.RealIP())
+	return ok && failures.(int) >= maxAuthFailCount
+}
+
+// Authenticate checks the credentials and counts the failures of the IP of the request
+func (a *Authenticator) Authenticate(c echo.Context, username, password string) bool {
+	return a.count(c, username == a.username && password == a.password)
+}
+
+// AuthenticatePassword is Authenticate for the APIs logged in without username
+func (a *Authenticator) AuthenticatePassword(c echo.Context, password string) bool {
+	return a.count(c, password == a.password)
+}
+
+func (a *Authenticator) count(c echo.Context, ok bool) bool {
+	ip := c.RealIP()
+
+	if !ok {
+		if _, err := a.failures.IncrementInt(ip, 1); err != nil {
+			a.failures.Set(ip, 1, cache.DefaultExpiration)
+		}
+		return false
+	}
+
+	a.failures.Delete(ip)
+	return true
+}
+
+type QbittorrentAuthenticationApi struct {
+	// session ids, refreshed on each authenticated request
+	sessions *cache.Cache
+	auth     *Authenticator
+}
+
+func NewQbittorrentAuthenticationApi(e *echo.Group, auth *Authenticator) *QbittorrentAuthenticationApi {
+	loginApi := &QbittorrentAuthenticationApi{
+		sessions: cache.New(sessionTimeout, 10*time.Minute),
+		auth:     auth,
+	}
 
 	g := e.Group("/auth")
 	g.POST("/login", loginApi.login)
 	g.GET("/login", loginApi.login)
+	g.POST("/logout", loginApi.logout)
+	g.GET("/logout", loginApi.logout)
 
 	return loginApi
 }
 
 func (q *QbittorrentAuthenticationApi) login(c echo.Context) error {
-
-	if _, exist := q.cache.Get("auth"); exist {
-		return Ok(c)
+	if q.auth.Banned(c) {
+		return banned(c)
 	}
 
-	var auth QbittorrentAuthentication
-
-	if c.Request().Method == "POST" {
-		parsedBody, err := parseLoginBody(c.Request().Body)
-
-		if err != nil {
-			return Fails(c)
-		}
+	username, _ := formValue(c, "username")
+	password, _ := formValue(c, "password")
 
-		auth = *parsedBody
-	} else {
-		auth.Username = c.QueryParam("username")
-		auth.Password = c.QueryParam("password")
+	if !q.auth.Authenticate(c, username, password) {
+		return Fails(c)
 	}
 
-	if auth.Username != q.username || auth.Password != q.password {
-		return Fails(c)
+	sid := make([]byte, 16)
+	if _, err := rand.Read(sid); err != nil {
+		return InternalError(c)
 	}
 
-	q.cache.Set("auth", auth, cache.NoExpiration)
+	session := hex.EncodeToString(sid)
+	q.sessions.Set(session, username, cache.DefaultExpiration)
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
 
 	return Ok(c)
 }
 
-func parseLoginBody(body io.ReadCloser) (*QbittorrentAuthentication, error) {
-	content, err := io.ReadAll(body)
-
-	if err != nil {
-		return nil, err
+func (q *QbittorrentAuthenticationApi) logout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		q.sessions.Delete(cookie.Value)
 	}
 
-	var auth QbittorrentAuthentication
+	c.SetCookie(&http.Cookie{
+		Name:    sessionCookie,
+		Value:   "",
+		Path:    "/",
+		Expires: time.Unix(0, 0),
+	})
 
-	for _, v := range strings.Split(string(content), "&") {
-		pair := strings.Split(v, "=")
-		if len(pair) != 2 {
-			return nil, nil
+	return c.String(http.StatusOK, "")
+}
+
+// RequireAuth accepts requests with a session cookie from /auth/login or basic
+// auth credentials, others get a 403 like qBittorrent
+func (q *QbittorrentAuthenticationApi) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			if username, ok := q.sessions.Get(cookie.Value); ok {
+				// sliding expiration
+				q.sessions.Set(cookie.Value, username, cache.DefaultExpiration)
+				return next(c)
+			}
 		}
 
-		switch pair[0] {
-		case "username":
-			auth.Username = pair[1]
-		case "password":
-			auth.Password = pair[1]
+		if username, password, ok := c.Request().BasicAuth(); ok {
+			if q.auth.Banned(c) {
+				return banned(c)
+			}
+			if q.auth.Authenticate(c, username, password) {
+				return next(c)
+			}
 		}
 
+		return Forbidden(c)
 	}
+}
 
-	return &auth, nil
-
+func banned(c echo.Context) error {
+	return c.String(http.StatusForbidden, "Your IP address has been banned after too many failed authentication attempts.")
 }
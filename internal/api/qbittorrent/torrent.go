@@ -1,15 +1,24 @@
 package qbittorrent
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	gorealdebrid "github.com/TOomaAh/go-realdebrid"
 	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/internal/verifier"
 	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
 	"github.com/labstack/echo/v4"
 	"github.com/patrickmn/go-cache"
 )
@@ -19,18 +28,37 @@ type QBittorrentTorrentApi struct {
 	preference *database.PreferencesRepository
 	category   *database.CategoryRepository
 	torrents   *database.TorrentRepository
-	client     *gorealdebrid.RealDebridClient
+	client     *realdebrid.Client
+	rdCache    *realdebrid.Cache
+	verifier   *verifier.Verifier
+	queue      *queue.DownloadQueue
+	admission  *jobs.TorrentAdmission
+	account    *jobs.AccountMonitor
 	logger     logger.Interface
 }
 
-type QbittorentAddRequest struct {
-	Urls     []string `json:"urls"`
-	Category string   `json:"category"`
-	Priority int      `json:"priority"`
+type CategoryResponse struct {
+	Name     string `json:"name"`
+	SavePath string `json:"savePath"`
 }
 
 type FileInfoResponse struct {
+	// File index
+	Index int `json:"index"`
+	// File name, including the relative path
 	Name string `json:"name"`
+	// File size (bytes)
+	Size int64 `json:"size"`
+	// File progress (percentage/100)
+	Progress float64 `json:"progress"`
+	// File priority, 1 as every file is downloaded
+	Priority int `json:"priority"`
+	// True if the file is downloaded
+	IsSeed bool `json:"is_seed"`
+	// First and last piece of the file
+	PieceRange []int64 `json:"piece_range"`
+	// Percentage of file pieces currently available (percentage/100)
+	Availability float64 `json:"availability"`
 }
 
 type TorrentPropertiesResponse struct {
@@ -169,7 +197,12 @@ func NewQbittorrentTorrentApi(l logger.Interface,
 	preference *database.PreferencesRepository,
 	category *database.CategoryRepository,
 	torrents *database.TorrentRepository,
-	client *gorealdebrid.RealDebridClient,
+	client *realdebrid.Client,
+	rdCache *realdebrid.Cache,
+	verifier *verifier.Verifier,
+	queue *queue.DownloadQueue,
+	admission *jobs.TorrentAdmission,
+	account *jobs.AccountMonitor,
 ) *QBittorrentTorrentApi {
 
 	torrentApi := &QBittorrentTorrentApi{
@@ -178,24 +211,40 @@ func NewQbittorrentTorrentApi(l logger.Interface,
 		category:   category,
 		torrents:   torrents,
 		client:     client,
+		rdCache:    rdCache,
+		verifier:   verifier,
+		queue:      queue,
+		admission:  admission,
+		account:    account,
 		logger:     l,
 	}
 
 	g := auth.Group("/torrents")
 	g.GET("/categories", torrentApi.categories)
 	g.POST("/categories", torrentApi.categories)
-	g.GET("/createCategory", torrentApi.saveCatergories)
-	g.POST("/createCategory", torrentApi.saveCatergories)
 	g.GET("/info", torrentApi.torrentsInfo)
 	g.POST("/info", torrentApi.torrentsInfo)
 	g.GET("/files", torrentApi.torrentsFiles)
 	g.POST("/files", torrentApi.torrentsFiles)
 	g.GET("/properties", torrentApi.torrentsProperties)
 	g.POST("/properties", torrentApi.torrentsProperties)
-	g.GET("/add", torrentApi.addTorrentFromUrls)
-	g.POST("/add", torrentApi.addTorrentFromFile)
-	g.GET("/delete", torrentApi.deleteTorrent)
-	g.POST("/delete", torrentApi.deleteTorrent)
+
+	// actions only accept POST, like qBittorrent
+	actions := map[string]echo.HandlerFunc{
+		"/createCategory": torrentApi.saveCatergories,
+		"/add":            torrentApi.addTorrentFromFile,
+		"/delete":         torrentApi.deleteTorrent,
+		"/recheck":        torrentApi.recheckTorrents,
+		"/increasePrio":   torrentApi.changePriority(queue.IncreasePriority),
+		"/decreasePrio":   torrentApi.changePriority(queue.DecreasePriority),
+		"/topPrio":        torrentApi.changePriority(queue.TopPriority),
+		"/bottomPrio":     torrentApi.changePriority(queue.BottomPriority),
+	}
+
+	for path, handler := range actions {
+		g.POST(path, handler)
+		g.GET(path, MethodNotAllowed)
+	}
 
 	return torrentApi
 
@@ -204,13 +253,12 @@ func NewQbittorrentTorrentApi(l logger.Interface,
 func (q *QBittorrentTorrentApi) categories(c echo.Context) error {
 
 	categories := q.category.GetTorrentCategoriesDistinct()
-	path := q.preference.GetSavePath()
 
-	var cats = make(map[string]map[string]string)
+	var cats = make(map[string]CategoryResponse)
 	for _, v := range categories {
-		cats[v] = map[string]string{
-			"name":      v,
-			"save_path": path + string(os.PathSeparator) + v,
+		cats[v] = CategoryResponse{
+			Name:     v,
+			SavePath: q.preference.CategorySavePath(v),
 		}
 	}
 
@@ -219,97 +267,171 @@ func (q *QBittorrentTorrentApi) categories(c echo.Context) error {
 }
 
 func (a *QBittorrentTorrentApi) saveCatergories(c echo.Context) error {
-	// print body
-	body, err := io.ReadAll(c.Request().Body)
-	if err != nil {
-		return Fails(c)
-	}
-	values := strings.Split(string(body), "=")
+	category, ok := formValue(c, "category")
 
-	if len(values) != 2 {
-		return Fails(c)
+	if !ok {
+		return BadRequest(c)
 	}
 
-	category := values[1]
-
-	if category == "" {
-		return Fails(c)
+	if !ValidCategoryName(category) {
+		return Conflict("Incorrect category name", c)
 	}
 
-	cat := database.NewCategory(category)
+	if a.category.Exist(category) {
+		return Conflict("Unable to create category", c)
+	}
 
-	if err := a.category.Create(cat); err != nil {
-		return Fails(c)
+	if err := a.category.Create(database.NewCategory(category)); err != nil {
+		return InternalError(c)
 	}
 
-	err = os.MkdirAll(a.preference.GetSavePath()+"/"+category, os.ModePerm)
+	err := os.MkdirAll(a.preference.CategorySavePath(category), os.ModePerm)
 	if err != nil {
-		return Fails(c)
+		return InternalError(c)
 	}
 
-	return c.JSON(200, cat)
+	return c.String(200, "")
 }
 
-func (q *QBittorrentTorrentApi) torrentsInfo(c echo.Context) error {
-	var queryCategory string
-	if c.Request().Method == "POST" {
-		body, err := io.ReadAll(c.Request().Body)
-		if err != nil {
-			return Fails(c)
+// ValidCategoryName follows qBittorrent's rules: subcategories are separated
+// by "/", without empty parts or a leading or trailing separator
+func ValidCategoryName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
 		}
+	}
 
-		values := strings.Split(string(body), "=")
+	return true
+}
 
-		if len(values) != 2 {
-			return Fails(c)
-		}
+// TorrentState maps the statuses of a torrent to a qBittorrent state
+func TorrentState(v database.Torrent) string {
+	failed := v.Status == database.TorrentStatusError || v.InternalStatus == database.TorrentInternalError
+	if v.Paused && !failed && v.InternalStatus != database.TorrentInternalDownloaded {
+		return "pausedDL"
+	} else if v.Status == database.TorrentStatusQueued {
+		return "queuedDL"
+	} else if v.InternalStatus == database.TorrentInternalChecking {
+		return "checkingUP"
+	} else if v.InternalStatus == database.TorrentInternalError {
+		return "error"
+	} else if v.Status == database.TorrentStatusDownloading || (v.Status == database.TorrentStatusDownloaded && v.InternalStatus == database.TorrentInternalDownloading) {
+		return "downloading"
+	} else if v.Status == database.TorrentStatusDownloaded && v.InternalStatus == database.TorrentInternalDownloaded {
+		return "pausedUP"
+	} else if v.Status == database.TorrentStatusDownloaded && v.InternalStatus == database.TorrentInternalWaitingForDownload {
+		// downloaded by Real-Debrid, waiting for a local download slot
+		return "queuedDL"
+	} else if v.Status == database.TorrentStatusError {
+		return "error"
+	} else if v.Status == database.TorrentStatusMissing {
+		return "missingFiles"
+	} else if v.Status == database.TorrentStatusDownloading && v.RDSeeders == 0 {
+		return "stalledDL"
+	}
+	return "pausedDL"
+}
 
-		queryCategory = values[1]
+// stateFilters are the states matching each filter of /torrents/info
+var stateFilters = map[string][]string{
+	"downloading":         {"downloading", "metaDL", "forcedMetaDL", "stalledDL", "checkingDL", "pausedDL", "stoppedDL", "queuedDL", "forcedDL", "allocating"},
+	"seeding":             {"uploading", "stalledUP", "checkingUP", "queuedUP", "forcedUP"},
+	"completed":           {"uploading", "stalledUP", "checkingUP", "pausedUP", "stoppedUP", "queuedUP", "forcedUP"},
+	"paused":              {"pausedDL", "pausedUP", "stoppedDL", "stoppedUP"},
+	"stopped":             {"pausedDL", "pausedUP", "stoppedDL", "stoppedUP"},
+	"active":              {"downloading", "metaDL", "forcedMetaDL", "forcedDL", "uploading", "forcedUP"},
+	"stalled":             {"stalledDL", "stalledUP"},
+	"stalled_uploading":   {"stalledUP"},
+	"stalled_downloading": {"stalledDL"},
+	"checking":            {"checkingDL", "checkingUP", "checkingResumeData"},
+	"moving":              {"moving"},
+	"errored":             {"error", "missingFiles"},
+}
 
-		if queryCategory == "" {
-			return Fails(c)
-		}
-	} else {
-		queryCategory = c.QueryParam("category")
+// matchFilter tells if a state is selected by the filter parameter of /torrents/info
+func matchFilter(filter, state string) bool {
+	switch filter {
+	case "", "all":
+		return true
+	case "resumed", "running":
+		return !matchFilter("paused", state)
+	case "inactive":
+		return !matchFilter("active", state)
+	}
+
+	states, ok := stateFilters[filter]
+	if !ok {
+		return true
 	}
+	return slices.Contains(states, state)
+}
+
+func (q *QBittorrentTorrentApi) torrentsInfo(c echo.Context) error {
+	var torrents []database.Torrent
+	var err error
 
-	torrents, err := q.torrents.FindByCategory(queryCategory)
+	// no category means all of them, an empty one the torrents without category
+	if category, ok := formValue(c, "category"); ok {
+		torrents, err = q.torrents.FindByCategory(category)
+	} else {
+		torrents, err = q.torrents.FindAll()
+	}
 
 	if err != nil {
-		return Fails(c)
+		return InternalError(c)
+	}
+
+	filter, _ := formValue(c, "filter")
+
+	var hashes []string
+	if value, ok := formValue(c, "hashes"); ok && value != "" {
+		hashes = strings.Split(strings.ToLower(value), "|")
 	}
 
-	var torrentsInfo = make([]QbittorentTorrent, len(torrents))
+	var torrentsInfo = make([]QbittorentTorrent, 0, len(torrents))
+
+	for _, v := range torrents {
+		// jobs of the SABnzbd API are followed by the client that added them
+		if v.AddedBy == database.Sabnzbd {
+			continue
+		}
+
+		status := TorrentState(v)
+
+		if !matchFilter(filter, status) {
+			continue
+		}
+
+		if hashes != nil && !slices.Contains(hashes, strings.ToLower(v.RDHash)) {
+			continue
+		}
+
+		// like qBittorrent, -1 when the torrent is not in the queue
+		priority := v.Priority
+		if priority == 0 {
+			priority = -1
+		}
 
-	for i, v := range torrents {
 		remainingTime, err := CalculateRemainingTime(int64(v.RDSize), v.CreatedAt, v.RDProgress)
 
 		if err != nil {
 			remainingTime = 0
 		}
 
-		var status string
-		if v.Status == database.TorrentStatusDownloading || (v.Status == database.TorrentStatusDownloaded && v.InternalStatus == database.TorrentInternalDownloading) {
-			status = "downloading"
-		} else if v.Status == database.TorrentStatusDownloaded && v.InternalStatus == database.TorrentInternalDownloaded {
-			status = "pausedUP"
-		} else if v.Status == database.TorrentStatusError {
-			status = "error"
-		} else if v.Status == database.TorrentStatusDownloading && v.RDSeeders == 0 {
-			status = "stalledDL"
-		} else {
-			status = "paused"
-		}
-
-		torrentsInfo[i] = QbittorentTorrent{
+		torrentsInfo = append(torrentsInfo, QbittorentTorrent{
 			AddedOn:           v.CreatedAt.Unix(),
-			AmountLeft:        int64(v.RDSize) * int64(v.RDProgress) / 100,
+			AmountLeft:        int64(float64(v.RDSize) * (100 - v.RDProgress) / 100),
 			AutoTMM:           false,
 			Availability:      0,
 			Category:          v.Category,
 			Completed:         0,
 			CompletionOn:      time.Unix(0, int64(remainingTime.Nanoseconds())).Unix(),
-			ContentPath:       "",
+			ContentPath:       q.preference.CategorySavePath(v.Category) + string(os.PathSeparator) + v.RDName,
 			DLLimit:           0,
 			DLSpeed:           0,
 			Downloaded:        0,
@@ -328,11 +450,11 @@ func (q *QBittorrentTorrentApi) torrentsInfo(c echo.Context) error {
 			NumIncomplete:     0,
 			NumLeechs:         0,
 			NumSeeds:          0,
-			Priority:          0,
+			Priority:          priority,
 			Progress:          v.RDProgress / 100,
 			Ratio:             0,
 			RatioLimit:        0,
-			SavePath:          q.preference.GetSavePath() + string(os.PathSeparator) + v.Category,
+			SavePath:          q.preference.CategorySavePath(v.Category),
 			SeedingTime:       0,
 			SeedingTimeLimit:  0,
 			SeenComplete:      0,
@@ -348,93 +470,211 @@ func (q *QBittorrentTorrentApi) torrentsInfo(c echo.Context) error {
 			Uploaded:          0,
 			UploadedSession:   0,
 			UpSpeed:           0,
+		})
+	}
+
+	if sortKey, ok := formValue(c, "sort"); ok && sortKey != "" {
+		if err := sortTorrents(torrentsInfo, sortKey, formBool(c, "reverse")); err != nil {
+			return BadRequest(c)
 		}
 	}
 
+	offset, _ := formValue(c, "offset")
+	limit, _ := formValue(c, "limit")
+	torrentsInfo = paginate(torrentsInfo, offset, limit)
+
 	return c.JSON(200, torrentsInfo)
 }
 
-func (q *QBittorrentTorrentApi) torrentsFiles(c echo.Context) error {
-	var queryHash string
-	if c.Request().Method == "POST" {
-		body, err := io.ReadAll(c.Request().Body)
+// sortTorrents sorts by any field of the JSON response, like qBittorrent
+func sortTorrents(torrents []QbittorentTorrent, key string, reverse bool) error {
+	values := make([]interface{}, len(torrents))
+	for i, t := range torrents {
+		data, err := json.Marshal(t)
 		if err != nil {
-			return Fails(c)
+			return err
 		}
 
-		values := strings.Split(string(body), "=")
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
 
-		if len(values) != 2 {
-			return Fails(c)
+		value, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("unknown sort key %s", key)
 		}
+		values[i] = value
+	}
 
-		queryHash = values[1]
+	indexes := make([]int, len(torrents))
+	for i := range indexes {
+		indexes[i] = i
+	}
 
-		if queryHash == "" {
-			return Fails(c)
+	sort.SliceStable(indexes, func(a, b int) bool {
+		if reverse {
+			return lessValue(values[indexes[b]], values[indexes[a]])
 		}
-	} else {
-		queryHash = c.QueryParam("hash")
+		return lessValue(values[indexes[a]], values[indexes[b]])
+	})
 
-		if queryHash == "" {
-			return Fails(c)
-		}
+	sorted := make([]QbittorentTorrent, len(torrents))
+	for i, index := range indexes {
+		sorted[i] = torrents[index]
+	}
+	copy(torrents, sorted)
+
+	return nil
+}
+
+func lessValue(a, b interface{}) bool {
+	switch a := a.(type) {
+	case float64:
+		return a < b.(float64)
+	case string:
+		return strings.ToLower(a) < strings.ToLower(b.(string))
+	case bool:
+		return !a && b.(bool)
+	}
+	return false
+}
+
+// paginate applies the offset and limit parameters, a negative offset counts from the end
+func paginate(torrents []QbittorentTorrent, offsetValue, limitValue string) []QbittorentTorrent {
+	offset, _ := strconv.Atoi(offsetValue)
+	if offset < 0 {
+		offset = max(len(torrents)+offset, 0)
+	}
+
+	if offset >= len(torrents) {
+		return []QbittorentTorrent{}
 	}
+	torrents = torrents[offset:]
 
-	torrent, err := q.torrents.FindByHash(queryHash)
+	if limit, err := strconv.Atoi(limitValue); err == nil && limit > 0 && limit < len(torrents) {
+		torrents = torrents[:limit]
+	}
+
+	return torrents
+}
+
+// torrentFromHash returns the torrent of the hash parameter, answering 400 or 404 itself
+func (q *QBittorrentTorrentApi) torrentFromHash(c echo.Context) (*database.Torrent, error) {
+	hash, _ := formValue(c, "hash")
+
+	if hash == "" {
+		return nil, BadRequest(c)
+	}
+
+	torrent, err := q.torrents.FindByHash(strings.ToLower(hash))
 
 	if err != nil {
-		return Fails(c)
+		return nil, NotFound(c)
 	}
 
-	downlaods, err := q.torrents.FindAllDownloadByRdId(torrent.ID)
+	return torrent, nil
+}
+
+func (q *QBittorrentTorrentApi) torrentsFiles(c echo.Context) error {
+	torrent, err := q.torrentFromHash(c)
+
+	if torrent == nil {
+		return err
+	}
+
+	downloads, err := q.torrents.FindAllDownloadByRdId(torrent.ID)
 
 	if err != nil {
-		return Fails(c)
+		return InternalError(c)
+	}
+
+	// the .torrent file describes the files before Real-Debrid does
+	if len(torrent.TorrentFile) > 0 {
+		if meta, err := metainfo.Parse(torrent.TorrentFile); err == nil {
+			return c.JSON(200, metadataFiles(torrent, meta, downloads))
+		}
 	}
 
-	var files = make([]FileInfoResponse, len(downlaods))
+	var files = make([]FileInfoResponse, len(downloads))
 
-	for i, v := range downlaods {
+	for i, v := range downloads {
+		progress := fileProgress(&v)
 		files[i] = FileInfoResponse{
-			Name: string(os.PathSeparator) + torrent.RDName + string(os.PathSeparator) + v.FileName,
+			Index:        i,
+			Name:         string(os.PathSeparator) + torrent.RDName + string(os.PathSeparator) + v.FileName,
+			Size:         v.FileSize,
+			Progress:     progress,
+			Priority:     1,
+			IsSeed:       progress == 1,
+			PieceRange:   []int64{0, 0},
+			Availability: 1,
 		}
 	}
 
 	return c.JSON(200, files)
 }
 
-func (q *QBittorrentTorrentApi) torrentsProperties(c echo.Context) error {
-	var queryHash string
-	if c.Request().Method == "POST" {
-		body, err := io.ReadAll(c.Request().Body)
-		if err != nil {
-			return Fails(c)
-		}
+// metadataFiles lists the files of a .torrent file with the progress of their download
+func metadataFiles(torrent *database.Torrent, meta *metainfo.MetaInfo, downloads []database.Download) []FileInfoResponse {
+	byName := make(map[string]*database.Download, len(downloads))
+	for i := range downloads {
+		byName[downloads[i].FileName] = &downloads[i]
+	}
 
-		values := strings.Split(string(body), "=")
+	availability := torrent.RDProgress / 100
+	if torrent.Status == database.TorrentStatusDownloaded {
+		availability = 1
+	}
 
-		if len(values) != 2 {
-			return Fails(c)
+	files := make([]FileInfoResponse, len(meta.Files))
+	for i, f := range meta.Files {
+		name := strings.Join(f.Path, "/")
+		if len(meta.Files) > 1 {
+			name = meta.Name + "/" + name
 		}
 
-		queryHash = values[1]
+		var progress float64
+		if download, ok := byName[f.FileName()]; ok {
+			progress = fileProgress(download)
+		}
 
-		if queryHash == "" {
-			return Fails(c)
+		first := f.Offset / meta.PieceLength
+		last := first
+		if f.Length > 0 {
+			last = (f.Offset + f.Length - 1) / meta.PieceLength
 		}
-	} else {
-		queryHash = c.QueryParam("hash")
 
-		if queryHash == "" {
-			return Fails(c)
+		files[i] = FileInfoResponse{
+			Index:        i,
+			Name:         name,
+			Size:         f.Length,
+			Progress:     progress,
+			Priority:     1,
+			IsSeed:       progress == 1,
+			PieceRange:   []int64{first, last},
+			Availability: availability,
 		}
 	}
 
-	torrent, err := q.torrents.FindByHash(queryHash)
+	return files
+}
 
-	if err != nil {
-		return Fails(c)
+func fileProgress(download *database.Download) float64 {
+	if download.IsDownloaded {
+		return 1
+	}
+	if download.FileSize <= 0 {
+		return 0
+	}
+	return float64(download.Downloaded) / float64(download.FileSize)
+}
+
+func (q *QBittorrentTorrentApi) torrentsProperties(c echo.Context) error {
+	torrent, err := q.torrentFromHash(c)
+
+	if torrent == nil {
+		return err
 	}
 
 	var properties = TorrentPropertiesResponse{
@@ -456,7 +696,7 @@ func (q *QBittorrentTorrentApi) torrentsProperties(c echo.Context) error {
 		PiecesNum:             len(torrent.Downloads),
 		PieceSize:             0,
 		Reannounce:            0,
-		SavePath:              q.preference.GetSavePath() + string(os.PathSeparator) + string(os.PathSeparator) + torrent.Category,
+		SavePath:              q.preference.CategorySavePath(torrent.Category),
 		SeedingTime:           1,
 		Seeds:                 torrent.RDSeeders,
 		SeedsTotal:            torrent.RDSeeders,
@@ -473,155 +713,229 @@ func (q *QBittorrentTorrentApi) torrentsProperties(c echo.Context) error {
 		UpSpeedAvg:            0,
 	}
 
-	return c.JSON(200, properties)
+	// live values from the last refresh, without asking Real-Debrid
+	if info, ok := q.rdCache.Lookup(torrent.RDId); ok {
+		properties.AdditionDate = info.Added.Unix()
+		if info.Ended != nil {
+			properties.CompletionDate = info.Ended.Unix()
+		}
+		if info.Speed != nil {
+			properties.DlSpeed = int64(*info.Speed)
+			properties.DlSpeedAvg = int64(*info.Speed)
+			if *info.Speed > 0 {
+				properties.Eta = int64(float64(info.Bytes) * (100 - info.Progress) / 100 / float64(*info.Speed))
+			}
+		}
+		if info.Seeders != nil {
+			properties.Seeds = *info.Seeders
+			properties.SeedsTotal = *info.Seeders
+			properties.Peers = *info.Seeders
+			properties.PeersTotal = *info.Seeders
+		}
+	}
 
+	return c.JSON(200, properties)
 }
 
-func (q *QBittorrentTorrentApi) addTorrent(c echo.Context, content io.Reader, category string) error {
-	add, err := q.client.AddTorrent(content)
+func (q *QBittorrentTorrentApi) addTorrentFromFile(c echo.Context) error {
+	category, _ := formValue(c, "category")
 
-	if err != nil {
-		q.logger.Error("Failed to add torrent %s", err.Error())
+	if category != "" && !ValidCategoryName(category) {
 		return Fails(c)
 	}
 
-	rdTorrent, err := q.client.GetTorrent(add.Id)
-
-	if err != nil {
-		q.logger.Error("Failed to get torrent %s", err.Error())
-		return Fails(c)
+	if !q.account.CanAdd() {
+		q.logger.Error("Torrent refused, the Real-Debrid account is not premium")
+		return FailsBecause("The Real-Debrid account is not premium.", c)
 	}
 
-	var speed = 0
-	if rdTorrent.Speed != nil {
-		speed = *rdTorrent.Speed
+	if !q.category.Exist(category) && category != "" {
+		q.category.Create(database.NewCategory(category))
 	}
 
-	var seeders = 0
-	if rdTorrent.Seeders != nil {
-		seeders = *rdTorrent.Seeders
+	var added int
+
+	if urls, _ := formValue(c, "urls"); urls != "" {
+		// hoster links, downloaded together as a single torrent
+		var links []string
+
+		for _, url := range strings.Split(urls, "\n") {
+			url = strings.TrimSpace(url)
+			if url == "" {
+				continue
+			}
+
+			if !strings.HasPrefix(strings.ToLower(url), "magnet:") {
+				links = append(links, url)
+				continue
+			}
+
+			_, err := q.admission.AddMagnet(url, category, database.Qbittorent)
+
+			if errors.Is(err, jobs.ErrNotCached) {
+				q.logger.Info("Torrent refused: %s", err)
+				return FailsBecause("The torrent is not cached on Real-Debrid.", c)
+			}
+
+			if err != nil {
+				q.logger.Error("Failed to add magnet %s", err.Error())
+				continue
+			}
+
+			added++
+		}
+
+		if len(links) > 0 {
+			name, _ := formValue(c, "rename")
+			if _, err := q.admission.AddLinks(links, name, category, database.Qbittorent); err != nil {
+				q.logger.Error("Failed to add links %s", err.Error())
+			} else {
+				added++
+			}
+		}
 	}
 
-	var torrent = &database.Torrent{
-		Status:     database.TorrentStatusDownloading,
-		Type:       database.TorrentTypeFile,
-		Category:   category,
-		AddedBy:    database.Qbittorent,
-		RDId:       add.Id,
-		RDProgress: rdTorrent.Progress,
-		RDName:     rdTorrent.Filename,
-		RDSize:     rdTorrent.Bytes,
-		RDSplit:    rdTorrent.Split,
-		RDHost:     rdTorrent.Host,
-		RDSpeed:    speed,
-		RDSeeders:  seeders,
-		RDHash:     rdTorrent.Hash,
+	if form := c.Request().MultipartForm; form != nil {
+		for _, file := range form.File["torrents"] {
+			src, err := file.Open()
+
+			if err != nil {
+				q.logger.Error("Failed to open file %s", err.Error())
+				continue
+			}
+
+			// keep the .torrent file to verify the pieces once downloaded
+			torrentFile, err := io.ReadAll(src)
+			src.Close()
+
+			if err == nil {
+				_, err = q.admission.AddTorrent(torrentFile, category, database.Qbittorent)
+			}
+
+			if errors.Is(err, jobs.ErrInvalidTorrent) {
+				return UnsupportedMediaType("Error: '"+file.Filename+"' is not a valid torrent file.", c)
+			}
+
+			// the client moves on to another release
+			if errors.Is(err, jobs.ErrNotCached) {
+				q.logger.Info("Torrent refused: %s", err)
+				return FailsBecause("The torrent is not cached on Real-Debrid.", c)
+			}
+
+			if err != nil {
+				q.logger.Error("Failed to add torrent %s", err.Error())
+				continue
+			}
+
+			added++
+		}
 	}
 
-	if err := q.torrents.Create(torrent); err != nil {
-		q.logger.Error("Failed to save torrent %s", err.Error())
+	if added == 0 {
 		return Fails(c)
 	}
 
-	return Ok(c)
-}
+	go q.admission.Run()
 
-func (q *QBittorrentTorrentApi) addTorrentFromUrls(c echo.Context) error {
-	return nil
+	return Ok(c)
 }
 
-func (q *QBittorrentTorrentApi) addTorrentFromFile(c echo.Context) error {
-
-	var addTorrentRequest QbittorentAddRequest
+func (q *QBittorrentTorrentApi) deleteTorrent(c echo.Context) error {
+	hashes, _ := formValue(c, "hashes")
 
-	if err := c.Bind(&addTorrentRequest); err != nil {
-		return Fails(c)
+	if hashes == "" {
+		return BadRequest(c)
 	}
 
-	form, err := c.MultipartForm()
+	torrents, err := q.findByHashes(hashes)
 
 	if err != nil {
-		q.logger.Error("Failed to parse form %s", err.Error())
-		return Fails(c)
+		return InternalError(c)
 	}
 
-	files := form.File["torrents"]
+	deleteFiles := formBool(c, "deleteFiles")
 
-	if len(files) == 0 {
-		q.logger.Error("No files found")
-		return Fails(c)
+	for _, torrent := range torrents {
+		if err := jobs.DeleteTorrent(q.torrents, q.client, q.rdCache, q.preference.GetSavePath(), &torrent, deleteFiles, q.logger); err != nil {
+			return InternalError(c)
+		}
 	}
 
-	categoryForm := form.Value["category"]
-	var category string
-	if len(categoryForm) == 0 {
-		category = ""
-	} else {
-		category = categoryForm[0]
-	}
+	return c.String(200, "")
 
-	if !q.category.Exist(category) && category != "" {
-		q.category.Create(database.NewCategory(category))
-	}
+}
 
-	for _, file := range files {
-		src, err := file.Open()
+// findByHashes returns the torrents of a "hash1|hash2" list, or every torrent for "all".
+// Unknown hashes are ignored, like qBittorrent does.
+func (q *QBittorrentTorrentApi) findByHashes(hashes string) ([]database.Torrent, error) {
+	if hashes == "all" {
+		all, err := q.torrents.FindAll()
+		return slices.DeleteFunc(all, func(t database.Torrent) bool {
+			return t.AddedBy == database.Sabnzbd
+		}), err
+	}
 
+	var torrents []database.Torrent
+	for _, h := range strings.Split(hashes, "|") {
+		torrent, err := q.torrents.FindByHash(strings.ToLower(h))
 		if err != nil {
-			q.logger.Error("Failed to open file %s", err.Error())
-			return Fails(c)
+			continue
 		}
+		torrents = append(torrents, *torrent)
+	}
 
-		defer src.Close()
-
-		err = q.addTorrent(c, src, category)
+	return torrents, nil
+}
 
-		if errors.Is(err, Fails(c)) && err != nil {
-			q.logger.Error("Failed to add torrent %s", err.Error())
-			return Fails(c)
-		}
+func (q *QBittorrentTorrentApi) recheckTorrents(c echo.Context) error {
+	hashes, _ := formValue(c, "hashes")
 
+	if hashes == "" {
+		return BadRequest(c)
 	}
 
-	return Ok(c)
-}
+	torrents, err := q.findByHashes(hashes)
 
-func (q *QBittorrentTorrentApi) deleteTorrent(c echo.Context) error {
-	// print body
-	body, err := io.ReadAll(c.Request().Body)
 	if err != nil {
-		return Fails(c)
+		return InternalError(c)
 	}
 
-	values := strings.Split(string(body), "&")
+	for _, torrent := range torrents {
+		// files still being downloaded are verified when they finish, streamed ones are not on disk
+		if torrent.Streamed || !q.torrents.HasDownload(torrent.ID) || q.torrents.HavePendingDownloads(torrent.ID) {
+			continue
+		}
 
-	savePath := q.preference.GetSavePath()
+		q.verifier.Start(torrent.ID)
+	}
 
-	// exemple value = "hashes=5cw3eirtiyij4&deleteFiles=true"
-	// get hash but search for "hashes=" and remove it
-	for _, v := range values {
-		if strings.Contains(v, "hashes=") {
-			hash := strings.Replace(v, "hashes=", "", 1)
-			// split hash with '|'
-			hashes := strings.Split(hash, "|")
-			for _, h := range hashes {
-				torrent, err := q.torrents.FindByHash(h)
+	return c.String(200, "")
+}
 
-				if err != nil {
-					return Fails(c)
-				}
+func (q *QBittorrentTorrentApi) changePriority(move func(ids []uint) error) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		hashes, _ := formValue(c, "hashes")
 
-				if err := os.RemoveAll(savePath + string(os.PathSeparator) + torrent.Category + string(os.PathSeparator) + torrent.RDName); err != nil {
-					return Fails(c)
-				}
+		if hashes == "" {
+			return BadRequest(c)
+		}
 
-				if err := q.torrents.DeleteByRDId(h); err != nil {
-					return Fails(c)
-				}
-			}
+		torrents, err := q.findByHashes(hashes)
+
+		if err != nil {
+			return InternalError(c)
 		}
-	}
 
-	return Ok(c)
+		ids := make([]uint, len(torrents))
+		for i, t := range torrents {
+			ids[i] = t.ID
+		}
 
+		if err := move(ids); err != nil {
+			q.logger.Error("Failed to change priority %s", err.Error())
+			return InternalError(c)
+		}
+
+		return c.String(200, "")
+	}
 }
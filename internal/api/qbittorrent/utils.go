@@ -2,6 +2,7 @@ package qbittorrent
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -16,11 +17,70 @@ var (
 		return c.String(200, body)
 	}
 
+	// Fails is qBittorrent's answer to a login or an add that did not work, with a 200
 	Fails = func(c echo.Context) error {
-		return c.String(500, "Fails.")
+		return c.String(200, "Fails.")
+	}
+
+	// FailsBecause adds the reason after "Fails.", clients only check that the answer is not "Ok."
+	// and show it to the user
+	FailsBecause = func(reason string, c echo.Context) error {
+		return c.String(200, "Fails. "+reason)
+	}
+
+	// BadRequest is returned when a required parameter is missing or invalid
+	BadRequest = func(c echo.Context) error {
+		return c.String(400, "")
+	}
+
+	Forbidden = func(c echo.Context) error {
+		return c.String(403, "Forbidden")
+	}
+
+	NotFound = func(c echo.Context) error {
+		return c.String(404, "")
+	}
+
+	MethodNotAllowed = func(c echo.Context) error {
+		return c.String(405, "Method Not Allowed")
+	}
+
+	Conflict = func(message string, c echo.Context) error {
+		return c.String(409, message)
+	}
+
+	UnsupportedMediaType = func(message string, c echo.Context) error {
+		return c.String(415, message)
+	}
+
+	InternalError = func(c echo.Context) error {
+		return c.String(500, "")
 	}
 )
 
+// formValue returns a parameter from the query string or the form body,
+// and whether it was sent at all
+func formValue(c echo.Context, name string) (string, bool) {
+	req := c.Request()
+	if strings.HasPrefix(req.Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		req.ParseMultipartForm(32 << 20)
+	} else {
+		req.ParseForm()
+	}
+
+	values, ok := req.Form[name]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// formBool parses a qBittorrent boolean parameter, "true" or "false"
+func formBool(c echo.Context, name string) bool {
+	value, _ := formValue(c, name)
+	return strings.EqualFold(value, "true")
+}
+
 func CalculateRemainingTime(totalSize int64, startTime time.Time, percentageDownloaded float64) (time.Duration, error) {
 	if percentageDownloaded <= 0 || percentageDownloaded >= 100 {
 		return 0, fmt.Errorf("le pourcentage téléchargé doit être compris entre 0 et 100")
@@ -0,0 +1,30 @@
+package qbrdt
+
+import (
+	"net/http"
+
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+// QbrdtAccountApi serves the state of the Real-Debrid account
+type QbrdtAccountApi struct {
+	account *jobs.AccountMonitor
+}
+
+func NewQbrdtAccountApi(g *echo.Group, account *jobs.AccountMonitor) *QbrdtAccountApi {
+	accountApi := &QbrdtAccountApi{account: account}
+
+	g.GET("/account", accountApi.get)
+
+	return accountApi
+}
+
+func (a *QbrdtAccountApi) get(c echo.Context) error {
+	account, ok := a.account.Account()
+	if !ok {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "the Real-Debrid account was not checked yet"})
+	}
+
+	return c.JSON(http.StatusOK, account)
+}
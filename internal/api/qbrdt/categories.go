@@ -0,0 +1,102 @@
+package qbrdt
+
+import (
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/labstack/echo/v4"
+)
+
+// QbrdtCategoryApi manages the categories, the folders of the save path the torrents are saved in
+type QbrdtCategoryApi struct {
+	preferences *database.PreferencesRepository
+	categories  *database.CategoryRepository
+	torrents    *database.TorrentRepository
+}
+
+// Category is a category of the JSON API
+type Category struct {
+	Name string `json:"name"`
+	// torrents of categories with a higher priority are downloaded first
+	Priority int    `json:"priority"`
+	SavePath string `json:"save_path"`
+}
+
+func NewQbrdtCategoryApi(g *echo.Group,
+	preferences *database.PreferencesRepository,
+	categories *database.CategoryRepository,
+	torrents *database.TorrentRepository,
+) *QbrdtCategoryApi {
+	categoryApi := &QbrdtCategoryApi{
+		preferences: preferences,
+		categories:  categories,
+		torrents:    torrents,
+	}
+
+	g.GET("/categories", categoryApi.list)
+	g.POST("/categories", categoryApi.save)
+	g.DELETE("/categories/:name", categoryApi.delete)
+
+	return categoryApi
+}
+
+func (a *QbrdtCategoryApi) list(c echo.Context) error {
+	categories, err := a.categories.FindAll()
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name < categories[j].Name
+	})
+
+	views := make([]Category, len(categories))
+	for i, category := range categories {
+		views[i] = Category{
+			Name:     category.Name,
+			Priority: category.Priority,
+			SavePath: a.preferences.GetSavePath() + string(os.PathSeparator) + category.Name,
+		}
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+// save creates a category or changes its priority
+func (a *QbrdtCategoryApi) save(c echo.Context) error {
+	var request Category
+	if err := c.Bind(&request); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if !qbittorrent.ValidCategoryName(request.Name) {
+		return apiError(c, http.StatusBadRequest, "invalid category")
+	}
+
+	if err := a.categories.SetPriority(request.Name, request.Priority); err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// delete removes a category no torrent is in
+func (a *QbrdtCategoryApi) delete(c echo.Context) error {
+	name := c.Param("name")
+	if !a.categories.Exist(name) {
+		return apiError(c, http.StatusNotFound, "unknown category")
+	}
+
+	if torrents, err := a.torrents.FindByCategory(name); err != nil || len(torrents) > 0 {
+		return apiError(c, http.StatusConflict, "torrents are in the category")
+	}
+
+	if err := a.categories.Delete(name); err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
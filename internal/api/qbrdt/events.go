@@ -0,0 +1,64 @@
+package qbrdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/events"
+	"github.com/labstack/echo/v4"
+)
+
+// a comment is sent this often so that proxies keep the stream open
+const keepAlive = 15 * time.Second
+
+// QbrdtEventsApi streams the events of the torrents and of their downloads as Server-Sent Events
+type QbrdtEventsApi struct {
+	bus *events.Bus
+}
+
+func NewQbrdtEventsApi(g *echo.Group, bus *events.Bus) *QbrdtEventsApi {
+	eventsApi := &QbrdtEventsApi{bus: bus}
+
+	g.GET("/events", eventsApi.stream)
+
+	return eventsApi
+}
+
+func (a *QbrdtEventsApi) stream(c echo.Context) error {
+	stream, cancel := a.bus.Subscribe()
+	defer cancel()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	// proxies like nginx would buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	w.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-stream:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		w.Flush()
+	}
+}
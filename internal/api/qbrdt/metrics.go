@@ -0,0 +1,78 @@
+package qbrdt
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/labstack/echo/v4"
+)
+
+// QbrdtMetricsApi serves metrics in the Prometheus text format
+type QbrdtMetricsApi struct {
+	account *jobs.AccountMonitor
+}
+
+// NewQbrdtMetricsApi serves /metrics behind auth, a group at the root would answer
+// 403 instead of 404 to every unknown path
+func NewQbrdtMetricsApi(e *echo.Echo, auth echo.MiddlewareFunc, account *jobs.AccountMonitor) *QbrdtMetricsApi {
+	metricsApi := &QbrdtMetricsApi{account: account}
+
+	e.GET("/metrics", metricsApi.get, auth)
+
+	return metricsApi
+}
+
+type metrics struct {
+	strings.Builder
+}
+
+func (m *metrics) gauge(name, help string, value interface{}) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+}
+
+func (m *metrics) labeled(name, help, label string, values map[string]int64) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(m, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func boolGauge(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (a *QbrdtMetricsApi) get(c echo.Context) error {
+	var m metrics
+
+	account, ok := a.account.Account()
+	m.gauge("qbrdt_account_checked", "1 once the Real-Debrid account was checked", boolGauge(ok))
+
+	if ok {
+		m.gauge("qbrdt_account_premium", "1 if the Real-Debrid account is premium", boolGauge(account.Premium))
+		m.gauge("qbrdt_account_premium_seconds_left", "Seconds left as a premium user", account.PremiumLeft)
+		m.gauge("qbrdt_account_expiring", "1 if the premium ends soon", boolGauge(account.Expiring))
+		m.gauge("qbrdt_account_points", "Real-Debrid fidelity points", account.Points)
+		m.gauge("qbrdt_account_checked_timestamp_seconds", "Time of the last check of the account", account.CheckedAt.Unix())
+
+		left := make(map[string]int64, len(account.Traffic))
+		for host, traffic := range account.Traffic {
+			left[host] = traffic.Left
+		}
+		m.labeled("qbrdt_account_traffic_left", "Traffic left on the limited hosts, in the unit of the host", "host", left)
+	}
+
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(m.String()))
+}
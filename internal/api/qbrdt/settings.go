@@ -0,0 +1,95 @@
+package qbrdt
+
+import (
+	"net/http"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/labstack/echo/v4"
+)
+
+// QbrdtSettingsApi changes the settings of the downloads while qbrdt runs,
+// the configuration file applies again on restart
+type QbrdtSettingsApi struct {
+	preferences *database.PreferencesRepository
+	queue       *queue.DownloadQueue
+	downloader  *downloader.Downloader
+}
+
+// Settings are the settings of the JSON API, the save path can't be changed
+type Settings struct {
+	SavePath string `json:"save_path"`
+	// no new download starts while the queue is paused
+	QueuePaused  bool `json:"queue_paused"`
+	MaxDownloads int  `json:"max_downloads"`
+	// KB/s per connection, 0 for unlimited
+	SpeedLimit int `json:"speed_limit"`
+}
+
+// settingsRequest leaves out the settings not sent
+type settingsRequest struct {
+	QueuePaused  *bool `json:"queue_paused"`
+	MaxDownloads *int  `json:"max_downloads"`
+	SpeedLimit   *int  `json:"speed_limit"`
+}
+
+func NewQbrdtSettingsApi(g *echo.Group,
+	preferences *database.PreferencesRepository,
+	queue *queue.DownloadQueue,
+	downloader *downloader.Downloader,
+) *QbrdtSettingsApi {
+	settingsApi := &QbrdtSettingsApi{
+		preferences: preferences,
+		queue:       queue,
+		downloader:  downloader,
+	}
+
+	g.GET("/settings", settingsApi.get)
+	g.PUT("/settings", settingsApi.set)
+
+	return settingsApi
+}
+
+func (a *QbrdtSettingsApi) settings() Settings {
+	return Settings{
+		SavePath:     a.preferences.GetSavePath(),
+		QueuePaused:  a.queue.Paused(),
+		MaxDownloads: a.queue.MaxDownloads(),
+		SpeedLimit:   a.downloader.SpeedLimit(),
+	}
+}
+
+func (a *QbrdtSettingsApi) get(c echo.Context) error {
+	return c.JSON(http.StatusOK, a.settings())
+}
+
+func (a *QbrdtSettingsApi) set(c echo.Context) error {
+	var request settingsRequest
+	if err := c.Bind(&request); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if request.MaxDownloads != nil && *request.MaxDownloads < 1 {
+		return apiError(c, http.StatusBadRequest, "max_downloads must be at least 1")
+	}
+	if request.SpeedLimit != nil && *request.SpeedLimit < 0 {
+		return apiError(c, http.StatusBadRequest, "speed_limit can't be negative")
+	}
+
+	if request.MaxDownloads != nil {
+		a.queue.SetMaxDownloads(*request.MaxDownloads)
+	}
+	if request.SpeedLimit != nil {
+		a.downloader.SetSpeedLimit(*request.SpeedLimit)
+	}
+	if request.QueuePaused != nil {
+		if *request.QueuePaused {
+			a.queue.Pause()
+		} else {
+			a.queue.Resume()
+		}
+	}
+
+	return c.JSON(http.StatusOK, a.settings())
+}
@@ -0,0 +1,404 @@
+package qbrdt
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/labstack/echo/v4"
+)
+
+// QbrdtTorrentApi manages every torrent, whatever API added it
+type QbrdtTorrentApi struct {
+	preferences *database.PreferencesRepository
+	categories  *database.CategoryRepository
+	torrents    *database.TorrentRepository
+	client      *realdebrid.Client
+	rdCache     *realdebrid.Cache
+	admission   *jobs.TorrentAdmission
+	queue       *queue.DownloadQueue
+	logger      logger.Interface
+}
+
+// Torrent is a torrent of the JSON API
+type Torrent struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// qBittorrent state, see the qBittorrent API
+	State          string                         `json:"state"`
+	Type           database.TorrentType           `json:"type"`
+	AddedBy        database.AddedBy               `json:"added_by"`
+	AddedOn        time.Time                      `json:"added_on"`
+	RDStatus       database.TorrentStatus         `json:"rd_status"`
+	InternalStatus database.TorrentInternalStatus `json:"internal_status"`
+	Paused         bool                           `json:"paused"`
+	Streamed       bool                           `json:"streamed"`
+	// position in the download queue, 0 once downloaded
+	Priority int   `json:"priority"`
+	Size     int64 `json:"size"`
+	// progress of the download by Real-Debrid, from 0 to 1
+	CloudProgress float64 `json:"cloud_progress"`
+	// speed of the download by Real-Debrid in bytes/s
+	CloudSpeed int64 `json:"cloud_speed"`
+	Seeders    int   `json:"seeders"`
+	// progress of the download from Real-Debrid, from 0 to 1
+	LocalProgress float64 `json:"local_progress"`
+	Downloaded    int64   `json:"downloaded"`
+	// speed of the download from Real-Debrid in bytes/s
+	Speed int64 `json:"speed"`
+	// why the torrent failed, empty if it did not
+	Error string `json:"error"`
+	Files []File `json:"files"`
+}
+
+// File is a file of a torrent downloaded from Real-Debrid
+type File struct {
+	Name        string  `json:"name"`
+	Size        int64   `json:"size"`
+	Downloaded  int64   `json:"downloaded"`
+	Progress    float64 `json:"progress"`
+	Speed       int64   `json:"speed"`
+	Downloading bool    `json:"downloading"`
+	Repairs     int     `json:"repairs"`
+	Error       string  `json:"error"`
+}
+
+func NewQbrdtTorrentApi(g *echo.Group,
+	l logger.Interface,
+	preferences *database.PreferencesRepository,
+	categories *database.CategoryRepository,
+	torrents *database.TorrentRepository,
+	client *realdebrid.Client,
+	rdCache *realdebrid.Cache,
+	admission *jobs.TorrentAdmission,
+	queue *queue.DownloadQueue,
+) *QbrdtTorrentApi {
+	torrentApi := &QbrdtTorrentApi{
+		preferences: preferences,
+		categories:  categories,
+		torrents:    torrents,
+		client:      client,
+		rdCache:     rdCache,
+		admission:   admission,
+		queue:       queue,
+		logger:      l,
+	}
+
+	g.GET("/torrents", torrentApi.list)
+	g.POST("/torrents", torrentApi.add)
+	g.GET("/torrents/:hash", torrentApi.get)
+	g.DELETE("/torrents/:hash", torrentApi.delete)
+	g.POST("/torrents/:hash/pause", torrentApi.pause(true))
+	g.POST("/torrents/:hash/resume", torrentApi.pause(false))
+	g.POST("/torrents/:hash/retry", torrentApi.retry)
+	g.PUT("/torrents/:hash/category", torrentApi.setCategory)
+
+	return torrentApi
+}
+
+func apiError(c echo.Context, status int, message string) error {
+	return c.JSON(status, map[string]string{"error": message})
+}
+
+func (a *QbrdtTorrentApi) view(torrent database.Torrent) Torrent {
+	view := Torrent{
+		Hash:           torrent.RDHash,
+		Name:           torrent.RDName,
+		Category:       torrent.Category,
+		State:          qbittorrent.TorrentState(torrent),
+		Type:           torrent.Type,
+		AddedBy:        torrent.AddedBy,
+		AddedOn:        torrent.CreatedAt,
+		RDStatus:       torrent.Status,
+		InternalStatus: torrent.InternalStatus,
+		Paused:         torrent.Paused,
+		Streamed:       torrent.Streamed,
+		Priority:       torrent.Priority,
+		Size:           int64(torrent.RDSize),
+		CloudProgress:  torrent.RDProgress / 100,
+		CloudSpeed:     int64(torrent.RDSpeed),
+		Seeders:        torrent.RDSeeders,
+		Files:          []File{},
+	}
+
+	if torrent.Status == database.TorrentStatusDownloaded {
+		view.CloudProgress = 1
+	}
+
+	var total int64
+	for _, download := range torrent.Downloads {
+		file := File{
+			Name:       download.FileName,
+			Size:       download.FileSize,
+			Downloaded: download.Downloaded,
+			Repairs:    download.Repairs,
+			Error:      download.Error,
+		}
+
+		if progress, ok := a.queue.Progress(download.ID); ok {
+			file.Downloading = true
+			file.Downloaded = progress.Downloaded
+			file.Speed = int64(progress.Speed)
+		} else if download.IsDownloaded {
+			file.Downloaded = download.FileSize
+		}
+
+		if download.FileSize > 0 {
+			file.Progress = float64(file.Downloaded) / float64(download.FileSize)
+		}
+
+		total += download.FileSize
+		view.Downloaded += file.Downloaded
+		view.Speed += file.Speed
+		view.Files = append(view.Files, file)
+
+		if view.Error == "" && download.Error != "" && !download.IsDownloaded {
+			view.Error = download.Error
+		}
+	}
+
+	if total > 0 {
+		view.LocalProgress = float64(view.Downloaded) / float64(total)
+	}
+
+	switch {
+	case torrent.Status == database.TorrentStatusError && torrent.Error != "":
+		view.Error = torrent.Error
+	case torrent.Status == database.TorrentStatusError:
+		view.Error = "Failed on Real-Debrid"
+	case torrent.Status == database.TorrentStatusMissing:
+		view.Error = "Removed from Real-Debrid"
+	case torrent.InternalStatus == database.TorrentInternalError && view.Error == "":
+		view.Error = "Download failed"
+	case torrent.InternalStatus != database.TorrentInternalError:
+		// the error of a download retried by the verifier
+		view.Error = ""
+	}
+
+	return view
+}
+
+func (a *QbrdtTorrentApi) list(c echo.Context) error {
+	torrents, err := a.torrents.FindAllWithDownloads()
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	category := c.QueryParam("category")
+	views := make([]Torrent, 0, len(torrents))
+	for _, torrent := range torrents {
+		if category != "" && torrent.Category != category {
+			continue
+		}
+		views = append(views, a.view(torrent))
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+// torrentFromHash returns the torrent of the hash parameter, answering 404 itself
+func (a *QbrdtTorrentApi) torrentFromHash(c echo.Context) (*database.Torrent, error) {
+	torrent, err := a.torrents.FindByHash(strings.ToLower(c.Param("hash")))
+	if err != nil {
+		return nil, apiError(c, http.StatusNotFound, "unknown torrent")
+	}
+
+	torrent.Downloads, err = a.torrents.FindAllDownloadByRdId(torrent.ID)
+	if err != nil {
+		return nil, apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return torrent, nil
+}
+
+func (a *QbrdtTorrentApi) get(c echo.Context) error {
+	torrent, err := a.torrentFromHash(c)
+	if torrent == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, a.view(*torrent))
+}
+
+type addResult struct {
+	Added  []string `json:"added"`
+	Errors []string `json:"errors"`
+}
+
+// add takes a form with magnets or hoster links in urls, one per line, and .torrent files in torrents
+func (a *QbrdtTorrentApi) add(c echo.Context) error {
+	category := c.FormValue("category")
+	paused, _ := strconv.ParseBool(c.FormValue("paused"))
+
+	if category != "" && !qbittorrent.ValidCategoryName(category) {
+		return apiError(c, http.StatusBadRequest, "invalid category")
+	}
+
+	if category != "" && !a.categories.Exist(category) {
+		a.categories.Create(database.NewCategory(category))
+	}
+
+	result := addResult{Added: []string{}, Errors: []string{}}
+	added := func(torrent *database.Torrent, err error) {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			return
+		}
+
+		if paused {
+			if err := a.torrents.SetPaused([]uint{torrent.ID}, true); err != nil {
+				a.logger.Error("Failed to pause %s: %s", torrent.RDName, err)
+			}
+		}
+		result.Added = append(result.Added, torrent.RDHash)
+	}
+
+	var links []string
+	for _, url := range strings.Split(c.FormValue("urls"), "\n") {
+		url = strings.TrimSpace(url)
+		switch {
+		case url == "":
+		case strings.HasPrefix(strings.ToLower(url), "magnet:"):
+			added(a.admission.AddMagnet(url, category, database.WebInterface))
+		default:
+			links = append(links, url)
+		}
+	}
+
+	if len(links) > 0 {
+		added(a.admission.AddLinks(links, c.FormValue("name"), category, database.WebInterface))
+	}
+
+	if form, err := c.MultipartForm(); err == nil {
+		for _, file := range form.File["torrents"] {
+			src, err := file.Open()
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				continue
+			}
+
+			torrentFile, err := io.ReadAll(src)
+			src.Close()
+			if err != nil {
+				result.Errors = append(result.Errors, err.Error())
+				continue
+			}
+
+			torrent, err := a.admission.AddTorrent(torrentFile, category, database.WebInterface)
+			if errors.Is(err, jobs.ErrInvalidTorrent) {
+				err = errors.New(file.Filename + " is not a valid torrent file")
+			}
+			added(torrent, err)
+		}
+	}
+
+	if len(result.Added) == 0 {
+		if len(result.Errors) == 0 {
+			result.Errors = append(result.Errors, "nothing to add")
+		}
+		return c.JSON(http.StatusBadRequest, result)
+	}
+
+	go a.admission.Run()
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (a *QbrdtTorrentApi) delete(c echo.Context) error {
+	torrent, err := a.torrentFromHash(c)
+	if torrent == nil {
+		return err
+	}
+
+	deleteFiles, _ := strconv.ParseBool(c.QueryParam("delete_files"))
+	if err := jobs.DeleteTorrent(a.torrents, a.client, a.rdCache, a.preferences.GetSavePath(), torrent, deleteFiles, a.logger); err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// pause stops or starts a torrent, like the torrent-stop of the Transmission RPC
+func (a *QbrdtTorrentApi) pause(paused bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		torrent, err := a.torrentFromHash(c)
+		if torrent == nil {
+			return err
+		}
+
+		if err := a.torrents.SetPaused([]uint{torrent.ID}, paused); err != nil {
+			return apiError(c, http.StatusInternalServerError, err.Error())
+		}
+
+		if !paused {
+			a.queue.Wake()
+			go a.admission.Run()
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+func (a *QbrdtTorrentApi) retry(c echo.Context) error {
+	torrent, err := a.torrentFromHash(c)
+	if torrent == nil {
+		return err
+	}
+
+	failed := torrent.Status == database.TorrentStatusError || torrent.Status == database.TorrentStatusMissing ||
+		torrent.InternalStatus == database.TorrentInternalError
+	if !failed {
+		return apiError(c, http.StatusConflict, "the torrent did not fail")
+	}
+
+	a.logger.Info("Retrying %s", torrent.RDName)
+	if err := a.torrents.Retry(torrent); err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	a.queue.Wake()
+	go a.admission.Run()
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type categoryRequest struct {
+	Category string `json:"category"`
+}
+
+// setCategory moves a torrent to another category, with its files once downloaded
+func (a *QbrdtTorrentApi) setCategory(c echo.Context) error {
+	torrent, err := a.torrentFromHash(c)
+	if torrent == nil {
+		return err
+	}
+
+	var request categoryRequest
+	if err := c.Bind(&request); err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+
+	if request.Category != "" && !a.categories.Exist(request.Category) {
+		return apiError(c, http.StatusBadRequest, "unknown category")
+	}
+
+	err = jobs.SetCategory(a.torrents, a.preferences.GetSavePath(), torrent, request.Category)
+	if errors.Is(err, jobs.ErrTorrentDownloading) {
+		return apiError(c, http.StatusConflict, "the torrent is downloading")
+	}
+	if err != nil {
+		return apiError(c, http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,20 @@
+package qbrdt
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed ui
+var ui embed.FS
+
+// NewQbrdtWebUi serves the web UI on /, built on the JSON API of /api/qbrdt.
+// It logs in with the login of the qBittorrent API.
+func NewQbrdtWebUi(e *echo.Echo) {
+	e.StaticFS("/ui", echo.MustSubFS(ui, "ui"))
+	e.GET("/", func(c echo.Context) error {
+		return c.Redirect(http.StatusFound, "/ui/")
+	})
+}
@@ -0,0 +1,292 @@
+'use strict';
+
+const api = '/api/qbrdt';
+const expanded = new Set();
+let view = 'torrents';
+let timer = null;
+let events = null;
+let pending = null;
+
+async function request(method, path, body) {
+  const options = { method, credentials: 'same-origin', headers: {} };
+  if (body instanceof FormData) {
+    options.body = body;
+  } else if (body !== undefined) {
+    options.headers['Content-Type'] = 'application/json';
+    options.body = JSON.stringify(body);
+  }
+
+  const response = await fetch(api + path, options);
+  if (response.status === 403) {
+    showLogin();
+    throw new Error('Not logged in');
+  }
+
+  const text = await response.text();
+  const data = text ? JSON.parse(text) : null;
+  if (!response.ok) {
+    throw new Error((data && (data.error || (data.errors || []).join(', '))) || response.statusText);
+  }
+  return data;
+}
+
+function el(tag, attributes, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attributes || {})) {
+    if (key.startsWith('on')) {
+      node.addEventListener(key.slice(2), value);
+    } else {
+      node.setAttribute(key, value);
+    }
+  }
+  for (const child of children) {
+    node.append(child instanceof Node ? child : document.createTextNode(child ?? ''));
+  }
+  return node;
+}
+
+function size(bytes) {
+  const units = ['B', 'KB', 'MB', 'GB', 'TB'];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return bytes.toFixed(i ? 1 : 0) + ' ' + units[i];
+}
+
+function bar(progress) {
+  const percent = Math.floor(progress * 1000) / 10;
+  return el('div', { class: 'bar' }, el('div', { style: 'width:' + percent + '%' }), el('span', {}, percent + '%'));
+}
+
+function action(label, run) {
+  return el('button', {
+    onclick: async (event) => {
+      event.stopPropagation();
+      try {
+        await run();
+        refresh();
+      } catch (e) {
+        alert(e.message);
+      }
+    },
+  }, label);
+}
+
+let categories = [];
+
+function categorySelect(current, onchange) {
+  const select = el('select', { onclick: (event) => event.stopPropagation(), onchange });
+  for (const name of ['', ...categories.map((c) => c.name)]) {
+    const option = el('option', { value: name }, name || '(none)');
+    option.selected = name === current;
+    select.append(option);
+  }
+  return select;
+}
+
+function torrentRows(torrent) {
+  const hash = encodeURIComponent(torrent.hash);
+  const state = torrent.error ? torrent.state + ': ' + torrent.error : torrent.state;
+  const actions = el('td', { class: 'actions' },
+    torrent.paused
+      ? action('Resume', () => request('POST', '/torrents/' + hash + '/resume'))
+      : action('Pause', () => request('POST', '/torrents/' + hash + '/pause')),
+    torrent.error ? action('Retry', () => request('POST', '/torrents/' + hash + '/retry')) : '',
+    action('Delete', () => confirm('Delete ' + torrent.name + '?') && request('DELETE', '/torrents/' + hash)),
+    action('Delete with files', () => confirm('Delete ' + torrent.name + ' and its files?') && request('DELETE', '/torrents/' + hash + '?delete_files=true')),
+  );
+
+  const category = categorySelect(torrent.category, async (event) => {
+    try {
+      await request('PUT', '/torrents/' + hash + '/category', { category: event.target.value });
+    } catch (e) {
+      alert(e.message);
+    }
+    refresh();
+  });
+
+  const row = el('tr', {
+    class: 'torrent',
+    onclick: () => {
+      expanded.has(torrent.hash) ? expanded.delete(torrent.hash) : expanded.add(torrent.hash);
+      refresh();
+    },
+  },
+    el('td', {}, torrent.name || torrent.hash),
+    el('td', {}, category),
+    el('td', { class: torrent.error ? 'error' : '' }, state),
+    el('td', {}, bar(torrent.cloud_progress), torrent.cloud_speed ? size(torrent.cloud_speed) + '/s' : ''),
+    el('td', {}, bar(torrent.local_progress)),
+    el('td', {}, torrent.speed ? size(torrent.speed) + '/s' : ''),
+    el('td', {}, size(torrent.size)),
+    actions,
+  );
+
+  const rows = [row];
+  if (expanded.has(torrent.hash)) {
+    const files = torrent.files.length
+      ? torrent.files.map((file) => el('div', {},
+        file.name + ' — ' + size(file.downloaded) + ' / ' + size(file.size),
+        bar(file.progress),
+        file.speed ? size(file.speed) + '/s' : '',
+        file.repairs ? ' (' + file.repairs + ' retries)' : '',
+        file.error ? el('span', { class: 'error' }, ' ' + file.error) : ''))
+      : ['No file yet, Real-Debrid status: ' + torrent.rd_status];
+    rows.push(el('tr', { class: 'files' }, el('td', { colspan: 8 }, ...files)));
+  }
+  return rows;
+}
+
+async function refreshTorrents() {
+  const torrents = await request('GET', '/torrents');
+  const speed = torrents.reduce((sum, t) => sum + t.speed, 0);
+  document.getElementById('summary').textContent = torrents.length + ' torrents, downloading at ' + size(speed) + '/s';
+  document.getElementById('torrent-list').replaceChildren(...torrents.flatMap(torrentRows));
+}
+
+async function refreshCategories() {
+  categories = await request('GET', '/categories');
+  for (const select of document.querySelectorAll('select.categories')) {
+    const current = select.value;
+    select.replaceWith(Object.assign(categorySelect(current), { name: 'category', className: 'categories' }));
+  }
+  document.getElementById('category-list').replaceChildren(...categories.map((category) => el('tr', {},
+    el('td', {}, category.name),
+    el('td', {}, String(category.priority)),
+    el('td', {}, category.save_path),
+    el('td', { class: 'actions' }, action('Delete', () => request('DELETE', '/categories/' + encodeURIComponent(category.name)))),
+  )));
+}
+
+async function refreshSettings() {
+  const settings = await request('GET', '/settings');
+  const form = document.getElementById('settings-form');
+  form.save_path.value = settings.save_path;
+  form.max_downloads.value = settings.max_downloads;
+  form.speed_limit.value = settings.speed_limit;
+  form.queue_paused.checked = settings.queue_paused;
+}
+
+async function refresh() {
+  try {
+    if (view === 'torrents') {
+      await refreshTorrents();
+    }
+  } catch (e) {
+    console.error(e);
+  }
+}
+
+function show(name) {
+  view = name;
+  for (const section of document.querySelectorAll('main section')) {
+    section.hidden = section.id !== name;
+  }
+  for (const button of document.querySelectorAll('nav button[data-view]')) {
+    button.classList.toggle('active', button.dataset.view === name);
+  }
+  refreshCategories().catch(console.error);
+  if (name === 'settings') {
+    refreshSettings().catch(console.error);
+  }
+  refresh();
+}
+
+// the list is refreshed on the events of qbrdt, at most twice a second
+function listen() {
+  events?.close();
+  events = new EventSource(api + '/events');
+  for (const type of ['torrent.added', 'torrent.changed', 'torrent.removed', 'download.started', 'download.progress', 'download.finished', 'download.failed']) {
+    events.addEventListener(type, () => {
+      pending ??= setTimeout(() => {
+        pending = null;
+        refresh();
+      }, 500);
+    });
+  }
+}
+
+function showLogin() {
+  clearInterval(timer);
+  events?.close();
+  document.getElementById('nav').hidden = true;
+  for (const section of document.querySelectorAll('main section')) {
+    section.hidden = true;
+  }
+  document.getElementById('login').hidden = false;
+}
+
+function start() {
+  document.getElementById('login').hidden = true;
+  document.getElementById('nav').hidden = false;
+  show(view);
+  listen();
+  clearInterval(timer);
+  // in case events were missed
+  timer = setInterval(refresh, 10000);
+}
+
+document.getElementById('login').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const response = await fetch('/api/v2/auth/login', {
+    method: 'POST',
+    credentials: 'same-origin',
+    body: new URLSearchParams(new FormData(event.target)),
+  });
+  const text = await response.text();
+  document.getElementById('login-error').textContent = text === 'Ok.' ? '' : text || 'Login failed';
+  if (text === 'Ok.') {
+    start();
+  }
+});
+
+document.getElementById('logout').addEventListener('click', async () => {
+  await fetch('/api/v2/auth/logout', { method: 'POST', credentials: 'same-origin' });
+  showLogin();
+});
+
+for (const button of document.querySelectorAll('nav button[data-view]')) {
+  button.addEventListener('click', () => show(button.dataset.view));
+}
+
+function submitForm(id, errorId, run) {
+  document.getElementById(id).addEventListener('submit', async (event) => {
+    event.preventDefault();
+    const error = document.getElementById(errorId);
+    try {
+      await run(event.target);
+      error.textContent = '';
+    } catch (e) {
+      error.textContent = e.message;
+    }
+  });
+}
+
+submitForm('add', 'add-error', async (form) => {
+  const result = await request('POST', '/torrents', new FormData(form));
+  form.reset();
+  refresh();
+  if (result.errors.length) {
+    throw new Error(result.errors.join(', '));
+  }
+});
+
+submitForm('add-category', 'category-error', async (form) => {
+  await request('POST', '/categories', { name: form.elements.name.value, priority: Number(form.elements.priority.value) });
+  form.reset();
+  refreshCategories();
+});
+
+submitForm('settings-form', 'settings-error', async (form) => {
+  await request('PUT', '/settings', {
+    max_downloads: Number(form.max_downloads.value),
+    speed_limit: Number(form.speed_limit.value),
+    queue_paused: form.queue_paused.checked,
+  });
+  refreshSettings();
+});
+
+// the session cookie may still be valid
+request('GET', '/settings').then(start, showLogin);
@@ -0,0 +1,193 @@
+package sabnzbd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/labstack/echo/v4"
+)
+
+type queueSlot struct {
+	Index      int    `json:"index"`
+	NzoId      string `json:"nzo_id"`
+	Filename   string `json:"filename"`
+	Cat        string `json:"cat"`
+	Status     string `json:"status"`
+	Priority   string `json:"priority"`
+	Mb         string `json:"mb"`
+	MbLeft     string `json:"mbleft"`
+	Percentage string `json:"percentage"`
+	TimeLeft   string `json:"timeleft"`
+}
+
+type queueStatus struct {
+	Status     string      `json:"status"`
+	Paused     bool        `json:"paused"`
+	SpeedLimit string      `json:"speedlimit"`
+	Speed      string      `json:"speed"`
+	KbPerSec   string      `json:"kbpersec"`
+	Mb         string      `json:"mb"`
+	MbLeft     string      `json:"mbleft"`
+	NoOfSlots  int         `json:"noofslots"`
+	Slots      []queueSlot `json:"slots"`
+}
+
+type historySlot struct {
+	NzoId        string `json:"nzo_id"`
+	Name         string `json:"name"`
+	NzbName      string `json:"nzb_name"`
+	Category     string `json:"category"`
+	Bytes        int64  `json:"bytes"`
+	Status       string `json:"status"`
+	FailMessage  string `json:"fail_message"`
+	Storage      string `json:"storage"`
+	Completed    int64  `json:"completed"`
+	DownloadTime int64  `json:"download_time"`
+}
+
+type historyStatus struct {
+	NoOfSlots int           `json:"noofslots"`
+	Slots     []historySlot `json:"slots"`
+}
+
+// finished reports if a job belongs to the history
+func finished(job database.Torrent) bool {
+	return job.InternalStatus == database.TorrentInternalDownloaded ||
+		job.InternalStatus == database.TorrentInternalError ||
+		job.Status == database.TorrentStatusError
+}
+
+// jobs answers the queue or the history, or deletes jobs from them
+func (s *SabnzbdApi) jobs(c echo.Context, history bool) error {
+	if c.FormValue("name") == "delete" {
+		return s.remove(c, history)
+	}
+
+	jobs, err := s.torrents.FindByAddedBy(database.Sabnzbd)
+	if err != nil {
+		return failure(c, err.Error())
+	}
+
+	if history {
+		return c.JSON(http.StatusOK, map[string]interface{}{"history": s.history(jobs)})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"queue": s.queueOf(jobs)})
+}
+
+func (s *SabnzbdApi) queueOf(jobs []database.Torrent) queueStatus {
+	paused := s.paused.Load()
+
+	q := queueStatus{Status: "Idle", Paused: paused, SpeedLimit: "0", Speed: "0 ", KbPerSec: "0.00", Slots: []queueSlot{}}
+	if paused {
+		q.Status = "Paused"
+	}
+
+	var total, left int64
+	for _, job := range jobs {
+		if finished(job) {
+			continue
+		}
+
+		size, downloaded := s.progress(job)
+		status := "Queued"
+		if job.Paused || s.queue.Paused() {
+			status = "Paused"
+		} else if job.InternalStatus == database.TorrentInternalDownloading || job.InternalStatus == database.TorrentInternalChecking {
+			status = "Downloading"
+			q.Status = "Downloading"
+		}
+
+		var percentage int64
+		if size > 0 {
+			percentage = downloaded * 100 / size
+		}
+
+		q.Slots = append(q.Slots, queueSlot{
+			Index:      len(q.Slots),
+			NzoId:      job.RDHash,
+			Filename:   job.RDName,
+			Cat:        category(job),
+			Status:     status,
+			Priority:   "Normal",
+			Mb:         megabytes(size),
+			MbLeft:     megabytes(size - downloaded),
+			Percentage: fmt.Sprint(percentage),
+			TimeLeft:   "0:00:00",
+		})
+		total += size
+		left += size - downloaded
+	}
+
+	q.NoOfSlots = len(q.Slots)
+	q.Mb = megabytes(total)
+	q.MbLeft = megabytes(left)
+	return q
+}
+
+func (s *SabnzbdApi) history(jobs []database.Torrent) historyStatus {
+	h := historyStatus{Slots: []historySlot{}}
+
+	for _, job := range jobs {
+		if !finished(job) {
+			continue
+		}
+
+		slot := historySlot{
+			NzoId:        job.RDHash,
+			Name:         job.RDName,
+			NzbName:      job.RDName,
+			Category:     category(job),
+			Bytes:        int64(job.RDSize),
+			Status:       "Completed",
+			Storage:      s.storage(job),
+			Completed:    job.UpdatedAt.Unix(),
+			DownloadTime: int64(job.UpdatedAt.Sub(job.CreatedAt) / time.Second),
+		}
+
+		if job.InternalStatus != database.TorrentInternalDownloaded {
+			slot.Status = "Failed"
+			slot.FailMessage = "Download failed"
+		}
+
+		h.Slots = append(h.Slots, slot)
+	}
+
+	h.NoOfSlots = len(h.Slots)
+	return h
+}
+
+// progress returns the size of a job and the bytes already downloaded
+func (s *SabnzbdApi) progress(job database.Torrent) (int64, int64) {
+	size := int64(job.RDSize)
+	downloads, err := s.torrents.FindAllDownloadByRdId(job.ID)
+	if err != nil || len(downloads) == 0 {
+		return size, 0
+	}
+
+	var total, downloaded int64
+	for _, d := range downloads {
+		total += d.FileSize
+		if progress, ok := s.queue.Progress(d.ID); ok {
+			downloaded += progress.Downloaded
+		} else if d.IsDownloaded {
+			downloaded += d.FileSize
+		} else {
+			downloaded += d.Downloaded
+		}
+	}
+	return total, downloaded
+}
+
+func category(job database.Torrent) string {
+	if job.Category == "" {
+		return "*"
+	}
+	return job.Category
+}
+
+func megabytes(bytes int64) string {
+	return fmt.Sprintf("%.2f", float64(bytes)/1024/1024)
+}
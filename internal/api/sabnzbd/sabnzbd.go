@@ -0,0 +1,275 @@
+package sabnzbd
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/labstack/echo/v4"
+)
+
+// Version of SABnzbd the clients see
+const version = "4.3.3"
+
+// SabnzbdApi serves the SABnzbd API for hoster links. A job is a link torrent
+// added through this API, its nzo_id is the hash of the torrent.
+type SabnzbdApi struct {
+	apiKey      string
+	preferences *database.PreferencesRepository
+	categories  *database.CategoryRepository
+	torrents    *database.TorrentRepository
+	admission   *jobs.TorrentAdmission
+	queue       *queue.DownloadQueue
+	logger      logger.Interface
+	// pause of the jobs of this API, the other torrents keep downloading
+	paused atomic.Bool
+}
+
+// NewSabnzbdApi serves the API on /api and /sabnzbd/api, the default paths of the clients
+func NewSabnzbdApi(e *echo.Echo,
+	apiKey string,
+	l logger.Interface,
+	preferences *database.PreferencesRepository,
+	categories *database.CategoryRepository,
+	torrents *database.TorrentRepository,
+	admission *jobs.TorrentAdmission,
+	queue *queue.DownloadQueue,
+) *SabnzbdApi {
+	sabnzbdApi := &SabnzbdApi{
+		apiKey:      apiKey,
+		preferences: preferences,
+		categories:  categories,
+		torrents:    torrents,
+		admission:   admission,
+		queue:       queue,
+		logger:      l,
+	}
+
+	for _, path := range []string{"/api", "/sabnzbd/api"} {
+		e.GET(path, sabnzbdApi.api)
+		e.POST(path, sabnzbdApi.api)
+	}
+
+	return sabnzbdApi
+}
+
+func (s *SabnzbdApi) api(c echo.Context) error {
+	mode := c.FormValue("mode")
+
+	// like SABnzbd, the version is given without key
+	if mode == "version" {
+		return c.JSON(http.StatusOK, map[string]string{"version": version})
+	}
+
+	switch c.FormValue("apikey") {
+	case "":
+		return failure(c, "API Key Required")
+	case s.apiKey:
+	default:
+		return failure(c, "API Key Incorrect")
+	}
+
+	switch mode {
+	case "addurl":
+		return s.addUrl(c)
+	case "queue":
+		return s.jobs(c, false)
+	case "history":
+		return s.jobs(c, true)
+	case "get_config":
+		return s.config(c)
+	case "get_cats":
+		return c.JSON(http.StatusOK, map[string][]string{"categories": s.categoryNames()})
+	case "pause":
+		return s.pause(c, true)
+	case "resume":
+		return s.pause(c, false)
+	}
+
+	return failure(c, "not implemented")
+}
+
+func success(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]bool{"status": true})
+}
+
+func failure(c echo.Context, message string) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": false, "error": message})
+}
+
+func (s *SabnzbdApi) addUrl(c echo.Context) error {
+	var links []string
+	for _, link := range strings.Split(c.FormValue("name"), "\n") {
+		if link = strings.TrimSpace(link); link != "" {
+			links = append(links, link)
+		}
+	}
+
+	if len(links) == 0 {
+		return failure(c, "expects one parameter")
+	}
+
+	category := c.FormValue("cat")
+	if category == "*" {
+		category = ""
+	}
+
+	if category != "" && !s.categories.Exist(category) {
+		s.categories.Create(database.NewCategory(category))
+	}
+
+	torrent, err := s.admission.AddLinks(links, c.FormValue("nzbname"), category, database.Sabnzbd)
+	if err != nil {
+		s.logger.Error("Failed to add links %s", err)
+		if errors.Is(err, jobs.ErrTorrentExists) {
+			return failure(c, "duplicate job")
+		}
+		return failure(c, err.Error())
+	}
+
+	if s.paused.Load() {
+		if err := s.torrents.SetPaused([]uint{torrent.ID}, true); err != nil {
+			return failure(c, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": true, "nzo_ids": []string{torrent.RDHash}})
+}
+
+// pause pauses or resumes the jobs of this API, the jobs added while paused are paused too
+func (s *SabnzbdApi) pause(c echo.Context, paused bool) error {
+	s.paused.Store(paused)
+
+	jobs, err := s.torrents.FindByAddedBy(database.Sabnzbd)
+	if err != nil {
+		return failure(c, err.Error())
+	}
+
+	var ids []uint
+	for _, job := range jobs {
+		if !finished(job) {
+			ids = append(ids, job.ID)
+		}
+	}
+
+	if len(ids) > 0 {
+		if err := s.torrents.SetPaused(ids, paused); err != nil {
+			return failure(c, err.Error())
+		}
+	}
+
+	if !paused {
+		s.queue.Wake()
+		go s.admission.Run()
+	}
+
+	return success(c)
+}
+
+// remove deletes the jobs of value, a comma separated list of nzo_id or "all"
+func (s *SabnzbdApi) remove(c echo.Context, history bool) error {
+	value := c.FormValue("value")
+	if value == "" {
+		return failure(c, "expects one parameter")
+	}
+
+	torrents, err := s.torrents.FindByAddedBy(database.Sabnzbd)
+	if err != nil {
+		return failure(c, err.Error())
+	}
+
+	ids := strings.Split(strings.ToLower(value), ",")
+	deleteFiles := c.FormValue("del_files") == "1"
+	var removed []string
+
+	for _, job := range torrents {
+		if finished(job) != history || (value != "all" && !contains(ids, job.RDHash)) {
+			continue
+		}
+
+		if deleteFiles {
+			if err := jobs.RemoveTorrentFiles(s.preferences.GetSavePath(), &job); err != nil {
+				s.logger.Error("Failed to delete files of %s: %s", job.RDName, err)
+			}
+		}
+
+		if err := s.torrents.Delete(job.ID); err != nil {
+			return failure(c, err.Error())
+		}
+		removed = append(removed, job.RDHash)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": true, "nzo_ids": removed})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// storage returns where the files of a job are saved
+func (s *SabnzbdApi) storage(job database.Torrent) string {
+	return filepath.Join(s.preferences.CategorySavePath(job.Category), job.RDName)
+}
+
+func (s *SabnzbdApi) categoryNames() []string {
+	names := []string{"*"}
+	categories, _ := s.categories.FindAll()
+	for _, category := range categories {
+		names = append(names, category.Name)
+	}
+	return names
+}
+
+type configCategory struct {
+	Name     string `json:"name"`
+	Order    int    `json:"order"`
+	PP       string `json:"pp"`
+	Script   string `json:"script"`
+	Dir      string `json:"dir"`
+	Priority int    `json:"priority"`
+}
+
+// config answers the settings the clients check: the folders and the categories
+func (s *SabnzbdApi) config(c echo.Context) error {
+	var categories []configCategory
+	for i, name := range s.categoryNames() {
+		category := configCategory{Name: name, Order: i, PP: "3", Script: "None", Priority: -100}
+		if name != "*" {
+			category.Dir = name
+		}
+		categories = append(categories, category)
+	}
+
+	savePath := s.preferences.GetSavePath()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"config": map[string]interface{}{
+			"misc": map[string]interface{}{
+				"complete_dir":         savePath,
+				"download_dir":         savePath,
+				"history_retention":    "",
+				"pre_check":            false,
+				"enable_tv_sorting":    false,
+				"enable_movie_sorting": false,
+				"enable_date_sorting":  false,
+				"tv_categories":        []string{},
+				"movie_categories":     []string{},
+				"date_categories":      []string{},
+			},
+			"categories": categories,
+			"sorters":    []interface{}{},
+			"servers":    []interface{}{},
+		},
+	})
+}
@@ -0,0 +1,450 @@
+package transmission
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+)
+
+var (
+	errUnknownMethod = errors.New("method name not recognized")
+	errInvalidIds    = errors.New("invalid ids")
+)
+
+// Statuses of tr_torrent_activity
+const (
+	statusStopped      = 0
+	statusCheckWait    = 1
+	statusCheck        = 2
+	statusDownloadWait = 3
+	statusDownload     = 4
+	statusSeedWait     = 5
+	statusSeed         = 6
+)
+
+// Value of the error field for a local error
+const errorLocal = 3
+
+// torrents added since that long are "recently-active"
+const recentlyActive = time.Minute
+
+// largest .torrent file downloaded for the filename argument
+const maxTorrentFileSize = 10 << 20
+
+type addArguments struct {
+	Filename    string   `json:"filename"`
+	Metainfo    string   `json:"metainfo"`
+	DownloadDir string   `json:"download-dir"`
+	Paused      bool     `json:"paused"`
+	Labels      []string `json:"labels"`
+}
+
+type addedTorrent struct {
+	Id         uint   `json:"id"`
+	Name       string `json:"name"`
+	HashString string `json:"hashString"`
+}
+
+func (t *TransmissionApi) torrentAdd(raw json.RawMessage) (interface{}, error) {
+	var args addArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	category := t.category(args)
+	if category != "" && !t.categories.Exist(category) {
+		t.categories.Create(database.NewCategory(category))
+	}
+
+	var (
+		torrent *database.Torrent
+		hash    string
+		err     error
+	)
+
+	if args.Metainfo == "" && strings.HasPrefix(strings.ToLower(args.Filename), "magnet:") {
+		if magnet, parseErr := metainfo.ParseMagnet(args.Filename); parseErr == nil {
+			hash = magnet.InfoHash
+		}
+		torrent, err = t.admission.AddMagnet(args.Filename, category, database.Transmission)
+	} else {
+		var torrentFile []byte
+		torrentFile, err = t.torrentFile(args)
+		if err != nil {
+			return nil, err
+		}
+
+		if meta, parseErr := metainfo.Parse(torrentFile); parseErr == nil {
+			hash = meta.InfoHash
+		}
+		torrent, err = t.admission.AddTorrent(torrentFile, category, database.Transmission)
+	}
+
+	if errors.Is(err, jobs.ErrTorrentExists) {
+		if existing, findErr := t.torrents.FindByHash(hash); findErr == nil {
+			return map[string]addedTorrent{"torrent-duplicate": added(existing)}, nil
+		}
+	}
+
+	if errors.Is(err, jobs.ErrInvalidTorrent) || errors.Is(err, jobs.ErrInvalidMagnet) {
+		return nil, errors.New("invalid or corrupt torrent file")
+	}
+
+	if err != nil {
+		t.logger.Error("Failed to add torrent %s", err)
+		return nil, err
+	}
+
+	if args.Paused {
+		if err := t.torrents.SetPaused([]uint{torrent.ID}, true); err != nil {
+			return nil, err
+		}
+	}
+
+	go t.admission.Run()
+
+	return map[string]addedTorrent{"torrent-added": added(torrent)}, nil
+}
+
+func added(torrent *database.Torrent) addedTorrent {
+	return addedTorrent{Id: torrent.ID, Name: torrent.RDName, HashString: torrent.RDHash}
+}
+
+// torrentFile returns the .torrent file sent in metainfo or downloaded from filename
+func (t *TransmissionApi) torrentFile(args addArguments) ([]byte, error) {
+	if args.Metainfo != "" {
+		torrentFile, err := base64.StdEncoding.DecodeString(args.Metainfo)
+		if err != nil {
+			return nil, errors.New("invalid or corrupt torrent file")
+		}
+		return torrentFile, nil
+	}
+
+	if !strings.HasPrefix(args.Filename, "http://") && !strings.HasPrefix(args.Filename, "https://") {
+		return nil, errors.New("no torrent file or magnet link")
+	}
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(args.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download the torrent file: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download the torrent file: %s", resp.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+}
+
+// category is the first label, or the folder of download-dir under the save path
+func (t *TransmissionApi) category(args addArguments) string {
+	if len(args.Labels) > 0 {
+		return args.Labels[0]
+	}
+
+	if args.DownloadDir == "" {
+		return ""
+	}
+
+	rel, err := filepath.Rel(t.preferences.GetSavePath(), args.DownloadDir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+type getArguments struct {
+	Ids    json.RawMessage `json:"ids"`
+	Fields []string        `json:"fields"`
+}
+
+func (t *TransmissionApi) torrentGet(raw json.RawMessage) (interface{}, error) {
+	var args getArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	torrents, err := t.selectTorrents(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0, len(torrents))
+	for _, torrent := range torrents {
+		fields := t.fields(torrent)
+
+		// only the requested fields, like Transmission
+		if len(args.Fields) > 0 {
+			selected := make(map[string]interface{}, len(args.Fields))
+			for _, name := range args.Fields {
+				if value, ok := fields[name]; ok {
+					selected[name] = value
+				}
+			}
+			fields = selected
+		}
+
+		result = append(result, fields)
+	}
+
+	return map[string]interface{}{"torrents": result}, nil
+}
+
+type file struct {
+	Name           string `json:"name"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+}
+
+type fileStat struct {
+	BytesCompleted int64 `json:"bytesCompleted"`
+	Wanted         bool  `json:"wanted"`
+	Priority       int   `json:"priority"`
+}
+
+// fields returns the fields of torrent-get the clients use
+func (t *TransmissionApi) fields(torrent database.Torrent) map[string]interface{} {
+	state := qbittorrent.TorrentState(torrent)
+	status := transmissionStatus(torrent)
+	left := leftUntilDone(torrent)
+	finished := state == "pausedUP"
+
+	var errorCode int
+	var errorString string
+	switch state {
+	case "error":
+		errorCode, errorString = errorLocal, "Download failed"
+	case "missingFiles":
+		errorCode, errorString = errorLocal, "Torrent removed from Real-Debrid"
+	}
+
+	var doneDate int64
+	if finished {
+		doneDate = torrent.UpdatedAt.Unix()
+	}
+
+	labels := []string{}
+	if torrent.Category != "" {
+		labels = append(labels, torrent.Category)
+	}
+
+	files := []file{}
+	fileStats := []fileStat{}
+	if downloads, err := t.torrents.FindAllDownloadByRdId(torrent.ID); err == nil {
+		for _, d := range downloads {
+			completed := d.Downloaded
+			if d.IsDownloaded {
+				completed = d.FileSize
+			}
+			files = append(files, file{Name: torrent.RDName + "/" + d.FileName, Length: d.FileSize, BytesCompleted: completed})
+			fileStats = append(fileStats, fileStat{BytesCompleted: completed, Wanted: true})
+		}
+	}
+
+	return map[string]interface{}{
+		"id":                 torrent.ID,
+		"hashString":         torrent.RDHash,
+		"name":               torrent.RDName,
+		"downloadDir":        t.preferences.CategorySavePath(torrent.Category),
+		"labels":             labels,
+		"status":             status,
+		"error":              errorCode,
+		"errorString":        errorString,
+		"isFinished":         finished,
+		"isStalled":          state == "stalledDL",
+		"isPrivate":          false,
+		"percentDone":        torrent.RDProgress / 100,
+		"totalSize":          torrent.RDSize,
+		"sizeWhenDone":       torrent.RDSize,
+		"leftUntilDone":      left,
+		"haveValid":          int64(torrent.RDSize) - left,
+		"downloadedEver":     int64(torrent.RDSize) - left,
+		"uploadedEver":       0,
+		"uploadRatio":        0,
+		"rateDownload":       0,
+		"rateUpload":         0,
+		"eta":                -1,
+		"queuePosition":      torrent.Priority,
+		"addedDate":          torrent.CreatedAt.Unix(),
+		"activityDate":       torrent.UpdatedAt.Unix(),
+		"doneDate":           doneDate,
+		"secondsDownloading": 0,
+		"secondsSeeding":     0,
+		"seedRatioLimit":     0,
+		"seedRatioMode":      0,
+		"seedIdleLimit":      0,
+		"seedIdleMode":       0,
+		"peersConnected":     torrent.RDSeeders,
+		"magnetLink":         torrent.Magnet,
+		"fileCount":          len(files),
+		"files":              files,
+		"fileStats":          fileStats,
+	}
+}
+
+// transmissionStatus maps the qBittorrent state of a torrent to a Transmission status
+func transmissionStatus(torrent database.Torrent) int {
+	switch qbittorrent.TorrentState(torrent) {
+	case "queuedDL":
+		return statusDownloadWait
+	case "checkingUP":
+		return statusCheck
+	case "downloading", "stalledDL":
+		return statusDownload
+	}
+	return statusStopped
+}
+
+func leftUntilDone(torrent database.Torrent) int64 {
+	return int64(float64(torrent.RDSize) * (100 - torrent.RDProgress) / 100)
+}
+
+// visibleTorrents returns the torrents of the RPC, the jobs of the SABnzbd API are left out
+func (t *TransmissionApi) visibleTorrents() ([]database.Torrent, error) {
+	torrents, err := t.torrents.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := torrents[:0]
+	for _, torrent := range torrents {
+		if torrent.AddedBy != database.Sabnzbd {
+			visible = append(visible, torrent)
+		}
+	}
+	return visible, nil
+}
+
+// selectTorrents returns the torrents of the ids argument: absent for all of them,
+// an id, a hash, "recently-active" or a list of ids and hashes
+func (t *TransmissionApi) selectTorrents(raw json.RawMessage) ([]database.Torrent, error) {
+	torrents, err := t.visibleTorrents()
+	if err != nil || len(raw) == 0 {
+		return torrents, err
+	}
+
+	var ids []interface{}
+	var single interface{}
+	if err := json.Unmarshal(raw, &single); err != nil {
+		return nil, errInvalidIds
+	}
+
+	switch value := single.(type) {
+	case []interface{}:
+		ids = value
+	case string:
+		if value == "recently-active" {
+			var recent []database.Torrent
+			for _, torrent := range torrents {
+				if time.Since(torrent.UpdatedAt) < recentlyActive {
+					recent = append(recent, torrent)
+				}
+			}
+			return recent, nil
+		}
+		ids = []interface{}{value}
+	default:
+		ids = []interface{}{value}
+	}
+
+	var selected []database.Torrent
+	for _, torrent := range torrents {
+		for _, id := range ids {
+			var match bool
+			switch id := id.(type) {
+			case float64:
+				match = uint(id) == torrent.ID
+			case string:
+				match = strings.EqualFold(id, torrent.RDHash)
+			default:
+				return nil, errInvalidIds
+			}
+
+			if match {
+				selected = append(selected, torrent)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+type removeArguments struct {
+	Ids             json.RawMessage `json:"ids"`
+	DeleteLocalData bool            `json:"delete-local-data"`
+}
+
+func (t *TransmissionApi) torrentRemove(raw json.RawMessage) (interface{}, error) {
+	var args removeArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	// unlike the other methods, no ids is not all the torrents
+	if len(args.Ids) == 0 {
+		return struct{}{}, nil
+	}
+
+	torrents, err := t.selectTorrents(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, torrent := range torrents {
+		if err := jobs.DeleteTorrent(t.torrents, t.client, t.rdCache, t.preferences.GetSavePath(), &torrent, args.DeleteLocalData, t.logger); err != nil {
+			return nil, err
+		}
+	}
+
+	return struct{}{}, nil
+}
+
+type idsArguments struct {
+	Ids json.RawMessage `json:"ids"`
+}
+
+// torrentPause stops or starts torrents. A stopped torrent is not sent to Real-Debrid
+// and none of its files start downloading, files already downloading are finished.
+func (t *TransmissionApi) torrentPause(raw json.RawMessage, paused bool) (interface{}, error) {
+	var args idsArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
+	}
+
+	torrents, err := t.selectTorrents(args.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(torrents))
+	for _, torrent := range torrents {
+		ids = append(ids, torrent.ID)
+	}
+
+	if len(ids) > 0 {
+		if err := t.torrents.SetPaused(ids, paused); err != nil {
+			return nil, err
+		}
+	}
+
+	if !paused {
+		t.queue.Wake()
+		go t.admission.Run()
+	}
+
+	return struct{}{}, nil
+}
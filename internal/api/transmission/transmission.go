@@ -0,0 +1,222 @@
+package transmission
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	sessionHeader = "X-Transmission-Session-Id"
+	// Version of Transmission the clients see
+	version    = "4.0.6 (qbrdt)"
+	rpcVersion = 17
+)
+
+// TransmissionApi serves the Transmission RPC on the same torrents as the qBittorrent API
+type TransmissionApi struct {
+	auth        *qbittorrent.Authenticator
+	sessionId   string
+	preferences *database.PreferencesRepository
+	categories  *database.CategoryRepository
+	torrents    *database.TorrentRepository
+	client      *realdebrid.Client
+	rdCache     *realdebrid.Cache
+	admission   *jobs.TorrentAdmission
+	queue       *queue.DownloadQueue
+	logger      logger.Interface
+}
+
+type rpcRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+type rpcResponse struct {
+	Result    string          `json:"result"`
+	Arguments interface{}     `json:"arguments"`
+	Tag       json.RawMessage `json:"tag,omitempty"`
+}
+
+// NewTransmissionApi serves the RPC on /transmission/rpc with the credentials of the qBittorrent API
+func NewTransmissionApi(e *echo.Echo,
+	auth *qbittorrent.Authenticator,
+	l logger.Interface,
+	preferences *database.PreferencesRepository,
+	categories *database.CategoryRepository,
+	torrents *database.TorrentRepository,
+	client *realdebrid.Client,
+	rdCache *realdebrid.Cache,
+	admission *jobs.TorrentAdmission,
+	queue *queue.DownloadQueue,
+) *TransmissionApi {
+	id := make([]byte, 24)
+	rand.Read(id)
+
+	transmissionApi := &TransmissionApi{
+		auth:        auth,
+		sessionId:   hex.EncodeToString(id),
+		preferences: preferences,
+		categories:  categories,
+		torrents:    torrents,
+		client:      client,
+		rdCache:     rdCache,
+		admission:   admission,
+		queue:       queue,
+		logger:      l,
+	}
+
+	e.GET("/transmission/rpc", transmissionApi.rpc, transmissionApi.requireAuth, transmissionApi.requireSession)
+	e.POST("/transmission/rpc", transmissionApi.rpc, transmissionApi.requireAuth, transmissionApi.requireSession)
+
+	return transmissionApi
+}
+
+// requireAuth checks the basic auth credentials, like Transmission with rpc-authentication-required
+func (t *TransmissionApi) requireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if t.auth.Banned(c) {
+			return c.String(http.StatusForbidden, "403: Forbidden")
+		}
+		if username, password, ok := c.Request().BasicAuth(); ok && t.auth.Authenticate(c, username, password) {
+			return next(c)
+		}
+
+		c.Response().Header().Set("WWW-Authenticate", `Basic realm="Transmission"`)
+		return c.String(http.StatusUnauthorized, "401: Unauthorized")
+	}
+}
+
+// requireSession answers 409 with the session id to requests without it, the clients retry with the header
+func (t *TransmissionApi) requireSession(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if c.Request().Header.Get(sessionHeader) == t.sessionId {
+			return next(c)
+		}
+
+		c.Response().Header().Set(sessionHeader, t.sessionId)
+		return c.String(http.StatusConflict, "409: Conflict")
+	}
+}
+
+func (t *TransmissionApi) rpc(c echo.Context) error {
+	var request rpcRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return c.String(http.StatusBadRequest, "400: Bad Request")
+	}
+
+	if len(request.Arguments) == 0 {
+		request.Arguments = json.RawMessage("{}")
+	}
+
+	var (
+		arguments interface{}
+		err       error
+	)
+
+	switch request.Method {
+	case "torrent-add":
+		arguments, err = t.torrentAdd(request.Arguments)
+	case "torrent-get":
+		arguments, err = t.torrentGet(request.Arguments)
+	case "torrent-remove":
+		arguments, err = t.torrentRemove(request.Arguments)
+	case "torrent-start", "torrent-start-now":
+		arguments, err = t.torrentPause(request.Arguments, false)
+	case "torrent-stop":
+		arguments, err = t.torrentPause(request.Arguments, true)
+	case "session-get":
+		arguments, err = t.sessionGet()
+	case "session-stats":
+		arguments, err = t.sessionStats()
+	default:
+		err = errUnknownMethod
+	}
+
+	response := rpcResponse{Result: "success", Arguments: arguments, Tag: request.Tag}
+	if err != nil {
+		t.logger.Debug("Transmission %s failed: %s", request.Method, err)
+		response.Result = err.Error()
+		response.Arguments = struct{}{}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (t *TransmissionApi) sessionGet() (interface{}, error) {
+	savePath := t.preferences.GetSavePath()
+
+	return map[string]interface{}{
+		"version":                      version,
+		"rpc-version":                  rpcVersion,
+		"rpc-version-minimum":          14,
+		"rpc-version-semver":           "5.3.0",
+		"session-id":                   t.sessionId,
+		"download-dir":                 savePath,
+		"incomplete-dir":               savePath,
+		"incomplete-dir-enabled":       false,
+		"config-dir":                   "",
+		"start-added-torrents":         true,
+		"download-queue-enabled":       true,
+		"seedRatioLimit":               0,
+		"seedRatioLimited":             false,
+		"idle-seeding-limit":           0,
+		"idle-seeding-limit-enabled":   false,
+		"speed-limit-down":             0,
+		"speed-limit-down-enabled":     false,
+		"speed-limit-up":               0,
+		"speed-limit-up-enabled":       false,
+		"alt-speed-enabled":            false,
+		"rename-partial-files":         false,
+		"trash-original-torrent-files": false,
+	}, nil
+}
+
+type sessionStats struct {
+	UploadedBytes   int64 `json:"uploadedBytes"`
+	DownloadedBytes int64 `json:"downloadedBytes"`
+	FilesAdded      int   `json:"filesAdded"`
+	SessionCount    int   `json:"sessionCount"`
+	SecondsActive   int64 `json:"secondsActive"`
+}
+
+func (t *TransmissionApi) sessionStats() (interface{}, error) {
+	torrents, err := t.visibleTorrents()
+	if err != nil {
+		return nil, err
+	}
+
+	var active, paused int
+	var downloaded int64
+	for _, torrent := range torrents {
+		switch transmissionStatus(torrent) {
+		case statusStopped:
+			paused++
+		default:
+			active++
+		}
+		downloaded += int64(torrent.RDSize) - leftUntilDone(torrent)
+	}
+
+	stats := sessionStats{DownloadedBytes: downloaded, FilesAdded: len(torrents), SessionCount: 1}
+
+	return map[string]interface{}{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(torrents),
+		"downloadSpeed":      0,
+		"uploadSpeed":        0,
+		"cumulative-stats":   stats,
+		"current-stats":      stats,
+	}, nil
+}
@@ -0,0 +1,215 @@
+package webdav
+
+import (
+	"context"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/patrickmn/go-cache"
+	dav "golang.org/x/net/webdav"
+)
+
+const (
+	// a listing asks for every entry, the tree is read from the database once for all of them
+	treeTTL = 2 * time.Second
+	// Real-Debrid download links stay valid for hours, a link is unrestricted again after this
+	linkTTL = time.Hour
+)
+
+// node is a folder or a file of the tree
+type node struct {
+	name     string
+	dir      bool
+	size     int64
+	modTime  time.Time
+	children map[string]*node
+	download database.Download
+}
+
+// fileSystem is a read-only dav.FileSystem of the streamed torrents
+type fileSystem struct {
+	torrents *database.TorrentRepository
+	client   *realdebrid.Client
+	logger   logger.Interface
+	// download links per hoster link
+	links *cache.Cache
+
+	lock    sync.Mutex
+	tree    *node
+	builtAt time.Time
+}
+
+func newFileSystem(torrents *database.TorrentRepository, client *realdebrid.Client, l logger.Interface) *fileSystem {
+	return &fileSystem{
+		torrents: torrents,
+		client:   client,
+		logger:   l,
+		links:    cache.New(linkTTL, 10*time.Minute),
+	}
+}
+
+// root returns the tree of the streamed torrents, built again once it is older than treeTTL
+func (f *fileSystem) root() (*node, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.tree != nil && time.Since(f.builtAt) < treeTTL {
+		return f.tree, nil
+	}
+
+	torrents, err := f.torrents.FindStreamed()
+	if err != nil {
+		return nil, err
+	}
+
+	root := &node{dir: true, children: map[string]*node{}}
+	for _, torrent := range torrents {
+		for _, download := range torrent.Downloads {
+			parent := root
+			for _, name := range strings.Split(path.Join(torrent.Category, torrent.RDName), "/") {
+				if name == "" {
+					continue
+				}
+				child, ok := parent.children[name]
+				if !ok {
+					child = &node{name: name, dir: true, children: map[string]*node{}}
+					parent.children[name] = child
+				}
+				if torrent.UpdatedAt.After(child.modTime) {
+					child.modTime = torrent.UpdatedAt
+				}
+				parent = child
+			}
+
+			parent.children[download.FileName] = &node{
+				name:     download.FileName,
+				size:     download.FileSize,
+				modTime:  torrent.UpdatedAt,
+				download: download,
+			}
+		}
+	}
+
+	f.tree = root
+	f.builtAt = time.Now()
+	return root, nil
+}
+
+func (f *fileSystem) find(name string) (*node, error) {
+	current, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		next, ok := current.children[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		current = next
+	}
+	return current, nil
+}
+
+func (f *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (dav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	n, err := f.find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.dir {
+		return &dirFile{node: n}, nil
+	}
+	return &remoteFile{ctx: ctx, fs: f, node: n}, nil
+}
+
+func (f *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	n, err := f.find(name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{n}, nil
+}
+
+func (f *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (f *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (f *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+// fileInfo gives the content type by the extension, so that listings don't read the files
+type fileInfo struct {
+	*node
+}
+
+func (i fileInfo) Name() string       { return i.name }
+func (i fileInfo) Size() int64        { return i.size }
+func (i fileInfo) ModTime() time.Time { return i.modTime }
+func (i fileInfo) IsDir() bool        { return i.dir }
+func (i fileInfo) Sys() interface{}   { return nil }
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fileInfo) ContentType(ctx context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(i.name)); contentType != "" {
+		return contentType, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// dirFile lists a folder of the tree
+type dirFile struct {
+	node   *node
+	offset int
+}
+
+func (d *dirFile) Readdir(count int) ([]fs.FileInfo, error) {
+	names := make([]string, 0, len(d.node.children))
+	for name := range d.node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var infos []fs.FileInfo
+	for _, name := range names[min(d.offset, len(names)):] {
+		if count > 0 && len(infos) == count {
+			break
+		}
+		infos = append(infos, fileInfo{d.node.children[name]})
+	}
+	d.offset += len(infos)
+	return infos, nil
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error)                   { return fileInfo{d.node}, nil }
+func (d *dirFile) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *dirFile) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *dirFile) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *dirFile) Close() error                                 { return nil }
@@ -0,0 +1,146 @@
+package webdav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+}
+
+// remoteFile reads a file from Real-Debrid: each read after a seek is a ranged request
+// to the download link, unrestricted again once it expired
+type remoteFile struct {
+	ctx    context.Context
+	fs     *fileSystem
+	node   *node
+	offset int64
+	body   io.ReadCloser
+}
+
+// url returns the download link of the file, unrestricted again when fresh is set or the link is too old
+func (r *remoteFile) url(fresh bool) (string, error) {
+	link := r.node.download.Link
+	if link == "" {
+		return r.node.download.Url, nil
+	}
+
+	if url, ok := r.fs.links.Get(link); ok && !fresh {
+		return url.(string), nil
+	}
+
+	unrestricted, err := r.fs.client.Unrestrict(link)
+	if err != nil {
+		return "", err
+	}
+
+	r.fs.links.SetDefault(link, unrestricted.Download)
+	return unrestricted.Download, nil
+}
+
+// open starts reading from the current offset
+func (r *remoteFile) open() error {
+	var lastErr error
+
+	// an expired link is unrestricted again once
+	for _, fresh := range []bool{false, true} {
+		url, err := r.url(fresh)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-")
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+			r.body = resp.Body
+			return nil
+		case http.StatusOK:
+			// no range support, skip to the offset
+			if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			r.body = resp.Body
+			return nil
+		}
+
+		resp.Body.Close()
+		lastErr = fmt.Errorf("%s answered %s", r.node.name, resp.Status)
+		r.fs.logger.Warn("Streaming %s: %s", r.node.name, lastErr)
+	}
+
+	return lastErr
+}
+
+func (r *remoteFile) Read(p []byte) (int, error) {
+	if r.offset >= r.node.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *remoteFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.node.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	// the next read asks for the new position
+	if offset != r.offset {
+		r.closeBody()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *remoteFile) closeBody() {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+}
+
+func (r *remoteFile) Close() error {
+	r.closeBody()
+	return nil
+}
+
+func (r *remoteFile) Stat() (fs.FileInfo, error)               { return fileInfo{r.node}, nil }
+func (r *remoteFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+func (r *remoteFile) Write(p []byte) (int, error)              { return 0, os.ErrPermission }
@@ -0,0 +1,52 @@
+package webdav
+
+import (
+	"net/http"
+
+	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	dav "golang.org/x/net/webdav"
+)
+
+const prefix = "/webdav"
+
+// NewWebdavApi serves the streamed torrents read-only on /webdav, laid out like the save path:
+// <category>/<name>/<file>. Mounted on the save path, the clients find the files where they expect them.
+func NewWebdavApi(e *echo.Echo,
+	auth *qbittorrent.Authenticator,
+	l logger.Interface,
+	torrents *database.TorrentRepository,
+	client *realdebrid.Client,
+) {
+	handler := &dav.Handler{
+		Prefix:     prefix,
+		FileSystem: newFileSystem(torrents, client, l),
+		LockSystem: dav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				l.Debug("WebDAV %s %s: %s", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	basicAuth := middleware.BasicAuth(func(u, p string, c echo.Context) (bool, error) {
+		return !auth.Banned(c) && auth.Authenticate(c, u, p), nil
+	})
+
+	serve := func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			handler.ServeHTTP(c.Response(), c.Request())
+			return nil
+		}
+		return c.String(http.StatusMethodNotAllowed, "read-only")
+	}
+
+	for _, path := range []string{prefix, prefix + "/*"} {
+		e.Match([]string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", http.MethodPut, http.MethodDelete, "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK", "PROPPATCH"}, path, serve, basicAuth)
+	}
+}
@@ -7,9 +7,35 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+type CategoryConfig struct {
+	// Torrents of categories with a higher priority are downloaded first
+	Priority int `yaml:"priority"`
+	// Replaces the global availability policy for the category
+	Availability *AvailabilityConfig `yaml:"availability"`
+	// Folder watched for the torrents of the category, empty to not watch
+	WatchDir string `yaml:"watch_dir"`
+	// How the files of the category are delivered: download, stream, strm or symlink. Empty for the global one
+	Output string `yaml:"output"`
+}
+
+type AvailabilityConfig struct {
+	// Refuses the torrents not cached on Real-Debrid
+	RejectUncached bool `yaml:"reject_uncached"`
+	// Seconds a torrent can stay on Real-Debrid without being downloaded, 0 for no limit
+	MaxWait int `yaml:"max_wait"`
+	// Once MaxWait is over, torrents with at least this many seeders are kept
+	MinSeeders int `yaml:"min_seeders"`
+}
+
 type QBRDTConfig struct {
 	RealDebrid struct {
 		Token string `yaml:"token"`
+		// Address of the Real-Debrid API, the official one if empty
+		BaseUrl string `yaml:"base_url"`
+		// Active torrents allowed on the Real-Debrid account, 0 to not check
+		MaxActiveTorrents int `yaml:"max_active_torrents"`
+		// Requests per minute sent to Real-Debrid, 0 for the default, -1 to not limit them
+		RateLimit int `yaml:"rate_limit"`
 	} `yaml:"realdebrid"`
 	QBittorrent struct {
 		Port     string `yaml:"port"`
@@ -18,14 +44,58 @@ type QBRDTConfig struct {
 	} `yaml:"qbittorrent"`
 	Qbrdt struct {
 		TorrentRefreshInterval string `yaml:"torrent_refresh_interval"`
+		// Torrents downloading on Real-Debrid at the same time, 0 for unlimited
+		MaxActiveDownloads int `yaml:"max_active_downloads"`
+		// Torrents downloading on Real-Debrid or locally at the same time, 0 for unlimited
+		MaxActiveTorrents int `yaml:"max_active_torrents"`
 	} `yaml:"qbrdt"`
 	Downloader struct {
-		SavePath     string `yaml:"save_path"`
-		Chunk        int    `yaml:"chunk"`
-		SpeedLimit   int    `yaml:"speed_limit"`
-		MaxDownloads int    `yaml:"max_downloads"`
+		SavePath              string `yaml:"save_path"`
+		Chunk                 int    `yaml:"chunk"`
+		MinChunkSize          int64  `yaml:"min_chunk_size"`
+		MaxConnectionsPerHost int    `yaml:"max_connections_per_host"`
+		SpeedLimit            int    `yaml:"speed_limit"`
+		MaxDownloads          int    `yaml:"max_downloads"`
 	} `yaml:"downloader"`
-	Logger struct {
+	Import struct {
+		// Seconds between two comparisons with the Real-Debrid account, 0 to only do it at startup
+		Interval string `yaml:"interval"`
+		// Adds the torrents of the account unknown to qbrdt
+		Adopt bool `yaml:"adopt"`
+		// Category given to the adopted torrents
+		Category string `yaml:"category"`
+	} `yaml:"import"`
+	Account struct {
+		// Seconds between two checks of the Real-Debrid account, 3600 if empty
+		Interval string `yaml:"interval"`
+		// Days before the end of the premium to start warning, 7 if 0
+		ExpiryWarning int `yaml:"expiry_warning"`
+	} `yaml:"account"`
+	Watch struct {
+		// Folder watched for the torrents without category, empty to not watch
+		Dir string `yaml:"dir"`
+		// Seconds between two scans of the watched folders, 10 if empty
+		Interval string `yaml:"interval"`
+	} `yaml:"watch"`
+	Stall struct {
+		// Seconds without progress on Real-Debrid before a torrent is failed, 0 to never
+		RealDebrid int `yaml:"realdebrid"`
+		// Seconds without receiving data before a download connection is dropped and retried, 120 if 0, -1 to never
+		Download int `yaml:"download"`
+	} `yaml:"stall"`
+	Stream struct {
+		// Serves the torrents downloaded by Real-Debrid on /webdav instead of downloading them
+		Enabled bool `yaml:"enabled"`
+		// Folder where /webdav is mounted, target of the symlinks of the categories with the symlink output
+		MountPath string `yaml:"mount_path"`
+	} `yaml:"stream"`
+	Sabnzbd struct {
+		// Key of the SABnzbd API for hoster links, empty to not serve it
+		ApiKey string `yaml:"api_key"`
+	} `yaml:"sabnzbd"`
+	Availability AvailabilityConfig        `yaml:"availability"`
+	Categories   map[string]CategoryConfig `yaml:"categories"`
+	Logger       struct {
 		Level string `yaml:"level"`
 	} `yaml:"logger"`
 }
@@ -55,6 +125,19 @@ func NewQBRDTConfig() *QBRDTConfig {
 		config.RealDebrid.Token = os.Getenv("REALDEBRID_TOKEN")
 	}
 
+	if os.Getenv("REALDEBRID_BASE_URL") != "" {
+		config.RealDebrid.BaseUrl = os.Getenv("REALDEBRID_BASE_URL")
+	}
+
+	if os.Getenv("REALDEBRID_RATE_LIMIT") != "" {
+		config.RealDebrid.RateLimit, err = strconv.Atoi(os.Getenv("REALDEBRID_RATE_LIMIT"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
 	if os.Getenv("QB_PORT") != "" {
 		config.QBittorrent.Port = os.Getenv("QB_PORT")
 	}
@@ -80,6 +163,24 @@ func NewQBRDTConfig() *QBRDTConfig {
 
 	}
 
+	if os.Getenv("DOWNLOADER_MIN_CHUNK_SIZE") != "" {
+		config.Downloader.MinChunkSize, err = strconv.ParseInt(os.Getenv("DOWNLOADER_MIN_CHUNK_SIZE"), 10, 64)
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("DOWNLOADER_MAX_CONNECTIONS_PER_HOST") != "" {
+		config.Downloader.MaxConnectionsPerHost, err = strconv.Atoi(os.Getenv("DOWNLOADER_MAX_CONNECTIONS_PER_HOST"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
 	if os.Getenv("DOWNLOADER_SPEED_LIMIT") != "" {
 		config.Downloader.SpeedLimit, err = strconv.Atoi(os.Getenv("DOWNLOADER_SPEED_LIMIT"))
 
@@ -89,5 +190,74 @@ func NewQBRDTConfig() *QBRDTConfig {
 
 	}
 
+	if os.Getenv("IMPORT_ADOPT") != "" {
+		config.Import.Adopt, err = strconv.ParseBool(os.Getenv("IMPORT_ADOPT"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("IMPORT_CATEGORY") != "" {
+		config.Import.Category = os.Getenv("IMPORT_CATEGORY")
+	}
+
+	if os.Getenv("ACCOUNT_INTERVAL") != "" {
+		config.Account.Interval = os.Getenv("ACCOUNT_INTERVAL")
+	}
+
+	if os.Getenv("ACCOUNT_EXPIRY_WARNING") != "" {
+		config.Account.ExpiryWarning, err = strconv.Atoi(os.Getenv("ACCOUNT_EXPIRY_WARNING"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("WATCH_DIR") != "" {
+		config.Watch.Dir = os.Getenv("WATCH_DIR")
+	}
+
+	if os.Getenv("WATCH_INTERVAL") != "" {
+		config.Watch.Interval = os.Getenv("WATCH_INTERVAL")
+	}
+
+	if os.Getenv("STALL_REALDEBRID") != "" {
+		config.Stall.RealDebrid, err = strconv.Atoi(os.Getenv("STALL_REALDEBRID"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("STALL_DOWNLOAD") != "" {
+		config.Stall.Download, err = strconv.Atoi(os.Getenv("STALL_DOWNLOAD"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("STREAM_ENABLED") != "" {
+		config.Stream.Enabled, err = strconv.ParseBool(os.Getenv("STREAM_ENABLED"))
+
+		if err != nil {
+			panic(err)
+		}
+
+	}
+
+	if os.Getenv("STREAM_MOUNT_PATH") != "" {
+		config.Stream.MountPath = os.Getenv("STREAM_MOUNT_PATH")
+	}
+
+	if os.Getenv("SABNZBD_API_KEY") != "" {
+		config.Sabnzbd.ApiKey = os.Getenv("SABNZBD_API_KEY")
+	}
+
 	return config
 }
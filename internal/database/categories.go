@@ -7,6 +7,8 @@ import (
 type Category struct {
 	gorm.Model
 	Name string `gorm:"unique"`
+	// Torrents of categories with a higher priority are downloaded first
+	Priority int
 }
 
 func NewCategory(name string) *Category {
@@ -21,6 +23,8 @@ type CategoryRepository struct {
 
 func NewCategoryRepository(db *gorm.DB) *CategoryRepository {
 	db.AutoMigrate(&Category{})
+	// categories deleted before would keep their name taken
+	db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&Category{})
 	return &CategoryRepository{
 		db: db,
 	}
@@ -45,6 +49,27 @@ func (r *CategoryRepository) Exist(name string) bool {
 	return true
 }
 
+func (r *CategoryRepository) FindByName(name string) (*Category, error) {
+	var category Category
+	err := r.db.Where("name = ?", name).First(&category).Error
+	return &category, err
+}
+
+// SetPriority updates the priority of a category, creating it if needed
+func (r *CategoryRepository) SetPriority(name string, priority int) error {
+	category, err := r.FindByName(name)
+	if err != nil {
+		category = NewCategory(name)
+	}
+	category.Priority = priority
+	return r.db.Save(category).Error
+}
+
+// Delete removes the category for good so that its name can be used again
+func (r *CategoryRepository) Delete(name string) error {
+	return r.db.Unscoped().Where("name = ?", name).Delete(&Category{}).Error
+}
+
 func (r *CategoryRepository) GetTorrentCategoriesDistinct() []string {
 	var categories []string
 	err := r.db.Model(&Category{}).Select("name").Find(&categories).Error
@@ -1,19 +1,43 @@
 package database
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+)
 
 type Download struct {
-	ID           uint   `json:"id" gorm:"primaryKey"`
-	UserId       int64  `json:"user_id"`
-	TorrentId    uint   `json:"torrent_id"`
-	FileName     string `json:"file_name"`
-	FileSize     int64  `json:"file_size"`
-	FilePath     string `json:"file_path"`
-	SavePath     string `json:"save_path"`
-	Url          string `json:"url"`
+	ID        uint   `json:"id" gorm:"primaryKey"`
+	UserId    int64  `json:"user_id"`
+	TorrentId uint   `json:"torrent_id"`
+	FileName  string `json:"file_name"`
+	FileSize  int64  `json:"file_size"`
+	FilePath  string `json:"file_path"`
+	SavePath  string `json:"save_path"`
+	Url       string `json:"url"`
+	// Link unrestricted to get Url, kept to get a fresh Url
+	Link         string `json:"link"`
 	IsDownloaded bool   `json:"is_downloaded"`
 	Progress     int    `json:"progress"`
 	Downloaded   int64  `json:"downloaded"`
+	Repairs      int    `json:"repairs"`
+	Error        string `json:"error"`
+	// Ranges to download again, empty to download the whole file
+	Ranges []Range `json:"ranges" gorm:"serializer:json"`
+	// Progress of the chunks when the download was interrupted by a shutdown
+	Segments []Segment `json:"segments" gorm:"serializer:json"`
+}
+
+// Range is an inclusive byte range of a file
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// Segment is where a chunk of an interrupted download stopped
+type Segment struct {
+	Index   int   `json:"index"`
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"`
+	Written int64 `json:"written"`
 }
 
 func NewDownload(userId int64, torrentId uint, fileName string, fileSize int64, filePath string, url string, downloaded bool) *Download {
@@ -51,6 +75,33 @@ func (r *DownloadRepository) FindAllByRdId(rdId uint) ([]Download, error) {
 	return downloads, err
 }
 
+// FindNextQueued returns the next download to start, ordered by torrent priority,
+// category priority and add time. Downloads already running are excluded.
+// Repairs of downloaded torrents have no queue position and go first.
+func (r *DownloadRepository) FindNextQueued(running []uint) (*Download, error) {
+	var download Download
+	query := r.db.Model(&Download{}).
+		Select("downloads.*").
+		Joins("JOIN torrents ON torrents.id = downloads.torrent_id AND torrents.deleted_at IS NULL").
+		Joins("LEFT JOIN categories ON categories.name = torrents.category AND categories.deleted_at IS NULL").
+		Where("downloads.is_downloaded = ?", false).
+		Where("torrents.internal_status <> ?", TorrentInternalError).
+		Where("torrents.paused = ?", false)
+
+	if len(running) > 0 {
+		query = query.Where("downloads.id NOT IN ?", running)
+	}
+
+	err := query.
+		Order("torrents.priority ASC").
+		Order("COALESCE(categories.priority, 0) DESC").
+		Order("torrents.created_at ASC").
+		Order("downloads.id ASC").
+		Take(&download).Error
+
+	return &download, err
+}
+
 func (r *DownloadRepository) CleanAllDownloads() error {
 	return r.db.Where("is_downloaded=?", 0).Delete(&Download{}).Error
 }
@@ -1,6 +1,10 @@
 package database
 
-import "gorm.io/gorm"
+import (
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
 
 type Preferences struct {
 	gorm.Model
@@ -52,3 +56,8 @@ func (r *PreferencesRepository) GetSavePath() string {
 	}
 	return p.SavePath
 }
+
+// CategorySavePath returns where the torrents of category are saved, the save path without a category
+func (r *PreferencesRepository) CategorySavePath(category string) string {
+	return filepath.Join(r.GetSavePath(), category)
+}
@@ -1,11 +1,15 @@
 package database
 
 import (
+	"errors"
 	"sync"
 
 	"gorm.io/gorm"
 )
 
+// ErrDuplicateHash is returned by CreateQueued for a torrent already known
+var ErrDuplicateHash = errors.New("torrent already exists")
+
 type TorrentStatus string
 
 const (
@@ -13,6 +17,8 @@ const (
 	TorrentStatusDownloading TorrentStatus = "downloading"
 	TorrentStatusDownloaded  TorrentStatus = "downloaded"
 	TorrentStatusError       TorrentStatus = "error"
+	// The torrent is not on Real-Debrid anymore
+	TorrentStatusMissing TorrentStatus = "missing"
 )
 
 type AddedBy string
@@ -20,6 +26,14 @@ type AddedBy string
 const (
 	WebInterface AddedBy = "web"
 	Qbittorent   AddedBy = "qbittorent"
+	// Found on the Real-Debrid account by TorrentImporter
+	Imported AddedBy = "import"
+	// Added through the SABnzbd API, hidden from the qBittorrent API
+	Sabnzbd AddedBy = "sabnzbd"
+	// Added through the Transmission RPC
+	Transmission AddedBy = "transmission"
+	// Added through the Deluge JSON-RPC
+	Deluge AddedBy = "deluge"
 )
 
 type TorrentType string
@@ -27,6 +41,8 @@ type TorrentType string
 const (
 	TorrentTypeMagnet TorrentType = "magnet"
 	TorrentTypeFile   TorrentType = "file"
+	// Hoster links unrestricted by Real-Debrid, nothing is downloaded by Real-Debrid itself
+	TorrentTypeLink TorrentType = "link"
 )
 
 type TorrentInternalStatus string
@@ -36,6 +52,7 @@ const (
 	TorrentInternalWaitingForDownload TorrentInternalStatus = "waiting_for_download"
 	TorrentInternalDownloading        TorrentInternalStatus = "downloading"
 	TorrentInternalDownloaded         TorrentInternalStatus = "downloaded"
+	TorrentInternalChecking           TorrentInternalStatus = "checking"
 	TorrentInternalError              TorrentInternalStatus = "error"
 )
 
@@ -46,7 +63,7 @@ type Torrent struct {
 	Downloads      []Download            `json:"downloads"`
 	Category       string                `json:"category"`
 	AddedBy        AddedBy               `json:"added_by"`
-	RDId           string                `json:"rd_id" gorm:"unique"`
+	RDId           string                `json:"rd_id" gorm:"uniqueIndex:idx_torrents_rd_id,where:rd_id <> ''"`
 	RDProgress     float64               `json:"rd_progress"`
 	RDName         string                `json:"rd_name"`
 	RDSize         int                   `json:"rd_size"`
@@ -56,6 +73,20 @@ type Torrent struct {
 	RDSeeders      int                   `json:"rd_seeders"`
 	RDHash         string                `json:"rd_hash"`
 	InternalStatus TorrentInternalStatus `json:"internal_status"`
+	// Position in the download queue starting at 1, 0 once downloaded
+	Priority int `json:"priority"`
+	// Content of the .torrent file, empty for magnets
+	TorrentFile []byte `json:"-"`
+	// Magnet link, empty for .torrent files
+	Magnet string `json:"-"`
+	// Hoster links of a link torrent
+	Links []string `json:"-" gorm:"serializer:json"`
+	// Stopped by the user: not sent to Real-Debrid and no new download started
+	Paused bool `json:"paused"`
+	// Files served from Real-Debrid over WebDAV instead of downloaded
+	Streamed bool `json:"streamed"`
+	// Why the torrent failed, empty if it did not
+	Error string `json:"error"`
 }
 
 type TorrentRepository struct {
@@ -75,6 +106,115 @@ func (r *TorrentRepository) Create(torrent *Torrent) error {
 	return r.db.Create(torrent).Error
 }
 
+// CreateQueued saves the torrent at the end of the download queue, but before
+// the torrents of categories with a lower priority. It fails with ErrDuplicateHash
+// if a torrent has the same hash.
+func (r *TorrentRepository) CreateQueued(torrent *Torrent) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if torrent.RDHash != "" {
+			var count int64
+			tx.Model(&Torrent{}).Where("rd_hash = ?", torrent.RDHash).Count(&count)
+			if count > 0 {
+				return ErrDuplicateHash
+			}
+		}
+
+		var categoryPriority int
+		tx.Model(&Category{}).Where("name = ?", torrent.Category).Select("priority").Scan(&categoryPriority)
+
+		var position int
+		err := tx.Model(&Torrent{}).
+			Joins("LEFT JOIN categories ON categories.name = torrents.category AND categories.deleted_at IS NULL").
+			Where("torrents.priority > 0 AND COALESCE(categories.priority, 0) >= ?", categoryPriority).
+			Select("COALESCE(MAX(torrents.priority), 0)").
+			Scan(&position).Error
+
+		if err != nil {
+			return err
+		}
+
+		position++
+		err = tx.Model(&Torrent{}).Where("priority >= ?", position).UpdateColumn("priority", gorm.Expr("priority + 1")).Error
+		if err != nil {
+			return err
+		}
+
+		torrent.Priority = position
+		return tx.Create(torrent).Error
+	})
+}
+
+// FindQueued returns the torrents not downloaded yet in queue order
+func (r *TorrentRepository) FindQueued() ([]Torrent, error) {
+	var torrents []Torrent
+	err := r.db.Where("priority > 0").Order("priority ASC").Find(&torrents).Error
+	return torrents, err
+}
+
+// SetPriorities saves the queue position of each torrent id
+func (r *TorrentRepository) SetPriorities(priorities map[uint]int) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for id, priority := range priorities {
+			if err := tx.Model(&Torrent{}).Where("id = ?", id).UpdateColumn("priority", priority).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// NormalizePriorities gives a queue position to the torrents not downloaded yet that have none,
+// and renumbers the queue without gaps
+func (r *TorrentRepository) NormalizePriorities() error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var missing []Torrent
+		err := tx.Where("priority = 0 AND internal_status <> ?", TorrentInternalDownloaded).Order("created_at ASC").Find(&missing).Error
+		if err != nil {
+			return err
+		}
+
+		var last int
+		tx.Model(&Torrent{}).Select("COALESCE(MAX(priority), 0)").Scan(&last)
+
+		for _, t := range missing {
+			last++
+			if err := tx.Model(&Torrent{}).Where("id = ?", t.ID).UpdateColumn("priority", last).Error; err != nil {
+				return err
+			}
+		}
+
+		return compactPriorities(tx)
+	})
+}
+
+// compactPriorities renumbers the queue positions from 1 without gaps
+func compactPriorities(tx *gorm.DB) error {
+	var queued []Torrent
+	if err := tx.Select("id", "priority").Where("priority > 0").Order("priority ASC").Find(&queued).Error; err != nil {
+		return err
+	}
+
+	for i, t := range queued {
+		if t.Priority == i+1 {
+			continue
+		}
+		if err := tx.Model(&Torrent{}).Where("id = ?", t.ID).UpdateColumn("priority", i+1).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *TorrentRepository) FindAll() ([]Torrent, error) {
 	var torrents []Torrent
 	err := r.db.Find(&torrents).Error
@@ -105,27 +245,190 @@ func (r *TorrentRepository) FindByHash(hash string) (*Torrent, error) {
 	return &torrent, err
 }
 
+// Update saves a torrent read by a job. The category and the pause are left out,
+// the APIs change them with SetCategory and SetPaused while the job runs. A torrent
+// deleted in the meantime is not created again.
 func (r *TorrentRepository) Update(torrent *Torrent) error {
-	return r.db.Save(torrent).Error
+	return r.db.Select("*").Omit("category", "paused").Save(torrent).Error
 }
 
 func (r *TorrentRepository) Delete(id uint) error {
-	return r.db.Delete(&Torrent{}, id).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Torrent{}).Where("id = ?", id).UpdateColumn("priority", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Torrent{}, id).Error; err != nil {
+			return err
+		}
+		return compactPriorities(tx)
+	})
 }
 
 func (r *TorrentRepository) DeleteByRDId(id string) error {
 	r.Mutex.Lock()
-	err := r.db.Where("rd_id = ?", id).Delete(&Torrent{}).Error
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Torrent{}).Where("rd_id = ?", id).UpdateColumn("priority", 0).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("rd_id = ?", id).Delete(&Torrent{}).Error; err != nil {
+			return err
+		}
+		return compactPriorities(tx)
+	})
 	r.Mutex.Unlock()
 	return err
 }
 
+// FindWaitingAdmission returns the torrents not sent to Real-Debrid yet, in queue order
+func (r *TorrentRepository) FindWaitingAdmission() ([]Torrent, error) {
+	var torrents []Torrent
+	err := r.db.Where("rd_id = '' AND type <> ? AND paused = ? AND status <> ?", TorrentTypeLink, false, TorrentStatusError).Order("priority ASC").Order("created_at ASC").Find(&torrents).Error
+	return torrents, err
+}
+
+// CountActiveDownloads returns the number of torrents sent to Real-Debrid and not downloaded by it yet
+func (r *TorrentRepository) CountActiveDownloads() int64 {
+	var count int64
+	r.db.Model(&Torrent{}).Where("rd_id <> '' AND status NOT IN ? AND internal_status <> ?", []TorrentStatus{TorrentStatusDownloaded, TorrentStatusMissing}, TorrentInternalError).Count(&count)
+	return count
+}
+
+// CountActiveTorrents returns the number of torrents sent to Real-Debrid and not downloaded locally yet
+func (r *TorrentRepository) CountActiveTorrents() int64 {
+	var count int64
+	r.db.Model(&Torrent{}).Where("rd_id <> '' AND status <> ? AND internal_status NOT IN ?", TorrentStatusMissing, []TorrentInternalStatus{TorrentInternalDownloaded, TorrentInternalError}).Count(&count)
+	return count
+}
+
+// FindStreamed returns the streamed torrents with their files
+func (r *TorrentRepository) FindStreamed() ([]Torrent, error) {
+	var torrents []Torrent
+	if err := r.db.Where("streamed = ? AND internal_status = ?", true, TorrentInternalDownloaded).Find(&torrents).Error; err != nil {
+		return nil, err
+	}
+	return torrents, r.withDownloads(torrents)
+}
+
+// FindAllWithDownloads returns every torrent with its files, in queue order then newest first
+func (r *TorrentRepository) FindAllWithDownloads() ([]Torrent, error) {
+	var torrents []Torrent
+	if err := r.db.Order("priority = 0").Order("priority ASC").Order("created_at DESC").Find(&torrents).Error; err != nil {
+		return nil, err
+	}
+	return torrents, r.withDownloads(torrents)
+}
+
+// withDownloads fills the Downloads of torrents with a single query
+func (r *TorrentRepository) withDownloads(torrents []Torrent) error {
+	ids := make([]uint, len(torrents))
+	for i, torrent := range torrents {
+		ids[i] = torrent.ID
+	}
+
+	var downloads []Download
+	if err := r.db.Where("torrent_id IN ?", ids).Order("id ASC").Find(&downloads).Error; err != nil {
+		return err
+	}
+
+	byTorrent := make(map[uint][]Download)
+	for _, download := range downloads {
+		byTorrent[download.TorrentId] = append(byTorrent[download.TorrentId], download)
+	}
+	for i := range torrents {
+		torrents[i].Downloads = byTorrent[torrents[i].ID]
+	}
+
+	return nil
+}
+
+// SetStreamed marks the files of a torrent as served over WebDAV, the torrent is complete
+func (r *TorrentRepository) SetStreamed(torrentId uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Torrent{}).Where("id = ?", torrentId).Updates(map[string]interface{}{"streamed": true, "status": TorrentStatusDownloaded, "internal_status": TorrentInternalDownloaded, "priority": 0}).Error
+		if err != nil {
+			return err
+		}
+		return compactPriorities(tx)
+	})
+}
+
+// SetCategory moves a torrent to another category, its downloads are saved in savePath
+func (r *TorrentRepository) SetCategory(torrentId uint, category string, savePath string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Torrent{}).Where("id = ?", torrentId).UpdateColumn("category", category).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Download{}).Where("torrent_id = ?", torrentId).UpdateColumn("save_path", savePath).Error
+	})
+}
+
+// Retry starts a failed torrent again at the end of the queue. The files that failed are downloaded
+// again, a torrent that failed on Real-Debrid is sent to it again.
+func (r *TorrentRepository) Retry(torrent *Torrent) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var failed int64
+		tx.Model(&Download{}).Where("torrent_id = ? AND is_downloaded = ?", torrent.ID, false).Count(&failed)
+
+		updates := map[string]interface{}{"error": ""}
+		switch {
+		case failed > 0:
+			err := tx.Model(&Download{}).Where("torrent_id = ? AND is_downloaded = ?", torrent.ID, false).
+				Updates(map[string]interface{}{"repairs": 0, "error": ""}).Error
+			if err != nil {
+				return err
+			}
+			updates["internal_status"] = TorrentInternalDownloading
+		case torrent.Type == TorrentTypeLink:
+			// the links are unrestricted again by the updater
+			updates["status"] = TorrentStatusDownloaded
+			updates["internal_status"] = TorrentInternalWaitingForDownload
+		default:
+			if err := tx.Where("torrent_id = ?", torrent.ID).Delete(&Download{}).Error; err != nil {
+				return err
+			}
+			updates["rd_id"] = ""
+			updates["rd_progress"] = 0
+			updates["status"] = TorrentStatusQueued
+			updates["internal_status"] = TorrentInternalWaiting
+			// imported torrents have nothing else to send
+			if len(torrent.TorrentFile) == 0 && torrent.Magnet == "" {
+				updates["magnet"] = "magnet:?xt=urn:btih:" + torrent.RDHash
+			}
+		}
+
+		if torrent.Priority == 0 {
+			var last int
+			tx.Model(&Torrent{}).Select("COALESCE(MAX(priority), 0)").Scan(&last)
+			updates["priority"] = last + 1
+		}
+
+		if err := tx.Model(&Torrent{}).Where("id = ?", torrent.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		return compactPriorities(tx)
+	})
+}
+
+// SetPaused stops or starts the torrents
+func (r *TorrentRepository) SetPaused(ids []uint, paused bool) error {
+	return r.db.Model(&Torrent{}).Where("id IN ?", ids).UpdateColumn("paused", paused).Error
+}
+
 func (r *TorrentRepository) FindByStatus(status TorrentStatus) ([]Torrent, error) {
 	var torrents []Torrent
 	err := r.db.Where("status = ?", status).Find(&torrents).Error
 	return torrents, err
 }
 
+func (r *TorrentRepository) FindByInternalStatus(status TorrentInternalStatus) ([]Torrent, error) {
+	var torrents []Torrent
+	err := r.db.Where("internal_status = ?", status).Find(&torrents).Error
+	return torrents, err
+}
+
 func (r *TorrentRepository) FindByCategory(category string) ([]Torrent, error) {
 	var torrents []Torrent
 	err := r.db.Where("category = ?", category).Find(&torrents).Error
@@ -165,8 +468,19 @@ func (r *TorrentRepository) HasDownload(torrentId uint) bool {
 func (r *TorrentRepository) UpdateTorrentStatusToDownloaded(torrentId uint) error {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
-	return r.db.Model(&Torrent{}).Where("id = ?", torrentId).Updates(map[string]interface{}{"status": TorrentStatusDownloaded, "internal_status": TorrentInternalDownloaded}).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Torrent{}).Where("id = ?", torrentId).Updates(map[string]interface{}{"status": TorrentStatusDownloaded, "internal_status": TorrentInternalDownloaded, "priority": 0}).Error
+		if err != nil {
+			return err
+		}
+		return compactPriorities(tx)
+	})
+}
 
+func (r *TorrentRepository) UpdateInternalStatus(torrentId uint, status TorrentInternalStatus) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	return r.db.Model(&Torrent{}).Where("id = ?", torrentId).Update("internal_status", status).Error
 }
 
 func (r *TorrentRepository) FindAllDownloadByRdId(torrentId uint) ([]Download, error) {
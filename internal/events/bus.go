@@ -0,0 +1,150 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Types of the events
+const (
+	TorrentAdded   = "torrent.added"
+	TorrentChanged = "torrent.changed"
+	TorrentRemoved = "torrent.removed"
+
+	DownloadStarted  = "download.started"
+	DownloadProgress = "download.progress"
+	DownloadFinished = "download.finished"
+	DownloadFailed   = "download.failed"
+
+	AccountExpiring = "account.expiring"
+)
+
+const (
+	// progress events of a download are published at most this often
+	progressInterval = time.Second
+	// events buffered per subscriber, a slower subscriber misses events
+	subscriberBuffer = 256
+)
+
+// Event is something that happened to a torrent or to one of its files
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"`
+	Name string    `json:"name"`
+	// TorrentData, DownloadData or AccountData
+	Data interface{} `json:"data,omitempty"`
+}
+
+// TorrentData is the state of a torrent after a torrent event
+type TorrentData struct {
+	Category       string  `json:"category"`
+	RDStatus       string  `json:"rd_status"`
+	InternalStatus string  `json:"internal_status"`
+	CloudProgress  float64 `json:"cloud_progress"`
+	Paused         bool    `json:"paused"`
+}
+
+// DownloadData is the progress of a file after a download event
+type DownloadData struct {
+	File       string  `json:"file"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Speed      int64   `json:"speed"`
+	Error      string  `json:"error,omitempty"`
+	Progress   float64 `json:"progress"`
+}
+
+// AccountData is the end of the premium after an account event, Name is the username
+type AccountData struct {
+	Expiration time.Time `json:"expiration"`
+	DaysLeft   int       `json:"days_left"`
+}
+
+// Bus sends the events to every subscriber without ever blocking the publisher
+type Bus struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+	// last progress event per download
+	progress map[uint]time.Time
+	closed   bool
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscribers: make(map[chan Event]struct{}),
+		progress:    make(map[uint]time.Time),
+	}
+}
+
+// Subscribe returns the events published from now on, until cancel is called.
+// The channel is closed by cancel or by Close.
+func (b *Bus) Subscribe() (events <-chan Event, cancel func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.lock.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.lock.Unlock()
+
+	return ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends the subscriptions, so that the streams of the subscribers end on shutdown
+func (b *Bus) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Progress reports if a progress event of download id can be published now, and
+// if so counts it as published
+func (b *Bus) Progress(id uint) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.subscribers) == 0 || time.Since(b.progress[id]) < progressInterval {
+		return false
+	}
+	b.progress[id] = time.Now()
+	return true
+}
+
+// Finished forgets the progress events of download id
+func (b *Bus) Finished(id uint) {
+	b.lock.Lock()
+	delete(b.progress, id)
+	b.lock.Unlock()
+}
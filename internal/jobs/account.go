@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/events"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// Days before the end of the premium to start warning when not configured
+const DefaultExpiryWarning = 7
+
+// The expiry warning is logged and published again after this delay
+const expiryWarningRepeat = 24 * time.Hour
+
+// Account is the state of the Real-Debrid account at the last check
+type Account struct {
+	Username string `json:"username"`
+	Type     string `json:"type"`
+	// The account can download torrents
+	Premium    bool      `json:"premium"`
+	Expiration time.Time `json:"expiration"`
+	// Seconds left as a premium user
+	PremiumLeft int64 `json:"premium_left"`
+	// Fidelity points
+	Points int `json:"points"`
+	// The premium ends in less than the expiry warning
+	Expiring bool `json:"expiring"`
+	// Traffic left on the limited hosts, by host
+	Traffic   map[string]realdebrid.Traffic `json:"traffic"`
+	CheckedAt time.Time                     `json:"checked_at"`
+}
+
+// AccountMonitor checks the Real-Debrid account and warns before the premium ends
+type AccountMonitor struct {
+	client        *realdebrid.Client
+	breaker       *CircuitBreaker
+	events        *events.Bus
+	logger        logger.Interface
+	expiryWarning time.Duration
+
+	lock    sync.Mutex
+	user    *realdebrid.User
+	traffic map[string]realdebrid.Traffic
+	checked time.Time
+	warned  time.Time
+}
+
+func NewAccountMonitor(client *realdebrid.Client, breaker *CircuitBreaker, bus *events.Bus, expiryWarningDays int, logger logger.Interface) *AccountMonitor {
+	if expiryWarningDays <= 0 {
+		expiryWarningDays = DefaultExpiryWarning
+	}
+
+	return &AccountMonitor{
+		client:        client,
+		breaker:       breaker,
+		events:        bus,
+		logger:        logger,
+		expiryWarning: time.Duration(expiryWarningDays) * 24 * time.Hour,
+	}
+}
+
+func (am *AccountMonitor) Run() {
+	if !am.breaker.Allow() {
+		return
+	}
+
+	user, err := am.client.GetUser()
+	if err != nil {
+		am.breaker.Failure(err)
+		am.logger.Error("Error getting the Real-Debrid account: %s", err)
+		return
+	}
+	am.breaker.Success()
+
+	// only some hosts are limited, the account is usable without it
+	traffic, err := am.client.GetTraffic()
+	if err != nil {
+		am.logger.Warn("Error getting the Real-Debrid traffic: %s", err)
+	}
+
+	am.lock.Lock()
+	am.user = user
+	if err == nil {
+		am.traffic = traffic
+	}
+	am.checked = time.Now()
+	am.lock.Unlock()
+
+	account, _ := am.Account()
+
+	if !account.Premium {
+		am.logger.Error("The Real-Debrid account %s is not premium, torrents can't be added", account.Username)
+		return
+	}
+
+	if account.Expiring {
+		am.warnExpiring(account)
+	}
+}
+
+func (am *AccountMonitor) warnExpiring(account Account) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	if time.Since(am.warned) < expiryWarningRepeat {
+		return
+	}
+	am.warned = time.Now()
+
+	days := int(time.Until(account.Expiration).Hours() / 24)
+	am.logger.Warn("The Real-Debrid premium of %s ends in %d days, on %s", account.Username, days, account.Expiration.Format(time.DateOnly))
+	am.events.Publish(events.Event{
+		Type: events.AccountExpiring,
+		Name: account.Username,
+		Data: events.AccountData{Expiration: account.Expiration, DaysLeft: days},
+	})
+}
+
+// Account returns the account at the last check, false if it was never checked
+func (am *AccountMonitor) Account() (Account, bool) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	if am.user == nil {
+		return Account{}, false
+	}
+
+	account := Account{
+		Username:  am.user.Username,
+		Type:      string(am.user.Type),
+		Points:    am.user.Points,
+		Traffic:   am.traffic,
+		CheckedAt: am.checked,
+	}
+
+	if expiration, err := time.Parse(time.RFC3339, am.user.Expiration); err == nil {
+		account.Expiration = expiration
+	} else {
+		account.Expiration = am.checked.Add(time.Duration(am.user.Premium) * time.Second)
+	}
+
+	// the premium may have ended since the last check
+	left := time.Until(account.Expiration)
+	account.Premium = am.user.Type == realdebrid.UserTypePremium && left > 0
+	if account.Premium {
+		account.PremiumLeft = int64(left.Seconds())
+		account.Expiring = left < am.expiryWarning
+	}
+
+	return account, true
+}
+
+// CanAdd reports if torrents can be sent to Real-Debrid. Before the first
+// check the account is assumed to be premium.
+func (am *AccountMonitor) CanAdd() bool {
+	account, ok := am.Account()
+	return !ok || account.Premium
+}
@@ -0,0 +1,374 @@
+package jobs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+var (
+	ErrInvalidTorrent = errors.New("invalid torrent file")
+	ErrInvalidMagnet  = metainfo.ErrInvalidMagnet
+	ErrNotPremium     = errors.New("the Real-Debrid account is not premium")
+	ErrTorrentExists  = database.ErrDuplicateHash
+	ErrInvalidLink    = errors.New("invalid hoster link")
+	// the name would not be a single folder of the save path
+	ErrInvalidName = errors.New("invalid name")
+	// Real-Debrid is failing, links can't be checked
+	ErrUnavailable = errors.New("Real-Debrid is unavailable")
+)
+
+// TorrentAdmission sends the torrents waiting locally to Real-Debrid
+// as long as the active torrent limits allow it
+type TorrentAdmission struct {
+	client             *realdebrid.Client
+	cache              *realdebrid.Cache
+	breaker            *CircuitBreaker
+	account            *AccountMonitor
+	availability       *Availability
+	torrents           *database.TorrentRepository
+	logger             logger.Interface
+	maxActiveDownloads int
+	maxActiveTorrents  int
+	maxRDActive        int
+	lock               sync.Mutex
+	stopped            bool
+}
+
+func NewTorrentAdmission(client *realdebrid.Client,
+	cache *realdebrid.Cache,
+	breaker *CircuitBreaker,
+	account *AccountMonitor,
+	availability *Availability,
+	torrents *database.TorrentRepository,
+	maxActiveDownloads, maxActiveTorrents, maxRDActive int,
+	logger logger.Interface) *TorrentAdmission {
+	return &TorrentAdmission{
+		client:             client,
+		cache:              cache,
+		breaker:            breaker,
+		account:            account,
+		availability:       availability,
+		torrents:           torrents,
+		logger:             logger,
+		maxActiveDownloads: maxActiveDownloads,
+		maxActiveTorrents:  maxActiveTorrents,
+		maxRDActive:        maxRDActive,
+	}
+}
+
+// AddTorrent queues a .torrent file, it is sent to Real-Debrid once a slot is free
+func (ta *TorrentAdmission) AddTorrent(torrentFile []byte, category string, addedBy database.AddedBy) (*database.Torrent, error) {
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTorrent, err)
+	}
+
+	return ta.enqueue(addedBy, &database.Torrent{
+		Type:        database.TorrentTypeFile,
+		Category:    category,
+		RDName:      meta.Name,
+		RDSize:      int(meta.TotalLength),
+		RDHash:      meta.InfoHash,
+		TorrentFile: torrentFile,
+	})
+}
+
+// AddMagnet queues a magnet link, it is sent to Real-Debrid once a slot is free
+func (ta *TorrentAdmission) AddMagnet(magnet string, category string, addedBy database.AddedBy) (*database.Torrent, error) {
+	link, err := metainfo.ParseMagnet(magnet)
+	if err != nil {
+		return nil, err
+	}
+
+	name := link.Name
+	if name == "" {
+		name = link.InfoHash
+	}
+
+	return ta.enqueue(addedBy, &database.Torrent{
+		Type:     database.TorrentTypeMagnet,
+		Category: category,
+		RDName:   name,
+		RDHash:   link.InfoHash,
+		Magnet:   strings.TrimSpace(magnet),
+	})
+}
+
+// AddLinks checks hoster links with Real-Debrid and queues them as a single torrent named
+// name, or after the first file if empty. Real-Debrid downloads nothing, the files are
+// unrestricted again by the updater when the torrent gets its turn.
+func (ta *TorrentAdmission) AddLinks(links []string, name string, category string, addedBy database.AddedBy) (*database.Torrent, error) {
+	if !ta.account.CanAdd() {
+		return nil, ErrNotPremium
+	}
+
+	if name != "" && !validName(name) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidName, name)
+	}
+
+	for _, link := range links {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidLink, link)
+		}
+	}
+
+	torrent := &database.Torrent{
+		Type:           database.TorrentTypeLink,
+		Category:       category,
+		RDName:         name,
+		RDHash:         linksHash(links),
+		RDProgress:     100,
+		InternalStatus: database.TorrentInternalWaitingForDownload,
+		Links:          links,
+	}
+
+	if _, err := ta.torrents.FindByHash(torrent.RDHash); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTorrentExists, links[0])
+	}
+
+	for _, link := range links {
+		if !ta.breaker.Allow() {
+			return nil, ErrUnavailable
+		}
+
+		unrestricted, err := ta.client.Unrestrict(link)
+		if err != nil {
+			ta.breaker.Failure(err)
+			if realdebrid.Classify(err).Transient() {
+				return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+			}
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidLink, link, err)
+		}
+		ta.breaker.Success()
+
+		if torrent.RDName == "" {
+			filename := filepath.Base(unrestricted.Filename)
+			torrent.RDName = filename
+			if len(links) > 1 {
+				torrent.RDName = strings.TrimSuffix(filename, path.Ext(filename))
+			}
+			if !validName(torrent.RDName) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidName, unrestricted.Filename)
+			}
+		}
+		torrent.RDSize += int(unrestricted.FileSize)
+	}
+
+	// nothing to wait for on Real-Debrid
+	torrent.Status = database.TorrentStatusDownloaded
+	torrent.AddedBy = addedBy
+	if err := ta.torrents.CreateQueued(torrent); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, torrent.RDName)
+	}
+	return torrent, nil
+}
+
+// validName reports if name can be used as the folder of a torrent, inside the save path
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// linksHash gives hoster links a hash like a torrent, the same links get the same hash
+func linksHash(links []string) string {
+	hash := sha1.Sum([]byte(strings.Join(links, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+func (ta *TorrentAdmission) enqueue(addedBy database.AddedBy, torrent *database.Torrent) (*database.Torrent, error) {
+	if !ta.account.CanAdd() {
+		return nil, ErrNotPremium
+	}
+
+	if _, err := ta.torrents.FindByHash(torrent.RDHash); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTorrentExists, torrent.RDName)
+	}
+
+	if err := ta.availability.Check(torrent.RDHash, torrent.Category); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, torrent.RDName)
+	}
+
+	torrent.Status = database.TorrentStatusQueued
+	torrent.AddedBy = addedBy
+	if err := ta.torrents.CreateQueued(torrent); err != nil {
+		return nil, fmt.Errorf("%w: %s", err, torrent.RDName)
+	}
+	return torrent, nil
+}
+
+func (ta *TorrentAdmission) Run() {
+	ta.lock.Lock()
+	defer ta.lock.Unlock()
+
+	if ta.stopped || !ta.breaker.Allow() {
+		return
+	}
+
+	waiting, err := ta.torrents.FindWaitingAdmission()
+	if err != nil {
+		ta.logger.Error("Error getting torrents waiting for admission: %s", err)
+		return
+	}
+
+	if len(waiting) == 0 {
+		return
+	}
+
+	if !ta.account.CanAdd() {
+		ta.logger.Debug("%d torrents waiting for the Real-Debrid account to be premium", len(waiting))
+		return
+	}
+
+	slots := ta.freeSlots()
+	if slots <= 0 {
+		ta.logger.Debug("%d torrents waiting for a free slot on Real-Debrid", len(waiting))
+		return
+	}
+
+	for i := range waiting {
+		if slots == 0 {
+			return
+		}
+
+		sent, next := ta.submit(&waiting[i])
+		if !next {
+			// Real-Debrid is failing or full, retry on next run
+			return
+		}
+		if sent {
+			slots--
+		}
+	}
+}
+
+// Stop waits for the running admission and prevents new ones
+func (ta *TorrentAdmission) Stop() {
+	ta.lock.Lock()
+	ta.stopped = true
+	ta.lock.Unlock()
+}
+
+// freeSlots returns how many torrents can be sent to Real-Debrid now
+func (ta *TorrentAdmission) freeSlots() int {
+	slots := math.MaxInt
+
+	if ta.maxActiveDownloads > 0 {
+		slots = min(slots, ta.maxActiveDownloads-int(ta.torrents.CountActiveDownloads()))
+	}
+
+	if ta.maxActiveTorrents > 0 {
+		slots = min(slots, ta.maxActiveTorrents-int(ta.torrents.CountActiveTorrents()))
+	}
+
+	if ta.maxRDActive > 0 {
+		active, err := ta.client.GetTorrents(&realdebrid.TorrentOptions{Filter: realdebrid.TorrentFilterActive, Limit: ta.maxRDActive})
+		if err != nil {
+			ta.breaker.Failure(err)
+			ta.logger.Error("Error getting active torrents on Real-Debrid: %s", err)
+			return 0
+		}
+		slots = min(slots, ta.maxRDActive-len(active))
+	}
+
+	return slots
+}
+
+// reject fails a torrent Real-Debrid will never accept, so that the queue moves on
+func (ta *TorrentAdmission) reject(torrent *database.Torrent, reason string) {
+	ta.logger.Error("Torrent %s refused: %s", torrent.RDName, reason)
+
+	torrent.Status = database.TorrentStatusError
+	torrent.InternalStatus = database.TorrentInternalError
+	torrent.Error = reason
+	if err := ta.torrents.Update(torrent); err != nil {
+		ta.logger.Error("Failed to save torrent %s", err.Error())
+	}
+}
+
+// submit sends torrent to Real-Debrid. It reports if it was sent, and if the next
+// torrents can be sent: not when Real-Debrid is failing or the account is full.
+func (ta *TorrentAdmission) submit(torrent *database.Torrent) (sent bool, next bool) {
+	var (
+		add *realdebrid.AddTorrent
+		err error
+	)
+
+	switch {
+	case len(torrent.TorrentFile) > 0:
+		add, err = ta.client.AddTorrent(bytes.NewReader(torrent.TorrentFile))
+	case torrent.Magnet != "":
+		add, err = ta.client.AddMagnet(torrent.Magnet)
+	default:
+		ta.reject(torrent, "nothing to send to Real-Debrid")
+		return false, true
+	}
+
+	if err != nil {
+		ta.breaker.Failure(err)
+		class := realdebrid.Classify(err)
+		if class.Transient() || class == realdebrid.ClassAuth || errors.Is(err, realdebrid.ErrActiveLimit) {
+			ta.logger.Error("Failed to add torrent %s to Real-Debrid: %s", torrent.RDName, err)
+			return false, false
+		}
+
+		ta.reject(torrent, "refused by Real-Debrid: "+err.Error())
+		return false, true
+	}
+
+	if add.Id == "" {
+		ta.reject(torrent, "refused by Real-Debrid")
+		return false, true
+	}
+
+	ta.breaker.Success()
+	ta.cache.Invalidate(add.Id)
+	ta.logger.Info("Torrent %s sent to Real-Debrid", torrent.RDName)
+
+	torrent.RDId = add.Id
+	torrent.Status = database.TorrentStatusDownloading
+
+	rdTorrent, err := ta.cache.Torrent(add.Id)
+
+	if err != nil {
+		// the updater fills the details on its next run
+		ta.logger.Error("Failed to get torrent %s", err.Error())
+	} else {
+		torrent.RDProgress = rdTorrent.Progress
+		// a magnet being converted is named after its hash, keep the name of the link
+		if rdTorrent.Status != "magnet_conversion" {
+			torrent.RDName = rdTorrent.Filename
+		}
+		torrent.RDSize = rdTorrent.Bytes
+		torrent.RDSplit = rdTorrent.Split
+		torrent.RDHost = rdTorrent.Host
+		torrent.RDHash = rdTorrent.Hash
+
+		if rdTorrent.Speed != nil {
+			torrent.RDSpeed = *rdTorrent.Speed
+		}
+
+		if rdTorrent.Seeders != nil {
+			torrent.RDSeeders = *rdTorrent.Seeders
+		}
+	}
+
+	if err := ta.torrents.Update(torrent); err != nil {
+		ta.logger.Error("Failed to save torrent %s", err.Error())
+	}
+
+	return true, true
+}
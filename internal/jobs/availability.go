@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// ErrNotCached is returned by Availability.Check for a torrent refused by the policy
+var ErrNotCached = errors.New("torrent not cached on Real-Debrid")
+
+// AvailabilityPolicy decides what happens to the torrents Real-Debrid can't download instantly
+type AvailabilityPolicy struct {
+	// torrents not in the Real-Debrid cache are refused when added
+	RejectUncached bool
+	// time a torrent can stay on Real-Debrid without being downloaded, 0 for no limit
+	MaxWait time.Duration
+	// once MaxWait is over, torrents with at least this many seeders are kept
+	MinSeeders int
+}
+
+// Real-Debrid statuses of a torrent not downloaded yet
+var waitingStatuses = map[string]bool{
+	"magnet_conversion":       true,
+	"waiting_files_selection": true,
+	"queued":                  true,
+	"downloading":             true,
+}
+
+// Uncached reports if the policy refuses torrent because Real-Debrid has to download it.
+// Real-Debrid gives a cached torrent as downloaded as soon as its files are selected.
+func (p AvailabilityPolicy) Uncached(torrent *realdebrid.Torrent) bool {
+	return p.RejectUncached && (torrent.Status == "queued" || torrent.Status == "downloading")
+}
+
+// Expired reports if torrent waited longer than the policy allows
+func (p AvailabilityPolicy) Expired(torrent *realdebrid.Torrent) bool {
+	if p.MaxWait <= 0 || !waitingStatuses[torrent.Status] || torrent.Added.IsZero() {
+		return false
+	}
+
+	if time.Since(torrent.Added) < p.MaxWait {
+		return false
+	}
+
+	if p.MinSeeders == 0 {
+		return true
+	}
+
+	return torrent.Seeders == nil || *torrent.Seeders < p.MinSeeders
+}
+
+// Availability applies the availability policy of each category
+type Availability struct {
+	client     *realdebrid.Client
+	logger     logger.Interface
+	global     AvailabilityPolicy
+	categories map[string]AvailabilityPolicy
+	// the disabled instantAvailability is reported once
+	disabled sync.Once
+}
+
+func NewAvailability(client *realdebrid.Client, global AvailabilityPolicy, categories map[string]AvailabilityPolicy, logger logger.Interface) *Availability {
+	return &Availability{
+		client:     client,
+		logger:     logger,
+		global:     global,
+		categories: categories,
+	}
+}
+
+// Policy returns the policy of category, the global one if it has none
+func (a *Availability) Policy(category string) AvailabilityPolicy {
+	if policy, ok := a.categories[category]; ok {
+		return policy
+	}
+	return a.global
+}
+
+// Check returns ErrNotCached when the policy of category refuses the torrent of hash.
+// Torrents are accepted when Real-Debrid can't be asked, the updater fails them once
+// their files are selected if they are not cached, see AvailabilityPolicy.Uncached.
+func (a *Availability) Check(hash, category string) error {
+	if !a.Policy(category).RejectUncached {
+		return nil
+	}
+
+	hash = strings.ToLower(hash)
+	available, err := a.client.InstantAvailability(hash)
+	if errors.Is(err, realdebrid.ErrDisabledEndpoint) {
+		a.disabled.Do(func() {
+			a.logger.Info("Real-Debrid does not tell which torrents are cached anymore, uncached torrents are failed once their files are selected")
+		})
+		return nil
+	}
+	if err != nil {
+		a.logger.Warn("Could not check if %s is cached on Real-Debrid, it is checked once its files are selected: %s", hash, err)
+		return nil
+	}
+
+	if !available[hash] {
+		return ErrNotCached
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+const (
+	// Transient errors in a row opening the circuit
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+	// the cooldown doubles each time the circuit opens again, up to this
+	breakerMaxCooldown = 10 * time.Minute
+)
+
+// CircuitBreaker stops the jobs from calling Real-Debrid while it is down.
+// After breakerThreshold transient errors in a row, or a rate limit, calls are
+// refused for a cooldown. Then calls are allowed again, but the first transient
+// error opens the circuit again until a call succeeds.
+type CircuitBreaker struct {
+	logger   logger.Interface
+	lock     sync.Mutex
+	failures int
+	// number of times the circuit opened without a success in between
+	trips     int
+	openUntil time.Time
+}
+
+func NewCircuitBreaker(logger logger.Interface) *CircuitBreaker {
+	return &CircuitBreaker{logger: logger}
+}
+
+// Allow reports if Real-Debrid can be called
+func (cb *CircuitBreaker) Allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	return !time.Now().Before(cb.openUntil)
+}
+
+// Success closes the circuit
+func (cb *CircuitBreaker) Success() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.trips > 0 {
+		cb.logger.Info("Real-Debrid is reachable again")
+	}
+
+	cb.failures = 0
+	cb.trips = 0
+}
+
+// Failure records the error of a call to Real-Debrid, only transient errors count
+func (cb *CircuitBreaker) Failure(err error) {
+	class := realdebrid.Classify(err)
+	if !class.Transient() {
+		return
+	}
+
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	// calls started before the circuit opened
+	if time.Now().Before(cb.openUntil) {
+		return
+	}
+
+	cb.failures++
+
+	// a rate limit or an error after a cooldown opens the circuit right away
+	if cb.failures < breakerThreshold && cb.trips == 0 && class != realdebrid.ClassRateLimit {
+		return
+	}
+
+	cooldown := breakerCooldown << min(cb.trips, 5)
+	cooldown = min(cooldown, breakerMaxCooldown)
+
+	cb.trips++
+	cb.failures = 0
+	cb.openUntil = time.Now().Add(cooldown)
+
+	cb.logger.Warn("Real-Debrid is failing (%s error: %s), pausing calls for %s", class, err, cooldown)
+}
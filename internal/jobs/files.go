@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// ErrTorrentDownloading is returned by SetCategory while the files of the torrent are written
+var ErrTorrentDownloading = errors.New("torrent is downloading")
+
+// TorrentFolder returns where the files of torrent are saved under savePath,
+// refusing a category or a name that leads outside of it
+func TorrentFolder(savePath string, torrent *database.Torrent) (string, error) {
+	categoryPath := filepath.Join(savePath, torrent.Category)
+	folder := filepath.Join(categoryPath, torrent.RDName)
+
+	if !inside(savePath, categoryPath, true) || !inside(categoryPath, folder, false) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidName, filepath.Join(torrent.Category, torrent.RDName))
+	}
+	return folder, nil
+}
+
+// inside reports if path is under parent, or is parent itself if same is allowed
+func inside(parent, path string, same bool) bool {
+	rel, err := filepath.Rel(parent, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return false
+	}
+	return same || rel != "."
+}
+
+// RemoveTorrentFiles deletes the files of torrent from savePath
+func RemoveTorrentFiles(savePath string, torrent *database.Torrent) error {
+	folder, err := TorrentFolder(savePath, torrent)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(folder)
+}
+
+// DeleteTorrent removes torrent from Real-Debrid and the database, with its files in savePath
+// if deleteFiles is set. The files or the Real-Debrid torrent failing to be removed is only logged
+func DeleteTorrent(torrents *database.TorrentRepository, client *realdebrid.Client, cache *realdebrid.Cache,
+	savePath string, torrent *database.Torrent, deleteFiles bool, logger logger.Interface) error {
+	if deleteFiles {
+		if err := RemoveTorrentFiles(savePath, torrent); err != nil {
+			logger.Error("Failed to delete files of %s: %s", torrent.RDName, err)
+		}
+	}
+
+	if torrent.RDId != "" {
+		if err := client.DeleteTorrent(torrent.RDId); err != nil {
+			logger.Error("Failed to delete torrent %s from Real-Debrid: %s", torrent.RDName, err)
+		}
+		cache.Invalidate(torrent.RDId)
+	}
+
+	return torrents.Delete(torrent.ID)
+}
+
+// SetCategory moves torrent to category, with the files already downloaded to savePath
+func SetCategory(torrents *database.TorrentRepository, savePath string, torrent *database.Torrent, category string) error {
+	if torrent.Category == category {
+		return nil
+	}
+
+	if torrent.InternalStatus == database.TorrentInternalDownloading || torrent.InternalStatus == database.TorrentInternalChecking {
+		return ErrTorrentDownloading
+	}
+
+	from, err := TorrentFolder(savePath, torrent)
+	if err != nil {
+		return err
+	}
+	moved := *torrent
+	moved.Category = category
+	to, err := TorrentFolder(savePath, &moved)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(from); err == nil {
+		if err := os.MkdirAll(filepath.Dir(to), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+
+	return torrents.SetCategory(torrent.ID, category, to)
+}
@@ -0,0 +1,175 @@
+package jobs
+
+import (
+	"strings"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// TorrentImporter compares the Real-Debrid account with the torrents known by qbrdt:
+// torrents are linked by hash, the ones removed from the account are flagged as
+// missing and the unknown ones can be adopted
+type TorrentImporter struct {
+	cache      *realdebrid.Cache
+	breaker    *CircuitBreaker
+	torrents   *database.TorrentRepository
+	categories *database.CategoryRepository
+	logger     logger.Interface
+	adopt      bool
+	category   string
+}
+
+func NewTorrentImporter(cache *realdebrid.Cache,
+	breaker *CircuitBreaker,
+	torrents *database.TorrentRepository,
+	categories *database.CategoryRepository,
+	adopt bool,
+	category string,
+	logger logger.Interface) *TorrentImporter {
+	return &TorrentImporter{
+		cache:      cache,
+		breaker:    breaker,
+		torrents:   torrents,
+		categories: categories,
+		logger:     logger,
+		adopt:      adopt,
+		category:   category,
+	}
+}
+
+func (ti *TorrentImporter) Run() {
+	if !ti.breaker.Allow() {
+		ti.logger.Debug("Real-Debrid is failing, skipping import")
+		return
+	}
+
+	account, err := ti.cache.Torrents()
+
+	// nothing can be concluded from a partial list
+	if err != nil {
+		ti.breaker.Failure(err)
+		ti.logger.Error("Error listing Real-Debrid torrents: %s", err)
+		return
+	}
+
+	ti.breaker.Success()
+
+	unknown := ti.reconcile(account)
+
+	if !ti.adopt {
+		if len(unknown) > 0 {
+			ti.logger.Debug("%d Real-Debrid torrents unknown to qbrdt", len(unknown))
+		}
+		return
+	}
+
+	if ti.category != "" && !ti.categories.Exist(ti.category) {
+		ti.categories.Create(database.NewCategory(ti.category))
+	}
+
+	for _, rd := range unknown {
+		ti.adoptTorrent(rd)
+	}
+}
+
+// reconcile updates the local torrents from the account and returns the torrents of the account unknown locally
+func (ti *TorrentImporter) reconcile(account []realdebrid.Torrent) []realdebrid.Torrent {
+	ti.torrents.Mutex.Lock()
+	defer ti.torrents.Mutex.Unlock()
+
+	local, err := ti.torrents.FindAll()
+	if err != nil {
+		ti.logger.Error("Error getting all torrents: %s", err)
+		return nil
+	}
+
+	onAccount := make(map[string]bool, len(account))
+	byHash := make(map[string]realdebrid.Torrent, len(account))
+	for _, rd := range account {
+		onAccount[rd.ID] = true
+		byHash[strings.ToLower(rd.Hash)] = rd
+	}
+
+	linked := make(map[string]bool, len(local))
+	known := make(map[string]bool, len(local))
+	for _, t := range local {
+		linked[t.RDId] = true
+		known[strings.ToLower(t.RDHash)] = true
+	}
+
+	for i := range local {
+		torrent := &local[i]
+
+		if torrent.RDId != "" && onAccount[torrent.RDId] {
+			if torrent.Status == database.TorrentStatusMissing {
+				ti.logger.Info("Torrent %s is back on Real-Debrid", torrent.RDName)
+				torrent.Status = database.TorrentStatusDownloading
+				ti.torrents.Update(torrent)
+			}
+			continue
+		}
+
+		// added again on Real-Debrid, or sent by the admission before a crash
+		if rd, ok := byHash[strings.ToLower(torrent.RDHash)]; ok && !linked[rd.ID] {
+			ti.logger.Info("Linking torrent %s to Real-Debrid torrent %s", torrent.RDName, rd.ID)
+			linked[rd.ID] = true
+			torrent.RDId = rd.ID
+			if torrent.Status == database.TorrentStatusQueued || torrent.Status == database.TorrentStatusMissing {
+				torrent.Status = database.TorrentStatusDownloading
+			}
+			ti.torrents.Update(torrent)
+			continue
+		}
+
+		// once the files are downloaded, removing the torrent from Real-Debrid is expected
+		if torrent.RDId == "" || torrent.Status == database.TorrentStatusMissing || torrent.Status == database.TorrentStatusError ||
+			torrent.InternalStatus == database.TorrentInternalDownloaded || ti.torrents.HasDownload(torrent.ID) {
+			continue
+		}
+
+		ti.logger.Warn("Torrent %s is not on Real-Debrid anymore", torrent.RDName)
+		torrent.Status = database.TorrentStatusMissing
+		ti.torrents.Update(torrent)
+	}
+
+	var unknown []realdebrid.Torrent
+	for _, rd := range account {
+		if !known[strings.ToLower(rd.Hash)] && !linked[rd.ID] {
+			unknown = append(unknown, rd)
+		}
+	}
+
+	return unknown
+}
+
+func (ti *TorrentImporter) adoptTorrent(rd realdebrid.Torrent) {
+	switch rd.Status {
+	case "error", "dead", "virus", "magnet_error":
+		ti.logger.Debug("Not adopting torrent %s, its status is %s", rd.Filename, rd.Status)
+		return
+	}
+
+	torrent := &database.Torrent{
+		Status:         database.TorrentStatusDownloading,
+		Type:           database.TorrentTypeMagnet,
+		Category:       ti.category,
+		AddedBy:        database.Imported,
+		RDId:           rd.ID,
+		RDProgress:     rd.Progress,
+		RDName:         rd.Filename,
+		RDSize:         rd.Bytes,
+		RDSplit:        rd.Split,
+		RDHost:         rd.Host,
+		RDHash:         strings.ToLower(rd.Hash),
+		InternalStatus: database.TorrentInternalWaitingForDownload,
+	}
+
+	if err := ti.torrents.CreateQueued(torrent); err != nil {
+		ti.logger.Error("Error adopting torrent %s: %s", rd.Filename, err)
+		return
+	}
+
+	ti.logger.Info("Adopted Real-Debrid torrent %s", rd.Filename)
+}
@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// Output is how the files of a torrent downloaded by Real-Debrid are delivered
+type Output string
+
+const (
+	// the files are downloaded to the save path
+	OutputDownload Output = "download"
+	// the files are served over WebDAV
+	OutputStream Output = "stream"
+	// a .strm file holding the download link is written for each file
+	OutputStrm Output = "strm"
+	// a symlink to the file in the mount of the WebDAV is created for each file
+	OutputSymlink Output = "symlink"
+)
+
+// Real-Debrid download links stay valid for hours, the .strm files are written again after this
+const strmMaxAge = 6 * time.Hour
+
+// Outputs applies the output of each category
+type Outputs struct {
+	global     Output
+	categories map[string]Output
+	// folder where /webdav is mounted, target of the symlinks
+	mountPath string
+}
+
+func NewOutputs(global Output, categories map[string]Output, mountPath string) *Outputs {
+	return &Outputs{
+		global:     global,
+		categories: categories,
+		mountPath:  mountPath,
+	}
+}
+
+// Output returns the output of category, the global one if it has none
+func (o *Outputs) Output(category string) Output {
+	if output, ok := o.categories[category]; ok && output != "" {
+		return output
+	}
+	if o.global == "" {
+		return OutputDownload
+	}
+	return o.global
+}
+
+// Streamed reports if some torrents are not downloaded, the WebDAV has to serve them
+func (o *Outputs) Streamed() bool {
+	if o.global != "" && o.global != OutputDownload {
+		return true
+	}
+	for _, output := range o.categories {
+		if output != "" && output != OutputDownload {
+			return true
+		}
+	}
+	return false
+}
+
+// write creates in the save path the file standing for download
+func (o *Outputs) write(output Output, torrent *database.Torrent, download *database.Download) error {
+	switch output {
+	case OutputStrm:
+		return writeStrm(download)
+	case OutputSymlink:
+		if err := os.MkdirAll(download.SavePath, os.ModePerm); err != nil {
+			return err
+		}
+		name := filepath.Join(download.SavePath, download.FileName)
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(filepath.Join(o.mountPath, torrent.Category, torrent.RDName, download.FileName), name)
+	}
+	return nil
+}
+
+// strmPath returns the .strm file of download, named like the file without its extension
+func strmPath(download *database.Download) string {
+	return filepath.Join(download.SavePath, strings.TrimSuffix(download.FileName, filepath.Ext(download.FileName))+".strm")
+}
+
+func writeStrm(download *database.Download) error {
+	if err := os.MkdirAll(download.SavePath, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(strmPath(download), []byte(download.Url+"\n"), 0644)
+}
+
+// StrmRefresher writes the .strm files again with a new download link before the old one expires
+type StrmRefresher struct {
+	client   *realdebrid.Client
+	breaker  *CircuitBreaker
+	torrents *database.TorrentRepository
+	logger   logger.Interface
+
+	lock sync.Mutex
+}
+
+func NewStrmRefresher(client *realdebrid.Client, breaker *CircuitBreaker, torrents *database.TorrentRepository, logger logger.Interface) *StrmRefresher {
+	return &StrmRefresher{
+		client:   client,
+		breaker:  breaker,
+		torrents: torrents,
+		logger:   logger,
+	}
+}
+
+func (s *StrmRefresher) Run() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.breaker.Allow() {
+		return
+	}
+
+	torrents, err := s.torrents.FindStreamed()
+	if err != nil {
+		s.logger.Error("Error getting streamed torrents: %s", err)
+		return
+	}
+
+	for _, torrent := range torrents {
+		for _, download := range torrent.Downloads {
+			stat, err := os.Stat(strmPath(&download))
+			if err != nil || download.Link == "" || time.Since(stat.ModTime()) < strmMaxAge {
+				continue
+			}
+
+			unrestricted, err := s.client.Unrestrict(download.Link)
+			if err != nil {
+				s.logger.Warn("Error refreshing the link of %s: %s", download.FileName, err)
+				if realdebrid.Classify(err).Transient() {
+					s.breaker.Failure(err)
+					return
+				}
+				continue
+			}
+			s.breaker.Success()
+
+			download.Url = unrestricted.Download
+			if err := writeStrm(&download); err != nil {
+				s.logger.Error("Error writing %s: %s", strmPath(&download), err)
+				continue
+			}
+			s.logger.Debug("Refreshed %s", strmPath(&download))
+		}
+	}
+}
@@ -2,30 +2,81 @@ package jobs
 
 import (
 	"os"
+	"time"
 
-	gorealdebrid "github.com/TOomaAh/go-realdebrid"
 	"github.com/TOomaAh/qbrdt/internal/database"
-	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/internal/events"
+	"github.com/TOomaAh/qbrdt/internal/queue"
 	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
 )
 
 type TorrentUpdater struct {
-	client      *gorealdebrid.RealDebridClient
+	client      *realdebrid.Client
+	cache       *realdebrid.Cache
 	torrents    *database.TorrentRepository
 	download    *database.DownloadRepository
 	preferences *database.PreferencesRepository
 	logger      logger.Interface
-	downloader  *downloader.Downloader
+	queue       *queue.DownloadQueue
+	breaker     *CircuitBreaker
+	// fails the torrents waiting too long on Real-Debrid
+	availability *Availability
+	// not found answers in a row per torrent
+	notFound map[uint]int
+	// torrents not checked again before their retry time after a transient error
+	retries map[uint]*retry
+	// torrents whose progress on Real-Debrid did not change for this long are failed, 0 to never
+	stallTimeout time.Duration
+	// last progress change on Real-Debrid per torrent
+	progress map[uint]progressMark
+	// how the files of each category are delivered
+	outputs *Outputs
+	events  *events.Bus
+	// state of each torrent at the end of the previous run, nil before the first one
+	states map[uint]torrentMark
 }
 
-func NewTorrentUpdater(client *gorealdebrid.RealDebridClient,
-	downloader *downloader.Downloader,
+const (
+	// not found answers in a row before a torrent is considered removed from Real-Debrid
+	notFoundChecks = 3
+	// transient errors allowed in one run, the other torrents wait for the next run
+	errorBudget = 3
+	retryDelay  = 5 * time.Second
+	// the retry delay doubles after each transient error, up to this
+	maxRetryDelay = 5 * time.Minute
+)
+
+type retry struct {
+	failures int
+	next     time.Time
+}
+
+type torrentMark struct {
+	hash  string
+	name  string
+	state events.TorrentData
+}
+
+type progressMark struct {
+	value float64
+	since time.Time
+}
+
+func NewTorrentUpdater(client *realdebrid.Client,
+	cache *realdebrid.Cache,
+	breaker *CircuitBreaker,
+	availability *Availability,
+	stallTimeout time.Duration,
+	outputs *Outputs,
+	bus *events.Bus,
+	queue *queue.DownloadQueue,
 	torrents *database.TorrentRepository,
 	download *database.DownloadRepository,
 	preferences *database.PreferencesRepository,
 	logger logger.Interface) *TorrentUpdater {
 
-	download.CleanAllDownloads()
+	// downloads not finished are kept in the queue and started again
 	torrents.UpdateTorrentsStatusToWaitingForDownload()
 
 	allTorrent, err := torrents.FindAll()
@@ -47,27 +98,48 @@ func NewTorrentUpdater(client *gorealdebrid.RealDebridClient,
 
 	}
 
-	return &TorrentUpdater{
-		client:      client,
-		torrents:    torrents,
-		download:    download,
-		preferences: preferences,
-		logger:      logger,
-		downloader:  downloader,
+	tu := &TorrentUpdater{
+		client:       client,
+		cache:        cache,
+		torrents:     torrents,
+		download:     download,
+		preferences:  preferences,
+		logger:       logger,
+		queue:        queue,
+		breaker:      breaker,
+		availability: availability,
+		notFound:     make(map[uint]int),
+		retries:      make(map[uint]*retry),
+		stallTimeout: stallTimeout,
+		progress:     make(map[uint]progressMark),
+		outputs:      outputs,
+		events:       bus,
 	}
+	// the torrents known at startup are not published as added
+	tu.publishChanges()
+
+	return tu
 }
 
 func (tu *TorrentUpdater) acceptTorrent(id string) error {
 	tu.logger.Info("Accepting torrent %s", id)
-	return tu.client.AcceptTorrent(id)
+	defer tu.cache.Invalidate(id)
+	return tu.client.SelectFiles(id)
 }
 
 func (tu *TorrentUpdater) DeleteTorrent(id string) error {
 	tu.logger.Info("Deleting torrent %s", id)
+	defer tu.cache.Invalidate(id)
 	return tu.client.DeleteTorrent(id)
 }
 
 func (tu *TorrentUpdater) Run() {
+	defer tu.publishChanges()
+
+	if !tu.breaker.Allow() {
+		tu.logger.Debug("Real-Debrid is failing, skipping torrent updater")
+		return
+	}
 
 	tu.logger.Info("Running torrent updater")
 	tu.torrents.Mutex.Lock()
@@ -75,13 +147,47 @@ func (tu *TorrentUpdater) Run() {
 	defer tu.torrents.Mutex.Unlock()
 
 	torrents, err := tu.torrents.FindAllNotDownloaded()
+	if err != nil || len(torrents) == 0 {
+		return
+	}
+
+	// one request for all the torrents instead of one per torrent
+	account, err := tu.cache.Torrents()
 	if err != nil {
+		tu.breaker.Failure(err)
+		tu.logger.Error("Error listing Real-Debrid torrents: %s", err)
 		return
 	}
+	tu.breaker.Success()
+
+	listed := make(map[string]*realdebrid.Torrent, len(account))
+	for i := range account {
+		listed[account[i].ID] = &account[i]
+	}
+
+	budget := errorBudget
 
 	for _, torrent := range torrents {
+		// files are being verified or failed too many times, nothing to do on Real-Debrid
+		if torrent.InternalStatus == database.TorrentInternalChecking || torrent.InternalStatus == database.TorrentInternalError {
+			continue
+		}
+
+		if torrent.Type == database.TorrentTypeLink {
+			if !tu.queueLinks(&torrent) {
+				return
+			}
+			continue
+		}
+
+		// not sent to Real-Debrid yet, see TorrentAdmission
 		if torrent.RDId == "" {
-			tu.torrents.Delete(torrent.ID)
+			continue
+		}
+
+		// removed from Real-Debrid, TorrentImporter links it again if it comes back
+		if torrent.Status == database.TorrentStatusMissing {
+			continue
 		}
 
 		// if torrent has pending downloads, set it to waiting for download
@@ -98,16 +204,35 @@ func (tu *TorrentUpdater) Run() {
 			tu.torrents.Update(&torrent)
 		}
 
-		info, err := tu.client.GetTorrent(torrent.RDId)
+		info, ok := listed[torrent.RDId]
 
-		// if torrent is not found, delete it
-		if err != nil {
-			tu.logger.Error("Error getting torrent info: %s", err)
-			tu.DeleteTorrent(torrent.RDId)
-			tu.torrents.Delete(torrent.ID)
-			continue
+		// only the torrents missing from the list are asked one by one
+		if !ok {
+			if r, ok := tu.retries[torrent.ID]; ok && time.Now().Before(r.next) {
+				continue
+			}
+
+			var err error
+			info, err = tu.cache.Torrent(torrent.RDId)
+			if err != nil {
+				if !tu.handleError(&torrent, err) {
+					return
+				}
+
+				if realdebrid.Classify(err).Transient() {
+					budget--
+					if budget == 0 {
+						tu.logger.Warn("Too many Real-Debrid errors, the other torrents are updated on the next run")
+						return
+					}
+				}
+				continue
+			}
 		}
 
+		delete(tu.notFound, torrent.ID)
+		delete(tu.retries, torrent.ID)
+
 		var needUpdate bool
 		if torrent.RDProgress != info.Progress {
 			torrent.RDProgress = info.Progress
@@ -128,9 +253,29 @@ func (tu *TorrentUpdater) Run() {
 			tu.torrents.Update(&torrent)
 		}
 
+		if tu.availability.Policy(torrent.Category).Expired(info) {
+			tu.failTorrent(&torrent, "was not downloaded by Real-Debrid in time")
+			continue
+		}
+
+		if tu.availability.Policy(torrent.Category).Uncached(info) {
+			tu.failTorrent(&torrent, "is not cached on Real-Debrid")
+			continue
+		}
+
+		if tu.stalled(&torrent, info) {
+			tu.failTorrent(&torrent, "made no progress on Real-Debrid for "+tu.stallTimeout.String())
+			continue
+		}
+
 		// if torrent is waiting for files selection, accept it
 		if info.Status == "waiting_files_selection" {
-			tu.acceptTorrent(torrent.RDId)
+			if err := tu.acceptTorrent(torrent.RDId); err != nil {
+				if !tu.handleError(&torrent, err) {
+					return
+				}
+				continue
+			}
 			torrent.InternalStatus = database.TorrentInternalWaitingForDownload
 			tu.torrents.Update(&torrent)
 		}
@@ -142,11 +287,10 @@ func (tu *TorrentUpdater) Run() {
 			}
 		}
 
-		// if torrent is dead, delete it
-		if info.Status == "dead" {
-			tu.logger.Error("Torrent " + torrent.RDId + " is dead, deleting it")
-			tu.DeleteTorrent(torrent.RDId)
-			tu.torrents.Delete(torrent.ID)
+		// Real-Debrid gave up the torrent, report it so that it can be searched again
+		switch info.Status {
+		case "dead", "error", "magnet_error", "virus":
+			tu.failTorrent(&torrent, "is "+info.Status+" on Real-Debrid")
 			continue
 		}
 
@@ -181,7 +325,15 @@ func (tu *TorrentUpdater) Run() {
 		if torrent.Status == database.TorrentStatusDownloaded && torrent.InternalStatus == database.TorrentInternalWaitingForDownload {
 			torrent.InternalStatus = database.TorrentInternalDownloading
 			tu.torrents.Update(&torrent)
-			tu.saveDownload(&torrent, info)
+
+			// links are unrestricted again on the next run
+			if err := tu.saveDownload(&torrent, info); err != nil {
+				torrent.InternalStatus = database.TorrentInternalWaitingForDownload
+				tu.torrents.Update(&torrent)
+				if !tu.handleError(&torrent, err) {
+					return
+				}
+			}
 			continue
 		}
 
@@ -214,39 +366,210 @@ func (tu *TorrentUpdater) Run() {
 
 }
 
-func (tu *TorrentUpdater) saveDownload(torrent *database.Torrent, info *gorealdebrid.Torrent) {
+// publishChanges publishes the torrents added, changed or removed since the previous run,
+// by this job or by anything else
+func (tu *TorrentUpdater) publishChanges() {
+	torrents, err := tu.torrents.FindAll()
+	if err != nil {
+		tu.logger.Error("Error getting torrents: %s", err)
+		return
+	}
+
+	states := make(map[uint]torrentMark, len(torrents))
+	for _, torrent := range torrents {
+		mark := torrentMark{
+			hash: torrent.RDHash,
+			name: torrent.RDName,
+			state: events.TorrentData{
+				Category:       torrent.Category,
+				RDStatus:       string(torrent.Status),
+				InternalStatus: string(torrent.InternalStatus),
+				CloudProgress:  torrent.RDProgress / 100,
+				Paused:         torrent.Paused,
+			},
+		}
+		states[torrent.ID] = mark
+
+		if tu.states == nil {
+			continue
+		}
+
+		previous, ok := tu.states[torrent.ID]
+		switch {
+		case !ok:
+			tu.events.Publish(events.Event{Type: events.TorrentAdded, Hash: mark.hash, Name: mark.name, Data: mark.state})
+		case previous != mark:
+			tu.events.Publish(events.Event{Type: events.TorrentChanged, Hash: mark.hash, Name: mark.name, Data: mark.state})
+		}
+	}
+
+	for id, previous := range tu.states {
+		if _, ok := states[id]; !ok {
+			tu.events.Publish(events.Event{Type: events.TorrentRemoved, Hash: previous.hash, Name: previous.name})
+		}
+	}
+
+	tu.states = states
+}
+
+// stalled reports if the progress of the torrent on Real-Debrid did not change for the stall timeout
+func (tu *TorrentUpdater) stalled(torrent *database.Torrent, info *realdebrid.Torrent) bool {
+	if tu.stallTimeout <= 0 || (info.Status != "downloading" && info.Status != "magnet_conversion") {
+		delete(tu.progress, torrent.ID)
+		return false
+	}
+
+	mark, ok := tu.progress[torrent.ID]
+	if !ok || mark.value != info.Progress {
+		tu.progress[torrent.ID] = progressMark{value: info.Progress, since: time.Now()}
+		return false
+	}
+
+	return time.Since(mark.since) >= tu.stallTimeout
+}
+
+// failTorrent gives up a torrent Real-Debrid could not download, it is
+// removed from Real-Debrid and reported as error to the client
+func (tu *TorrentUpdater) failTorrent(torrent *database.Torrent, reason string) {
+	tu.logger.Warn("Torrent %s %s, failing it", torrent.RDName, reason)
+	delete(tu.progress, torrent.ID)
+
+	if err := tu.DeleteTorrent(torrent.RDId); err != nil {
+		tu.logger.Error("Error deleting torrent %s from Real-Debrid: %s", torrent.RDName, err)
+	}
+
+	torrent.Status = database.TorrentStatusError
+	torrent.InternalStatus = database.TorrentInternalError
+	torrent.Error = reason
+	tu.torrents.Update(torrent)
+}
+
+// handleError deals with an error of Real-Debrid about torrent and
+// reports if the run can go on with the next torrents
+func (tu *TorrentUpdater) handleError(torrent *database.Torrent, err error) bool {
+	class := realdebrid.Classify(err)
+	tu.breaker.Failure(err)
+
+	switch class {
+	case realdebrid.ClassNotFound:
+		// keep the torrent, it may have been removed by hand or come back later
+		tu.notFound[torrent.ID]++
+		if tu.notFound[torrent.ID] < notFoundChecks {
+			tu.logger.Warn("Torrent %s not found on Real-Debrid (%d/%d)", torrent.RDName, tu.notFound[torrent.ID], notFoundChecks)
+			return true
+		}
+
+		tu.logger.Warn("Torrent %s not found on Real-Debrid", torrent.RDName)
+		delete(tu.notFound, torrent.ID)
+		torrent.Status = database.TorrentStatusMissing
+		tu.torrents.Update(torrent)
+		return true
+	case realdebrid.ClassAuth:
+		// every call fails the same way
+		tu.logger.Error("Real-Debrid refused the token: %s", err)
+		return false
+	}
+
+	if !class.Transient() {
+		tu.logger.Error("Real-Debrid error for torrent %s: %s", torrent.RDName, err)
+		return true
+	}
+
+	r, ok := tu.retries[torrent.ID]
+	if !ok {
+		r = &retry{}
+		tu.retries[torrent.ID] = r
+	}
+	r.failures++
+	delay := min(retryDelay<<min(r.failures-1, 10), maxRetryDelay)
+	r.next = time.Now().Add(delay)
+
+	tu.logger.Warn("Real-Debrid %s error for torrent %s, retrying in %s: %s", class, torrent.RDName, delay, err)
+
+	return tu.breaker.Allow()
+}
+
+// queueLinks queues the files of a link torrent once and reports if the run can go on.
+// Links refused by Real-Debrid fail the torrent.
+func (tu *TorrentUpdater) queueLinks(torrent *database.Torrent) bool {
+	if torrent.InternalStatus != database.TorrentInternalWaitingForDownload || tu.torrents.HasDownload(torrent.ID) {
+		return true
+	}
+
+	if r, ok := tu.retries[torrent.ID]; ok && time.Now().Before(r.next) {
+		return true
+	}
+
+	torrent.InternalStatus = database.TorrentInternalDownloading
+	tu.torrents.Update(torrent)
+
+	err := tu.saveDownload(torrent, &realdebrid.Torrent{Links: torrent.Links})
+	if err == nil {
+		delete(tu.retries, torrent.ID)
+		return true
+	}
+
+	if class := realdebrid.Classify(err); class.Transient() || class == realdebrid.ClassAuth {
+		torrent.InternalStatus = database.TorrentInternalWaitingForDownload
+		tu.torrents.Update(torrent)
+		return tu.handleError(torrent, err)
+	}
+
+	tu.breaker.Success()
+	tu.logger.Error("Links of %s refused by Real-Debrid, failing it: %s", torrent.RDName, err)
+	torrent.Status = database.TorrentStatusError
+	torrent.InternalStatus = database.TorrentInternalError
+	tu.torrents.Update(torrent)
+	return true
+}
+
+// saveDownload queues the files of torrent, nothing is saved if a link can't be unrestricted
+func (tu *TorrentUpdater) saveDownload(torrent *database.Torrent, info *realdebrid.Torrent) error {
+	var downloads []*database.Download
+	output := tu.outputs.Output(torrent.Category)
+
 	for _, link := range info.Links {
-		debrid, err := tu.client.DebridTorrent(link)
+		debrid, err := tu.client.Unrestrict(link)
 		if err != nil {
-			tu.logger.Error("Error debriding torrent: %s", err)
-			continue
+			return err
 		}
 
-		download := &database.Download{
+		downloads = append(downloads, &database.Download{
 			UserId:       0,
 			TorrentId:    torrent.ID,
 			FileName:     debrid.Filename,
 			FileSize:     debrid.FileSize,
-			IsDownloaded: false,
+			IsDownloaded: output != OutputDownload,
 			Url:          debrid.Download,
+			Link:         link,
 			SavePath:     tu.preferences.GetSavePath() + string(os.PathSeparator) + torrent.Category + string(os.PathSeparator) + torrent.RDName,
+		})
+	}
+
+	for _, download := range downloads {
+		if err := tu.outputs.write(output, torrent, download); err != nil {
+			return err
 		}
+	}
 
+	for _, download := range downloads {
 		d := tu.download.Create(download)
 
 		if d != nil {
 			tu.logger.Error("Error saving download: %s", d)
 		}
 
-		tu.logger.Info("Start downloading %s", download.FileName)
-
-		go tu.downloader.AddDownload(&downloader.Download{
-			Url:      download.Url,
-			FileName: download.FileName,
-			FileSize: download.FileSize,
-			SavePath: download.SavePath,
-			Object:   download,
-		})
+		if output == OutputDownload {
+			tu.logger.Info("Queueing download of %s", download.FileName)
+		}
+	}
 
+	if output != OutputDownload {
+		tu.logger.Info("Streaming %s as %s", torrent.RDName, output)
+		return tu.torrents.SetStreamed(torrent.ID)
 	}
+
+	tu.queue.Wake()
+
+	return nil
 }
@@ -0,0 +1,185 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+)
+
+// Subfolders of a watched folder receiving the processed files
+const (
+	WatchDoneDir   = "done"
+	WatchFailedDir = "failed"
+)
+
+// WatchFolder adds the .torrent files and the magnet links dropped in folders.
+// The folders are polled instead of watched so that network mounts work.
+type WatchFolder struct {
+	admission *TorrentAdmission
+	// category of the torrents found in each folder
+	dirs   map[string]string
+	logger logger.Interface
+
+	lock sync.Mutex
+	// files found by the previous run, a file is added once it stops changing
+	seen map[string]fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func NewWatchFolder(admission *TorrentAdmission, dirs map[string]string, logger logger.Interface) *WatchFolder {
+	for dir, category := range dirs {
+		logger.Info("Watching %s for torrents of category %q", dir, category)
+	}
+
+	return &WatchFolder{
+		admission: admission,
+		dirs:      dirs,
+		logger:    logger,
+		seen:      make(map[string]fileStamp),
+	}
+}
+
+func (w *WatchFolder) Run() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	seen := make(map[string]fileStamp)
+	var added int
+
+	for dir, category := range w.dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			w.logger.Error("Error reading watched folder %s: %s", dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !watched(entry.Name()) {
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+
+			// the file may still be copied, wait until it is the same on two runs
+			path := filepath.Join(dir, entry.Name())
+			stamp := fileStamp{size: info.Size(), modTime: info.ModTime()}
+			if previous, ok := w.seen[path]; !ok || previous != stamp {
+				seen[path] = stamp
+				continue
+			}
+
+			err = w.add(path, category)
+
+			// kept in place until the account can add torrents again
+			if errors.Is(err, ErrNotPremium) {
+				w.logger.Debug("%s waits for the Real-Debrid account to be premium", path)
+				seen[path] = stamp
+				continue
+			}
+
+			if err != nil {
+				w.logger.Error("Failed to add %s: %s", path, err)
+				w.move(dir, entry.Name(), WatchFailedDir)
+				continue
+			}
+
+			w.logger.Info("Added %s", path)
+			w.move(dir, entry.Name(), WatchDoneDir)
+			added++
+		}
+	}
+
+	w.seen = seen
+
+	if added > 0 {
+		w.admission.Run()
+	}
+}
+
+// watched reports if a file name is a .torrent or a text file with magnet links
+func watched(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".torrent", ".magnet", ".txt":
+		return true
+	}
+	return false
+}
+
+// add queues the torrent or the magnet links of a file, through the same path as the qBittorrent API
+func (w *WatchFolder) add(path, category string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".torrent") {
+		_, err := w.admission.AddTorrent(content, category, database.Qbittorent)
+		return err
+	}
+
+	var (
+		links int
+		added int
+		errs  []error
+	)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(strings.ToLower(line), "magnet:") {
+			continue
+		}
+
+		links++
+		if _, err := w.admission.AddMagnet(line, category, database.Qbittorent); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		added++
+	}
+
+	if links == 0 {
+		return fmt.Errorf("%w: no magnet link in the file", ErrInvalidMagnet)
+	}
+
+	// the file is done once a link is added, dropping it again would add that link twice
+	if added > 0 {
+		for _, err := range errs {
+			w.logger.Error("Failed to add a magnet link of %s: %s", path, err)
+		}
+		return nil
+	}
+
+	// only the premium check fails every link, the file is kept to try them again
+	if errors.Is(errs[0], ErrNotPremium) {
+		return ErrNotPremium
+	}
+
+	return errors.Join(errs...)
+}
+
+// move puts a processed file in a subfolder of its watched folder
+func (w *WatchFolder) move(dir, name, subfolder string) {
+	target := filepath.Join(dir, subfolder)
+	if err := os.MkdirAll(target, os.ModePerm); err != nil {
+		w.logger.Error("Error creating %s: %s", target, err)
+		return
+	}
+
+	if err := os.Rename(filepath.Join(dir, name), filepath.Join(target, name)); err != nil {
+		w.logger.Error("Error moving %s to %s: %s", name, target, err)
+	}
+}
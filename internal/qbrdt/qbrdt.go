@@ -1,19 +1,57 @@
 package qbrdt
 
 import (
-	gorealdebrid "github.com/TOomaAh/go-realdebrid"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/api/deluge"
 	"github.com/TOomaAh/qbrdt/internal/api/qbittorrent"
+	qbrdtapi "github.com/TOomaAh/qbrdt/internal/api/qbrdt"
+	"github.com/TOomaAh/qbrdt/internal/api/sabnzbd"
+	"github.com/TOomaAh/qbrdt/internal/api/transmission"
+	"github.com/TOomaAh/qbrdt/internal/api/webdav"
 	"github.com/TOomaAh/qbrdt/internal/config"
 	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/events"
 	"github.com/TOomaAh/qbrdt/internal/jobs"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/internal/verifier"
 	"github.com/TOomaAh/qbrdt/pkg/downloader"
 	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
 )
 
+// Time given to the HTTP server to finish the requests in progress on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// Endpoints still served while shutting down, they do not change anything
+var readOnlyEndpoints = []string{
+	"/app/version",
+	"/app/webapiVersion",
+	"/app/buildInfo",
+	"/app/defaultSavePath",
+	"/app/preferences",
+	"/torrents/info",
+	"/torrents/files",
+	"/torrents/properties",
+	"/torrents/categories",
+}
+
 type QBRDT struct {
+	db          *gorm.DB
+	stopping    atomic.Bool
 	logger      logger.Interface
 	conf        *config.QBRDTConfig
 	downloader  *downloader.Downloader
@@ -21,7 +59,21 @@ type QBRDT struct {
 	categories  *database.CategoryRepository
 	torrents    *database.TorrentRepository
 	downloads   *database.DownloadRepository
-	client      *gorealdebrid.RealDebridClient
+	client      *realdebrid.Client
+	verifier    *verifier.Verifier
+	queue       *queue.DownloadQueue
+	admission   *jobs.TorrentAdmission
+	// shared by the jobs and the API
+	rdCache *realdebrid.Cache
+	// shared by the jobs calling Real-Debrid
+	breaker *jobs.CircuitBreaker
+	account *jobs.AccountMonitor
+	// availability policy of each category
+	availability *jobs.Availability
+	// output of each category
+	outputs *jobs.Outputs
+	// events of the torrents and of their downloads
+	events *events.Bus
 }
 
 func New(logger logger.Interface, conf *config.QBRDTConfig) *QBRDT {
@@ -31,86 +83,416 @@ func New(logger logger.Interface, conf *config.QBRDTConfig) *QBRDT {
 	categories := database.NewCategoryRepository(db)
 	torrents := database.NewTorrentRepository(db)
 	downloads := database.NewDownloadRepository(db)
-	client := gorealdebrid.NewRealDebridClient(conf.RealDebrid.Token)
-	d := downloader.NewDownloader(
-		conf.Downloader.Chunk,
-		conf.Downloader.SpeedLimit,
-		conf.Downloader.MaxDownloads,
-		logger,
-	)
+	client := realdebrid.NewClient(conf.RealDebrid.Token, conf.RealDebrid.BaseUrl)
+	if conf.RealDebrid.RateLimit != 0 {
+		client.SetRateLimit(conf.RealDebrid.RateLimit)
+	}
+	rdCache := realdebrid.NewCache(client, cacheTTL(conf.Qbrdt.TorrentRefreshInterval))
+	breaker := jobs.NewCircuitBreaker(logger)
+	bus := events.NewBus()
+	account := jobs.NewAccountMonitor(client, breaker, bus, conf.Account.ExpiryWarning, logger)
+
+	categoryPolicies := make(map[string]jobs.AvailabilityPolicy)
+	for name, category := range conf.Categories {
+		if category.Availability != nil {
+			categoryPolicies[name] = availabilityPolicy(*category.Availability)
+		}
+	}
+	availability := jobs.NewAvailability(client, availabilityPolicy(conf.Availability), categoryPolicies, logger)
+	outputs := newOutputs(conf, logger)
+	d := downloader.NewDownloader(downloader.Config{
+		MaxChunks:             conf.Downloader.Chunk,
+		MinChunkSize:          conf.Downloader.MinChunkSize * 1024,
+		MaxConnectionsPerHost: conf.Downloader.MaxConnectionsPerHost,
+		SpeedLimit:            conf.Downloader.SpeedLimit,
+		StallTimeout:          time.Duration(conf.Stall.Download) * time.Second,
+	}, logger)
+
+	for name, category := range conf.Categories {
+		if err := categories.SetPriority(name, category.Priority); err != nil {
+			logger.Error("Error saving category %s: %s", name, err)
+		}
+	}
+
+	q := queue.NewDownloadQueue(torrents, downloads, d, conf.Downloader.MaxDownloads, logger)
+	v := verifier.NewVerifier(torrents, downloads, q, logger)
+
+	// verifications interrupted by a shutdown are done again
+	if checking, err := torrents.FindByInternalStatus(database.TorrentInternalChecking); err == nil {
+		for _, t := range checking {
+			v.Start(t.ID)
+		}
+	}
 
 	d.OnStart = func(download *downloader.Download) {
 		download.Object.(*database.Download).IsDownloaded = false
 		downloads.Update(download.Object.(*database.Download))
 
-		t, err := torrents.FindOne(download.Object.(*database.Download).TorrentId)
+		err := torrents.UpdateInternalStatus(download.Object.(*database.Download).TorrentId, database.TorrentInternalDownloading)
 
 		if err != nil {
 			logger.Error("Error while updating torrent status to downloading")
 			return
 		}
 
-		t.InternalStatus = database.TorrentInternalDownloading
+		bus.Publish(downloadEvent(torrents, events.DownloadStarted, download))
 	}
 
 	d.OnUpdate = func(download *downloader.Download) {
-
+		if bus.Progress(download.Object.(*database.Download).ID) {
+			bus.Publish(downloadEvent(torrents, events.DownloadProgress, download))
+		}
 	}
 	d.OnFinish = func(download *downloader.Download) {
-		download.Object.(*database.Download).IsDownloaded = true
-		downloads.Update(download.Object.(*database.Download))
-		// if all downloads are downloaded, update torrent status to downloaded
-		if torrents.AllDownloadsAreDownloaded(download.Object.(*database.Download).TorrentId) {
-			torrents.UpdateTorrentStatusToDownloaded(download.Object.(*database.Download).TorrentId)
+		dl := download.Object.(*database.Download)
+		defer q.Done(dl)
+		bus.Finished(dl.ID)
+
+		switch {
+		case download.Err == nil:
+			bus.Publish(downloadEvent(torrents, events.DownloadFinished, download))
+		case !errors.Is(download.Err, downloader.ErrStopped):
+			bus.Publish(downloadEvent(torrents, events.DownloadFailed, download))
+		}
+
+		// interrupted by a shutdown, keep where it stopped to resume it
+		if errors.Is(download.Err, downloader.ErrStopped) {
+			dl.Segments = savedSegments(download.Segments)
+			downloads.Update(dl)
+			return
+		}
+
+		if download.Err != nil {
+			dl.Error = download.Err.Error()
+			// a stalled connection is tried again from where it stopped
+			if errors.Is(download.Err, downloader.ErrStalled) {
+				dl.Segments = savedSegments(download.Segments)
+			}
+			v.Retry(dl)
+			return
+		}
+
+		dl.IsDownloaded = true
+		dl.Error = ""
+		dl.Ranges = nil
+		dl.Segments = nil
+		downloads.Update(dl)
+		// if all downloads are downloaded, verify them before setting the torrent status to downloaded
+		if torrents.AllDownloadsAreDownloaded(dl.TorrentId) {
+			v.Verify(dl.TorrentId)
 		}
 	}
 	return &QBRDT{
-		logger:      logger,
-		conf:        conf,
-		preferences: preferences,
-		categories:  categories,
-		torrents:    torrents,
-		downloads:   downloads,
-		client:      client,
-		downloader:  d,
+		db:           db,
+		logger:       logger,
+		conf:         conf,
+		preferences:  preferences,
+		categories:   categories,
+		torrents:     torrents,
+		downloads:    downloads,
+		client:       client,
+		downloader:   d,
+		verifier:     v,
+		queue:        q,
+		rdCache:      rdCache,
+		breaker:      breaker,
+		account:      account,
+		availability: availability,
+		outputs:      outputs,
+		events:       bus,
+		admission: jobs.NewTorrentAdmission(
+			client,
+			rdCache,
+			breaker,
+			account,
+			availability,
+			torrents,
+			conf.Qbrdt.MaxActiveDownloads,
+			conf.Qbrdt.MaxActiveTorrents,
+			conf.RealDebrid.MaxActiveTorrents,
+			logger,
+		),
 	}
 }
 
+// Run serves the API until SIGINT or SIGTERM is received, then shuts down gracefully
 func (qbrdt *QBRDT) Run() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	qbrdt.Serve(ctx)
+}
 
+// Serve serves the API until ctx is done, then shuts down gracefully
+func (qbrdt *QBRDT) Serve(ctx context.Context) {
 	e := echo.New()
 
 	e.HideBanner = true
+	// the login ban is per IP, X-Forwarded-For and X-Real-IP are set by the clients
+	e.IPExtractor = echo.ExtractIPDirect()
 	e.Use(middleware.Logger())
 
+	go qbrdt.queue.Run()
+
 	c := cron.New()
 	c.AddJob("@every "+qbrdt.conf.Qbrdt.TorrentRefreshInterval+"s", jobs.NewTorrentUpdater(
 		qbrdt.client,
-		qbrdt.downloader,
+		qbrdt.rdCache,
+		qbrdt.breaker,
+		qbrdt.availability,
+		time.Duration(qbrdt.conf.Stall.RealDebrid)*time.Second,
+		qbrdt.outputs,
+		qbrdt.events,
+		qbrdt.queue,
 		qbrdt.torrents,
 		qbrdt.downloads,
 		qbrdt.preferences,
 		qbrdt.logger,
 	))
 
+	c.AddJob("@every "+qbrdt.conf.Qbrdt.TorrentRefreshInterval+"s", qbrdt.admission)
+
+	importer := jobs.NewTorrentImporter(
+		qbrdt.rdCache,
+		qbrdt.breaker,
+		qbrdt.torrents,
+		qbrdt.categories,
+		qbrdt.conf.Import.Adopt,
+		qbrdt.conf.Import.Category,
+		qbrdt.logger,
+	)
+
+	// compare with the account once at startup, in case torrents were added on
+	// Real-Debrid or the database was lost
+	c.Schedule(&onceSchedule{}, importer)
+	if qbrdt.conf.Import.Interval != "" && qbrdt.conf.Import.Interval != "0" {
+		c.AddJob("@every "+qbrdt.conf.Import.Interval+"s", importer)
+	}
+
+	// check the account at startup, adds are refused if it is not premium
+	c.Schedule(&onceSchedule{}, qbrdt.account)
+	accountInterval := qbrdt.conf.Account.Interval
+	if accountInterval == "" {
+		accountInterval = "3600"
+	}
+	c.AddJob("@every "+accountInterval+"s", qbrdt.account)
+
+	if dirs := watchDirs(qbrdt.conf); len(dirs) > 0 {
+		watchInterval := qbrdt.conf.Watch.Interval
+		if watchInterval == "" {
+			watchInterval = "10"
+		}
+		c.AddJob("@every "+watchInterval+"s", jobs.NewWatchFolder(qbrdt.admission, dirs, qbrdt.logger))
+	}
+
+	if qbrdt.outputs.Streamed() {
+		c.AddJob("@every 10m", jobs.NewStrmRefresher(qbrdt.client, qbrdt.breaker, qbrdt.torrents, qbrdt.logger))
+	}
+
 	c.Start()
 
-	defer c.Stop()
+	noAuthApi := e.Group("/api/v2")
+	auth := qbittorrent.NewAuthenticator(qbrdt.conf.QBittorrent.Username, qbrdt.conf.QBittorrent.Password)
+	loginApi := qbittorrent.NewQbittorrentAuthenticationApi(noAuthApi, auth)
 
 	authApi := e.Group("/api/v2")
-	authApi.Use(middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
-		if username == qbrdt.conf.QBittorrent.Username && password == qbrdt.conf.QBittorrent.Password {
-			return true, nil
+	authApi.Use(qbrdt.writeGuard)
+	authApi.Use(loginApi.RequireAuth)
+
+	qbittorrent.NewQbittorrentAppApi(authApi, qbrdt.preferences, qbrdt.conf.Qbrdt.MaxActiveDownloads, qbrdt.conf.Qbrdt.MaxActiveTorrents, watchDirs(qbrdt.conf))
+	qbittorrent.NewQbittorrentTorrentApi(qbrdt.logger, authApi, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.client, qbrdt.rdCache, qbrdt.verifier, qbrdt.queue, qbrdt.admission, qbrdt.account)
+
+	qbrdtApi := e.Group("/api/qbrdt")
+	qbrdtApi.Use(loginApi.RequireAuth)
+	qbrdtapi.NewQbrdtAccountApi(qbrdtApi, qbrdt.account)
+	qbrdtapi.NewQbrdtTorrentApi(qbrdtApi, qbrdt.logger, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.client, qbrdt.rdCache, qbrdt.admission, qbrdt.queue)
+	qbrdtapi.NewQbrdtCategoryApi(qbrdtApi, qbrdt.preferences, qbrdt.categories, qbrdt.torrents)
+	qbrdtapi.NewQbrdtSettingsApi(qbrdtApi, qbrdt.preferences, qbrdt.queue, qbrdt.downloader)
+	qbrdtapi.NewQbrdtEventsApi(qbrdtApi, qbrdt.events)
+	qbrdtapi.NewQbrdtWebUi(e)
+
+	qbrdtapi.NewQbrdtMetricsApi(e, loginApi.RequireAuth, qbrdt.account)
+
+	transmission.NewTransmissionApi(e, auth, qbrdt.logger, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.client, qbrdt.rdCache, qbrdt.admission, qbrdt.queue)
+
+	deluge.NewDelugeApi(e, auth, qbrdt.logger, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.client, qbrdt.rdCache, qbrdt.admission, qbrdt.queue)
+
+	if qbrdt.outputs.Streamed() {
+		webdav.NewWebdavApi(e, auth, qbrdt.logger, qbrdt.torrents, qbrdt.client)
+	}
+
+	if qbrdt.conf.Sabnzbd.ApiKey != "" {
+		sabnzbd.NewSabnzbdApi(e, qbrdt.conf.Sabnzbd.ApiKey, qbrdt.logger, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.admission, qbrdt.queue)
+	}
+
+	go func() {
+		if err := e.Start(":" + qbrdt.conf.QBittorrent.Port); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			qbrdt.logger.Fatal(err)
 		}
-		return false, nil
-	}))
+	}()
 
-	noAuthApi := e.Group("/api/v2")
+	<-ctx.Done()
+
+	qbrdt.shutdown(e, c)
+}
+
+func (qbrdt *QBRDT) shutdown(e *echo.Echo, c *cron.Cron) {
+	qbrdt.logger.Info("Shutting down")
+
+	// refuse API calls changing torrents
+	qbrdt.stopping.Store(true)
+
+	// wait for the updater and admission runs in progress
+	<-c.Stop().Done()
+	qbrdt.admission.Stop()
+
+	// interrupt downloads, OnFinish saves their progress
+	qbrdt.queue.Stop()
+	qbrdt.downloader.Stop()
+	qbrdt.verifier.Stop()
+
+	// end the event streams, they would keep the HTTP server open
+	qbrdt.events.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		qbrdt.logger.Error("Error shutting down HTTP server: %s", err)
+	}
+
+	if sqlDB, err := qbrdt.db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			qbrdt.logger.Error("Error closing database: %s", err)
+		}
+	}
+
+	qbrdt.logger.Info("Shutdown complete")
+}
+
+// onceSchedule runs a cron job a single time, as soon as the cron starts
+type onceSchedule struct {
+	done bool
+}
+
+func (s *onceSchedule) Next(t time.Time) time.Time {
+	if s.done {
+		return time.Time{}
+	}
+	s.done = true
+	return t
+}
+
+// writeGuard answers 503 to requests that would change torrents once the shutdown started
+func (qbrdt *QBRDT) writeGuard(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !qbrdt.stopping.Load() {
+			return next(c)
+		}
+
+		for _, endpoint := range readOnlyEndpoints {
+			if strings.HasSuffix(c.Path(), endpoint) {
+				return next(c)
+			}
+		}
 
-	qbittorrent.NewQbittorrentAuthenticationApi(noAuthApi, qbrdt.conf.QBittorrent.Username, qbrdt.conf.QBittorrent.Password)
-	qbittorrent.NewQbittorrentAppApi(noAuthApi, qbrdt.preferences)
-	qbittorrent.NewQbittorrentTorrentApi(qbrdt.logger, authApi, qbrdt.preferences, qbrdt.categories, qbrdt.torrents, qbrdt.client)
+		return c.String(http.StatusServiceUnavailable, "Fails.")
+	}
+}
+
+// cacheTTL keeps the answers of Real-Debrid for half the refresh interval,
+// so that each refresh asks Real-Debrid once
+func cacheTTL(refreshInterval string) time.Duration {
+	seconds, err := strconv.Atoi(refreshInterval)
+	if err != nil || seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second / 2
+}
+
+func availabilityPolicy(conf config.AvailabilityConfig) jobs.AvailabilityPolicy {
+	return jobs.AvailabilityPolicy{
+		RejectUncached: conf.RejectUncached,
+		MaxWait:        time.Duration(conf.MaxWait) * time.Second,
+		MinSeeders:     conf.MinSeeders,
+	}
+}
+
+// downloadEvent returns the event of a file of a torrent
+// savedSegments is where the chunks of an interrupted download stopped, as saved in the database
+func savedSegments(segments []downloader.Segment) []database.Segment {
+	var result []database.Segment
+	for _, s := range segments {
+		result = append(result, database.Segment(s))
+	}
+	return result
+}
 
-	e.Logger.Fatal(e.Start(":" + qbrdt.conf.QBittorrent.Port))
+func downloadEvent(torrents *database.TorrentRepository, eventType string, download *downloader.Download) events.Event {
+	progress := download.Status()
+	data := events.DownloadData{
+		File:       download.FileName,
+		Size:       progress.Total,
+		Downloaded: progress.Downloaded,
+		Speed:      int64(progress.Speed),
+		Progress:   progress.Percent,
+	}
+	if download.Err != nil {
+		data.Error = download.Err.Error()
+	}
+	// the last progress may not be counted yet
+	if eventType == events.DownloadFinished {
+		data.Downloaded = data.Size
+		data.Progress = 1
+	}
 
+	event := events.Event{Type: eventType, Data: data}
+	if torrent, err := torrents.FindOne(download.Object.(*database.Download).TorrentId); err == nil {
+		event.Hash = torrent.RDHash
+		event.Name = torrent.RDName
+	}
+	return event
+}
+
+// newOutputs returns the output of each category, an unknown output downloads the files
+func newOutputs(conf *config.QBRDTConfig, logger logger.Interface) *jobs.Outputs {
+	global := jobs.OutputDownload
+	if conf.Stream.Enabled {
+		global = jobs.OutputStream
+	}
+
+	categories := make(map[string]jobs.Output)
+	for name, category := range conf.Categories {
+		switch output := jobs.Output(category.Output); output {
+		case "":
+		case jobs.OutputDownload, jobs.OutputStream, jobs.OutputStrm:
+			categories[name] = output
+		case jobs.OutputSymlink:
+			if conf.Stream.MountPath == "" {
+				logger.Error("Category %s: the symlink output needs stream.mount_path, the files are downloaded", name)
+				categories[name] = jobs.OutputDownload
+				continue
+			}
+			categories[name] = output
+		default:
+			logger.Error("Category %s: unknown output %q, the files are downloaded", name, output)
+			categories[name] = jobs.OutputDownload
+		}
+	}
+
+	return jobs.NewOutputs(global, categories, conf.Stream.MountPath)
+}
+
+// watchDirs returns the watched folders with the category of their torrents
+func watchDirs(conf *config.QBRDTConfig) map[string]string {
+	dirs := make(map[string]string)
+	if conf.Watch.Dir != "" {
+		dirs[conf.Watch.Dir] = ""
+	}
+	for name, category := range conf.Categories {
+		if category.WatchDir != "" {
+			dirs[category.WatchDir] = name
+		}
+	}
+	return dirs
 }
@@ -0,0 +1,2120 @@
+package qbrdt_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/internal/config"
+	"github.com/TOomaAh/qbrdt/internal/qbrdt"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+	"github.com/TOomaAh/qbrdt/pkg/qbrdtclient"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid/realdebridtest"
+)
+
+const (
+	username = "admin"
+	password = "adminadmin"
+)
+
+type harness struct {
+	t        *testing.T
+	rd       *realdebridtest.Server
+	conf     *config.QBRDTConfig
+	endpoint string
+	// stop shuts qbrdt down and waits for it, it is done at the end of the test otherwise
+	stop func()
+}
+
+// start runs qbrdt against a fake Real-Debrid until the end of the test,
+// configure can change the configuration before
+func start(t *testing.T, configure ...func(*config.QBRDTConfig)) *harness {
+	t.Helper()
+
+	rd := realdebridtest.NewServer()
+	rd.Token = "token"
+	t.Cleanup(rd.Close)
+
+	dir := t.TempDir()
+	t.Setenv("QBRDT_DB", filepath.Join(dir, "qbrdt.db"))
+
+	conf := &config.QBRDTConfig{}
+	conf.RealDebrid.Token = rd.Token
+	conf.RealDebrid.BaseUrl = rd.ApiUrl()
+	conf.QBittorrent.Port = freePort(t)
+	conf.QBittorrent.Username = username
+	conf.QBittorrent.Password = password
+	conf.Qbrdt.TorrentRefreshInterval = "1"
+	conf.Downloader.SavePath = filepath.Join(dir, "downloads")
+	conf.Downloader.Chunk = 4
+	conf.Downloader.MinChunkSize = 64
+
+	for _, f := range configure {
+		f(conf)
+	}
+
+	h := &harness{t: t, rd: rd, conf: conf, endpoint: "http://127.0.0.1:" + conf.QBittorrent.Port + "/api/v2"}
+	h.serve()
+	return h
+}
+
+// serve runs qbrdt until stop is called or the test ends
+func (h *harness) serve() {
+	h.t.Helper()
+
+	app := qbrdt.New(logger.New("error"), h.conf)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.Serve(ctx)
+		close(done)
+	}()
+	h.stop = func() {
+		cancel()
+		<-done
+	}
+	h.t.Cleanup(h.stop)
+
+	h.eventually(10*time.Second, func() bool {
+		resp, err := http.Get(h.endpoint + "/app/webapiVersion")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func (h *harness) eventually(timeout time.Duration, condition func() bool) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatal("condition not met before the deadline")
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// get returns the body of a GET on qbrdt, path starting from the server root
+func (h *harness) get(path string) (int, string) {
+	h.t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:"+h.conf.QBittorrent.Port+path, nil)
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func (h *harness) addTorrent(torrentFile []byte, category string) {
+	h.t.Helper()
+
+	if answer := h.postTorrent(torrentFile, category); answer != "Ok." {
+		h.t.Fatalf("add torrent: %s", answer)
+	}
+}
+
+// postTorrent adds a torrent and returns the answer of qbrdt
+func (h *harness) postTorrent(torrentFile []byte, category string) string {
+	h.t.Helper()
+
+	return h.postAdd(func(form *multipart.Writer) {
+		part, _ := form.CreateFormFile("torrents", "test.torrent")
+		part.Write(torrentFile)
+		form.WriteField("category", category)
+	})
+}
+
+// postUrls adds links, one per line, and returns the answer of qbrdt
+func (h *harness) postUrls(urls string, category string) string {
+	h.t.Helper()
+
+	return h.postAdd(func(form *multipart.Writer) {
+		form.WriteField("urls", urls)
+		form.WriteField("category", category)
+	})
+}
+
+func (h *harness) postAdd(fill func(form *multipart.Writer)) string {
+	h.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	fill(form)
+	form.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, h.endpoint+"/torrents/add", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		h.t.Fatalf("add torrent: HTTP %d", resp.StatusCode)
+	}
+
+	answer, _ := io.ReadAll(resp.Body)
+	return string(answer)
+}
+
+type torrentInfo struct {
+	Hash        string  `json:"hash"`
+	Name        string  `json:"name"`
+	State       string  `json:"state"`
+	Progress    float64 `json:"progress"`
+	ContentPath string  `json:"content_path"`
+	Priority    int     `json:"priority"`
+}
+
+func (h *harness) torrents(category string) []torrentInfo {
+	h.t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, h.endpoint+"/torrents/info?category="+category, nil)
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var torrents []torrentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&torrents); err != nil {
+		h.t.Fatal(err)
+	}
+	return torrents
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(content)
+	return content
+}
+
+func TestFailedBasicAuthBanned(t *testing.T) {
+	h := start(t)
+
+	request := func(password, forwardedFor string) (int, string) {
+		req, _ := http.NewRequest(http.MethodGet, h.endpoint+"/app/version", nil)
+		req.SetBasicAuth(username, password)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// a new forwarded IP for each attempt does not get around the ban
+	for i := 0; i < 5; i++ {
+		if status, _ := request("wrong", "10.0.0."+strconv.Itoa(i)); status != http.StatusForbidden {
+			t.Fatalf("expected the wrong password to be refused, got HTTP %d", status)
+		}
+	}
+
+	if status, body := request(password, "10.0.1.1"); status != http.StatusForbidden || !strings.Contains(body, "banned") {
+		t.Errorf("expected the IP to be banned, got HTTP %d %q", status, body)
+	}
+}
+
+func TestTorrentDownloadedToDisk(t *testing.T) {
+	h := start(t)
+
+	movie := randomContent(3*1024*1024 + 123)
+	subtitles := randomContent(4321)
+	torrentFile := h.rd.NewTorrent("Movie", 256*1024,
+		realdebridtest.File{Path: "movie.mkv", Content: movie},
+		realdebridtest.File{Path: "subs/en.srt", Content: subtitles},
+	)
+
+	h.addTorrent(torrentFile, "movies")
+
+	torrents := h.torrents("movies")
+	if len(torrents) != 1 || torrents[0].Name != "Movie" || torrents[0].Priority != 1 {
+		t.Fatalf("unexpected torrents after add: %+v", torrents)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	// out of the queue once downloaded, like a seeding torrent
+	if torrents := h.torrents("movies"); torrents[0].Priority != -1 {
+		t.Errorf("expected the priority -1 once downloaded, got %d", torrents[0].Priority)
+	}
+
+	dir := filepath.Join(h.conf.Downloader.SavePath, "movies", "Movie")
+	for name, want := range map[string][]byte{"movie.mkv": movie, "en.srt": subtitles} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: content differs from the torrent", name)
+		}
+	}
+
+	if ids := h.rd.Torrents(); len(ids) != 1 {
+		t.Errorf("expected 1 torrent on Real-Debrid, got %d", len(ids))
+	}
+}
+
+func TestTorrentDownloadedWithoutRanges(t *testing.T) {
+	h := start(t)
+	h.rd.DisableRanges = true
+	h.rd.Progression = []realdebridtest.Step{{Status: "downloaded", Progress: 100}}
+
+	content := randomContent(1024*1024 + 7)
+	torrentFile := h.rd.NewTorrent("single.bin", 64*1024, realdebridtest.File{Path: "single.bin", Content: content})
+
+	h.addTorrent(torrentFile, "")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "single.bin", "single.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs from the torrent")
+	}
+}
+
+func TestTorrentAddedOnRealDebridAdopted(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Import.Interval = "1"
+		conf.Import.Adopt = true
+		conf.Import.Category = "imported"
+	})
+
+	content := randomContent(512*1024 + 3)
+	torrentFile := h.rd.NewTorrent("adopted.bin", 64*1024, realdebridtest.File{Path: "adopted.bin", Content: content})
+
+	// added on real-debrid.com, qbrdt never saw it
+	client := realdebrid.NewClient(h.rd.Token, h.rd.ApiUrl())
+	add, err := client.AddTorrent(bytes.NewReader(torrentFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SelectFiles(add.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("imported")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "imported", "adopted.bin", "adopted.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs from the torrent")
+	}
+}
+
+func TestTorrentRemovedFromRealDebridKept(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Import.Interval = "1"
+	})
+	// never finishes on Real-Debrid
+	h.rd.Progression = []realdebridtest.Step{{Status: "downloading", Progress: 10}}
+
+	torrentFile := h.rd.NewTorrent("removed.bin", 64*1024, realdebridtest.File{Path: "removed.bin", Content: randomContent(1024)})
+	h.addTorrent(torrentFile, "tv")
+
+	h.eventually(10*time.Second, func() bool {
+		return len(h.rd.Torrents()) == 1
+	})
+
+	client := realdebrid.NewClient(h.rd.Token, h.rd.ApiUrl())
+	if err := client.DeleteTorrent(h.rd.Torrents()[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	h.eventually(10*time.Second, func() bool {
+		torrents := h.torrents("tv")
+		return len(torrents) == 1 && torrents[0].State == "missingFiles"
+	})
+}
+
+func TestTorrentSurvivesRealDebridErrors(t *testing.T) {
+	h := start(t)
+
+	content := randomContent(256*1024 + 11)
+	torrentFile := h.rd.NewTorrent("outage.bin", 64*1024, realdebridtest.File{Path: "outage.bin", Content: content})
+	h.addTorrent(torrentFile, "tv")
+
+	h.eventually(10*time.Second, func() bool {
+		return len(h.rd.Torrents()) == 1
+	})
+
+	// fewer errors than needed to open the circuit, the updater retries them
+	h.rd.Fail(http.StatusServiceUnavailable, 1)
+	h.rd.Fail(http.StatusBadGateway, 1)
+
+	h.eventually(60*time.Second, func() bool {
+		torrents := h.torrents("tv")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "tv", "outage.bin", "outage.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs from the torrent")
+	}
+}
+
+func TestTorrentsRefreshedTogether(t *testing.T) {
+	h := start(t)
+
+	const count = 5
+	for i := 0; i < count; i++ {
+		name := "batch" + strconv.Itoa(i) + ".bin"
+		torrentFile := h.rd.NewTorrent(name, 64*1024, realdebridtest.File{Path: name, Content: randomContent(1024 + i)})
+		h.addTorrent(torrentFile, "batch")
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("batch")
+		for _, torrent := range torrents {
+			if torrent.State != "pausedUP" {
+				return false
+			}
+		}
+		return len(torrents) == count
+	})
+
+	// the admission asks each torrent once, the updater uses the list
+	if info := h.rd.Requests("GET /rest/1.0/torrents/info/{id}"); info > count {
+		t.Errorf("expected at most %d info requests, got %d", count, info)
+	}
+}
+
+func TestAccountExpiringReported(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Account.Interval = "1"
+	})
+	events := h.events()
+
+	user := h.rd.User
+	user.Points = 1200
+	user.Expiration = time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	h.rd.SetUser(user)
+
+	var account struct {
+		Premium  bool `json:"premium"`
+		Expiring bool `json:"expiring"`
+		Points   int  `json:"points"`
+	}
+	h.eventually(10*time.Second, func() bool {
+		status, body := h.get("/api/qbrdt/account")
+		return status == http.StatusOK && json.Unmarshal([]byte(body), &account) == nil && account.Points == 1200
+	})
+
+	if !account.Premium || !account.Expiring {
+		t.Errorf("expected a premium account ending soon, got %+v", account)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for warned := false; !warned; {
+		select {
+		case event := <-events:
+			warned = event.Type == "account.expiring"
+		case <-timeout:
+			t.Fatal("expected an account.expiring event")
+		}
+	}
+
+	_, metrics := h.get("/metrics")
+	for _, line := range []string{"qbrdt_account_premium 1", "qbrdt_account_expiring 1", "qbrdt_account_points 1200", `qbrdt_account_traffic_left{host="rapidgator.net"}`} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics without %q:\n%s", line, metrics)
+		}
+	}
+
+	// without a login too
+	resp, err := http.Get("http://127.0.0.1:" + h.conf.QBittorrent.Port + "/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected an unknown path to be not found, got HTTP %d", resp.StatusCode)
+	}
+}
+
+func TestAddRefusedWithoutPremium(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Account.Interval = "1"
+	})
+
+	user := h.rd.User
+	user.Type = realdebrid.UserTypeFree
+	user.Premium = 0
+	user.Expiration = time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	h.rd.SetUser(user)
+
+	h.eventually(10*time.Second, func() bool {
+		_, body := h.get("/api/qbrdt/account")
+		return strings.Contains(body, `"premium":false`)
+	})
+
+	torrentFile := h.rd.NewTorrent("free.bin", 64*1024, realdebridtest.File{Path: "free.bin", Content: randomContent(1024)})
+	if answer := h.postTorrent(torrentFile, "tv"); !strings.HasPrefix(answer, "Fails.") {
+		t.Errorf("expected the add to fail, got %q", answer)
+	}
+
+	if torrents := h.torrents("tv"); len(torrents) != 0 {
+		t.Errorf("expected no torrent, got %+v", torrents)
+	}
+}
+
+func TestUncachedTorrentRejected(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Categories = map[string]config.CategoryConfig{
+			"sonarr": {Availability: &config.AvailabilityConfig{RejectUncached: true}},
+		}
+	})
+
+	torrentFile := h.rd.NewTorrent("uncached.bin", 64*1024, realdebridtest.File{Path: "uncached.bin", Content: randomContent(2048)})
+
+	if answer := h.postTorrent(torrentFile, "sonarr"); !strings.HasPrefix(answer, "Fails.") {
+		t.Errorf("expected the uncached torrent to be refused, got %q", answer)
+	}
+
+	// other categories use the global policy
+	other := h.rd.NewTorrent("other.bin", 64*1024, realdebridtest.File{Path: "other.bin", Content: randomContent(2049)})
+	h.addTorrent(other, "radarr")
+
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.rd.SetCached(meta.InfoHash)
+
+	h.addTorrent(torrentFile, "sonarr")
+}
+
+func TestUncachedTorrentFailedWithoutInstantAvailability(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Availability.RejectUncached = true
+	})
+	h.rd.DisableInstantAvailability = true
+
+	uncached := h.rd.NewTorrent("uncached.bin", 64*1024, realdebridtest.File{Path: "uncached.bin", Content: randomContent(2048)})
+	h.addTorrent(uncached, "tv")
+
+	cachedContent := randomContent(2049)
+	cached := h.rd.NewTorrent("cached.bin", 64*1024, realdebridtest.File{Path: "cached.bin", Content: cachedContent})
+	meta, err := metainfo.Parse(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.rd.SetCached(meta.InfoHash)
+	h.addTorrent(cached, "tv")
+
+	h.eventually(30*time.Second, func() bool {
+		states := make(map[string]string)
+		for _, torrent := range h.torrents("tv") {
+			states[torrent.Name] = torrent.State
+		}
+		return states["uncached.bin"] == "error" && states["cached.bin"] == "pausedUP"
+	})
+
+	for _, torrent := range h.webTorrents() {
+		if torrent.Name == "uncached.bin" && torrent.Error != "is not cached on Real-Debrid" {
+			t.Errorf("expected the reason of the failure, got %q", torrent.Error)
+		}
+	}
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "tv", "cached.bin", "cached.bin"))
+	if err != nil || !bytes.Equal(got, cachedContent) {
+		t.Errorf("expected the cached torrent to be downloaded, got %v", err)
+	}
+}
+
+func TestTorrentFailedAfterMaxWait(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Availability.MaxWait = 1
+		conf.Availability.MinSeeders = 10
+	})
+	// 5 seeders while downloading, never finishes
+	h.rd.Progression = []realdebridtest.Step{{Status: "downloading", Progress: 10}}
+
+	torrentFile := h.rd.NewTorrent("slow.bin", 64*1024, realdebridtest.File{Path: "slow.bin", Content: randomContent(4096)})
+	h.addTorrent(torrentFile, "tv")
+
+	h.eventually(15*time.Second, func() bool {
+		torrents := h.torrents("tv")
+		return len(torrents) == 1 && torrents[0].State == "error"
+	})
+
+	if ids := h.rd.Torrents(); len(ids) != 0 {
+		t.Errorf("expected the torrent to be removed from Real-Debrid, got %v", ids)
+	}
+}
+
+func TestTorrentStalledOnRealDebridFailed(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stall.RealDebrid = 2
+	})
+	// stuck at 12%
+	h.rd.Progression = []realdebridtest.Step{{Status: "downloading", Progress: 12}}
+
+	torrentFile := h.rd.NewTorrent("stuck.bin", 64*1024, realdebridtest.File{Path: "stuck.bin", Content: randomContent(4096)})
+	h.addTorrent(torrentFile, "tv")
+
+	h.eventually(15*time.Second, func() bool {
+		torrents := h.torrents("tv")
+		return len(torrents) == 1 && torrents[0].State == "error"
+	})
+
+	if ids := h.rd.Torrents(); len(ids) != 0 {
+		t.Errorf("expected the torrent to be removed from Real-Debrid, got %v", ids)
+	}
+}
+
+func TestTorrentFailedOnRealDebridReported(t *testing.T) {
+	for _, status := range []string{"dead", "error", "magnet_error", "virus"} {
+		t.Run(status, func(t *testing.T) {
+			h := start(t)
+			h.rd.Progression = []realdebridtest.Step{{Status: status}}
+
+			torrentFile := h.rd.NewTorrent("failed.bin", 64*1024, realdebridtest.File{Path: "failed.bin", Content: randomContent(4096)})
+			h.addTorrent(torrentFile, "tv")
+
+			h.eventually(15*time.Second, func() bool {
+				torrents := h.torrents("tv")
+				return len(torrents) == 1 && torrents[0].State == "error"
+			})
+
+			if torrents := h.webTorrents(); len(torrents) != 1 || torrents[0].Error != "is "+status+" on Real-Debrid" {
+				t.Errorf("expected the reason of the failure, got %+v", torrents)
+			}
+			if ids := h.rd.Torrents(); len(ids) != 0 {
+				t.Errorf("expected the torrent to be removed from Real-Debrid, got %v", ids)
+			}
+		})
+	}
+}
+
+func TestStalledDownloadResumed(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stall.Download = 1
+	})
+	h.rd.StallFiles(1)
+
+	movie := randomContent(1024*1024 + 7)
+	torrentFile := h.rd.NewTorrent("Hang", 64*1024, realdebridtest.File{Path: "hang.mkv", Content: movie})
+	h.addTorrent(torrentFile, "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "movies", "Hang", "hang.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, movie) {
+		t.Error("content differs from the torrent")
+	}
+}
+
+func TestInterruptedDownloadResumedAfterRestart(t *testing.T) {
+	h := start(t)
+	// a chunk hangs in the middle of the file until qbrdt is stopped
+	h.rd.StallFiles(1)
+
+	movie := randomContent(1024*1024 + 7)
+	h.addTorrent(h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: movie}), "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.webTorrents()
+		return len(torrents) == 1 && len(torrents[0].Files) == 1 && torrents[0].Files[0].Progress > 0.9
+	})
+
+	h.stop()
+	transfers := len(h.rd.Ranges())
+	h.serve()
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	folder := filepath.Join(h.conf.Downloader.SavePath, "movies", "Movie")
+	got, err := os.ReadFile(filepath.Join(folder, "movie.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, movie) {
+		t.Error("content differs from the torrent")
+	}
+
+	// only the bytes missing at the shutdown are downloaded again
+	for _, r := range h.rd.Ranges()[transfers:] {
+		if r == "" || (r != "bytes=0-0" && strings.HasPrefix(r, "bytes=0-")) {
+			t.Errorf("expected the download to be resumed, got the transfer %q", r)
+		}
+	}
+	if entries, _ := os.ReadDir(folder); len(entries) != 1 {
+		t.Errorf("expected only the file in the folder, got %d entries", len(entries))
+	}
+}
+
+func TestStalledDownloadFailed(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stall.Download = 1
+	})
+	// the host never sends the second half
+	h.rd.StallFiles(1000)
+
+	torrentFile := h.rd.NewTorrent("Dead", 64*1024, realdebridtest.File{Path: "dead.mkv", Content: randomContent(256 * 1024)})
+	h.addTorrent(torrentFile, "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "error"
+	})
+}
+
+func TestWatchFolderTorrentAdded(t *testing.T) {
+	watch := t.TempDir()
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Watch.Dir = watch
+		conf.Watch.Interval = "1"
+	})
+
+	content := randomContent(200 * 1024)
+	torrentFile := h.rd.NewTorrent("Dropped", 64*1024, realdebridtest.File{Path: "dropped.mkv", Content: content})
+	if err := os.WriteFile(filepath.Join(watch, "dropped.torrent"), torrentFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	if _, err := os.Stat(filepath.Join(watch, "done", "dropped.torrent")); err != nil {
+		t.Errorf("expected the torrent file to be moved to done: %s", err)
+	}
+}
+
+func TestWatchFolderMagnetAdded(t *testing.T) {
+	watch := t.TempDir()
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Watch.Interval = "1"
+		conf.Categories = map[string]config.CategoryConfig{"tv": {WatchDir: watch}}
+	})
+
+	torrentFile := h.rd.NewTorrent("Show.S01E01", 64*1024, realdebridtest.File{Path: "episode.mkv", Content: randomContent(4096)})
+	links := "# exported links\n" + realdebridtest.Magnet(torrentFile) + "\n"
+	if err := os.WriteFile(filepath.Join(watch, "show.magnet"), []byte(links), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("tv")
+		return len(torrents) == 1 && torrents[0].Name == "Show.S01E01"
+	})
+
+	if _, err := os.Stat(filepath.Join(watch, "done", "show.magnet")); err != nil {
+		t.Errorf("expected the magnet file to be moved to done: %s", err)
+	}
+
+	code, body := h.get("/api/v2/app/preferences")
+	if code != http.StatusOK || !strings.Contains(body, `"scan_dirs":{"`+watch+`":`) {
+		t.Errorf("expected the watched folder in scan_dirs, got %d %s", code, body)
+	}
+}
+
+func TestWatchFolderPartlyAddedFileDone(t *testing.T) {
+	watch := t.TempDir()
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Watch.Interval = "1"
+		conf.Categories = map[string]config.CategoryConfig{"tv": {WatchDir: watch}}
+	})
+
+	known := h.rd.NewTorrent("Show.S01E01", 64*1024, realdebridtest.File{Path: "episode1.mkv", Content: randomContent(4096)})
+	h.addTorrent(known, "tv")
+
+	next := h.rd.NewTorrent("Show.S01E02", 64*1024, realdebridtest.File{Path: "episode2.mkv", Content: randomContent(4097)})
+	links := realdebridtest.Magnet(known) + "\n" + realdebridtest.Magnet(next) + "\n"
+	if err := os.WriteFile(filepath.Join(watch, "season.txt"), []byte(links), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		_, err := os.Stat(filepath.Join(watch, "done", "season.txt"))
+		return err == nil
+	})
+
+	if torrents := h.torrents("tv"); len(torrents) != 2 {
+		t.Errorf("expected the new link to be added next to the known one, got %+v", torrents)
+	}
+	if _, err := os.Stat(filepath.Join(watch, "failed", "season.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file not to be failed, got %v", err)
+	}
+}
+
+func TestWatchFolderInvalidFileFailed(t *testing.T) {
+	watch := t.TempDir()
+	start(t, func(conf *config.QBRDTConfig) {
+		conf.Watch.Dir = watch
+		conf.Watch.Interval = "1"
+	})
+
+	if err := os.WriteFile(filepath.Join(watch, "broken.torrent"), []byte("not a torrent"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(watch, "notes.txt"), []byte("no links here"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(15 * time.Second)
+	for _, name := range []string{"broken.torrent", "notes.txt"} {
+		for {
+			if _, err := os.Stat(filepath.Join(watch, "failed", name)); err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %s to be moved to failed", name)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+func TestDuplicateTorrentRefused(t *testing.T) {
+	h := start(t)
+	h.rd.Progression = []realdebridtest.Step{{Status: "queued"}}
+
+	torrentFile := h.rd.NewTorrent("Twice", 64*1024, realdebridtest.File{Path: "twice.mkv", Content: randomContent(4096)})
+	h.addTorrent(torrentFile, "movies")
+
+	if answer := h.postTorrent(torrentFile, "movies"); answer != "Fails." {
+		t.Errorf("expected the duplicate to be refused, got %q", answer)
+	}
+
+	magnet := realdebridtest.Magnet(torrentFile)
+	if answer := h.postUrls(magnet, "movies"); answer != "Fails." {
+		t.Errorf("expected the duplicate magnet to be refused, got %q", answer)
+	}
+
+	h.eventually(10*time.Second, func() bool {
+		return len(h.rd.Torrents()) == 1
+	})
+	time.Sleep(1500 * time.Millisecond)
+	if ids := h.rd.Torrents(); len(ids) != 1 {
+		t.Errorf("expected 1 torrent on Real-Debrid, got %v", ids)
+	}
+}
+
+func TestRefusedTorrentFailedAndQueueGoesOn(t *testing.T) {
+	h := start(t)
+
+	refusedFile := h.rd.NewTorrent("Refused", 64*1024, realdebridtest.File{Path: "refused.mkv", Content: randomContent(4096)})
+	refused, err := metainfo.Parse(refusedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.rd.Refuse(refused.InfoHash)
+
+	h.addTorrent(refusedFile, "movies")
+	h.addTorrent(h.rd.NewTorrent("Accepted", 64*1024, realdebridtest.File{Path: "accepted.mkv", Content: randomContent(8192)}), "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		states := map[string]string{}
+		for _, torrent := range h.torrents("movies") {
+			states[torrent.Name] = torrent.State
+		}
+		return states["Refused"] == "error" && states["Accepted"] == "pausedUP"
+	})
+
+	for _, torrent := range h.webTorrents() {
+		if torrent.Name == "Refused" && !strings.Contains(torrent.Error, "infringing_file") {
+			t.Errorf("expected the reason of the refusal, got %q", torrent.Error)
+		}
+	}
+}
+
+func TestMagnetAddedFromUrls(t *testing.T) {
+	h := start(t)
+
+	content := randomContent(100 * 1024)
+	torrentFile := h.rd.NewTorrent("Linked", 64*1024, realdebridtest.File{Path: "linked.mkv", Content: content})
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the btih in base32 like some indexers give it
+	hash, _ := hex.DecodeString(meta.InfoHash)
+	magnet := "magnet:?xt=urn:btih:" + base32.StdEncoding.EncodeToString(hash) + "&dn=Linked"
+
+	if answer := h.postUrls(magnet, "movies"); answer != "Ok." {
+		t.Fatalf("add magnet: %s", answer)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].Hash == meta.InfoHash && torrents[0].State == "pausedUP"
+	})
+
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "movies", "Linked", "linked.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs from the torrent")
+	}
+}
+
+func TestFilesListedBeforeRealDebrid(t *testing.T) {
+	h := start(t)
+	h.rd.Progression = []realdebridtest.Step{{Status: "queued"}}
+
+	torrentFile := h.rd.NewTorrent("Season", 64*1024,
+		realdebridtest.File{Path: "e01.mkv", Content: randomContent(100 * 1024)},
+		realdebridtest.File{Path: "e02.mkv", Content: randomContent(30 * 1024)},
+	)
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.addTorrent(torrentFile, "tv")
+
+	code, body := h.get("/api/v2/torrents/files?hash=" + meta.InfoHash)
+	if code != http.StatusOK {
+		t.Fatalf("files: HTTP %d", code)
+	}
+
+	var files []struct {
+		Name       string  `json:"name"`
+		Size       int64   `json:"size"`
+		PieceRange []int64 `json:"piece_range"`
+	}
+	if err := json.Unmarshal([]byte(body), &files); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 2 || files[0].Name != "Season/e01.mkv" || files[1].Name != "Season/e02.mkv" {
+		t.Fatalf("unexpected files: %s", body)
+	}
+	if files[1].Size != 30*1024 || files[1].PieceRange[0] != 1 || files[1].PieceRange[1] != 2 {
+		t.Errorf("unexpected second file: %+v", files[1])
+	}
+}
+
+func TestHosterLinksDownloaded(t *testing.T) {
+	h := start(t)
+
+	first := randomContent(300 * 1024)
+	second := randomContent(12345)
+	links := h.rd.HostFile(realdebridtest.File{Path: "pack.part1.rar", Content: first}) + "\n" +
+		h.rd.HostFile(realdebridtest.File{Path: "pack.part2.rar", Content: second})
+
+	answer := h.postAdd(func(form *multipart.Writer) {
+		form.WriteField("urls", links)
+		form.WriteField("rename", "Pack")
+		form.WriteField("category", "movies")
+	})
+	if answer != "Ok." {
+		t.Fatalf("add links: %s", answer)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].Name == "Pack" && torrents[0].State == "pausedUP"
+	})
+
+	if hash := h.torrents("movies")[0].Hash; len(hash) != 40 {
+		t.Errorf("expected a 40 characters hash, got %q", hash)
+	}
+
+	dir := filepath.Join(h.conf.Downloader.SavePath, "movies", "Pack")
+	for name, want := range map[string][]byte{"pack.part1.rar": first, "pack.part2.rar": second} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: content differs from the hoster", name)
+		}
+	}
+
+	if ids := h.rd.Torrents(); len(ids) != 0 {
+		t.Errorf("expected no torrent on Real-Debrid, got %v", ids)
+	}
+
+	if answer := h.postUrls(links, "movies"); answer != "Fails." {
+		t.Errorf("expected the same links to be refused, got %q", answer)
+	}
+}
+
+func TestHosterLinksNameOutsideSavePathRefused(t *testing.T) {
+	h := start(t)
+
+	link := h.rd.HostFile(realdebridtest.File{Path: "escape.rar", Content: randomContent(4096)})
+	for _, name := range []string{"../../escape", "a/b", ".."} {
+		answer := h.postAdd(func(form *multipart.Writer) {
+			form.WriteField("urls", link)
+			form.WriteField("rename", name)
+			form.WriteField("category", "movies")
+		})
+		if answer != "Fails." {
+			t.Errorf("expected the name %q to be refused, got %q", name, answer)
+		}
+	}
+
+	if torrents := h.torrents("movies"); len(torrents) != 0 {
+		t.Errorf("expected no torrent, got %+v", torrents)
+	}
+	if _, err := os.Stat(filepath.Join(h.conf.Downloader.SavePath, "..", "escape")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written outside the save path, got %v", err)
+	}
+}
+
+func TestFilesOutsideTorrentFolderKept(t *testing.T) {
+	h := start(t)
+	client := qbrdtclient.NewClient("http://127.0.0.1:"+h.conf.QBittorrent.Port, username, password)
+
+	kept := filepath.Join(h.conf.Downloader.SavePath, "movies", "kept.mkv")
+	if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(kept, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the name of a torrent comes from its creator
+	torrentFile := h.rd.NewTorrent("..", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: randomContent(4096)})
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.addTorrent(torrentFile, "movies")
+
+	if err := client.Delete(meta.InfoHash, true); err != nil {
+		t.Fatal(err)
+	}
+	if torrents := h.torrents("movies"); len(torrents) != 0 {
+		t.Errorf("expected the torrent to be deleted, got %+v", torrents)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("expected the files of the category to be kept, got %v", err)
+	}
+}
+
+func TestDeadHosterLinkRefused(t *testing.T) {
+	h := start(t)
+
+	if answer := h.postUrls(h.rd.URL+"/hoster/42", "movies"); answer != "Fails." {
+		t.Errorf("expected the dead link to be refused, got %q", answer)
+	}
+
+	if torrents := h.torrents("movies"); len(torrents) != 0 {
+		t.Errorf("expected no torrent, got %+v", torrents)
+	}
+}
+
+// sabnzbd calls the SABnzbd API with the key of the configuration
+func (h *harness) sabnzbd(mode string, params url.Values) map[string]interface{} {
+	h.t.Helper()
+
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("mode", mode)
+	params.Set("output", "json")
+	if params.Get("apikey") == "" {
+		params.Set("apikey", h.conf.Sabnzbd.ApiKey)
+	}
+
+	_, body := h.get("/sabnzbd/api?" + params.Encode())
+
+	var answer map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &answer); err != nil {
+		h.t.Fatalf("%s: %s", body, err)
+	}
+	return answer
+}
+
+func slots(answer map[string]interface{}, key string) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, slot := range answer[key].(map[string]interface{})["slots"].([]interface{}) {
+		result = append(result, slot.(map[string]interface{}))
+	}
+	return result
+}
+
+func withSabnzbd(conf *config.QBRDTConfig) {
+	conf.Sabnzbd.ApiKey = "sabnzbdkey"
+}
+
+func TestSabnzbdLinksDownloaded(t *testing.T) {
+	h := start(t, withSabnzbd)
+
+	content := randomContent(200 * 1024)
+	link := h.rd.HostFile(realdebridtest.File{Path: "show.mkv", Content: content})
+
+	answer := h.sabnzbd("addurl", url.Values{"name": {link}, "cat": {"tv"}, "nzbname": {"Show"}})
+	if answer["status"] != true {
+		t.Fatalf("addurl: %v", answer)
+	}
+	id := answer["nzo_ids"].([]interface{})[0].(string)
+
+	h.eventually(30*time.Second, func() bool {
+		history := slots(h.sabnzbd("history", nil), "history")
+		return len(history) == 1 && history[0]["status"] == "Completed"
+	})
+
+	history := slots(h.sabnzbd("history", nil), "history")[0]
+	storage := filepath.Join(h.conf.Downloader.SavePath, "tv", "Show")
+	if history["nzo_id"] != id || history["category"] != "tv" || history["storage"] != storage {
+		t.Errorf("unexpected history slot %v", history)
+	}
+
+	got, err := os.ReadFile(filepath.Join(storage, "show.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs from the hoster")
+	}
+
+	// the jobs belong to the SABnzbd clients only
+	if torrents := h.torrents("tv"); len(torrents) != 0 {
+		t.Errorf("expected no torrent in qBittorrent, got %+v", torrents)
+	}
+
+	h.sabnzbd("history", url.Values{"name": {"delete"}, "value": {id}, "del_files": {"1"}})
+	if history := slots(h.sabnzbd("history", nil), "history"); len(history) != 0 {
+		t.Errorf("expected an empty history, got %v", history)
+	}
+	if _, err := os.Stat(storage); !os.IsNotExist(err) {
+		t.Errorf("expected the files to be deleted, got %v", err)
+	}
+}
+
+func TestSabnzbdQueueProgress(t *testing.T) {
+	h := start(t, withSabnzbd, func(conf *config.QBRDTConfig) {
+		conf.Stall.Download = 5
+	})
+	// the download hangs in the middle of the file until the stall timeout
+	h.rd.StallFiles(1)
+
+	link := h.rd.HostFile(realdebridtest.File{Path: "show.mkv", Content: randomContent(1024 * 1024)})
+	if answer := h.sabnzbd("addurl", url.Values{"name": {link}}); answer["status"] != true {
+		t.Fatalf("addurl: %v", answer)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		jobs := slots(h.sabnzbd("queue", nil), "queue")
+		return len(jobs) == 1 && jobs[0]["status"] == "Downloading" && jobs[0]["percentage"] != "0" && jobs[0]["mbleft"] != jobs[0]["mb"]
+	})
+
+	h.eventually(30*time.Second, func() bool {
+		history := slots(h.sabnzbd("history", nil), "history")
+		return len(history) == 1 && history[0]["status"] == "Completed"
+	})
+}
+
+func TestSabnzbdWrongKeyRefused(t *testing.T) {
+	h := start(t, withSabnzbd)
+
+	if answer := h.sabnzbd("queue", url.Values{"apikey": {"wrong"}}); answer["status"] != false || answer["error"] != "API Key Incorrect" {
+		t.Errorf("expected the key to be refused, got %v", answer)
+	}
+
+	if answer := h.sabnzbd("version", url.Values{"apikey": {"wrong"}}); answer["version"] == nil {
+		t.Errorf("expected the version without key, got %v", answer)
+	}
+}
+
+func TestSabnzbdPausedQueue(t *testing.T) {
+	h := start(t, withSabnzbd)
+
+	if answer := h.sabnzbd("pause", nil); answer["status"] != true {
+		t.Fatalf("pause: %v", answer)
+	}
+
+	link := h.rd.HostFile(realdebridtest.File{Path: "paused.bin", Content: randomContent(1024)})
+	if answer := h.sabnzbd("addurl", url.Values{"name": {link}}); answer["status"] != true {
+		t.Fatalf("addurl: %v", answer)
+	}
+
+	time.Sleep(3 * time.Second)
+
+	queue := h.sabnzbd("queue", nil)
+	if jobs := slots(queue, "queue"); len(jobs) != 1 || jobs[0]["status"] != "Paused" || queue["queue"].(map[string]interface{})["paused"] != true {
+		t.Fatalf("expected a paused job, got %v", queue)
+	}
+
+	// only the jobs of the SABnzbd API are paused
+	h.addTorrent(h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: randomContent(100 * 1024)}), "movies")
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+	if jobs := slots(h.sabnzbd("queue", nil), "queue"); len(jobs) != 1 || jobs[0]["status"] != "Paused" {
+		t.Fatalf("expected the job still paused, got %v", jobs)
+	}
+
+	h.sabnzbd("resume", nil)
+
+	h.eventually(30*time.Second, func() bool {
+		history := slots(h.sabnzbd("history", nil), "history")
+		return len(history) == 1 && history[0]["status"] == "Completed"
+	})
+}
+
+// transmission calls the Transmission RPC, getting a session id first like the clients
+func (h *harness) transmission(method string, arguments interface{}) map[string]interface{} {
+	h.t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"method": method, "arguments": arguments})
+	rpc := "http://127.0.0.1:" + h.conf.QBittorrent.Port + "/transmission/rpc"
+
+	var sessionId string
+	for attempt := 0; attempt < 2; attempt++ {
+		req, _ := http.NewRequest(http.MethodPost, rpc, bytes.NewReader(body))
+		req.SetBasicAuth(username, password)
+		req.Header.Set("X-Transmission-Session-Id", sessionId)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		answer, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusConflict {
+			sessionId = resp.Header.Get("X-Transmission-Session-Id")
+			continue
+		}
+
+		var response map[string]interface{}
+		if err := json.Unmarshal(answer, &response); err != nil {
+			h.t.Fatalf("%s: %s", answer, err)
+		}
+		if response["result"] != "success" {
+			h.t.Fatalf("%s: %v", method, response)
+		}
+		return response["arguments"].(map[string]interface{})
+	}
+
+	h.t.Fatal("no session id")
+	return nil
+}
+
+func (h *harness) transmissionTorrents() []map[string]interface{} {
+	h.t.Helper()
+
+	var torrents []map[string]interface{}
+	arguments := map[string]interface{}{"fields": []string{"id", "name", "hashString", "status", "isFinished", "percentDone", "downloadDir", "labels"}}
+	for _, torrent := range h.transmission("torrent-get", arguments)["torrents"].([]interface{}) {
+		torrents = append(torrents, torrent.(map[string]interface{}))
+	}
+	return torrents
+}
+
+func TestTransmissionTorrentDownloaded(t *testing.T) {
+	h := start(t)
+
+	content := randomContent(200 * 1024)
+	torrentFile := h.rd.NewTorrent("Episode", 64*1024, realdebridtest.File{Path: "episode.mkv", Content: content})
+	downloadDir := filepath.Join(h.conf.Downloader.SavePath, "tv")
+
+	added := h.transmission("torrent-add", map[string]interface{}{
+		"metainfo":     base64.StdEncoding.EncodeToString(torrentFile),
+		"download-dir": downloadDir,
+	})["torrent-added"].(map[string]interface{})
+	if added["name"] != "Episode" {
+		t.Fatalf("unexpected added torrent %v", added)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.transmissionTorrents()
+		return len(torrents) == 1 && torrents[0]["isFinished"] == true
+	})
+
+	torrent := h.transmissionTorrents()[0]
+	if torrent["status"] != 0.0 || torrent["percentDone"] != 1.0 || torrent["downloadDir"] != downloadDir || torrent["hashString"] != added["hashString"] {
+		t.Errorf("unexpected torrent %v", torrent)
+	}
+
+	// the same torrent through the qBittorrent API
+	if torrents := h.torrents("tv"); len(torrents) != 1 || torrents[0].State != "pausedUP" {
+		t.Errorf("expected the torrent in qBittorrent, got %+v", torrents)
+	}
+
+	duplicate := h.transmission("torrent-add", map[string]interface{}{"metainfo": base64.StdEncoding.EncodeToString(torrentFile)})
+	if duplicate["torrent-duplicate"] == nil {
+		t.Errorf("expected a duplicate, got %v", duplicate)
+	}
+
+	h.transmission("torrent-remove", map[string]interface{}{"ids": []interface{}{added["id"]}, "delete-local-data": true})
+	if torrents := h.transmissionTorrents(); len(torrents) != 0 {
+		t.Errorf("expected no torrent, got %v", torrents)
+	}
+	if _, err := os.Stat(filepath.Join(downloadDir, "Episode")); !os.IsNotExist(err) {
+		t.Errorf("expected the files to be deleted, got %v", err)
+	}
+}
+
+func TestTransmissionStoppedTorrentKept(t *testing.T) {
+	h := start(t)
+
+	torrentFile := h.rd.NewTorrent("Later", 64*1024, realdebridtest.File{Path: "later.mkv", Content: randomContent(4096)})
+	added := h.transmission("torrent-add", map[string]interface{}{
+		"metainfo": base64.StdEncoding.EncodeToString(torrentFile),
+		"paused":   true,
+		"labels":   []string{"movies"},
+	})["torrent-added"].(map[string]interface{})
+
+	time.Sleep(2 * time.Second)
+
+	if ids := h.rd.Torrents(); len(ids) != 0 {
+		t.Errorf("expected the stopped torrent to stay local, got %v", ids)
+	}
+	if torrents := h.torrents("movies"); len(torrents) != 1 || torrents[0].State != "pausedDL" {
+		t.Fatalf("expected a paused torrent, got %+v", torrents)
+	}
+
+	h.transmission("torrent-start", map[string]interface{}{"ids": added["hashString"]})
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.transmissionTorrents()
+		return len(torrents) == 1 && torrents[0]["isFinished"] == true
+	})
+}
+
+func TestTransmissionSessionRequired(t *testing.T) {
+	h := start(t)
+
+	rpc := "http://127.0.0.1:" + h.conf.QBittorrent.Port + "/transmission/rpc"
+
+	req, _ := http.NewRequest(http.MethodPost, rpc, strings.NewReader(`{"method":"session-get"}`))
+	req.SetBasicAuth(username, "wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 with wrong credentials, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, rpc, strings.NewReader(`{"method":"session-get"}`))
+	req.SetBasicAuth(username, password)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict || resp.Header.Get("X-Transmission-Session-Id") == "" {
+		t.Errorf("expected 409 with a session id, got %d", resp.StatusCode)
+	}
+
+	if session := h.transmission("session-get", nil); session["rpc-version"] != 17.0 {
+		t.Errorf("unexpected session %v", session)
+	}
+}
+
+func TestTransmissionFailedAuthBanned(t *testing.T) {
+	h := start(t)
+
+	request := func(password string) int {
+		req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:"+h.conf.QBittorrent.Port+"/transmission/rpc", strings.NewReader(`{"method":"session-get"}`))
+		req.SetBasicAuth(username, password)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := request("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("expected the wrong password to be refused, got HTTP %d", status)
+		}
+	}
+
+	if status := request(password); status != http.StatusForbidden {
+		t.Errorf("expected the IP to be banned, got HTTP %d", status)
+	}
+	// the ban is shared with the qBittorrent API
+	if status, body := h.get("/api/v2/app/version"); status != http.StatusForbidden || !strings.Contains(body, "banned") {
+		t.Errorf("expected the IP to be banned from the qBittorrent API, got HTTP %d %q", status, body)
+	}
+}
+
+// delugeClient logs in the Deluge JSON-RPC and calls it with its session cookie
+type delugeClient struct {
+	h      *harness
+	client *http.Client
+}
+
+func (h *harness) deluge() *delugeClient {
+	h.t.Helper()
+
+	jar, _ := cookiejar.New(nil)
+	d := &delugeClient{h: h, client: &http.Client{Jar: jar}}
+
+	if result, _ := d.call("auth.login", password); result != true {
+		h.t.Fatalf("deluge login: %v", result)
+	}
+	return d
+}
+
+// call returns the result of a method, or the message of its error
+func (d *delugeClient) call(method string, params ...interface{}) (interface{}, string) {
+	d.h.t.Helper()
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"method": method, "params": params, "id": 1})
+
+	resp, err := d.client.Post("http://127.0.0.1:"+d.h.conf.QBittorrent.Port+"/json", "application/json", bytes.NewReader(body))
+	if err != nil {
+		d.h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result interface{}
+		Error  *struct{ Message string }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		d.h.t.Fatal(err)
+	}
+	if response.Error != nil {
+		return nil, response.Error.Message
+	}
+	return response.Result, ""
+}
+
+func (d *delugeClient) status(hash string) map[string]interface{} {
+	d.h.t.Helper()
+
+	result, message := d.call("core.get_torrent_status", hash, []string{"name", "state", "is_finished", "progress", "label", "save_path"})
+	if message != "" {
+		d.h.t.Fatal(message)
+	}
+	return result.(map[string]interface{})
+}
+
+func TestDelugeTorrentLabeled(t *testing.T) {
+	h := start(t)
+	d := h.deluge()
+
+	content := randomContent(100 * 1024)
+	torrentFile := h.rd.NewTorrent("Film", 64*1024, realdebridtest.File{Path: "film.mkv", Content: content})
+
+	if _, message := d.call("label.add", "radarr"); message != "" {
+		t.Fatal(message)
+	}
+
+	hash, message := d.call("core.add_torrent_file", "film.torrent", base64.StdEncoding.EncodeToString(torrentFile), map[string]interface{}{})
+	if message != "" {
+		t.Fatal(message)
+	}
+	if _, message := d.call("label.set_torrent", hash, "radarr"); message != "" {
+		t.Fatal(message)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		return d.status(hash.(string))["is_finished"] == true
+	})
+
+	status := d.status(hash.(string))
+	if status["label"] != "radarr" || status["save_path"] != filepath.Join(h.conf.Downloader.SavePath, "radarr") || status["progress"] != 100.0 {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	// labels are the categories of the qBittorrent API
+	if torrents := h.torrents("radarr"); len(torrents) != 1 {
+		t.Errorf("expected the torrent in the radarr category, got %+v", torrents)
+	}
+
+	labeled, _ := d.call("core.get_torrents_status", map[string]interface{}{"label": "radarr"}, []string{"name"})
+	if torrents := labeled.(map[string]interface{}); len(torrents) != 1 || torrents[hash.(string)] == nil {
+		t.Errorf("expected the torrent in the label filter, got %v", torrents)
+	}
+
+	// a post-import label moves the files
+	d.call("label.add", "imported")
+	if _, message := d.call("label.set_torrent", hash, "imported"); message != "" {
+		t.Fatal(message)
+	}
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "imported", "Film", "film.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs after the move")
+	}
+
+	if _, message := d.call("core.add_torrent_file", "film.torrent", base64.StdEncoding.EncodeToString(torrentFile), map[string]interface{}{}); message == "" {
+		t.Error("expected the duplicate to be refused")
+	}
+
+	if result, _ := d.call("core.remove_torrent", hash, true); result != true {
+		t.Errorf("remove: %v", result)
+	}
+	if _, err := os.Stat(filepath.Join(h.conf.Downloader.SavePath, "imported", "Film")); !os.IsNotExist(err) {
+		t.Errorf("expected the files to be deleted, got %v", err)
+	}
+}
+
+func TestDelugeLabelAddedAgain(t *testing.T) {
+	h := start(t)
+	d := h.deluge()
+
+	for i := 0; i < 2; i++ {
+		if _, message := d.call("label.add", "sonarr"); message != "" {
+			t.Fatalf("add %d: %s", i, message)
+		}
+		if _, message := d.call("label.remove", "sonarr"); message != "" {
+			t.Fatalf("remove %d: %s", i, message)
+		}
+	}
+
+	if _, message := d.call("label.add", "sonarr"); message != "" {
+		t.Fatal(message)
+	}
+
+	// the torrents of a removed label are left without one
+	content := randomContent(100 * 1024)
+	torrentFile := h.rd.NewTorrent("Show", 64*1024, realdebridtest.File{Path: "show.mkv", Content: content})
+	hash, message := d.call("core.add_torrent_file", "show.torrent", base64.StdEncoding.EncodeToString(torrentFile), map[string]interface{}{})
+	if message != "" {
+		t.Fatal(message)
+	}
+	if _, message := d.call("label.set_torrent", hash, "sonarr"); message != "" {
+		t.Fatal(message)
+	}
+	h.eventually(30*time.Second, func() bool {
+		return d.status(hash.(string))["is_finished"] == true
+	})
+
+	if _, message := d.call("label.remove", "sonarr"); message != "" {
+		t.Fatal(message)
+	}
+	if status := d.status(hash.(string)); status["label"] != "" || status["save_path"] != h.conf.Downloader.SavePath {
+		t.Errorf("expected the torrent without label, got %v", status)
+	}
+	got, err := os.ReadFile(filepath.Join(h.conf.Downloader.SavePath, "Show", "show.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("content differs after the move")
+	}
+
+	if _, message := d.call("label.add", "sonarr"); message != "" {
+		t.Fatal(message)
+	}
+	if labels, _ := d.call("label.get_labels"); len(labels.([]interface{})) != 1 || labels.([]interface{})[0] != "sonarr" {
+		t.Errorf("expected the label back, got %v", labels)
+	}
+}
+
+func TestDelugeAuthenticationRequired(t *testing.T) {
+	h := start(t)
+
+	d := &delugeClient{h: h, client: http.DefaultClient}
+	if result, _ := d.call("auth.login", "wrong"); result != false {
+		t.Errorf("expected the wrong password to be refused, got %v", result)
+	}
+	if _, message := d.call("web.update_ui", []string{"name"}, map[string]interface{}{}); message != "Not authenticated" {
+		t.Errorf("expected an authentication error, got %q", message)
+	}
+
+	if connected, _ := h.deluge().call("web.connected"); connected != true {
+		t.Errorf("expected the web UI to be connected, got %v", connected)
+	}
+}
+
+func TestDelugeFailedLoginBanned(t *testing.T) {
+	h := start(t)
+
+	d := &delugeClient{h: h, client: http.DefaultClient}
+	for i := 0; i < 5; i++ {
+		if result, _ := d.call("auth.login", "wrong"); result != false {
+			t.Fatalf("expected the wrong password to be refused, got %v", result)
+		}
+	}
+
+	if result, _ := d.call("auth.login", password); result != false {
+		t.Errorf("expected the IP to be banned, got %v", result)
+	}
+	if status, body := h.get("/api/v2/app/version"); status != http.StatusForbidden || !strings.Contains(body, "banned") {
+		t.Errorf("expected the IP to be banned from the qBittorrent API, got HTTP %d %q", status, body)
+	}
+}
+
+// webdav sends a request to the WebDAV server of qbrdt
+func (h *harness) webdav(method, path string, header map[string]string) (*http.Response, []byte) {
+	h.t.Helper()
+
+	req, _ := http.NewRequest(method, "http://127.0.0.1:"+h.conf.QBittorrent.Port+"/webdav"+path, nil)
+	req.SetBasicAuth(username, password)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
+
+func TestStreamedTorrentServedOverWebdav(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stream.Enabled = true
+	})
+
+	movie := randomContent(512*1024 + 7)
+	torrentFile := h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: movie})
+	h.addTorrent(torrentFile, "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.torrents("movies")
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	if _, err := os.Stat(filepath.Join(h.conf.Downloader.SavePath, "movies", "Movie")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing downloaded, got %v", err)
+	}
+
+	resp, listing := h.webdav("PROPFIND", "/movies/Movie/", map[string]string{"Depth": "1"})
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(string(listing), "movie.mkv") || !strings.Contains(string(listing), strconv.Itoa(len(movie))) {
+		t.Fatalf("unexpected listing %d: %s", resp.StatusCode, listing)
+	}
+
+	resp, body := h.webdav(http.MethodGet, "/movies/Movie/movie.mkv", map[string]string{"Range": "bytes=1000-199999"})
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, movie[1000:200000]) {
+		t.Errorf("unexpected range %d, %d bytes", resp.StatusCode, len(body))
+	}
+
+	if _, body := h.webdav(http.MethodGet, "/movies/Movie/movie.mkv", nil); !bytes.Equal(body, movie) {
+		t.Error("content differs from the torrent")
+	}
+
+	if resp, _ := h.webdav(http.MethodDelete, "/movies/Movie/movie.mkv", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected the server to be read-only, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebdavFailedAuthBanned(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stream.Enabled = true
+	})
+
+	request := func(password string) int {
+		req, _ := http.NewRequest("PROPFIND", "http://127.0.0.1:"+h.conf.QBittorrent.Port+"/webdav/", nil)
+		req.SetBasicAuth(username, password)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for i := 0; i < 5; i++ {
+		if status := request("wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("expected the wrong password to be refused, got HTTP %d", status)
+		}
+	}
+
+	if status := request(password); status != http.StatusUnauthorized {
+		t.Errorf("expected the IP to be banned, got HTTP %d", status)
+	}
+	if status, body := h.get("/api/v2/app/version"); status != http.StatusForbidden || !strings.Contains(body, "banned") {
+		t.Errorf("expected the IP to be banned from the qBittorrent API, got HTTP %d %q", status, body)
+	}
+}
+
+func TestStrmAndSymlinkOutputs(t *testing.T) {
+	mount := t.TempDir()
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stream.MountPath = mount
+		conf.Categories = map[string]config.CategoryConfig{
+			"tv":     {Output: "strm"},
+			"movies": {Output: "symlink"},
+		}
+	})
+
+	episode := randomContent(100 * 1024)
+	h.addTorrent(h.rd.NewTorrent("Show", 64*1024, realdebridtest.File{Path: "show.s01e01.mkv", Content: episode}), "tv")
+	h.addTorrent(h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: randomContent(1024)}), "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		tv, movies := h.torrents("tv"), h.torrents("movies")
+		return len(tv) == 1 && tv[0].State == "pausedUP" && len(movies) == 1 && movies[0].State == "pausedUP"
+	})
+
+	show := filepath.Join(h.conf.Downloader.SavePath, "tv", "Show")
+	if path := h.torrents("tv")[0].ContentPath; path != show {
+		t.Errorf("expected content path %s, got %s", show, path)
+	}
+
+	link, err := os.ReadFile(filepath.Join(show, "show.s01e01.strm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(strings.TrimSpace(string(link)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, episode) {
+		t.Error("the .strm link does not give the file")
+	}
+
+	target, err := os.Readlink(filepath.Join(h.conf.Downloader.SavePath, "movies", "Movie", "movie.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(mount, "movies", "Movie", "movie.mkv"); target != expected {
+		t.Errorf("expected a symlink to %s, got %s", expected, target)
+	}
+
+	if resp, _ := h.webdav("PROPFIND", "/movies/Movie/", map[string]string{"Depth": "1"}); resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("expected the symlinked torrent served over WebDAV, got %d", resp.StatusCode)
+	}
+}
+
+// qbrdtApi calls the JSON API of the web UI and decodes its answer into answer if not nil
+func (h *harness) qbrdtApi(method, path string, body io.Reader, contentType string, answer interface{}) int {
+	h.t.Helper()
+
+	req, _ := http.NewRequest(method, "http://127.0.0.1:"+h.conf.QBittorrent.Port+"/api/qbrdt"+path, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if answer != nil {
+		if err := json.NewDecoder(resp.Body).Decode(answer); err != nil {
+			h.t.Fatalf("%s %s: %s", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+type webTorrent struct {
+	Hash          string  `json:"hash"`
+	Name          string  `json:"name"`
+	Category      string  `json:"category"`
+	State         string  `json:"state"`
+	LocalProgress float64 `json:"local_progress"`
+	Error         string  `json:"error"`
+	Files         []struct {
+		Name     string  `json:"name"`
+		Progress float64 `json:"progress"`
+	} `json:"files"`
+}
+
+func (h *harness) webTorrents() []webTorrent {
+	h.t.Helper()
+
+	var torrents []webTorrent
+	if status := h.qbrdtApi(http.MethodGet, "/torrents", nil, "", &torrents); status != http.StatusOK {
+		h.t.Fatalf("list torrents: HTTP %d", status)
+	}
+	return torrents
+}
+
+func (h *harness) webAdd(torrentFile []byte, category string) string {
+	h.t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("torrents", "test.torrent")
+	part.Write(torrentFile)
+	form.WriteField("category", category)
+	form.Close()
+
+	var result struct {
+		Added  []string `json:"added"`
+		Errors []string `json:"errors"`
+	}
+	if status := h.qbrdtApi(http.MethodPost, "/torrents", &body, form.FormDataContentType(), &result); status != http.StatusOK || len(result.Added) != 1 {
+		h.t.Fatalf("add torrent: HTTP %d %v", status, result.Errors)
+	}
+	return result.Added[0]
+}
+
+func TestWebApiManagesTorrents(t *testing.T) {
+	h := start(t)
+
+	if status, page := h.get("/ui/"); status != http.StatusOK || !strings.Contains(page, "<title>qbrdt</title>") {
+		t.Fatalf("expected the web UI, got %d", status)
+	}
+
+	content := randomContent(300 * 1024)
+	hash := h.webAdd(h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: content}), "movies")
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.webTorrents()
+		return len(torrents) == 1 && torrents[0].State == "pausedUP"
+	})
+
+	torrent := h.webTorrents()[0]
+	if torrent.Hash != hash || torrent.LocalProgress != 1 || len(torrent.Files) != 1 || torrent.Files[0].Name != "movie.mkv" || torrent.Files[0].Progress != 1 {
+		t.Errorf("unexpected torrent %+v", torrent)
+	}
+
+	if status := h.qbrdtApi(http.MethodPost, "/categories", strings.NewReader(`{"name":"archive","priority":1}`), "application/json", nil); status != http.StatusNoContent {
+		t.Fatalf("create category: HTTP %d", status)
+	}
+	if status := h.qbrdtApi(http.MethodPut, "/torrents/"+hash+"/category", strings.NewReader(`{"category":"archive"}`), "application/json", nil); status != http.StatusNoContent {
+		t.Fatalf("change category: HTTP %d", status)
+	}
+
+	moved := filepath.Join(h.conf.Downloader.SavePath, "archive", "Movie", "movie.mkv")
+	if data, err := os.ReadFile(moved); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("expected the file moved to %s: %v", moved, err)
+	}
+	if status := h.qbrdtApi(http.MethodDelete, "/categories/archive", nil, "", nil); status != http.StatusConflict {
+		t.Errorf("expected a used category to be kept, got %d", status)
+	}
+
+	var settings map[string]interface{}
+	if status := h.qbrdtApi(http.MethodPut, "/settings", strings.NewReader(`{"speed_limit":100,"max_downloads":2}`), "application/json", &settings); status != http.StatusOK {
+		t.Fatalf("save settings: HTTP %d", status)
+	}
+	if settings["speed_limit"] != float64(100) || settings["max_downloads"] != float64(2) || settings["queue_paused"] != false {
+		t.Errorf("unexpected settings %v", settings)
+	}
+
+	if status := h.qbrdtApi(http.MethodDelete, "/torrents/"+hash+"?delete_files=true", nil, "", nil); status != http.StatusNoContent {
+		t.Fatalf("delete torrent: HTTP %d", status)
+	}
+	if _, err := os.Stat(filepath.Dir(moved)); !os.IsNotExist(err) {
+		t.Errorf("expected the files deleted, got %v", err)
+	}
+	if torrents := h.webTorrents(); len(torrents) != 0 {
+		t.Errorf("expected no torrent left, got %+v", torrents)
+	}
+}
+
+func TestWebApiRetriesFailedTorrent(t *testing.T) {
+	h := start(t, func(conf *config.QBRDTConfig) {
+		conf.Stall.RealDebrid = 2
+	})
+	// stuck at 12%
+	h.rd.Progression = []realdebridtest.Step{{Status: "downloading", Progress: 12}}
+
+	hash := h.webAdd(h.rd.NewTorrent("stuck.bin", 64*1024, realdebridtest.File{Path: "stuck.bin", Content: randomContent(4096)}), "tv")
+
+	h.eventually(15*time.Second, func() bool {
+		torrents := h.webTorrents()
+		return len(torrents) == 1 && torrents[0].State == "error" && torrents[0].Error != ""
+	})
+
+	h.rd.Progression = nil
+	if status := h.qbrdtApi(http.MethodPost, "/torrents/"+hash+"/retry", nil, "", nil); status != http.StatusNoContent {
+		t.Fatalf("retry: HTTP %d", status)
+	}
+
+	h.eventually(30*time.Second, func() bool {
+		torrents := h.webTorrents()
+		return len(torrents) == 1 && torrents[0].State == "pausedUP" && torrents[0].Error == ""
+	})
+
+	if status := h.qbrdtApi(http.MethodPost, "/torrents/"+hash+"/retry", nil, "", nil); status != http.StatusConflict {
+		t.Errorf("expected a finished torrent not to be retried, got %d", status)
+	}
+}
+
+type streamedEvent struct {
+	Type string `json:"type"`
+	Hash string `json:"hash"`
+	Data struct {
+		File           string  `json:"file"`
+		Progress       float64 `json:"progress"`
+		InternalStatus string  `json:"internal_status"`
+	} `json:"data"`
+}
+
+// events subscribes to the Server-Sent Events of qbrdt until the test ends
+func (h *harness) events() <-chan streamedEvent {
+	h.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:"+h.conf.QBittorrent.Port+"/api/qbrdt/events", nil)
+	req.SetBasicAuth(username, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		h.t.Fatalf("events: HTTP %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan streamedEvent, 100)
+	go func() {
+		defer resp.Body.Close()
+		defer close(events)
+
+		scanner := bufio.NewScanner(resp.Body)
+		var eventType string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				eventType = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var event streamedEvent
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil || event.Type != eventType {
+					h.t.Errorf("unexpected event %q: %s", eventType, line)
+					return
+				}
+				events <- event
+			}
+		}
+	}()
+	return events
+}
+
+func TestEventsStreamed(t *testing.T) {
+	h := start(t)
+
+	resp, err := http.Get("http://127.0.0.1:" + h.conf.QBittorrent.Port + "/api/qbrdt/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the events to need a login, got %d", resp.StatusCode)
+	}
+
+	events := h.events()
+
+	torrentFile := h.rd.NewTorrent("Show", 64*1024, realdebridtest.File{Path: "show.mkv", Content: randomContent(200 * 1024)})
+	h.addTorrent(torrentFile, "tv")
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := meta.InfoHash
+
+	seen := make(map[string]bool)
+	timeout := time.After(30 * time.Second)
+	for !seen["download.finished"] || !seen["torrent.changed downloaded"] {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			if event.Hash != hash {
+				t.Errorf("unexpected event %+v", event)
+				continue
+			}
+			seen[event.Type] = true
+			if event.Type == "torrent.changed" {
+				seen["torrent.changed "+event.Data.InternalStatus] = true
+			}
+			if event.Type == "download.finished" && (event.Data.File != "show.mkv" || event.Data.Progress != 1) {
+				t.Errorf("unexpected finished event %+v", event)
+			}
+		case <-timeout:
+			t.Fatalf("events missing, got %v", seen)
+		}
+	}
+
+	for _, expected := range []string{"torrent.added", "download.started"} {
+		if !seen[expected] {
+			t.Errorf("expected a %s event, got %v", expected, seen)
+		}
+	}
+}
+
+func TestEventsStreamEndedOnShutdown(t *testing.T) {
+	h := start(t)
+	events := h.events()
+
+	started := time.Now()
+	h.stop()
+
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("expected no event")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the stream to end")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("expected the shutdown not to wait for the stream, took %s", elapsed)
+	}
+}
+
+func TestClientManagesTorrents(t *testing.T) {
+	h := start(t)
+	client := qbrdtclient.NewClient("http://127.0.0.1:"+h.conf.QBittorrent.Port, username, password)
+
+	if _, err := qbrdtclient.NewClient("http://127.0.0.1:"+h.conf.QBittorrent.Port, username, "wrong").Torrents(""); err == nil {
+		t.Error("expected the client to need a login")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan qbrdtclient.Event, 100)
+	go client.Events(ctx, func(event qbrdtclient.Event) {
+		events <- event
+	})
+	// the subscription must be made before the torrent is added
+	time.Sleep(200 * time.Millisecond)
+
+	result, err := client.Add(qbrdtclient.AddRequest{
+		Torrents: []qbrdtclient.TorrentFile{{Name: "movie.torrent", Data: h.rd.NewTorrent("Movie", 64*1024, realdebridtest.File{Path: "movie.mkv", Content: randomContent(200 * 1024)})}},
+		Category: "movies",
+	})
+	if err != nil || len(result.Added) != 1 {
+		t.Fatalf("add: %v %+v", err, result)
+	}
+	hash := result.Added[0]
+
+	if result, err := client.Add(qbrdtclient.AddRequest{Urls: []string{"magnet:?xt=urn:btih:" + hash}}); err == nil || len(result.Errors) != 1 {
+		t.Errorf("expected a known torrent refused, got %v %+v", err, result)
+	}
+
+	finished := false
+	timeout := time.After(30 * time.Second)
+	for !finished {
+		select {
+		case event := <-events:
+			if event.Type != qbrdtclient.DownloadFinished {
+				continue
+			}
+			data, err := event.Download()
+			if err != nil || event.Hash != hash || data.File != "movie.mkv" {
+				t.Errorf("unexpected event %+v: %v", event, err)
+			}
+			finished = true
+		case <-timeout:
+			t.Fatal("no download.finished event")
+		}
+	}
+
+	h.eventually(10*time.Second, func() bool {
+		torrent, err := client.Torrent(hash)
+		return err == nil && torrent.State == "pausedUP" && torrent.LocalProgress == 1
+	})
+
+	if torrents, err := client.Torrents("movies"); err != nil || len(torrents) != 1 || torrents[0].Name != "Movie" || len(torrents[0].Files) != 1 {
+		t.Errorf("unexpected torrents %+v: %v", torrents, err)
+	}
+	if torrents, err := client.Torrents("tv"); err != nil || len(torrents) != 0 {
+		t.Errorf("expected no torrent in tv, got %+v: %v", torrents, err)
+	}
+	if categories, err := client.Categories(); err != nil || len(categories) != 1 || categories[0].Name != "movies" {
+		t.Errorf("unexpected categories %+v: %v", categories, err)
+	}
+
+	var apiErr *qbrdtclient.Error
+	if err := client.Retry(hash); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict {
+		t.Errorf("expected a finished torrent not to be retried, got %v", err)
+	}
+
+	if err := client.Pause(hash); err != nil {
+		t.Fatal(err)
+	}
+	if torrent, err := client.Torrent(hash); err != nil || !torrent.Paused {
+		t.Errorf("expected the torrent paused, got %+v: %v", torrent, err)
+	}
+
+	h.eventually(10*time.Second, func() bool {
+		account, err := client.Account()
+		return err == nil && account.Premium
+	})
+
+	if err := client.Delete(hash, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(h.conf.Downloader.SavePath, "movies", "Movie")); !os.IsNotExist(err) {
+		t.Errorf("expected the files deleted, got %v", err)
+	}
+	if _, err := client.Torrent(hash); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the torrent deleted, got %v", err)
+	}
+}
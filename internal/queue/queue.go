@@ -0,0 +1,254 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+)
+
+// DownloadQueue starts the downloads saved in the database, in priority order,
+// without running more than maxDownloads at the same time
+type DownloadQueue struct {
+	torrents     *database.TorrentRepository
+	downloads    *database.DownloadRepository
+	downloader   *downloader.Downloader
+	logger       logger.Interface
+	maxDownloads int
+	lock         sync.Mutex
+	// running downloads by download id
+	running map[uint]*downloader.Download
+	stopped bool
+	paused  bool
+	wake    chan struct{}
+}
+
+func NewDownloadQueue(torrents *database.TorrentRepository,
+	downloads *database.DownloadRepository,
+	d *downloader.Downloader,
+	maxDownloads int,
+	logger logger.Interface) *DownloadQueue {
+
+	if maxDownloads <= 0 {
+		maxDownloads = 1
+	}
+
+	if err := torrents.NormalizePriorities(); err != nil {
+		logger.Error("Error normalizing torrent priorities: %s", err)
+	}
+
+	return &DownloadQueue{
+		torrents:     torrents,
+		downloads:    downloads,
+		downloader:   d,
+		logger:       logger,
+		maxDownloads: maxDownloads,
+		running:      make(map[uint]*downloader.Download),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Run starts queued downloads each time the queue is woken up, it never returns
+func (q *DownloadQueue) Run() {
+	q.Wake()
+	for range q.wake {
+		q.dispatch()
+	}
+}
+
+// Wake tells the queue new downloads may be waiting
+func (q *DownloadQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Done frees the slot of a finished download
+func (q *DownloadQueue) Done(download *database.Download) {
+	q.lock.Lock()
+	delete(q.running, download.ID)
+	q.lock.Unlock()
+
+	q.Wake()
+}
+
+// Stop prevents new downloads from starting, running ones are not interrupted
+func (q *DownloadQueue) Stop() {
+	q.lock.Lock()
+	q.stopped = true
+	q.lock.Unlock()
+}
+
+// Pause prevents new downloads from starting until Resume, running ones are not interrupted
+func (q *DownloadQueue) Pause() {
+	q.lock.Lock()
+	q.paused = true
+	q.lock.Unlock()
+}
+
+func (q *DownloadQueue) Resume() {
+	q.lock.Lock()
+	q.paused = false
+	q.lock.Unlock()
+
+	q.Wake()
+}
+
+func (q *DownloadQueue) Paused() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.paused
+}
+
+// SetMaxDownloads changes how many downloads run at the same time, running ones are not interrupted
+func (q *DownloadQueue) SetMaxDownloads(maxDownloads int) {
+	q.lock.Lock()
+	q.maxDownloads = max(maxDownloads, 1)
+	q.lock.Unlock()
+
+	q.Wake()
+}
+
+func (q *DownloadQueue) MaxDownloads() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.maxDownloads
+}
+
+// Progress returns the progress of the download of id if it is running
+func (q *DownloadQueue) Progress(id uint) (downloader.Progress, bool) {
+	q.lock.Lock()
+	download, ok := q.running[id]
+	q.lock.Unlock()
+
+	if !ok {
+		return downloader.Progress{}, false
+	}
+	return download.Status(), true
+}
+
+func (q *DownloadQueue) dispatch() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for !q.stopped && !q.paused && len(q.running) < q.maxDownloads {
+		running := make([]uint, 0, len(q.running))
+		for id := range q.running {
+			running = append(running, id)
+		}
+
+		next, err := q.downloads.FindNextQueued(running)
+		if err != nil {
+			// nothing left to download
+			return
+		}
+
+		download := &downloader.Download{
+			Url:      next.Url,
+			FileName: next.FileName,
+			FileSize: next.FileSize,
+			SavePath: next.SavePath,
+			Ranges:   downloadRanges(next.Ranges),
+			Segments: downloadSegments(next.Segments),
+			Object:   next,
+		}
+		q.running[next.ID] = download
+		q.logger.Info("Start downloading %s", next.FileName)
+
+		q.downloader.Start(download)
+	}
+}
+
+// TopPriority moves the torrents to the top of the queue
+func (q *DownloadQueue) TopPriority(ids []uint) error {
+	return q.reorder(func(queue []database.Torrent, selected map[uint]bool) []database.Torrent {
+		var top, rest []database.Torrent
+		for _, t := range queue {
+			if selected[t.ID] {
+				top = append(top, t)
+			} else {
+				rest = append(rest, t)
+			}
+		}
+		return append(top, rest...)
+	}, ids)
+}
+
+// BottomPriority moves the torrents to the bottom of the queue
+func (q *DownloadQueue) BottomPriority(ids []uint) error {
+	return q.reorder(func(queue []database.Torrent, selected map[uint]bool) []database.Torrent {
+		var bottom, rest []database.Torrent
+		for _, t := range queue {
+			if selected[t.ID] {
+				bottom = append(bottom, t)
+			} else {
+				rest = append(rest, t)
+			}
+		}
+		return append(rest, bottom...)
+	}, ids)
+}
+
+// IncreasePriority moves each torrent one position up in the queue
+func (q *DownloadQueue) IncreasePriority(ids []uint) error {
+	return q.reorder(func(queue []database.Torrent, selected map[uint]bool) []database.Torrent {
+		for i := 1; i < len(queue); i++ {
+			if selected[queue[i].ID] && !selected[queue[i-1].ID] {
+				queue[i], queue[i-1] = queue[i-1], queue[i]
+			}
+		}
+		return queue
+	}, ids)
+}
+
+// DecreasePriority moves each torrent one position down in the queue
+func (q *DownloadQueue) DecreasePriority(ids []uint) error {
+	return q.reorder(func(queue []database.Torrent, selected map[uint]bool) []database.Torrent {
+		for i := len(queue) - 2; i >= 0; i-- {
+			if selected[queue[i].ID] && !selected[queue[i+1].ID] {
+				queue[i], queue[i+1] = queue[i+1], queue[i]
+			}
+		}
+		return queue
+	}, ids)
+}
+
+func (q *DownloadQueue) reorder(move func(queue []database.Torrent, selected map[uint]bool) []database.Torrent, ids []uint) error {
+	queue, err := q.torrents.FindQueued()
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	priorities := make(map[uint]int, len(queue))
+	for i, t := range move(queue, selected) {
+		priorities[t.ID] = i + 1
+	}
+
+	return q.torrents.SetPriorities(priorities)
+}
+
+// downloadRanges and downloadSegments give the state saved in the database to the downloader
+func downloadRanges(ranges []database.Range) []downloader.Range {
+	var result []downloader.Range
+	for _, r := range ranges {
+		result = append(result, downloader.Range(r))
+	}
+	return result
+}
+
+func downloadSegments(segments []database.Segment) []downloader.Segment {
+	var result []downloader.Segment
+	for _, s := range segments {
+		result = append(result, downloader.Segment(s))
+	}
+	return result
+}
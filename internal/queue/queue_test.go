@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+)
+
+// newQueue returns a queue holding torrents a to e in this order and their ids by name
+func newQueue(t *testing.T) (*DownloadQueue, *database.TorrentRepository, map[string]uint) {
+	t.Setenv("QBRDT_DB", filepath.Join(t.TempDir(), "qbrdt.db"))
+
+	l := logger.New("error")
+	db := database.NewDatabase(l)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	// migrates the categories the torrents refer to
+	database.NewCategoryRepository(db)
+	torrents := database.NewTorrentRepository(db)
+	ids := make(map[string]uint)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		torrent := &database.Torrent{RDName: name}
+		if err := torrents.CreateQueued(torrent); err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = torrent.ID
+	}
+
+	q := NewDownloadQueue(torrents, database.NewDownloadRepository(db), downloader.NewDownloader(downloader.Config{}, l), 1, l)
+	return q, torrents, ids
+}
+
+func TestReorder(t *testing.T) {
+	top := (*DownloadQueue).TopPriority
+	bottom := (*DownloadQueue).BottomPriority
+	increase := (*DownloadQueue).IncreasePriority
+	decrease := (*DownloadQueue).DecreasePriority
+
+	tests := []struct {
+		name     string
+		move     func(*DownloadQueue, []uint) error
+		selected []string
+		want     []string
+	}{
+		{"top", top, []string{"d"}, []string{"d", "a", "b", "c", "e"}},
+		{"top keeps the order of the torrents", top, []string{"e", "b"}, []string{"b", "e", "a", "c", "d"}},
+		{"top of the first", top, []string{"a"}, []string{"a", "b", "c", "d", "e"}},
+		{"bottom", bottom, []string{"b"}, []string{"a", "c", "d", "e", "b"}},
+		{"bottom keeps the order of the torrents", bottom, []string{"d", "a"}, []string{"b", "c", "e", "a", "d"}},
+		{"bottom of the last", bottom, []string{"e"}, []string{"a", "b", "c", "d", "e"}},
+		{"increase", increase, []string{"c"}, []string{"a", "c", "b", "d", "e"}},
+		{"increase the first", increase, []string{"a"}, []string{"a", "b", "c", "d", "e"}},
+		{"increase next to the first", increase, []string{"a", "b", "d"}, []string{"a", "b", "d", "c", "e"}},
+		{"increase adjacent torrents", increase, []string{"c", "d"}, []string{"a", "c", "d", "b", "e"}},
+		{"increase apart torrents", increase, []string{"b", "e"}, []string{"b", "a", "c", "e", "d"}},
+		{"decrease", decrease, []string{"c"}, []string{"a", "b", "d", "c", "e"}},
+		{"decrease the last", decrease, []string{"e"}, []string{"a", "b", "c", "d", "e"}},
+		{"decrease next to the last", decrease, []string{"b", "d", "e"}, []string{"a", "c", "b", "d", "e"}},
+		{"decrease adjacent torrents", decrease, []string{"b", "c"}, []string{"a", "d", "b", "c", "e"}},
+		{"decrease apart torrents", decrease, []string{"a", "d"}, []string{"b", "a", "c", "e", "d"}},
+		{"no torrent", top, nil, []string{"a", "b", "c", "d", "e"}},
+		{"unknown torrent", increase, []string{"unknown"}, []string{"a", "b", "c", "d", "e"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, torrents, ids := newQueue(t)
+
+			var selected []uint
+			for _, name := range tt.selected {
+				id, ok := ids[name]
+				if !ok {
+					id = 1000
+				}
+				selected = append(selected, id)
+			}
+
+			if err := tt.move(q, selected); err != nil {
+				t.Fatal(err)
+			}
+
+			queued, err := torrents.FindQueued()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, torrent := range queued {
+				if torrent.Priority != i+1 {
+					t.Errorf("expected %s at position %d, got %d", torrent.RDName, i+1, torrent.Priority)
+				}
+				got = append(got, torrent.RDName)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected the queue %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package verifier
+
+import (
+	"crypto/sha1"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+)
+
+// Number of times a download is fetched again before the torrent is reported in error
+const maxRepairs = 3
+
+type Verifier struct {
+	torrents  *database.TorrentRepository
+	downloads *database.DownloadRepository
+	queue     *queue.DownloadQueue
+	logger    logger.Interface
+
+	lock    sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+func NewVerifier(torrents *database.TorrentRepository,
+	downloads *database.DownloadRepository,
+	queue *queue.DownloadQueue,
+	logger logger.Interface) *Verifier {
+	return &Verifier{
+		torrents:  torrents,
+		downloads: downloads,
+		queue:     queue,
+		logger:    logger,
+	}
+}
+
+// Start verifies the torrent in the background, nothing is started once Stop was called
+func (v *Verifier) Start(torrentId uint) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.stopped {
+		return
+	}
+
+	v.running.Add(1)
+	go func() {
+		defer v.running.Done()
+		v.Verify(torrentId)
+	}()
+}
+
+// Stop refuses new verifications and waits for the ones started in the background
+func (v *Verifier) Stop() {
+	v.lock.Lock()
+	v.stopped = true
+	v.lock.Unlock()
+
+	v.running.Wait()
+}
+
+// Verify checks the size of every file of the torrent and, when the .torrent file is known, the hash of every piece.
+// Damaged ranges are downloaded again. It returns true when the torrent is intact on disk.
+func (v *Verifier) Verify(torrentId uint) bool {
+	torrent, err := v.torrents.FindOne(torrentId)
+	if err != nil {
+		v.logger.Error("Error getting torrent %d to verify: %s", torrentId, err)
+		return false
+	}
+
+	downloads, err := v.downloads.FindAllByRdId(torrent.ID)
+	if err != nil {
+		v.logger.Error("Error getting downloads of torrent %d to verify: %s", torrentId, err)
+		return false
+	}
+
+	v.torrents.UpdateInternalStatus(torrent.ID, database.TorrentInternalChecking)
+	v.logger.Info("Verifying %s", torrent.RDName)
+
+	damaged := make(map[int][]database.Range)
+
+	for i, d := range downloads {
+		if r := checkSize(&d); r != nil {
+			damaged[i] = append(damaged[i], *r)
+		}
+	}
+
+	if len(torrent.TorrentFile) > 0 {
+		meta, err := metainfo.Parse(torrent.TorrentFile)
+		if err != nil {
+			v.logger.Warn("Cannot parse torrent file of %s, skipping pieces verification: %s", torrent.RDName, err)
+		} else {
+			for i, ranges := range checkPieces(meta, downloads) {
+				damaged[i] = append(damaged[i], ranges...)
+			}
+		}
+	}
+
+	// the files found intact get their repairs back for a later damage
+	for i := range downloads {
+		if _, ok := damaged[i]; !ok && downloads[i].Repairs > 0 {
+			downloads[i].Repairs = 0
+			v.downloads.Update(&downloads[i])
+		}
+	}
+
+	if len(damaged) == 0 {
+		v.logger.Info("%s verified successfully", torrent.RDName)
+		v.torrents.UpdateTorrentStatusToDownloaded(torrent.ID)
+		return true
+	}
+
+	// every damaged file is repaired, even once one of them gave up
+	failed := false
+	for i, ranges := range damaged {
+		d := downloads[i]
+		v.logger.Warn("%s is damaged, %d ranges to download again", d.FileName, len(ranges))
+		if !v.repair(&d, mergeRanges(ranges)) {
+			failed = true
+		}
+	}
+
+	if failed {
+		v.torrents.UpdateInternalStatus(torrent.ID, database.TorrentInternalError)
+	}
+	return false
+}
+
+// Retry downloads a file that failed again, or reports the torrent in error once it failed too many times
+func (v *Verifier) Retry(download *database.Download) {
+	v.repair(download, nil)
+}
+
+func (v *Verifier) repair(download *database.Download, ranges []database.Range) bool {
+	if download.Repairs >= maxRepairs {
+		v.logger.Error("%s failed %d times, giving up", download.FileName, download.Repairs)
+		download.IsDownloaded = false
+		v.downloads.Update(download)
+		v.torrents.UpdateInternalStatus(download.TorrentId, database.TorrentInternalError)
+		return false
+	}
+
+	download.Repairs++
+	download.IsDownloaded = false
+	download.Ranges = ranges
+	v.downloads.Update(download)
+	v.torrents.UpdateInternalStatus(download.TorrentId, database.TorrentInternalDownloading)
+	v.queue.Wake()
+
+	return true
+}
+
+// checkSize returns the range missing from the file on disk, nil if it has the expected size
+func checkSize(download *database.Download) *database.Range {
+	if download.FileSize <= 0 {
+		return nil
+	}
+
+	filename := download.SavePath + string(os.PathSeparator) + download.FileName
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return &database.Range{Start: 0, End: download.FileSize - 1}
+	}
+
+	if stat.Size() > download.FileSize {
+		// the beginning may still be good, the pieces verification tells
+		os.Truncate(filename, download.FileSize)
+		return nil
+	}
+
+	if stat.Size() < download.FileSize {
+		return &database.Range{Start: stat.Size(), End: download.FileSize - 1}
+	}
+
+	return nil
+}
+
+// matchFiles maps each file of the torrent to the download holding it, by name and size.
+// Files Real-Debrid did not give us (archives, unselected files) are left out.
+func matchFiles(meta *metainfo.MetaInfo, downloads []database.Download) map[int]int {
+	matched := make(map[int]int)
+	used := make(map[int]bool)
+
+	for fi, f := range meta.Files {
+		for di, d := range downloads {
+			if !used[di] && d.FileName == f.FileName() && d.FileSize == f.Length {
+				matched[fi] = di
+				used[di] = true
+				break
+			}
+		}
+	}
+
+	return matched
+}
+
+// checkPieces hashes every piece whose files are all on disk and returns the damaged ranges per download index
+func checkPieces(meta *metainfo.MetaInfo, downloads []database.Download) map[int][]database.Range {
+	matched := matchFiles(meta, downloads)
+	damaged := make(map[int][]database.Range)
+	buffer := make([]byte, meta.PieceLength)
+
+	files := make(map[int]*os.File)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for index, expected := range meta.Pieces {
+		start, length := meta.PieceBounds(index)
+		end := start + length
+
+		hash := sha1.New()
+		readable := true
+		var spans []span
+
+		for fi, f := range meta.Files {
+			if f.Offset+f.Length <= start || f.Offset >= end || f.Length == 0 {
+				continue
+			}
+
+			di, ok := matched[fi]
+			if !ok {
+				readable = false
+				break
+			}
+
+			from := max(start, f.Offset) - f.Offset
+			to := min(end, f.Offset+f.Length) - f.Offset
+			spans = append(spans, span{download: di, from: from, to: to})
+
+			if !readSpan(files, &downloads[di], di, from, buffer[:to-from]) {
+				// unreadable part, hash will not match
+				clear(buffer[:to-from])
+			}
+			hash.Write(buffer[:to-from])
+		}
+
+		if !readable {
+			continue
+		}
+
+		if [sha1.Size]byte(hash.Sum(nil)) == expected {
+			continue
+		}
+
+		for _, s := range spans {
+			damaged[s.download] = append(damaged[s.download], database.Range{Start: s.from, End: s.to - 1})
+		}
+	}
+
+	return damaged
+}
+
+// span is the part of a piece stored in a download, [from, to) in the file
+type span struct {
+	download int
+	from     int64
+	to       int64
+}
+
+// readSpan fills buffer from the file of the download, opened once and kept in files
+func readSpan(files map[int]*os.File, download *database.Download, index int, offset int64, buffer []byte) bool {
+	file, ok := files[index]
+	if !ok {
+		var err error
+		file, err = os.Open(download.SavePath + string(os.PathSeparator) + download.FileName)
+		if err != nil {
+			return false
+		}
+		files[index] = file
+	}
+
+	_, err := file.ReadAt(buffer, offset)
+	return err == nil
+}
+
+// mergeRanges sorts the ranges and joins the ones that overlap or touch
+func mergeRanges(ranges []database.Range) []database.Range {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+
+	var merged []database.Range
+	for _, r := range ranges {
+		last := len(merged) - 1
+		if last >= 0 && r.Start <= merged[last].End+1 {
+			merged[last].End = max(merged[last].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
@@ -0,0 +1,222 @@
+package verifier
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/TOomaAh/qbrdt/internal/database"
+	"github.com/TOomaAh/qbrdt/internal/queue"
+	"github.com/TOomaAh/qbrdt/pkg/downloader"
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+)
+
+type fixture struct {
+	t         *testing.T
+	dir       string
+	torrents  *database.TorrentRepository
+	downloads *database.DownloadRepository
+	verifier  *Verifier
+}
+
+func newFixture(t *testing.T) *fixture {
+	dir := t.TempDir()
+	t.Setenv("QBRDT_DB", filepath.Join(dir, "qbrdt.db"))
+
+	l := logger.New("error")
+	db := database.NewDatabase(l)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	torrents := database.NewTorrentRepository(db)
+	downloads := database.NewDownloadRepository(db)
+	q := queue.NewDownloadQueue(torrents, downloads, downloader.NewDownloader(downloader.Config{}, l), 1, l)
+
+	return &fixture{
+		t:         t,
+		dir:       dir,
+		torrents:  torrents,
+		downloads: downloads,
+		verifier:  NewVerifier(torrents, downloads, q, l),
+	}
+}
+
+// download saves a downloaded file of torrent with written bytes on disk out of size
+func (f *fixture) download(torrent *database.Torrent, name string, size, written int64, repairs int) *database.Download {
+	f.t.Helper()
+
+	if err := os.WriteFile(filepath.Join(f.dir, name), make([]byte, written), 0644); err != nil {
+		f.t.Fatal(err)
+	}
+
+	d := &database.Download{TorrentId: torrent.ID, FileName: name, FileSize: size, SavePath: f.dir, IsDownloaded: true, Repairs: repairs}
+	if err := f.downloads.Create(d); err != nil {
+		f.t.Fatal(err)
+	}
+	return d
+}
+
+func (f *fixture) reload(torrent *database.Torrent) map[string]database.Download {
+	f.t.Helper()
+
+	downloads, err := f.downloads.FindAllByRdId(torrent.ID)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	byName := make(map[string]database.Download)
+	for _, d := range downloads {
+		byName[d.FileName] = d
+	}
+	return byName
+}
+
+func TestVerifyRepairsEveryDamagedFile(t *testing.T) {
+	f := newFixture(t)
+
+	torrent := &database.Torrent{RDName: "torrent"}
+	if err := f.torrents.Create(torrent); err != nil {
+		t.Fatal(err)
+	}
+	// intact after two repairs, damaged once, damaged too many times
+	f.download(torrent, "intact.bin", 100, 100, 2)
+	f.download(torrent, "short.bin", 100, 60, 0)
+	f.download(torrent, "broken.bin", 100, 10, maxRepairs)
+
+	if f.verifier.Verify(torrent.ID) {
+		t.Fatal("expected the torrent to be damaged")
+	}
+
+	downloads := f.reload(torrent)
+	if d := downloads["intact.bin"]; d.Repairs != 0 || !d.IsDownloaded {
+		t.Errorf("expected the intact file to get its repairs back, got %+v", d)
+	}
+	short := downloads["short.bin"]
+	if short.Repairs != 1 || short.IsDownloaded || len(short.Ranges) != 1 || short.Ranges[0] != (database.Range{Start: 60, End: 99}) {
+		t.Errorf("expected the end of the short file to be downloaded again, got %+v", short)
+	}
+	if d := downloads["broken.bin"]; d.Repairs != maxRepairs || d.IsDownloaded {
+		t.Errorf("expected the broken file to be given up, got %+v", d)
+	}
+
+	saved, err := f.torrents.FindOne(torrent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.InternalStatus != database.TorrentInternalError {
+		t.Errorf("expected the torrent to be in error, got %s", saved.InternalStatus)
+	}
+}
+
+func TestCheckPieces(t *testing.T) {
+	// pieces of 4 bytes: a.bin and the first byte of b.bin, the middle of b.bin, the last byte of b.bin and c.bin
+	content := map[string][]byte{
+		"a.bin": []byte("abc"),
+		"b.bin": []byte("defghi"),
+		"c.bin": []byte("jk"),
+	}
+	data := []byte("abcdefghijk")
+	meta := &metainfo.MetaInfo{
+		PieceLength: 4,
+		TotalLength: int64(len(data)),
+		Files: []metainfo.File{
+			{Path: []string{"cd1", "a.bin"}, Length: 3},
+			{Path: []string{"b.bin"}, Length: 6, Offset: 3},
+			{Path: []string{"c.bin"}, Length: 2, Offset: 9},
+		},
+	}
+	for i := 0; i < len(data); i += 4 {
+		meta.Pieces = append(meta.Pieces, sha1.Sum(data[i:min(i+4, len(data))]))
+	}
+
+	tests := []struct {
+		name    string
+		corrupt map[string]int
+		missing string
+		sizes   map[string]int64
+		want    map[string][]database.Range
+	}{
+		{name: "intact", want: map[string][]database.Range{}},
+		{name: "piece spanning two files", corrupt: map[string]int{"b.bin": 0}, want: map[string][]database.Range{
+			"a.bin": {{Start: 0, End: 2}},
+			"b.bin": {{Start: 0, End: 0}},
+		}},
+		{name: "piece inside one file", corrupt: map[string]int{"b.bin": 3}, want: map[string][]database.Range{
+			"b.bin": {{Start: 1, End: 4}},
+		}},
+		{name: "every piece", corrupt: map[string]int{"a.bin": 1, "b.bin": 2, "c.bin": 1}, want: map[string][]database.Range{
+			"a.bin": {{Start: 0, End: 2}},
+			"b.bin": {{Start: 0, End: 0}, {Start: 1, End: 4}, {Start: 5, End: 5}},
+			"c.bin": {{Start: 0, End: 1}},
+		}},
+		{name: "file missing on disk", missing: "c.bin", want: map[string][]database.Range{
+			"b.bin": {{Start: 5, End: 5}},
+			"c.bin": {{Start: 0, End: 1}},
+		}},
+		{name: "unmatched file skips its pieces", corrupt: map[string]int{"b.bin": 5}, sizes: map[string]int64{"c.bin": 3}, want: map[string][]database.Range{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			var downloads []database.Download
+			for _, name := range []string{"a.bin", "b.bin", "c.bin"} {
+				size := int64(len(content[name]))
+				if s, ok := tt.sizes[name]; ok {
+					size = s
+				}
+				downloads = append(downloads, database.Download{FileName: name, FileSize: size, SavePath: dir})
+
+				if name == tt.missing {
+					continue
+				}
+				written := bytes.Clone(content[name])
+				if i, ok := tt.corrupt[name]; ok {
+					written[i] ^= 0xff
+				}
+				if err := os.WriteFile(filepath.Join(dir, name), written, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := make(map[string][]database.Range)
+			for i, ranges := range checkPieces(meta, downloads) {
+				got[downloads[i].FileName] = ranges
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected the damaged ranges %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMergeRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []database.Range
+		want   []database.Range
+	}{
+		{"empty", nil, nil},
+		{"single", []database.Range{{Start: 4, End: 7}}, []database.Range{{Start: 4, End: 7}}},
+		{"unsorted apart", []database.Range{{Start: 10, End: 12}, {Start: 0, End: 3}}, []database.Range{{Start: 0, End: 3}, {Start: 10, End: 12}}},
+		{"touching", []database.Range{{Start: 0, End: 3}, {Start: 4, End: 7}}, []database.Range{{Start: 0, End: 7}}},
+		{"overlapping", []database.Range{{Start: 5, End: 9}, {Start: 0, End: 6}}, []database.Range{{Start: 0, End: 9}}},
+		{"contained", []database.Range{{Start: 0, End: 9}, {Start: 2, End: 3}}, []database.Range{{Start: 0, End: 9}}},
+		{"gap of one byte", []database.Range{{Start: 0, End: 3}, {Start: 5, End: 7}}, []database.Range{{Start: 0, End: 3}, {Start: 5, End: 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeRanges(tt.ranges); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
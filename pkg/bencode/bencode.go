@@ -0,0 +1,175 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrUnexpectedEnd = errors.New("bencode: unexpected end of data")
+	ErrInvalidData   = errors.New("bencode: invalid data")
+)
+
+// Decode parses bencoded data. Integers are returned as int64, strings as string,
+// lists as []interface{} and dictionaries as map[string]interface{}.
+func Decode(data []byte) (interface{}, error) {
+	d := &decoder{data: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%w: trailing data at offset %d", ErrInvalidData, d.pos)
+	}
+
+	return v, nil
+}
+
+// Raw returns the bencoded value of key in the top level dictionary of data, as is
+func Raw(data []byte, key string) ([]byte, error) {
+	d := &decoder{data: data}
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("%w: not a dictionary", ErrInvalidData)
+	}
+
+	// skip 'd'
+	d.pos++
+	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+		k, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		start := d.pos
+		if _, err := d.value(); err != nil {
+			return nil, err
+		}
+
+		if k == key {
+			return d.data[start:d.pos], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: key %q not found", ErrInvalidData, key)
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) value() (interface{}, error) {
+	if d.pos >= len(d.data) {
+		return nil, ErrUnexpectedEnd
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		return d.integer()
+	case c == 'l':
+		return d.list()
+	case c == 'd':
+		return d.dict()
+	case c >= '0' && c <= '9':
+		return d.string()
+	default:
+		return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrInvalidData, c, d.pos)
+	}
+}
+
+func (d *decoder) integer() (int64, error) {
+	// skip 'i'
+	d.pos++
+	end := d.indexFrom('e')
+	if end < 0 {
+		return 0, ErrUnexpectedEnd
+	}
+
+	n, err := strconv.ParseInt(string(d.data[d.pos:end]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad integer at offset %d", ErrInvalidData, d.pos)
+	}
+
+	d.pos = end + 1
+	return n, nil
+}
+
+func (d *decoder) string() (string, error) {
+	colon := d.indexFrom(':')
+	if colon < 0 {
+		return "", ErrUnexpectedEnd
+	}
+
+	length, err := strconv.Atoi(string(d.data[d.pos:colon]))
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("%w: bad string length at offset %d", ErrInvalidData, d.pos)
+	}
+
+	start := colon + 1
+	if start+length > len(d.data) {
+		return "", ErrUnexpectedEnd
+	}
+
+	d.pos = start + length
+	return string(d.data[start:d.pos]), nil
+}
+
+func (d *decoder) list() ([]interface{}, error) {
+	// skip 'l'
+	d.pos++
+	list := []interface{}{}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, ErrUnexpectedEnd
+		}
+
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return list, nil
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+func (d *decoder) dict() (map[string]interface{}, error) {
+	// skip 'd'
+	d.pos++
+	dict := map[string]interface{}{}
+	for {
+		if d.pos >= len(d.data) {
+			return nil, ErrUnexpectedEnd
+		}
+
+		if d.data[d.pos] == 'e' {
+			d.pos++
+			return dict, nil
+		}
+
+		key, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		dict[key] = v
+	}
+}
+
+func (d *decoder) indexFrom(c byte) int {
+	for i := d.pos; i < len(d.data); i++ {
+		if d.data[i] == c {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,127 @@
+package bencode
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want interface{}
+	}{
+		{"integer", "i42e", int64(42)},
+		{"negative integer", "i-7e", int64(-7)},
+		{"zero", "i0e", int64(0)},
+		{"string", "4:spam", "spam"},
+		{"empty string", "0:", ""},
+		{"binary string", "3:\x00\xff:", "\x00\xff:"},
+		{"list", "l4:spami42ee", []interface{}{"spam", int64(42)}},
+		{"empty list", "le", []interface{}{}},
+		{"dictionary", "d3:bar4:spam3:fooi42ee", map[string]interface{}{"bar": "spam", "foo": int64(42)}},
+		{"empty dictionary", "de", map[string]interface{}{}},
+		{"nested", "d4:listld1:ai1eeee", map[string]interface{}{"list": []interface{}{map[string]interface{}{"a": int64(1)}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"empty", "", ErrUnexpectedEnd},
+		{"unknown type", "x", ErrInvalidData},
+		{"unterminated integer", "i42", ErrUnexpectedEnd},
+		{"empty integer", "ie", ErrInvalidData},
+		{"bad integer", "i4x2e", ErrInvalidData},
+		{"string without colon", "4spam", ErrUnexpectedEnd},
+		{"short string", "10:spam", ErrUnexpectedEnd},
+		{"negative string length", "-1:a", ErrInvalidData},
+		{"unterminated list", "li1e", ErrUnexpectedEnd},
+		{"unterminated dictionary", "d1:ai1e", ErrUnexpectedEnd},
+		{"dictionary without value", "d1:ae", ErrInvalidData},
+		{"integer key", "di1e1:ae", ErrInvalidData},
+		{"trailing data", "i1ei2e", ErrInvalidData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %#v and error %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data := []byte("d8:announce3:url4:infod4:name1:a6:lengthi3ee7:comment2:hie")
+
+	tests := []struct {
+		name string
+		data []byte
+		key  string
+		want string
+		err  error
+	}{
+		{"dictionary value", data, "info", "d4:name1:a6:lengthi3ee", nil},
+		{"string value", data, "comment", "2:hi", nil},
+		{"missing key", data, "pieces", "", ErrInvalidData},
+		{"not a dictionary", []byte("l4:infoe"), "info", "", ErrInvalidData},
+		{"truncated", data[:20], "info", "", ErrUnexpectedEnd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Raw(tt.data, tt.key)
+			if !errors.Is(err, tt.err) || string(got) != tt.want {
+				t.Errorf("got %q and error %v, want %q and %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"integer", 42, "i42e"},
+		{"string", "spam", "4:spam"},
+		{"bytes", []byte{0, 1}, "2:\x00\x01"},
+		{"strings", []string{"a", "bc"}, "l1:a2:bce"},
+		{"sorted keys", map[string]interface{}{"b": 1, "a": "x", "c": []interface{}{}}, "d1:a1:x1:bi1e1:clee"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := Encode(3.14); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("expected a float to be refused, got %v", err)
+	}
+}
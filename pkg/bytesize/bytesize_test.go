@@ -0,0 +1,24 @@
+package bytesize
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{5 << 20, "5.0 MB"},
+		{3 << 30, "3.0 GB"},
+		{2048 << 40, "2048.0 TB"},
+	}
+
+	for _, tt := range tests {
+		if got := Format(tt.bytes); got != tt.want {
+			t.Errorf("%d bytes: got %q, want %q", tt.bytes, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package downloader
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
 )
@@ -25,8 +26,8 @@ func (c *DownloaderClient) DoRequest(req *http.Request) (*http.Response, error)
 	return resp, nil
 }
 
-func (c *DownloaderClient) NewRequest(method, url string, headers map[string]string, body []byte) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, nil)
+func (c *DownloaderClient) NewRequest(ctx context.Context, method, url string, headers map[string]string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +43,8 @@ func (c *DownloaderClient) NewRequest(method, url string, headers map[string]str
 	return req, nil
 }
 
-func (c *DownloaderClient) Do(method string, url string, headers map[string]string) (*http.Response, error) {
-	req, err := c.NewRequest(method, url, headers, nil)
+func (c *DownloaderClient) Do(ctx context.Context, method string, url string, headers map[string]string) (*http.Response, error) {
+	req, err := c.NewRequest(ctx, method, url, headers, nil)
 	if err != nil {
 		return nil, err
 	}
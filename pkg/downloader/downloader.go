@@ -1,21 +1,62 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/TOomaAh/qbrdt/pkg/logger"
 )
 
+const (
+	defaultMinChunkSize = 4 * 1024 * 1024
+	defaultStallTimeout = 2 * time.Minute
+)
+
+var (
+	ErrSizeMismatch = errors.New("downloaded file size does not match")
+	ErrStopped      = errors.New("downloader stopped")
+	// no data was received for the stall timeout, the download can be resumed
+	ErrStalled = errors.New("download stalled")
+)
+
+type Config struct {
+	// Maximum number of ranges a single file is split into
+	MaxChunks int
+	// Smallest range (bytes) worth opening a new connection for
+	MinChunkSize int64
+	// Maximum simultaneous connections to the same host, 0 for unlimited
+	MaxConnectionsPerHost int
+	// Speed limit per connection in KB/s, 0 for unlimited
+	SpeedLimit int
+	// A connection receiving nothing for this long is dropped, 2 minutes if 0, never if negative
+	StallTimeout time.Duration
+}
+
 type Downloader struct {
 	chunk        int
-	speedLimit   int
-	downloadLock chan struct{}
+	minChunkSize int64
+	perHost      int
+	// KB/s per connection, changed while downloading by SetSpeedLimit
+	speedLimit   atomic.Int64
+	stallTimeout time.Duration
+	hosts        map[string]chan struct{}
+	hostsLock    sync.Mutex
+	client       *DownloaderClient
 	logger       logger.Interface
+	ctx          context.Context
+	stop         context.CancelFunc
+	running      sync.WaitGroup
 	OnStart      func(download *Download)
 	OnUpdate     func(download *Download)
 	OnFinish     func(download *Download)
@@ -37,39 +78,168 @@ type Download struct {
 	SavePath   string
 	Progress   int
 	Downloaded int64
+	Speed      float64
 	Remaining  time.Duration
-	Object     interface{}
-	lock       sync.Mutex
+	// When set, only these ranges are downloaded again into the existing file
+	Ranges []Range
+	// State of an interrupted download, set when the downloader is stopped and used to resume it
+	Segments []Segment
+	// Error of the last attempt, nil if the file was downloaded and verified
+	Err    error
+	Object interface{}
+	lock   sync.Mutex
+}
+
+// Range is an inclusive byte range of a file
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// Segment is the saved state of a range of an interrupted download
+type Segment struct {
+	Index   int   `json:"index"`
+	Start   int64 `json:"start"`
+	End     int64 `json:"end"`
+	Written int64 `json:"written"`
+}
+
+// probeResult is what the server told us about a file before downloading it
+type probeResult struct {
+	size         int64
+	acceptRanges bool
+}
+
+// segment is a byte range of a file downloaded by a single connection into its own part file
+type segment struct {
+	index   int
+	start   int64
+	end     int64 // inclusive, -1 when the size is unknown
+	written int64
 }
 
-func NewDownloader(chunk, speedLimit, maxDownlaods int, logger logger.Interface) *Downloader {
-	logger.Info("Initialisation of downloader with %d chunks, speed limit %d KB/s and %d simultaneous downloads", chunk, speedLimit, maxDownlaods)
-	return &Downloader{
-		chunk:        chunk,
-		speedLimit:   speedLimit,
-		downloadLock: make(chan struct{}, maxDownlaods),
+func (s *segment) remaining() int64 {
+	if s.end < 0 {
+		return -1
+	}
+	return s.end - (s.start + s.written) + 1
+}
+
+// transfer holds the segments of a file being downloaded
+type transfer struct {
+	lock         sync.Mutex
+	filename     string
+	segments     []*segment
+	acceptRanges bool
+}
+
+func NewDownloader(config Config, logger logger.Interface) *Downloader {
+	if config.MaxChunks <= 0 {
+		config.MaxChunks = 1
+	}
+
+	if config.MinChunkSize <= 0 {
+		config.MinChunkSize = defaultMinChunkSize
+	}
+
+	if config.StallTimeout == 0 {
+		config.StallTimeout = defaultStallTimeout
+	}
+
+	logger.Info("Initialisation of downloader with up to %d chunks of at least %d bytes, %d connections per host and speed limit %d KB/s",
+		config.MaxChunks, config.MinChunkSize, config.MaxConnectionsPerHost, config.SpeedLimit)
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	d := &Downloader{
+		ctx:          ctx,
+		stop:         stop,
+		chunk:        config.MaxChunks,
+		minChunkSize: config.MinChunkSize,
+		perHost:      config.MaxConnectionsPerHost,
+		stallTimeout: config.StallTimeout,
+		hosts:        make(map[string]chan struct{}),
+		client:       NewHttpClient(),
 		logger:       logger,
 		OnStart:      func(download *Download) {},
 		OnUpdate:     func(download *Download) {},
 		OnFinish:     func(download *Download) {},
 	}
+	d.speedLimit.Store(int64(config.SpeedLimit))
+
+	return d
+}
+
+// SetSpeedLimit changes the speed limit per connection in KB/s, 0 for unlimited.
+// Running downloads use it from their next read.
+func (d *Downloader) SetSpeedLimit(limit int) {
+	d.speedLimit.Store(int64(max(limit, 0)))
+}
+
+func (d *Downloader) SpeedLimit() int {
+	return int(d.speedLimit.Load())
+}
+
+// Status returns the progress of download, it can be called while it runs
+func (download *Download) Status() Progress {
+	download.lock.Lock()
+	defer download.lock.Unlock()
+
+	progress := Progress{
+		Downloaded: download.Downloaded,
+		Total:      download.FileSize,
+		Speed:      download.Speed,
+		Remaining:  download.Remaining,
+	}
+	if download.FileSize > 0 {
+		progress.Percent = float64(download.Downloaded) / float64(download.FileSize)
+	}
+	return progress
 }
 
+// AddDownload starts the download right away and returns once it is finished,
+// the caller decides how many downloads run at the same time
 func (d *Downloader) AddDownload(download *Download) {
-	progressChan := make(chan Progress)
-	download.lock = sync.Mutex{}
+	d.running.Add(1)
+	d.run(download)
+}
+
+// Start is AddDownload in the background, Stop waits for the download once Start returned
+func (d *Downloader) Start(download *Download) {
+	d.running.Add(1)
+	go d.run(download)
+}
+
+// run downloads and reports the progress, running must have been incremented for the download
+func (d *Downloader) run(download *Download) {
+	progressChan := make(chan int64)
+
+	// bytes already on disk when resuming
+	download.lock.Lock()
+	for _, s := range download.Segments {
+		download.Downloaded += s.Written
+	}
+	resumed := download.Downloaded
+	download.lock.Unlock()
 
 	// Lancer le téléchargement dans une goroutine
 	go func() {
-		// Verrouiller le téléchargement
-		d.downloadLock <- struct{}{}
-		defer func() {
-			// Déverrouiller le téléchargement
-			<-d.downloadLock
-		}()
-		err := d.downloadFile(download, progressChan)
-		if err != nil {
-			fmt.Printf("Erreur lors du téléchargement : %v\n", err)
+		defer d.running.Done()
+
+		d.OnStart(download)
+		var err error
+		if d.ctx.Err() != nil {
+			err = ErrStopped
+		} else if len(download.Ranges) > 0 {
+			err = d.repairFile(download, progressChan)
+		} else {
+			err = d.downloadFile(download, progressChan)
+		}
+		download.Err = err
+		if errors.Is(err, ErrStopped) {
+			d.logger.Info("Download of %s interrupted", download.FileName)
+		} else if err != nil {
+			d.logger.Error("Error while downloading %s: %s", download.FileName, err)
 		}
 		close(progressChan)
 
@@ -77,14 +247,24 @@ func (d *Downloader) AddDownload(download *Download) {
 		d.OnFinish(download)
 
 	}()
-	for progress := range progressChan {
+
+	startTime := time.Now()
+	for n := range progressChan {
 
 		download.lock.Lock()
 
 		// update download object
-		download.Progress = int(progress.Percent) / d.chunk
-		download.Downloaded = download.Downloaded + progress.Downloaded
-		download.Remaining = progress.Remaining * time.Duration(d.chunk)
+		download.Downloaded += n
+		if download.FileSize > 0 {
+			download.Progress = int(download.Downloaded * 100 / download.FileSize)
+		}
+
+		download.Speed = float64(download.Downloaded-resumed) / time.Since(startTime).Seconds()
+		if download.Speed > 0 && download.FileSize > download.Downloaded {
+			download.Remaining = time.Duration(float64(download.FileSize-download.Downloaded)/download.Speed) * time.Second
+		} else {
+			download.Remaining = 0
+		}
 
 		download.lock.Unlock()
 
@@ -92,23 +272,199 @@ func (d *Downloader) AddDownload(download *Download) {
 	}
 }
 
+// Stop interrupts the running downloads and waits for their OnFinish callbacks.
+// Interrupted downloads end with ErrStopped and their Segments set to resume them.
+func (d *Downloader) Stop() {
+	d.stop()
+	d.running.Wait()
+}
+
+// probe asks the server for the first byte of the file to learn its real size and whether ranges are honoured
+func (d *Downloader) probe(url string) (*probeResult, error) {
+	ctx, cancel := d.ctx, context.CancelFunc(func() {})
+	if d.stallTimeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(d.ctx, d.stallTimeout, ErrStalled)
+	}
+	defer cancel()
+
+	resp, err := d.client.Do(ctx, http.MethodGet, url, map[string]string{"Range": "bytes=0-0"})
+	if err != nil {
+		if errors.Is(context.Cause(ctx), ErrStalled) {
+			return nil, fmt.Errorf("%w: no answer to the probe", ErrStalled)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		size := parseContentRangeSize(resp.Header.Get("Content-Range"))
+		return &probeResult{size: size, acceptRanges: size > 0}, nil
+	case http.StatusOK:
+		return &probeResult{size: resp.ContentLength, acceptRanges: false}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status while probing: %s", resp.Status)
+	}
+}
+
+// parseContentRangeSize returns the total size from a "bytes 0-0/1234" header, -1 if unknown
+func parseContentRangeSize(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+
+	return size
+}
+
+// chunkCount picks how many ranges a file of the given size is split into
+func (d *Downloader) chunkCount(size int64) int {
+	if size <= 0 {
+		return 1
+	}
+
+	n := size / d.minChunkSize
+	if n < 1 {
+		return 1
+	}
+
+	if n > int64(d.chunk) {
+		return d.chunk
+	}
+
+	return int(n)
+}
+
+// acquireHost blocks until a connection slot to the host of url is available and returns its release function,
+// nil if the downloader is stopped while waiting
+func (d *Downloader) acquireHost(rawUrl string) func() {
+	if d.perHost <= 0 {
+		return func() {}
+	}
+
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+
+	d.hostsLock.Lock()
+	slots, ok := d.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, d.perHost)
+		d.hosts[host] = slots
+	}
+	d.hostsLock.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+	case <-d.ctx.Done():
+		return nil
+	}
+
+	return func() {
+		<-slots
+	}
+}
+
+// steal splits the segment with the most bytes left in two and returns the second half,
+// so a connection that finished early takes over the tail of a slow one
+func (t *transfer) steal(minChunkSize int64) *segment {
+	if !t.acceptRanges {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var slowest *segment
+	for _, s := range t.segments {
+		if slowest == nil || s.remaining() > slowest.remaining() {
+			slowest = s
+		}
+	}
+
+	if slowest == nil || slowest.remaining() < 2*minChunkSize {
+		return nil
+	}
+
+	half := slowest.remaining() / 2
+	stolen := &segment{
+		index: len(t.segments),
+		start: slowest.end - half + 1,
+		end:   slowest.end,
+	}
+	slowest.end = stolen.start - 1
+	t.segments = append(t.segments, stolen)
+
+	return stolen
+}
+
+// checkpoint returns the state of the segments to resume the transfer later
+func (t *transfer) checkpoint() []Segment {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	segments := make([]Segment, len(t.segments))
+	for i, s := range t.segments {
+		segments[i] = Segment{Index: s.index, Start: s.start, End: s.end, Written: s.written}
+	}
+	return segments
+}
+
+// resume rebuilds the segments of an interrupted transfer from their saved state,
+// trusting only what is really in the part files. It returns false if the parts cannot be used.
+func (t *transfer) resume(saved []Segment, size int64) bool {
+	var total int64
+	for _, s := range saved {
+		seg := &segment{index: s.Index, start: s.Start, end: s.End, written: s.Written}
+		stat, err := os.Stat(t.partName(seg))
+		if err != nil {
+			seg.written = 0
+		} else if stat.Size() < seg.written {
+			seg.written = stat.Size()
+		} else if stat.Size() > seg.written {
+			// bytes written after the checkpoint, drop them
+			if err := os.Truncate(t.partName(seg), seg.written); err != nil {
+				return false
+			}
+		}
+		total += seg.end - seg.start + 1
+		t.segments = append(t.segments, seg)
+	}
+
+	return total == size
+}
+
+func (t *transfer) partName(s *segment) string {
+	return fmt.Sprintf("%s.part%d", t.filename, s.index)
+}
+
 // Fonction pour fusionner les chunks en un seul fichier
-func (d *Downloader) mergeChunks(filePath string) error {
-	out, err := os.Create(filePath)
+func (d *Downloader) mergeChunks(t *transfer) error {
+	out, err := os.Create(t.filename)
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	for i := 0; i < d.chunk; i++ {
-		chunkFileName := fmt.Sprintf("%s.part%d", filePath, i)
+	segments := make([]*segment, len(t.segments))
+	copy(segments, t.segments)
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start < segments[j].start
+	})
+
+	for _, s := range segments {
+		chunkFileName := t.partName(s)
 		chunkFile, err := os.Open(chunkFileName)
 		if err != nil {
 			return err
 		}
 
-		defer chunkFile.Close()
-
 		_, err = io.Copy(out, chunkFile)
 		if err != nil {
 			chunkFile.Close()
@@ -123,15 +479,120 @@ func (d *Downloader) mergeChunks(filePath string) error {
 	return nil
 }
 
+// patchChunks writes each chunk at its position in the existing file
+func (d *Downloader) patchChunks(t *transfer) error {
+	out, err := os.OpenFile(t.filename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, s := range t.segments {
+		chunkFileName := t.partName(s)
+		chunk, err := os.ReadFile(chunkFileName)
+		if err != nil {
+			return err
+		}
+
+		if _, err := out.WriteAt(chunk, s.start); err != nil {
+			return err
+		}
+
+		os.Remove(chunkFileName)
+	}
+
+	return nil
+}
+
+// repairFile downloads the ranges of the download again and writes them over the existing file
+func (d *Downloader) repairFile(download *Download, progressChan chan<- int64) error {
+	t := &transfer{
+		filename:     download.SavePath + string(os.PathSeparator) + download.FileName,
+		acceptRanges: true,
+	}
+
+	for i, r := range download.Ranges {
+		t.segments = append(t.segments, &segment{index: i, start: r.Start, end: r.End})
+	}
+
+	d.logger.Info("Repairing %d ranges of %s", len(t.segments), download.FileName)
+
+	if err := d.runSegments(download.Url, t, progressChan); err != nil {
+		return err
+	}
+
+	if err := d.patchChunks(t); err != nil {
+		return err
+	}
+
+	download.Ranges = nil
+	return d.verifySize(download, true, progressChan)
+}
+
+// verifySize checks the file on disk has the expected size and downloads the missing tail if it is truncated
+func (d *Downloader) verifySize(download *Download, acceptRanges bool, progressChan chan<- int64) error {
+	if download.FileSize <= 0 {
+		return nil
+	}
+
+	filename := download.SavePath + string(os.PathSeparator) + download.FileName
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	if stat.Size() == download.FileSize {
+		return nil
+	}
+
+	// a server without ranges cannot resume a truncated file
+	if stat.Size() > download.FileSize || !acceptRanges {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrSizeMismatch, download.FileName, stat.Size(), download.FileSize)
+	}
+
+	d.logger.Warn("%s is truncated (%d of %d bytes), downloading the missing part", download.FileName, stat.Size(), download.FileSize)
+
+	t := &transfer{filename: filename, acceptRanges: true}
+	t.segments = []*segment{{index: 0, start: stat.Size(), end: download.FileSize - 1}}
+
+	if err := d.runSegments(download.Url, t, progressChan); err != nil {
+		return err
+	}
+
+	if err := d.patchChunks(t); err != nil {
+		return err
+	}
+
+	if stat, err = os.Stat(filename); err != nil {
+		return err
+	}
+
+	if stat.Size() != download.FileSize {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrSizeMismatch, download.FileName, stat.Size(), download.FileSize)
+	}
+
+	return nil
+}
+
 // Fonction pour télécharger le fichier en plusieurs chunks
-func (d *Downloader) downloadFile(download *Download, progressChan chan<- Progress) error {
-	// Faire une requête HEAD pour obtenir la taille du fichier
+func (d *Downloader) downloadFile(download *Download, progressChan chan<- int64) error {
+	probe, err := d.probe(download.Url)
+	if err != nil {
+		if d.ctx.Err() != nil {
+			return ErrStopped
+		}
+		return err
+	}
 
-	// Obtenir la taille totale du fichier
+	// Trust the server over the size announced by the debrid service
 	totalSize := download.FileSize
-	chunkSize := totalSize / int64(d.chunk)
-
-	wg := sync.WaitGroup{}
+	if probe.size > 0 {
+		if totalSize > 0 && probe.size != totalSize {
+			d.logger.Warn("Size of %s announced as %d bytes but server reports %d bytes", download.FileName, totalSize, probe.size)
+		}
+		totalSize = probe.size
+		download.FileSize = totalSize
+	}
 
 	filename := download.SavePath + string(os.PathSeparator) + download.FileName
 
@@ -145,53 +606,141 @@ func (d *Downloader) downloadFile(download *Download, progressChan chan<- Progre
 		}
 	}
 
-	for i := 0; i < d.chunk; i++ {
-		// Calculer la plage de bytes pour ce chunk
-		start := int64(i) * chunkSize
-		end := start + chunkSize - 1
-		if i == d.chunk-1 {
-			end = totalSize - 1 // Le dernier chunk peut être plus grand
-		}
-
-		// Télécharger ce chunk
-		chunkFileName := fmt.Sprintf("%s.part%d", filename, i)
-		wg.Add(1)
-		go func() {
-			err := d.downloadChunk(download.Url, chunkFileName, start, end, progressChan, &wg)
+	t := &transfer{
+		filename:     filename,
+		acceptRanges: probe.acceptRanges,
+	}
 
-			if err != nil {
-				fmt.Printf("Erreur lors du téléchargement du chunk %d : %v\n", i, err)
+	saved := download.Segments
+	download.Segments = nil
+
+	if len(saved) > 0 && probe.acceptRanges && t.resume(saved, totalSize) {
+		d.logger.Info("Resuming %s", download.FileName)
+	} else if !probe.acceptRanges {
+		d.logger.Info("Server does not support ranges for %s, falling back to a single stream", download.FileName)
+		// without a size from the server, read until the end of the stream
+		end := int64(-1)
+		if probe.size > 0 {
+			end = probe.size - 1
+		}
+		t.segments = []*segment{{index: 0, start: 0, end: end}}
+	} else {
+		t.segments = nil
+		chunks := d.chunkCount(totalSize)
+		chunkSize := totalSize / int64(chunks)
+		for i := 0; i < chunks; i++ {
+			// Calculer la plage de bytes pour ce chunk
+			start := int64(i) * chunkSize
+			end := start + chunkSize - 1
+			if i == chunks-1 {
+				end = totalSize - 1 // Le dernier chunk peut être plus grand
 			}
-		}()
+			t.segments = append(t.segments, &segment{index: i, start: start, end: end})
+		}
+	}
 
+	d.logger.Debug("Downloading %s (%d bytes) with %d chunks", download.FileName, totalSize, len(t.segments))
+
+	if err := d.runSegments(download.Url, t, progressChan); err != nil {
+		if (errors.Is(err, ErrStopped) || errors.Is(err, ErrStalled)) && t.acceptRanges {
+			download.Segments = t.checkpoint()
+		}
+		return err
 	}
-	wg.Wait()
+
 	// Fusionner les fichiers chunks en un seul fichier final
-	if err := d.mergeChunks(filename); err != nil {
+	if err := d.mergeChunks(t); err != nil {
 		return err
 	}
 
-	return nil
+	return d.verifySize(download, t.acceptRanges, progressChan)
 }
 
-func (d *Downloader) downloadChunk(url, filePath string, start, end int64, progressChan chan<- Progress, wg *sync.WaitGroup) error {
+// runSegments downloads every segment of the transfer, one connection each
+func (d *Downloader) runSegments(url string, t *transfer, progressChan chan<- int64) error {
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
 
-	defer wg.Done()
-	// Créer une requête HTTP GET avec un en-tête Range pour télécharger une portion du fichier
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	initial := make([]*segment, len(t.segments))
+	copy(initial, t.segments)
+
+	for _, s := range initial {
+		wg.Add(1)
+		go func(s *segment) {
+			defer wg.Done()
+			// once its own range is done, a connection helps the slowest one
+			for s != nil {
+				if err := d.downloadChunk(url, t, s, progressChan); err != nil {
+					errLock.Lock()
+					if d.ctx.Err() != nil {
+						firstErr = ErrStopped
+					} else if firstErr == nil {
+						firstErr = fmt.Errorf("chunk %d: %w", s.index, err)
+					}
+					errLock.Unlock()
+					return
+				}
+				s = t.steal(d.minChunkSize)
+			}
+		}(s)
 	}
-	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	wg.Wait()
 
-	resp, err := http.DefaultClient.Do(req)
+	return firstErr
+}
+
+func (d *Downloader) downloadChunk(url string, t *transfer, s *segment, progressChan chan<- int64) error {
+	release := d.acquireHost(url)
+	if release == nil {
+		return ErrStopped
+	}
+	defer release()
+
+	headers := map[string]string{}
+	if t.acceptRanges {
+		t.lock.Lock()
+		// finished before the transfer was interrupted
+		if s.remaining() == 0 {
+			t.lock.Unlock()
+			return nil
+		}
+		headers["Range"] = fmt.Sprintf("bytes=%d-%d", s.start+s.written, s.end)
+		t.lock.Unlock()
+	}
+
+	// the watchdog drops the connection once nothing was received for the stall timeout
+	ctx, cancel := context.WithCancelCause(d.ctx)
+	defer cancel(nil)
+
+	watchdog := time.AfterFunc(d.stallTimeout, func() { cancel(ErrStalled) })
+	if d.stallTimeout <= 0 {
+		watchdog.Stop()
+	}
+	defer watchdog.Stop()
+
+	resp, err := d.client.Do(ctx, http.MethodGet, url, headers)
 	if err != nil {
-		return err
+		return stallError(ctx, err)
 	}
 	defer resp.Body.Close()
 
-	// Créer le fichier chunk
-	chunkFile, err := os.Create(filePath)
+	if t.acceptRanges && resp.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("server did not honour range request: %s", resp.Status)
+	}
+
+	if !t.acceptRanges && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	// Créer le fichier chunk, ou le compléter si on reprend un téléchargement interrompu
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if s.written > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	chunkFile, err := os.OpenFile(t.partName(s), flags, 0644)
 	if err != nil {
 		return err
 	}
@@ -200,35 +749,42 @@ func (d *Downloader) downloadChunk(url, filePath string, start, end int64, progr
 	// Variables pour le suivi du téléchargement
 	var downloadedSize int64
 	startTime := time.Now()
-	maxSpeed := int64(d.speedLimit * 1024)
 	buffer := make([]byte, 32*1024) // 32 KB
 
 	for {
 		// Lire un morceau de données
 		n, err := resp.Body.Read(buffer)
 		if n > 0 {
+			if d.stallTimeout > 0 {
+				watchdog.Reset(d.stallTimeout)
+			}
+
+			// the end of the segment may have moved if another connection stole its tail
+			t.lock.Lock()
+			remaining := s.remaining()
+			if remaining >= 0 && int64(n) > remaining {
+				n = int(remaining)
+			}
+			s.written += int64(n)
+			t.lock.Unlock()
+
 			// Écrire les données dans le fichier chunk
-			chunkFile.Write(buffer[:n])
+			if _, err := chunkFile.Write(buffer[:n]); err != nil {
+				return err
+			}
 
 			// Mettre à jour la taille téléchargée
 			downloadedSize += int64(n)
+			progressChan <- int64(n)
 
-			// Calculer la vitesse de téléchargement et le temps restant
-			elapsedTime := time.Since(startTime).Seconds()
-			speed := float64(downloadedSize) / elapsedTime
-			remaining := time.Duration(float64(end-start-downloadedSize)/speed) * time.Second
-
-			// Envoyer la progression sur le canal
-			progressChan <- Progress{
-				Downloaded: downloadedSize,
-				Total:      end - start + 1,
-				Percent:    float64(downloadedSize) / float64(end-start+1) * 100,
-				Speed:      speed,
-				Remaining:  remaining,
+			if remaining >= 0 && remaining == int64(n) {
+				return nil
 			}
 
 			// Limiter la vitesse si nécessaire, sauf si maxSpeedKBps est à 0
-			if d.speedLimit > 0 && speed > float64(maxSpeed) {
+			speed := float64(downloadedSize) / time.Since(startTime).Seconds()
+			maxSpeed := d.speedLimit.Load() * 1024
+			if maxSpeed > 0 && speed > float64(maxSpeed) {
 				sleepDuration := time.Duration(float64(n)/float64(maxSpeed)*1000) * time.Millisecond
 				time.Sleep(sleepDuration)
 			}
@@ -241,9 +797,25 @@ func (d *Downloader) downloadChunk(url, filePath string, start, end int64, progr
 
 		// Gérer les autres erreurs possibles
 		if err != nil {
-			return err
+			return stallError(ctx, err)
 		}
 	}
 
+	t.lock.Lock()
+	remaining := s.remaining()
+	t.lock.Unlock()
+
+	if remaining > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
 	return nil
 }
+
+// stallError returns ErrStalled instead of err when the watchdog of ctx dropped the connection
+func stallError(ctx context.Context, err error) error {
+	if errors.Is(context.Cause(ctx), ErrStalled) {
+		return fmt.Errorf("%w: nothing received for too long", ErrStalled)
+	}
+	return err
+}
@@ -0,0 +1,399 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/logger"
+)
+
+// server serves content like a file host, with or without ranges, and records the requests
+type server struct {
+	content []byte
+	ranges  bool
+	// sends the Content-Length of the whole file, when ranges are not honoured
+	length bool
+	// delay before answering each request
+	delay time.Duration
+	// slow reports if the range starting at start is sent slowly
+	slow func(start int64) bool
+
+	lock      sync.Mutex
+	requested []string
+	active    int
+	maxActive int
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requested = append(s.requested, r.Header.Get("Range"))
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	slow := s.slow
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		s.active--
+		s.lock.Unlock()
+	}()
+
+	time.Sleep(s.delay)
+
+	size := int64(len(s.content))
+	start, end := int64(0), size-1
+	if s.ranges && r.Header.Get("Range") != "" {
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil || start > end || end >= size {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		if s.length {
+			w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		}
+		w.WriteHeader(http.StatusOK)
+		// without Content-Length the body is chunked
+		w.(http.Flusher).Flush()
+	}
+
+	body := s.content[start : end+1]
+	if slow == nil || !slow(start) {
+		w.Write(body)
+		return
+	}
+
+	for len(body) > 0 && r.Context().Err() == nil {
+		n := min(len(body), 256)
+		w.Write(body[:n])
+		w.(http.Flusher).Flush()
+		body = body[n:]
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// requests returns the ranges requested after the probe, by start
+func (s *server) requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requests := append([]string(nil), s.requested[1:]...)
+	sort.Slice(requests, func(i, j int) bool {
+		return rangeStart(requests[i]) < rangeStart(requests[j])
+	})
+	return requests
+}
+
+func rangeStart(header string) int64 {
+	var start int64
+	fmt.Sscanf(header, "bytes=%d-", &start)
+	return start
+}
+
+func randomContent(size int) []byte {
+	content := make([]byte, size)
+	rand.Read(content)
+	return content
+}
+
+func download(t *testing.T, d *Downloader, url string, announced int64) (*Download, []byte) {
+	t.Helper()
+
+	dir := t.TempDir()
+	download := &Download{Url: url, FileName: "file.bin", FileSize: announced, SavePath: dir}
+	d.AddDownload(download)
+
+	got, _ := os.ReadFile(filepath.Join(dir, "file.bin"))
+	return download, got
+}
+
+func TestChunkCount(t *testing.T) {
+	d := NewDownloader(Config{MaxChunks: 4, MinChunkSize: 100}, logger.New("error"))
+
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{-1, 1},
+		{0, 1},
+		{99, 1},
+		{100, 1},
+		{250, 2},
+		{399, 3},
+		{400, 4},
+		{100000, 4},
+	}
+
+	for _, tt := range tests {
+		if got := d.chunkCount(tt.size); got != tt.want {
+			t.Errorf("chunkCount(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestParseContentRangeSize(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int64
+	}{
+		{"bytes 0-0/1234", 1234},
+		{"bytes 0-0/*", -1},
+		{"bytes 0-0", -1},
+		{"", -1},
+	}
+
+	for _, tt := range tests {
+		if got := parseContentRangeSize(tt.header); got != tt.want {
+			t.Errorf("parseContentRangeSize(%q) = %d, want %d", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		ranges    bool
+		length    bool
+		size      int
+		announced int64
+		// ranges requested after the probe
+		want []string
+	}{
+		{"split in chunks", true, false, 4096, 4096,
+			[]string{"bytes=0-1023", "bytes=1024-2047", "bytes=2048-3071", "bytes=3072-4095"}},
+		{"last chunk larger", true, false, 4099, 4099,
+			[]string{"bytes=0-1023", "bytes=1024-2047", "bytes=2048-3071", "bytes=3072-4098"}},
+		{"below the chunk size", true, false, 1000, 1000, []string{"bytes=0-999"}},
+		{"size of the server trusted", true, false, 2048, 5000, []string{"bytes=0-1023", "bytes=1024-2047"}},
+		{"without ranges", false, true, 4096, 4096, []string{""}},
+		{"without ranges nor size", false, false, 4096, 0, []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server{content: randomContent(tt.size), ranges: tt.ranges, length: tt.length}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			d := NewDownloader(Config{MaxChunks: 4, MinChunkSize: 1024}, logger.New("error"))
+			dl, got := download(t, d, ts.URL, tt.announced)
+			if dl.Err != nil {
+				t.Fatal(dl.Err)
+			}
+			if !bytes.Equal(got, srv.content) {
+				t.Errorf("content differs, got %d bytes of %d", len(got), tt.size)
+			}
+			if dl.FileSize != int64(tt.size) && tt.announced != 0 {
+				t.Errorf("expected the size of the server, got %d", dl.FileSize)
+			}
+
+			if srv.requested[0] != "bytes=0-0" {
+				t.Errorf("expected a probe of the first byte, got %q", srv.requested[0])
+			}
+			if requested := srv.requests(); fmt.Sprint(requested) != fmt.Sprint(tt.want) {
+				t.Errorf("expected the ranges %q, got %q", tt.want, requested)
+			}
+		})
+	}
+}
+
+func TestSteal(t *testing.T) {
+	tests := []struct {
+		name         string
+		acceptRanges bool
+		segments     []*segment
+		want         *segment
+		// end of the segment the tail was stolen from
+		end int64
+	}{
+		{"without ranges", false, []*segment{{start: 0, end: 999}}, nil, 999},
+		{"too small", true, []*segment{{start: 0, end: 198}}, nil, 198},
+		{"just large enough", true, []*segment{{start: 0, end: 199}}, &segment{index: 1, start: 100, end: 199}, 99},
+		{"half of the remaining bytes", true, []*segment{{start: 0, end: 999, written: 200}}, &segment{index: 1, start: 600, end: 999}, 599},
+		{"slowest segment", true, []*segment{
+			{start: 0, end: 999, written: 900},
+			{index: 1, start: 1000, end: 1999, written: 100},
+		}, &segment{index: 2, start: 1550, end: 1999}, 1549},
+		{"unknown size", true, []*segment{{start: 0, end: -1}}, nil, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &transfer{acceptRanges: tt.acceptRanges, segments: tt.segments}
+			slowest := tt.segments[len(tt.segments)-1]
+
+			got := tr.steal(100)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %+v to be stolen, got %+v", tt.want, got)
+			}
+			if slowest.end != tt.end {
+				t.Errorf("expected the segment to end at %d, got %d", tt.end, slowest.end)
+			}
+		})
+	}
+}
+
+func TestSlowChunkStolen(t *testing.T) {
+	srv := &server{
+		content: randomContent(4 * 16 * 1024),
+		ranges:  true,
+		// the first chunk trickles
+		slow: func(start int64) bool { return start == 0 },
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	d := NewDownloader(Config{MaxChunks: 4, MinChunkSize: 1024}, logger.New("error"))
+	dl, got := download(t, d, ts.URL, int64(len(srv.content)))
+	if dl.Err != nil {
+		t.Fatal(dl.Err)
+	}
+	if !bytes.Equal(got, srv.content) {
+		t.Fatal("content differs")
+	}
+
+	// the other connections took over the tail of the first chunk
+	var stolen bool
+	for _, r := range srv.requests() {
+		if start := rangeStart(r); start > 0 && start < 16*1024 {
+			stolen = true
+		}
+	}
+	if !stolen {
+		t.Errorf("expected a range inside the first chunk, got %q", srv.requests())
+	}
+}
+
+func TestMaxConnectionsPerHost(t *testing.T) {
+	tests := []struct {
+		perHost int
+		want    int
+	}{
+		{1, 1},
+		{2, 2},
+		{0, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.perHost), func(t *testing.T) {
+			srv := &server{content: randomContent(4096), ranges: true, delay: 50 * time.Millisecond}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			d := NewDownloader(Config{MaxChunks: 4, MinChunkSize: 1024, MaxConnectionsPerHost: tt.perHost}, logger.New("error"))
+			dl, got := download(t, d, ts.URL, 4096)
+			if dl.Err != nil {
+				t.Fatal(dl.Err)
+			}
+			if !bytes.Equal(got, srv.content) {
+				t.Fatal("content differs")
+			}
+			if srv.maxActive != tt.want {
+				t.Errorf("expected up to %d connections at the same time, got %d", tt.want, srv.maxActive)
+			}
+		})
+	}
+}
+
+func TestResume(t *testing.T) {
+	saved := []Segment{
+		{Index: 0, Start: 0, End: 99, Written: 100},
+		{Index: 1, Start: 100, End: 199, Written: 40},
+	}
+
+	tests := []struct {
+		name string
+		// bytes in the part files, -1 for no file
+		parts []int
+		size  int64
+		ok    bool
+		// written of the segments once resumed
+		written []int64
+	}{
+		{"parts as saved", []int{100, 40}, 200, true, []int64{100, 40}},
+		{"bytes written after the checkpoint dropped", []int{100, 70}, 200, true, []int64{100, 40}},
+		{"short part trusted", []int{100, 10}, 200, true, []int64{100, 10}},
+		{"missing part downloaded again", []int{100, -1}, 200, true, []int64{100, 0}},
+		{"other size", []int{100, 40}, 300, false, []int64{100, 40}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &transfer{filename: filepath.Join(t.TempDir(), "file.bin"), acceptRanges: true}
+			for i, n := range tt.parts {
+				if n >= 0 {
+					os.WriteFile(fmt.Sprintf("%s.part%d", tr.filename, i), make([]byte, n), 0644)
+				}
+			}
+
+			if ok := tr.resume(saved, tt.size); ok != tt.ok {
+				t.Fatalf("expected resume to return %v", tt.ok)
+			}
+			for i, s := range tr.segments {
+				if s.written != tt.written[i] {
+					t.Errorf("segment %d: expected %d bytes written, got %d", i, tt.written[i], s.written)
+				}
+				if stat, err := os.Stat(tr.partName(s)); err == nil && stat.Size() != s.written {
+					t.Errorf("segment %d: expected a part of %d bytes, got %d", i, s.written, stat.Size())
+				}
+			}
+		})
+	}
+}
+
+func TestStoppedDownloadResumed(t *testing.T) {
+	srv := &server{
+		content: randomContent(4 * 16 * 1024),
+		ranges:  true,
+		// the last chunk trickles until the downloader is stopped
+		slow: func(start int64) bool { return start == 3*16*1024 },
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	dl := &Download{Url: ts.URL, FileName: "file.bin", FileSize: int64(len(srv.content)), SavePath: dir}
+
+	d := NewDownloader(Config{MaxChunks: 4, MinChunkSize: 16 * 1024}, logger.New("error"))
+	d.Start(dl)
+	time.Sleep(100 * time.Millisecond)
+	d.Stop()
+
+	if !errors.Is(dl.Err, ErrStopped) || len(dl.Segments) != 4 {
+		t.Fatalf("expected the download stopped with its segments, got %v and %+v", dl.Err, dl.Segments)
+	}
+
+	srv.lock.Lock()
+	srv.slow = nil
+	requested := len(srv.requested)
+	srv.lock.Unlock()
+
+	d = NewDownloader(Config{MaxChunks: 4, MinChunkSize: 16 * 1024}, logger.New("error"))
+	d.AddDownload(dl)
+	if dl.Err != nil {
+		t.Fatal(dl.Err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "file.bin"))
+	if !bytes.Equal(got, srv.content) {
+		t.Fatal("content differs")
+	}
+
+	// the probe and the rest of the last chunk
+	resumed := srv.requested[requested:]
+	if len(resumed) != 2 || rangeStart(resumed[1]) <= 3*16*1024 {
+		t.Errorf("expected only the rest of the last chunk, got %q", resumed)
+	}
+}
@@ -0,0 +1,68 @@
+package metainfo
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidMagnet = errors.New("invalid magnet link")
+
+type Magnet struct {
+	// SHA-1 of the info dictionary, hex encoded like MetaInfo.InfoHash
+	InfoHash string
+	// Display name, empty if the link has none
+	Name     string
+	Trackers []string
+}
+
+// ParseMagnet reads the info hash of a magnet link, given in hex or base32
+func ParseMagnet(link string) (*Magnet, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !strings.EqualFold(u.Scheme, "magnet") {
+		return nil, ErrInvalidMagnet
+	}
+
+	query := u.Query()
+	for _, xt := range query["xt"] {
+		if len(xt) < 9 || !strings.EqualFold(xt[:9], "urn:btih:") {
+			continue
+		}
+
+		hash, err := decodeInfoHash(xt[9:])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Magnet{
+			InfoHash: hash,
+			Name:     query.Get("dn"),
+			Trackers: query["tr"],
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: no btih", ErrInvalidMagnet)
+}
+
+func decodeInfoHash(hash string) (string, error) {
+	var raw []byte
+	var err error
+
+	switch len(hash) {
+	case 40:
+		raw, err = hex.DecodeString(hash)
+	case 32:
+		raw, err = base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+	default:
+		err = errors.New("bad length")
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%w: bad btih %s: %s", ErrInvalidMagnet, hash, err)
+	}
+
+	return hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,129 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/TOomaAh/qbrdt/pkg/bencode"
+)
+
+var ErrInvalidTorrent = errors.New("invalid torrent file")
+
+type File struct {
+	// Path of the file inside the torrent, without the torrent name
+	Path []string
+	// Size of the file in bytes
+	Length int64
+	// Position of the first byte of the file in the torrent data
+	Offset int64
+}
+
+type MetaInfo struct {
+	// SHA-1 of the info dictionary, hex encoded
+	InfoHash    string
+	Name        string
+	PieceLength int64
+	Pieces      [][sha1.Size]byte
+	Files       []File
+	TotalLength int64
+}
+
+// Parse reads the metadata of a .torrent file
+func Parse(data []byte) (*MetaInfo, error) {
+	v, err := bencode.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidTorrent
+	}
+
+	info, ok := root["info"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: missing info dictionary", ErrInvalidTorrent)
+	}
+
+	rawInfo, err := bencode.Raw(data, "info")
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha1.Sum(rawInfo)
+
+	m := &MetaInfo{}
+	m.InfoHash = hex.EncodeToString(hash[:])
+	m.Name, _ = info["name"].(string)
+	m.PieceLength, _ = info["piece length"].(int64)
+
+	pieces, _ := info["pieces"].(string)
+	if m.PieceLength <= 0 || len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("%w: bad pieces", ErrInvalidTorrent)
+	}
+
+	for i := 0; i < len(pieces); i += sha1.Size {
+		var piece [sha1.Size]byte
+		copy(piece[:], pieces[i:i+sha1.Size])
+		m.Pieces = append(m.Pieces, piece)
+	}
+
+	if length, ok := info["length"].(int64); ok {
+		// single file torrent
+		m.Files = []File{{Path: []string{m.Name}, Length: length}}
+		m.TotalLength = length
+	} else {
+		files, ok := info["files"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: missing files", ErrInvalidTorrent)
+		}
+
+		for _, f := range files {
+			file, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: bad file entry", ErrInvalidTorrent)
+			}
+
+			length, _ := file["length"].(int64)
+			rawPath, _ := file["path"].([]interface{})
+
+			var path []string
+			for _, p := range rawPath {
+				if s, ok := p.(string); ok {
+					path = append(path, s)
+				}
+			}
+
+			if len(path) == 0 {
+				return nil, fmt.Errorf("%w: file without path", ErrInvalidTorrent)
+			}
+
+			m.Files = append(m.Files, File{Path: path, Length: length, Offset: m.TotalLength})
+			m.TotalLength += length
+		}
+	}
+
+	expected := (m.TotalLength + m.PieceLength - 1) / m.PieceLength
+	if int64(len(m.Pieces)) != expected {
+		return nil, fmt.Errorf("%w: %d pieces for %d bytes", ErrInvalidTorrent, len(m.Pieces), m.TotalLength)
+	}
+
+	return m, nil
+}
+
+// PieceBounds returns the position of the first byte and the size of a piece in the torrent data
+func (m *MetaInfo) PieceBounds(index int) (int64, int64) {
+	start := int64(index) * m.PieceLength
+	length := m.PieceLength
+	if start+length > m.TotalLength {
+		length = m.TotalLength - start
+	}
+	return start, length
+}
+
+// FileName returns the last element of the file path
+func (f File) FileName() string {
+	return f.Path[len(f.Path)-1]
+}
@@ -0,0 +1,181 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/TOomaAh/qbrdt/pkg/bencode"
+)
+
+func encode(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	data, err := bencode.Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func multiFileInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"name":         "album",
+		"piece length": 4,
+		"pieces":       strings.Repeat("x", 3*sha1.Size),
+		"files": []interface{}{
+			map[string]interface{}{"length": 3, "path": []interface{}{"cd1", "a.flac"}},
+			map[string]interface{}{"length": 6, "path": []interface{}{"b.flac"}},
+			map[string]interface{}{"length": 2, "path": []interface{}{"c.txt"}},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	single := map[string]interface{}{
+		"name":         "movie.mkv",
+		"piece length": 8,
+		"pieces":       strings.Repeat("y", 2*sha1.Size),
+		"length":       10,
+	}
+
+	tests := []struct {
+		name   string
+		info   map[string]interface{}
+		files  []File
+		total  int64
+		pieces int
+	}{
+		{"single file", single, []File{{Path: []string{"movie.mkv"}, Length: 10}}, 10, 2},
+		{"multi file", multiFileInfo(), []File{
+			{Path: []string{"cd1", "a.flac"}, Length: 3},
+			{Path: []string{"b.flac"}, Length: 6, Offset: 3},
+			{Path: []string{"c.txt"}, Length: 2, Offset: 9},
+		}, 11, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := encode(t, tt.info)
+			m, err := Parse(encode(t, map[string]interface{}{"announce": "http://tracker", "info": tt.info}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hash := sha1.Sum(info)
+			if m.InfoHash != hex.EncodeToString(hash[:]) {
+				t.Errorf("expected the info hash %x, got %s", hash, m.InfoHash)
+			}
+			if !reflect.DeepEqual(m.Files, tt.files) {
+				t.Errorf("expected the files %+v, got %+v", tt.files, m.Files)
+			}
+			if m.TotalLength != tt.total || len(m.Pieces) != tt.pieces {
+				t.Errorf("expected %d bytes in %d pieces, got %d bytes in %d pieces", tt.total, tt.pieces, m.TotalLength, len(m.Pieces))
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	withInfo := func(change func(info map[string]interface{})) interface{} {
+		info := multiFileInfo()
+		change(info)
+		return map[string]interface{}{"info": info}
+	}
+
+	tests := []struct {
+		name    string
+		torrent interface{}
+	}{
+		{"not a dictionary", []interface{}{"info"}},
+		{"no info", map[string]interface{}{"announce": "http://tracker"}},
+		{"info not a dictionary", map[string]interface{}{"info": "album"}},
+		{"truncated pieces", withInfo(func(info map[string]interface{}) { info["pieces"] = strings.Repeat("x", 2*sha1.Size+1) })},
+		{"no piece length", withInfo(func(info map[string]interface{}) { delete(info, "piece length") })},
+		{"zero piece length", withInfo(func(info map[string]interface{}) { info["piece length"] = 0 })},
+		{"no files", withInfo(func(info map[string]interface{}) { delete(info, "files") })},
+		{"bad file entry", withInfo(func(info map[string]interface{}) { info["files"] = []interface{}{"a.flac"} })},
+		{"file without path", withInfo(func(info map[string]interface{}) {
+			info["files"] = []interface{}{map[string]interface{}{"length": 11, "path": []interface{}{}}}
+		})},
+		{"missing piece", withInfo(func(info map[string]interface{}) { info["pieces"] = strings.Repeat("x", 2*sha1.Size) })},
+		{"extra piece", withInfo(func(info map[string]interface{}) { info["pieces"] = strings.Repeat("x", 4*sha1.Size) })},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m, err := Parse(encode(t, tt.torrent)); !errors.Is(err, ErrInvalidTorrent) {
+				t.Errorf("expected an invalid torrent, got %+v and error %v", m, err)
+			}
+		})
+	}
+
+	if _, err := Parse([]byte("d4:info")); !errors.Is(err, bencode.ErrUnexpectedEnd) {
+		t.Errorf("expected a truncated torrent to be refused, got %v", err)
+	}
+}
+
+func TestPieceBounds(t *testing.T) {
+	m := &MetaInfo{PieceLength: 4, TotalLength: 11}
+
+	tests := []struct {
+		index  int
+		start  int64
+		length int64
+	}{
+		{0, 0, 4},
+		{1, 4, 4},
+		{2, 8, 3},
+	}
+
+	for _, tt := range tests {
+		if start, length := m.PieceBounds(tt.index); start != tt.start || length != tt.length {
+			t.Errorf("piece %d: expected %d bytes from %d, got %d bytes from %d", tt.index, tt.length, tt.start, length, start)
+		}
+	}
+}
+
+func TestParseMagnet(t *testing.T) {
+	const hash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	const base32Hash = "YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK"
+
+	tests := []struct {
+		name string
+		link string
+		want *Magnet
+	}{
+		{"hex", "magnet:?xt=urn:btih:" + hash, &Magnet{InfoHash: hash}},
+		{"upper case hex", "magnet:?xt=urn:btih:" + strings.ToUpper(hash), &Magnet{InfoHash: hash}},
+		{"base32", "magnet:?xt=urn:btih:" + base32Hash, &Magnet{InfoHash: hash}},
+		{"lower case base32", "magnet:?xt=urn:btih:" + strings.ToLower(base32Hash), &Magnet{InfoHash: hash}},
+		{"name and trackers", " magnet:?xt=urn:btih:" + hash + "&dn=Some+Movie&tr=udp%3A%2F%2Fa&tr=udp%3A%2F%2Fb ",
+			&Magnet{InfoHash: hash, Name: "Some Movie", Trackers: []string{"udp://a", "udp://b"}}},
+		{"btih after another topic", "magnet:?xt=urn:sha1:abc&xt=urn:btih:" + hash, &Magnet{InfoHash: hash}},
+		{"no btih", "magnet:?xt=urn:sha1:abc&dn=movie", nil},
+		{"short hash", "magnet:?xt=urn:btih:" + hash[:39], nil},
+		{"bad hex", "magnet:?xt=urn:btih:" + strings.Repeat("z", 40), nil},
+		{"bad base32", "magnet:?xt=urn:btih:" + strings.Repeat("1", 32), nil},
+		{"http link", "http://example.com/?xt=urn:btih:" + hash, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMagnet(tt.link)
+			if tt.want == nil {
+				if !errors.Is(err, ErrInvalidMagnet) {
+					t.Errorf("expected an invalid magnet, got %+v and error %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
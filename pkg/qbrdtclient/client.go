@@ -0,0 +1,247 @@
+package qbrdtclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is returned when qbrdt answers with an error status code
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("qbrdt: %s (HTTP %d)", e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("qbrdt: HTTP %d", e.StatusCode)
+}
+
+// Client talks to the JSON API of a running qbrdt
+type Client struct {
+	baseUrl  string
+	username string
+	password string
+	client   *http.Client
+	// without timeout, for the events
+	stream *http.Client
+}
+
+// NewClient returns a client for the qbrdt listening on baseUrl, like http://localhost:8080,
+// logged in with the credentials of the qBittorrent API
+func NewClient(baseUrl, username, password string) *Client {
+	return &Client{
+		baseUrl:  strings.TrimSuffix(baseUrl, "/") + "/api/qbrdt",
+		username: username,
+		password: password,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		stream: &http.Client{},
+	}
+}
+
+func (c *Client) newRequest(method, path, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.SetBasicAuth(c.username, c.password)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request, v interface{}) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return readError(resp)
+	}
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func readError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusForbidden {
+		apiErr.Message = "wrong username or password"
+		return apiErr
+	}
+	json.NewDecoder(resp.Body).Decode(apiErr)
+	return apiErr
+}
+
+func (c *Client) call(method, path string, body interface{}, v interface{}) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	req, err := c.newRequest(method, path, contentType, reader)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, v)
+}
+
+// Torrents returns every torrent in queue order, then newest first, only those of category if not empty
+func (c *Client) Torrents(category string) ([]Torrent, error) {
+	path := "/torrents"
+	if category != "" {
+		path += "?category=" + url.QueryEscape(category)
+	}
+
+	var torrents []Torrent
+	if err := c.call(http.MethodGet, path, nil, &torrents); err != nil {
+		return nil, err
+	}
+	return torrents, nil
+}
+
+func (c *Client) Torrent(hash string) (*Torrent, error) {
+	var torrent Torrent
+	if err := c.call(http.MethodGet, "/torrents/"+url.PathEscape(hash), nil, &torrent); err != nil {
+		return nil, err
+	}
+	return &torrent, nil
+}
+
+// TorrentFile is a .torrent file to add
+type TorrentFile struct {
+	Name string
+	Data []byte
+}
+
+type AddRequest struct {
+	// Magnet or hoster links, the hoster links are downloaded together as one torrent
+	Urls     []string
+	Torrents []TorrentFile
+	Category string
+	// Name of the torrent of the hoster links, the first file if empty
+	Name   string
+	Paused bool
+}
+
+type AddResult struct {
+	// Hashes of the torrents added
+	Added  []string `json:"added"`
+	Errors []string `json:"errors"`
+}
+
+// Add adds torrents, the result is returned with the error when nothing was added
+func (c *Client) Add(request AddRequest) (*AddResult, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("urls", strings.Join(request.Urls, "\n"))
+	form.WriteField("category", request.Category)
+	form.WriteField("name", request.Name)
+	form.WriteField("paused", strconv.FormatBool(request.Paused))
+	for _, torrent := range request.Torrents {
+		part, err := form.CreateFormFile("torrents", torrent.Name)
+		if err != nil {
+			return nil, err
+		}
+		part.Write(torrent.Data)
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := c.newRequest(http.MethodPost, "/torrents", form.FormDataContentType(), &body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &AddResult{}
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		json.NewDecoder(resp.Body).Decode(result)
+		return result, &Error{StatusCode: resp.StatusCode, Message: strings.Join(result.Errors, ", ")}
+	case resp.StatusCode > http.StatusBadRequest:
+		return nil, readError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Delete deletes a torrent from qbrdt and Real-Debrid, with its files if deleteFiles
+func (c *Client) Delete(hash string, deleteFiles bool) error {
+	path := "/torrents/" + url.PathEscape(hash)
+	if deleteFiles {
+		path += "?delete_files=true"
+	}
+	return c.call(http.MethodDelete, path, nil, nil)
+}
+
+func (c *Client) Pause(hash string) error {
+	return c.call(http.MethodPost, "/torrents/"+url.PathEscape(hash)+"/pause", nil, nil)
+}
+
+func (c *Client) Resume(hash string) error {
+	return c.call(http.MethodPost, "/torrents/"+url.PathEscape(hash)+"/resume", nil, nil)
+}
+
+// Retry starts a failed torrent again, qbrdt answers 409 if it did not fail
+func (c *Client) Retry(hash string) error {
+	return c.call(http.MethodPost, "/torrents/"+url.PathEscape(hash)+"/retry", nil, nil)
+}
+
+// SetCategory moves a torrent to another category, with its files once downloaded
+func (c *Client) SetCategory(hash, category string) error {
+	return c.call(http.MethodPut, "/torrents/"+url.PathEscape(hash)+"/category", map[string]string{"category": category}, nil)
+}
+
+func (c *Client) Categories() ([]Category, error) {
+	var categories []Category
+	if err := c.call(http.MethodGet, "/categories", nil, &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// Account returns the Real-Debrid account, qbrdt answers 503 until it was checked once
+func (c *Client) Account() (*Account, error) {
+	var account Account
+	if err := c.call(http.MethodGet, "/account", nil, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
@@ -0,0 +1,56 @@
+package qbrdtclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Events calls handle with each event of qbrdt until ctx is done or the stream ends.
+// Events published before the call are not replayed.
+func (c *Client) Events(ctx context.Context, handle func(Event)) error {
+	req, err := c.newRequest(http.MethodGet, "/events", "", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.stream.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return readError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// the event: field repeats the type of the data, comments are keep-alives
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		handle(event)
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	if ctx.Err() == nil {
+		return errors.New("qbrdt: the event stream was closed")
+	}
+	return nil
+}
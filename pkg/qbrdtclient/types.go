@@ -0,0 +1,145 @@
+package qbrdtclient
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Torrent is a torrent of qbrdt, whatever API added it
+type Torrent struct {
+	Hash     string `json:"hash"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	// qBittorrent state
+	State          string    `json:"state"`
+	Type           string    `json:"type"`
+	AddedBy        string    `json:"added_by"`
+	AddedOn        time.Time `json:"added_on"`
+	RDStatus       string    `json:"rd_status"`
+	InternalStatus string    `json:"internal_status"`
+	Paused         bool      `json:"paused"`
+	Streamed       bool      `json:"streamed"`
+	// Position in the download queue, 0 once downloaded
+	Priority int   `json:"priority"`
+	Size     int64 `json:"size"`
+	// Download by Real-Debrid, progress from 0 to 1
+	CloudProgress float64 `json:"cloud_progress"`
+	CloudSpeed    int64   `json:"cloud_speed"`
+	Seeders       int     `json:"seeders"`
+	// Download from Real-Debrid
+	LocalProgress float64 `json:"local_progress"`
+	Downloaded    int64   `json:"downloaded"`
+	Speed         int64   `json:"speed"`
+	// Why the torrent failed, empty if it did not
+	Error string `json:"error"`
+	Files []File `json:"files"`
+}
+
+type File struct {
+	Name        string  `json:"name"`
+	Size        int64   `json:"size"`
+	Downloaded  int64   `json:"downloaded"`
+	Progress    float64 `json:"progress"`
+	Speed       int64   `json:"speed"`
+	Downloading bool    `json:"downloading"`
+	Repairs     int     `json:"repairs"`
+	Error       string  `json:"error"`
+}
+
+type Category struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	SavePath string `json:"save_path"`
+}
+
+// Account is the Real-Debrid account of qbrdt
+type Account struct {
+	Username    string    `json:"username"`
+	Type        string    `json:"type"`
+	Premium     bool      `json:"premium"`
+	Expiration  time.Time `json:"expiration"`
+	PremiumLeft int64     `json:"premium_left"`
+	Points      int       `json:"points"`
+	Expiring    bool      `json:"expiring"`
+	// Traffic left on the limited hosts, by host
+	Traffic   map[string]Traffic `json:"traffic"`
+	CheckedAt time.Time          `json:"checked_at"`
+}
+
+type Traffic struct {
+	// Bytes or links left for the period
+	Left  int64 `json:"left"`
+	Bytes int64 `json:"bytes"`
+	Links int   `json:"links"`
+	Limit int64 `json:"limit"`
+	// "links", "gigabytes" or "bytes"
+	Type  string `json:"type"`
+	Reset string `json:"reset"`
+}
+
+// Types of the events
+const (
+	TorrentAdded   = "torrent.added"
+	TorrentChanged = "torrent.changed"
+	TorrentRemoved = "torrent.removed"
+
+	DownloadStarted  = "download.started"
+	DownloadProgress = "download.progress"
+	DownloadFinished = "download.finished"
+	DownloadFailed   = "download.failed"
+
+	AccountExpiring = "account.expiring"
+)
+
+// Event is something that happened to a torrent or to one of its files
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Hash string    `json:"hash"`
+	Name string    `json:"name"`
+	// TorrentData, DownloadData or AccountData depending on Type
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// TorrentData is the state of a torrent after a torrent event
+type TorrentData struct {
+	Category       string  `json:"category"`
+	RDStatus       string  `json:"rd_status"`
+	InternalStatus string  `json:"internal_status"`
+	CloudProgress  float64 `json:"cloud_progress"`
+	Paused         bool    `json:"paused"`
+}
+
+// DownloadData is the progress of a file after a download event
+type DownloadData struct {
+	File       string  `json:"file"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Speed      int64   `json:"speed"`
+	Error      string  `json:"error"`
+	Progress   float64 `json:"progress"`
+}
+
+// AccountData is the end of the premium after an account event, Name is the username
+type AccountData struct {
+	Expiration time.Time `json:"expiration"`
+	DaysLeft   int       `json:"days_left"`
+}
+
+func (e Event) Torrent() (TorrentData, error) {
+	var data TorrentData
+	err := json.Unmarshal(e.Data, &data)
+	return data, err
+}
+
+func (e Event) Download() (DownloadData, error) {
+	var data DownloadData
+	err := json.Unmarshal(e.Data, &data)
+	return data, err
+}
+
+func (e Event) Account() (AccountData, error) {
+	var data AccountData
+	err := json.Unmarshal(e.Data, &data)
+	return data, err
+}
@@ -0,0 +1,38 @@
+package realdebrid
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// InstantAvailability reports for each hash if Real-Debrid has the torrent in
+// its cache, such torrents are downloaded instantly. Real-Debrid has turned the
+// endpoint off, it fails with ErrDisabledEndpoint there.
+func (c *Client) InstantAvailability(hashes ...string) (map[string]bool, error) {
+	req, err := c.newRequest(http.MethodGet, "/torrents/instantAvailability/"+strings.Join(hashes, "/"), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// {"hash": {"rd": [{"1": {"filename": ..., "filesize": ...}}]}}, or {"hash": []} when not cached
+	var answer map[string]json.RawMessage
+	if err := c.do(req, &answer); err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		available[hash] = false
+	}
+
+	for hash, raw := range answer {
+		var hosts map[string][]json.RawMessage
+		if json.Unmarshal(raw, &hosts) != nil {
+			continue
+		}
+		available[strings.ToLower(hash)] = len(hosts["rd"]) > 0
+	}
+
+	return available, nil
+}
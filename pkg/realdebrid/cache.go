@@ -0,0 +1,147 @@
+package realdebrid
+
+import (
+	"sync"
+	"time"
+)
+
+// Torrents asked per page when listing the whole account
+const listPageSize = 1000
+
+// Cache keeps the answers of Real-Debrid for a short time, so that the callers
+// share them instead of sending the same requests. Concurrent callers wait for
+// the request in progress.
+type Cache struct {
+	client *Client
+	ttl    time.Duration
+	// held while the account is listed
+	listing sync.Mutex
+
+	// guards the cached answers, never held during a request
+	lock   sync.Mutex
+	list   []Torrent
+	listed time.Time
+	infos  map[string]*cachedTorrent
+}
+
+type cachedTorrent struct {
+	// held while the torrent is requested
+	fetching sync.Mutex
+	torrent  *Torrent
+	fetched  time.Time
+}
+
+func NewCache(client *Client, ttl time.Duration) *Cache {
+	return &Cache{
+		client: client,
+		ttl:    ttl,
+		infos:  make(map[string]*cachedTorrent),
+	}
+}
+
+// Torrents returns all the torrents of the account, without their files.
+// The slice is shared and must not be modified.
+func (c *Cache) Torrents() ([]Torrent, error) {
+	c.listing.Lock()
+	defer c.listing.Unlock()
+
+	c.lock.Lock()
+	if time.Since(c.listed) < c.ttl {
+		defer c.lock.Unlock()
+		return c.list, nil
+	}
+	c.lock.Unlock()
+
+	var list []Torrent
+	for page := 1; ; page++ {
+		torrents, err := c.client.GetTorrents(&TorrentOptions{Page: page, Limit: listPageSize})
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, torrents...)
+
+		if len(torrents) < listPageSize {
+			break
+		}
+	}
+
+	c.lock.Lock()
+	c.list = list
+	c.listed = time.Now()
+	// the list is fresher than the old torrents
+	for id, entry := range c.infos {
+		if time.Since(entry.fetched) > c.ttl {
+			delete(c.infos, id)
+		}
+	}
+	c.lock.Unlock()
+
+	return list, nil
+}
+
+// Torrent returns a torrent with its files
+func (c *Cache) Torrent(id string) (*Torrent, error) {
+	c.lock.Lock()
+	entry, ok := c.infos[id]
+	if !ok {
+		entry = &cachedTorrent{}
+		c.infos[id] = entry
+	}
+	c.lock.Unlock()
+
+	entry.fetching.Lock()
+	defer entry.fetching.Unlock()
+
+	c.lock.Lock()
+	if entry.torrent != nil && time.Since(entry.fetched) < c.ttl {
+		defer c.lock.Unlock()
+		return entry.torrent, nil
+	}
+	c.lock.Unlock()
+
+	torrent, err := c.client.GetTorrent(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	entry.torrent = torrent
+	entry.fetched = time.Now()
+	c.lock.Unlock()
+
+	return torrent, nil
+}
+
+// Lookup returns the last known state of a torrent without calling Real-Debrid
+func (c *Cache) Lookup(id string) (Torrent, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.infos[id]
+	if ok && entry.torrent != nil && entry.fetched.After(c.listed) {
+		return *entry.torrent, true
+	}
+
+	for _, torrent := range c.list {
+		if torrent.ID == id {
+			return torrent, true
+		}
+	}
+
+	if ok && entry.torrent != nil {
+		return *entry.torrent, true
+	}
+
+	return Torrent{}, false
+}
+
+// Invalidate is called once torrent id was changed on Real-Debrid, the next
+// calls ask Real-Debrid again
+func (c *Cache) Invalidate(id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.infos, id)
+	c.listed = time.Time{}
+}
@@ -0,0 +1,141 @@
+package realdebrid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DefaultBaseUrl = "https://api.real-debrid.com/rest/1.0"
+
+var (
+	ErrUnauthorized = errors.New("realdebrid: unauthorized")
+	ErrForbidden    = errors.New("realdebrid: forbidden")
+	ErrNotFound     = errors.New("realdebrid: not found")
+	ErrRateLimited  = errors.New("realdebrid: too many requests")
+	ErrServer       = errors.New("realdebrid: server error")
+	// Real-Debrid could not be reached or did not answer in time
+	ErrNetwork = errors.New("realdebrid: network error")
+	// the account has too many active torrents to add another one
+	ErrActiveLimit = errors.New("realdebrid: too many active torrents")
+	// Real-Debrid turned the endpoint off, like /torrents/instantAvailability
+	ErrDisabledEndpoint = errors.New("realdebrid: disabled endpoint")
+)
+
+// Real-Debrid answers 509 when the active torrents limit of the account is reached
+const statusActiveLimit = 509
+
+// Error is returned when Real-Debrid answers with an error status code
+type Error struct {
+	StatusCode int
+	// Real-Debrid error code and message, empty if the body was not an error document
+	Code    int    `json:"error_code"`
+	Message string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("realdebrid: %s (code %d, HTTP %d)", e.Message, e.Code, e.StatusCode)
+	}
+	return fmt.Sprintf("realdebrid: HTTP %d", e.StatusCode)
+}
+
+// Is allows errors.Is(err, ErrNotFound) and friends
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError && e.StatusCode != statusActiveLimit
+	case ErrActiveLimit:
+		return e.StatusCode == statusActiveLimit
+	case ErrDisabledEndpoint:
+		return e.StatusCode == http.StatusForbidden && e.Code == errorCodeDisabledEndpoint
+	}
+	return false
+}
+
+type Client struct {
+	token   string
+	baseUrl string
+	client  *http.Client
+	// nil when requests are not limited
+	limiter *limiter
+}
+
+// NewClient returns a client for the Real-Debrid API at baseUrl, DefaultBaseUrl if empty.
+// Requests are limited to DefaultRateLimit per minute, see SetRateLimit.
+func NewClient(token, baseUrl string) *Client {
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	return &Client{
+		token:   token,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		limiter: newLimiter(DefaultRateLimit),
+	}
+}
+
+// SetRateLimit changes the requests sent per minute, 0 or less to not limit them.
+// It must be called before the client is used.
+func (c *Client) SetRateLimit(perMinute int) {
+	if perMinute <= 0 {
+		c.limiter = nil
+		return
+	}
+	c.limiter = newLimiter(perMinute)
+}
+
+func (c *Client) newRequest(method, path, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request, v interface{}) error {
+	if c.limiter != nil {
+		c.limiter.wait()
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNetwork, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		rdErr := &Error{}
+		json.NewDecoder(resp.Body).Decode(rdErr)
+		rdErr.StatusCode = resp.StatusCode
+		return rdErr
+	}
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,72 @@
+package realdebrid
+
+import "errors"
+
+// Real-Debrid error_code answered with a 403 when selecting the files of a torrent twice
+const errorCodeActionAlreadyDone = 19
+
+// Real-Debrid error_code answered with a 403 by the endpoints it turned off
+const errorCodeDisabledEndpoint = 37
+
+// ErrorClass tells how an error of the client should be handled
+type ErrorClass int
+
+const (
+	ClassNone ErrorClass = iota
+	ClassNotFound
+	// the token is invalid, expired or the account is not premium
+	ClassAuth
+	ClassRateLimit
+	ClassServer
+	ClassNetwork
+	// any other error, the request should not be sent again as is
+	ClassOther
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassNone:
+		return "none"
+	case ClassNotFound:
+		return "not found"
+	case ClassAuth:
+		return "auth"
+	case ClassRateLimit:
+		return "rate limit"
+	case ClassServer:
+		return "server"
+	case ClassNetwork:
+		return "network"
+	}
+	return "other"
+}
+
+// Transient reports if the same request may succeed later
+func (c ErrorClass) Transient() bool {
+	return c == ClassRateLimit || c == ClassServer || c == ClassNetwork
+}
+
+// Classify returns the class of an error returned by the client
+func Classify(err error) ErrorClass {
+	switch {
+	case err == nil:
+		return ClassNone
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrUnauthorized):
+		return ClassAuth
+	case errors.Is(err, ErrForbidden):
+		var rdErr *Error
+		if errors.As(err, &rdErr) && (rdErr.Code == errorCodeActionAlreadyDone || rdErr.Code == errorCodeDisabledEndpoint) {
+			return ClassOther
+		}
+		return ClassAuth
+	case errors.Is(err, ErrRateLimited):
+		return ClassRateLimit
+	case errors.Is(err, ErrServer):
+		return ClassServer
+	case errors.Is(err, ErrNetwork):
+		return ClassNetwork
+	}
+	return ClassOther
+}
@@ -0,0 +1,48 @@
+package realdebrid
+
+import (
+	"sync"
+	"time"
+)
+
+// Requests per minute sent by default, Real-Debrid allows 250
+const DefaultRateLimit = 240
+
+// Requests sent without waiting after the client was idle
+const rateLimitBurst = 10
+
+// limiter is a token bucket spacing the requests of a client. Callers reserve
+// their token in turn, so they are served in order.
+type limiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	return &limiter{
+		interval: time.Minute / time.Duration(perMinute),
+		tokens:   rateLimitBurst,
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a request can be sent
+func (l *limiter) wait() {
+	l.lock.Lock()
+
+	now := time.Now()
+	l.tokens = min(rateLimitBurst, l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	l.last = now
+	l.tokens--
+
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens * float64(l.interval))
+	}
+
+	l.lock.Unlock()
+
+	time.Sleep(delay)
+}
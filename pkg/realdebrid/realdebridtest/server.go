@@ -0,0 +1,821 @@
+// Package realdebridtest provides a fake Real-Debrid API to test the clients
+// of the real one without an account.
+package realdebridtest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TOomaAh/qbrdt/pkg/bencode"
+	"github.com/TOomaAh/qbrdt/pkg/metainfo"
+	"github.com/TOomaAh/qbrdt/pkg/realdebrid"
+)
+
+// Step is a status a torrent goes through once its files are selected
+type Step struct {
+	Status   string
+	Progress float64
+}
+
+// DefaultProgression is used by torrents when Server.Progression is empty
+var DefaultProgression = []Step{
+	{Status: "queued", Progress: 0},
+	{Status: "downloading", Progress: 50},
+	{Status: "downloaded", Progress: 100},
+}
+
+// File is a file of a torrent built with NewTorrent
+type File struct {
+	// Path inside the torrent, "/" separated
+	Path    string
+	Content []byte
+}
+
+type torrent struct {
+	info  realdebrid.Torrent
+	files []File
+	// steps left, one is applied on each info or list request once the files are selected
+	steps    []Step
+	selected bool
+}
+
+// Server is a fake Real-Debrid API. The API is served under ApiUrl() and the
+// unrestricted files are served with range support by the same server.
+type Server struct {
+	*httptest.Server
+
+	// Token expected in the Authorization header, anything is accepted if empty
+	Token string
+	// Status progression of the torrents added from now on
+	Progression []Step
+	// The file host ignores Range headers and always answers the whole file
+	DisableRanges bool
+	// Returned by /user, change it with SetUser once the server is used
+	User realdebrid.User
+	// Returned by /traffic
+	Traffic map[string]realdebrid.Traffic
+	// instantAvailability answers like Real-Debrid since it turned it off
+	DisableInstantAvailability bool
+
+	lock     sync.Mutex
+	contents map[string][]File
+	// torrent names by info hash, given to magnets once converted
+	names    map[string]string
+	torrents map[string]*torrent
+	order    []string
+	nextId   int
+	// status codes of the next API answers, see Fail
+	failures []int
+	// requests received per route pattern
+	requests map[string]int
+	// info hashes in the Real-Debrid cache
+	cached map[string]bool
+	// info hashes refused when added, see Refuse
+	refused map[string]bool
+	// file transfers left to hang, see StallFiles
+	stalls int
+	// Range headers of the file transfers, see Ranges
+	ranges []string
+	// files of the fake hoster, see HostFile
+	hosted []File
+}
+
+// NewServer starts a fake Real-Debrid server, Close it once done
+func NewServer() *Server {
+	s := &Server{
+		User: realdebrid.User{
+			Id:         1,
+			Username:   "qbrdt",
+			Email:      "qbrdt@example.com",
+			Type:       realdebrid.UserTypePremium,
+			Premium:    30 * 24 * 3600,
+			Expiration: time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339),
+		},
+		Traffic: map[string]realdebrid.Traffic{
+			"rapidgator.net": {Left: 50 << 30, Limit: 50 << 30, Type: "gigabytes", Reset: "daily"},
+		},
+		contents: make(map[string][]File),
+		names:    make(map[string]string),
+		torrents: make(map[string]*torrent),
+		requests: make(map[string]int),
+		cached:   make(map[string]bool),
+		refused:  make(map[string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /rest/1.0/torrents", s.auth(s.list))
+	mux.HandleFunc("GET /rest/1.0/torrents/info/{id}", s.auth(s.info))
+	mux.HandleFunc("PUT /rest/1.0/torrents/addTorrent", s.auth(s.addTorrent))
+	mux.HandleFunc("POST /rest/1.0/torrents/addMagnet", s.auth(s.addMagnet))
+	mux.HandleFunc("GET /rest/1.0/torrents/instantAvailability/{hashes...}", s.auth(s.instantAvailability))
+	mux.HandleFunc("POST /rest/1.0/torrents/selectFiles/{id}", s.auth(s.selectFiles))
+	mux.HandleFunc("DELETE /rest/1.0/torrents/delete/{id}", s.auth(s.delete))
+	mux.HandleFunc("POST /rest/1.0/unrestrict/link", s.auth(s.unrestrict))
+	mux.HandleFunc("GET /rest/1.0/user", s.auth(s.user))
+	mux.HandleFunc("GET /rest/1.0/traffic", s.auth(s.traffic))
+	mux.HandleFunc("GET /d/{id}/{file}/{name}", s.serveFile)
+	mux.HandleFunc("GET /h/{file}/{name}", s.serveHosted)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		s.lock.Lock()
+		s.requests[pattern]++
+		s.lock.Unlock()
+
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// Requests returns how many requests matched a route pattern of the server,
+// like "GET /rest/1.0/torrents/info/{id}"
+func (s *Server) Requests(pattern string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.requests[pattern]
+}
+
+// ApiUrl is the base URL to give to realdebrid.NewClient
+func (s *Server) ApiUrl() string {
+	return s.URL + "/rest/1.0"
+}
+
+// NewTorrent builds a .torrent file holding files and remembers their content,
+// so that the torrent can be added and downloaded from the server
+func (s *Server) NewTorrent(name string, pieceLength int64, files ...File) []byte {
+	var data []byte
+	for _, f := range files {
+		data = append(data, f.Content...)
+	}
+
+	var pieces []byte
+	for start := int64(0); start < int64(len(data)); start += pieceLength {
+		end := min(start+pieceLength, int64(len(data)))
+		sum := sha1.Sum(data[start:end])
+		pieces = append(pieces, sum[:]...)
+	}
+
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+
+	if len(files) == 1 && files[0].Path == name {
+		info["length"] = int64(len(files[0].Content))
+	} else {
+		list := make([]interface{}, len(files))
+		for i, f := range files {
+			list[i] = map[string]interface{}{
+				"length": int64(len(f.Content)),
+				"path":   strings.Split(f.Path, "/"),
+			}
+		}
+		info["files"] = list
+	}
+
+	torrentFile, err := bencode.Encode(map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info":     info,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		panic(err)
+	}
+
+	s.lock.Lock()
+	s.contents[meta.InfoHash] = files
+	s.names[meta.InfoHash] = name
+	s.lock.Unlock()
+
+	return torrentFile
+}
+
+// Magnet returns the magnet link of a .torrent file
+func Magnet(torrentFile []byte) string {
+	meta, err := metainfo.Parse(torrentFile)
+	if err != nil {
+		panic(err)
+	}
+	return "magnet:?xt=urn:btih:" + meta.InfoHash + "&dn=" + url.QueryEscape(meta.Name)
+}
+
+// Torrent returns the torrent as /torrents/info would, without advancing its status
+func (s *Server) Torrent(id string) (realdebrid.Torrent, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t, ok := s.torrents[id]
+	if !ok {
+		return realdebrid.Torrent{}, false
+	}
+	return t.info, true
+}
+
+// Torrents returns the ids of the torrents on the account, oldest first
+func (s *Server) Torrents() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.order...)
+}
+
+// SetStatus forces the status of a torrent, its remaining progression is dropped
+func (s *Server) SetStatus(id, status string, progress float64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t, ok := s.torrents[id]
+	if !ok {
+		return
+	}
+	t.steps = nil
+	s.apply(t, Step{Status: status, Progress: progress})
+}
+
+// Fail makes the next count API requests fail with status, like during a
+// Real-Debrid outage. The files are still served.
+func (s *Server) Fail(status, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Refuse makes Real-Debrid refuse the torrent of hash as an infringing file
+func (s *Server) Refuse(hash string) {
+	s.lock.Lock()
+	s.refused[hash] = true
+	s.lock.Unlock()
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		failure := 0
+		if len(s.failures) > 0 {
+			failure, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.lock.Unlock()
+
+		switch {
+		case failure == http.StatusTooManyRequests:
+			writeError(w, failure, "too_many_requests", 34)
+			return
+		case failure != 0:
+			writeError(w, failure, "service_unavailable", 25)
+			return
+		}
+
+		if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+			writeError(w, http.StatusUnauthorized, "bad_token", 8)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	filter := r.URL.Query().Get("filter")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	torrents := []realdebrid.Torrent{}
+	// newest first, like Real-Debrid
+	for i := len(s.order) - 1; i >= 0; i-- {
+		t := s.torrents[s.order[i]]
+		s.advance(t)
+		if filter == string(realdebrid.TorrentFilterActive) && !active(t.info.Status) {
+			continue
+		}
+		info := t.info
+		info.Files = nil
+		torrents = append(torrents, info)
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(torrents)))
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	offset = max(offset, 0)
+	if page, _ := strconv.Atoi(r.URL.Query().Get("page")); page > 1 && limit > 0 {
+		offset = (page - 1) * limit
+	}
+
+	if offset >= len(torrents) && offset > 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	torrents = torrents[offset:]
+
+	if limit > 0 && len(torrents) > limit {
+		torrents = torrents[:limit]
+	}
+
+	writeJSON(w, http.StatusOK, torrents)
+}
+
+func (s *Server) info(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t, ok := s.torrents[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_ressource", 7)
+		return
+	}
+
+	s.advance(t)
+
+	writeJSON(w, http.StatusOK, t.info)
+}
+
+func (s *Server) addTorrent(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parameter_missing", 1)
+		return
+	}
+
+	meta, err := metainfo.Parse(data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parameter_value_not_allowed", 30)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refused[meta.InfoHash] {
+		writeError(w, http.StatusUnavailableForLegalReasons, "infringing_file", 35)
+		return
+	}
+
+	files, ok := s.contents[meta.InfoHash]
+	if !ok {
+		// unknown torrent, its files are served zero filled
+		for _, f := range meta.Files {
+			files = append(files, File{Path: strings.Join(f.Path, "/"), Content: make([]byte, f.Length)})
+		}
+	}
+
+	t := s.add(meta.InfoHash, meta.Name, files)
+	s.apply(t, Step{Status: "waiting_files_selection"})
+
+	writeJSON(w, http.StatusCreated, realdebrid.AddTorrent{Id: t.info.ID, Uri: s.ApiUrl() + "/torrents/info/" + t.info.ID})
+}
+
+func (s *Server) addMagnet(w http.ResponseWriter, r *http.Request) {
+	magnet, err := metainfo.ParseMagnet(r.FormValue("magnet"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "parameter_value_not_allowed", 30)
+		return
+	}
+
+	hash := magnet.InfoHash
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.refused[hash] {
+		writeError(w, http.StatusUnavailableForLegalReasons, "infringing_file", 35)
+		return
+	}
+
+	// a magnet nobody knows stays in conversion forever
+	t := s.add(hash, hash, s.contents[hash])
+	s.apply(t, Step{Status: "magnet_conversion"})
+
+	writeJSON(w, http.StatusCreated, realdebrid.AddTorrent{Id: t.info.ID, Uri: s.ApiUrl() + "/torrents/info/" + t.info.ID})
+}
+
+func (s *Server) selectFiles(w http.ResponseWriter, r *http.Request) {
+	selection := r.FormValue("files")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t, ok := s.torrents[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_ressource", 7)
+		return
+	}
+
+	if t.info.Status != "waiting_files_selection" {
+		writeError(w, http.StatusForbidden, "action_already_done", 19)
+		return
+	}
+
+	if selection == "" {
+		writeError(w, http.StatusBadRequest, "parameter_missing", 1)
+		return
+	}
+
+	for i := range t.info.Files {
+		if selection == "all" {
+			t.info.Files[i].Selected = 1
+		}
+	}
+
+	if selection != "all" {
+		for _, id := range strings.Split(selection, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil || n < 1 || n > len(t.info.Files) {
+				writeError(w, http.StatusBadRequest, "parameter_value_not_allowed", 30)
+				return
+			}
+			t.info.Files[n-1].Selected = 1
+		}
+	}
+
+	t.selected = true
+	if s.cached[strings.ToLower(t.info.Hash)] {
+		// nothing to download
+		t.steps = nil
+		s.apply(t, Step{Status: "downloaded", Progress: 100})
+	} else if len(t.steps) > 0 {
+		step := t.steps[0]
+		t.steps = t.steps[1:]
+		s.apply(t, step)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.torrents[id]; !ok {
+		writeError(w, http.StatusNotFound, "unknown_ressource", 7)
+		return
+	}
+
+	delete(s.torrents, id)
+	for i, o := range s.order {
+		if o == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) unrestrict(w http.ResponseWriter, r *http.Request) {
+	link := r.FormValue("link")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if ref, found := strings.CutPrefix(link, s.URL+"/hoster/"); found {
+		s.unrestrictHosted(w, ref)
+		return
+	}
+
+	ref, found := strings.CutPrefix(link, s.URL+"/link/")
+	t, file, ok := s.lookupLink(ref)
+	if !found || !ok {
+		writeError(w, http.StatusServiceUnavailable, "unavailable_file", 24)
+		return
+	}
+
+	name := path.Base(t.files[file].Path)
+	writeJSON(w, http.StatusOK, realdebrid.Link{
+		ID:         fmt.Sprintf("%s%d", t.info.ID, file+1),
+		Filename:   name,
+		MimeType:   "application/octet-stream",
+		Link:       link,
+		Host:       "real-debrid.com",
+		Chunks:     16,
+		FileSize:   int64(len(t.files[file].Content)),
+		Download:   fmt.Sprintf("%s/d/%s/%d/%s", s.URL, t.info.ID, file+1, url.PathEscape(name)),
+		Streamable: 1,
+	})
+}
+
+// SetCached puts a torrent in the Real-Debrid cache, instantAvailability reports it
+// and it is downloaded as soon as its files are selected
+func (s *Server) SetCached(hash string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.cached[strings.ToLower(hash)] = true
+}
+
+func (s *Server) instantAvailability(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.DisableInstantAvailability {
+		writeError(w, http.StatusForbidden, "disabled_endpoint", 37)
+		return
+	}
+
+	answer := make(map[string]interface{})
+	for _, hash := range strings.Split(r.PathValue("hashes"), "/") {
+		hash = strings.ToLower(hash)
+		if !s.cached[hash] {
+			answer[hash] = []interface{}{}
+			continue
+		}
+
+		variant := make(map[string]interface{})
+		for i, f := range s.contents[hash] {
+			variant[strconv.Itoa(i+1)] = map[string]interface{}{"filename": path.Base(f.Path), "filesize": len(f.Content)}
+		}
+		answer[hash] = map[string]interface{}{"rd": []interface{}{variant}}
+	}
+
+	writeJSON(w, http.StatusOK, answer)
+}
+
+// SetUser changes the account returned by /user
+func (s *Server) SetUser(user realdebrid.User) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.User = user
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, s.User)
+}
+
+func (s *Server) traffic(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJSON(w, http.StatusOK, s.Traffic)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	t, file, ok := s.lookupLink(r.PathValue("id") + "/" + r.PathValue("file"))
+	var content []byte
+	if ok {
+		content = t.files[file].Content
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.serveContent(w, r, content)
+}
+
+// HostFile puts a file on a fake hoster and returns its link, Real-Debrid unrestricts it
+// like a 1fichier or Uptobox link
+func (s *Server) HostFile(file File) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.hosted = append(s.hosted, file)
+	return fmt.Sprintf("%s/hoster/%d", s.URL, len(s.hosted))
+}
+
+// unrestrictHosted answers the unrestriction of a hoster link, the lock must be held
+func (s *Server) unrestrictHosted(w http.ResponseWriter, ref string) {
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 1 || n > len(s.hosted) {
+		writeError(w, http.StatusServiceUnavailable, "unavailable_file", 24)
+		return
+	}
+
+	file := s.hosted[n-1]
+	name := path.Base(file.Path)
+	writeJSON(w, http.StatusOK, realdebrid.Link{
+		ID:         fmt.Sprintf("HOSTED%d", n),
+		Filename:   name,
+		MimeType:   "application/octet-stream",
+		Link:       fmt.Sprintf("%s/hoster/%d", s.URL, n),
+		Host:       "1fichier.com",
+		Chunks:     16,
+		FileSize:   int64(len(file.Content)),
+		Download:   fmt.Sprintf("%s/h/%d/%s", s.URL, n, url.PathEscape(name)),
+		Streamable: 1,
+	})
+}
+
+func (s *Server) serveHosted(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.Atoi(r.PathValue("file"))
+
+	s.lock.Lock()
+	ok := err == nil && n >= 1 && n <= len(s.hosted)
+	var content []byte
+	if ok {
+		content = s.hosted[n-1].Content
+	}
+	s.lock.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.serveContent(w, r, content)
+}
+
+// serveContent sends a file with range support, unless DisableRanges is set
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, content []byte) {
+	s.lock.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	s.lock.Unlock()
+
+	if s.DisableRanges {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+		return
+	}
+
+	http.ServeContent(w, r, r.PathValue("name"), time.Time{}, &stallingReader{
+		Reader: bytes.NewReader(content),
+		server: s,
+		done:   r.Context().Done(),
+	})
+}
+
+// StallFiles makes the next count file transfers reaching the middle of a file
+// hang until the client goes away, like a host dropping data without closing
+func (s *Server) StallFiles(count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stalls += count
+}
+
+// Ranges returns the Range header of each file transfer so far, empty for a whole file
+func (s *Server) Ranges() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string(nil), s.ranges...)
+}
+
+// stallingReader serves content up to its middle and then hangs if the server has stalls left
+type stallingReader struct {
+	*bytes.Reader
+	server *Server
+	done   <-chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	middle := r.Size() / 2
+	pos := r.Size() - int64(r.Len())
+
+	if pos >= middle {
+		r.server.lock.Lock()
+		stall := r.server.stalls > 0
+		if stall {
+			r.server.stalls--
+		}
+		r.server.lock.Unlock()
+
+		if stall {
+			<-r.done
+			return 0, io.ErrUnexpectedEOF
+		}
+	} else if int64(len(p)) > middle-pos {
+		p = p[:middle-pos]
+	}
+
+	return r.Reader.Read(p)
+}
+
+// lookupLink finds the file of a "<torrent id>/<file id>" link, the lock must be held
+func (s *Server) lookupLink(link string) (*torrent, int, bool) {
+	id, file, found := strings.Cut(link, "/")
+	if !found {
+		return nil, 0, false
+	}
+
+	t, ok := s.torrents[id]
+	if !ok || t.info.Status != "downloaded" {
+		return nil, 0, false
+	}
+
+	n, err := strconv.Atoi(file)
+	if err != nil || n < 1 || n > len(t.files) {
+		return nil, 0, false
+	}
+
+	return t, n - 1, true
+}
+
+// add registers a new torrent, the lock must be held
+func (s *Server) add(hash, name string, files []File) *torrent {
+	s.nextId++
+	id := fmt.Sprintf("FAKE%06d", s.nextId)
+
+	steps := s.Progression
+	if len(steps) == 0 {
+		steps = DefaultProgression
+	}
+
+	t := &torrent{
+		info: realdebrid.Torrent{
+			ID:       id,
+			Filename: name,
+			Hash:     hash,
+			Host:     "real-debrid.com",
+			Split:    2000,
+			Added:    time.Now().UTC(),
+			Links:    []string{},
+		},
+		files: files,
+		steps: append([]Step(nil), steps...),
+	}
+
+	for i, f := range files {
+		t.info.Bytes += len(f.Content)
+		t.info.Files = append(t.info.Files, realdebrid.TorrentFile{
+			ID:    i + 1,
+			Path:  "/" + f.Path,
+			Bytes: int64(len(f.Content)),
+		})
+	}
+
+	s.torrents[id] = t
+	s.order = append(s.order, id)
+	return t
+}
+
+// advance applies the next step of a torrent, the lock must be held
+func (s *Server) advance(t *torrent) {
+	if t.info.Status == "magnet_conversion" && t.files != nil {
+		t.info.Filename = s.names[t.info.Hash]
+		s.apply(t, Step{Status: "waiting_files_selection"})
+	} else if t.selected && len(t.steps) > 0 {
+		step := t.steps[0]
+		t.steps = t.steps[1:]
+		s.apply(t, step)
+	}
+}
+
+// apply moves a torrent to a new status, the lock must be held
+func (s *Server) apply(t *torrent, step Step) {
+	t.info.Status = step.Status
+	t.info.Progress = step.Progress
+
+	speed, seeders := 0, 0
+	if step.Status == "downloading" {
+		speed, seeders = 10*1024*1024, 5
+	}
+	t.info.Speed = &speed
+	t.info.Seeders = &seeders
+
+	t.info.Links = []string{}
+	if step.Status == "downloaded" {
+		now := time.Now().UTC()
+		t.info.Ended = &now
+		for _, f := range t.info.Files {
+			if f.Selected == 1 {
+				t.info.Links = append(t.info.Links, fmt.Sprintf("%s/link/%s/%d", s.URL, t.info.ID, f.ID))
+			}
+		}
+	}
+}
+
+func active(status string) bool {
+	switch status {
+	case "downloaded", "error", "dead", "magnet_error", "virus":
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string, code int) {
+	writeJSON(w, status, map[string]interface{}{"error": message, "error_code": code})
+}
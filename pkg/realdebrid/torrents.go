@@ -0,0 +1,169 @@
+package realdebrid
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Torrent struct {
+	ID       string        `json:"id"`
+	Filename string        `json:"filename"`
+	Hash     string        `json:"hash"`
+	Bytes    int           `json:"bytes"`
+	Host     string        `json:"host"`
+	Split    int           `json:"split"`
+	Progress float64       `json:"progress"`
+	Status   string        `json:"status"`
+	Added    time.Time     `json:"added"`
+	Files    []TorrentFile `json:"files,omitempty"`
+	Links    []string      `json:"links"`
+	Ended    *time.Time    `json:"ended,omitempty"`
+	Speed    *int          `json:"speed,omitempty"`
+	Seeders  *int          `json:"seeders,omitempty"`
+}
+
+type TorrentFile struct {
+	ID       int    `json:"id"`
+	Path     string `json:"path"`
+	Bytes    int64  `json:"bytes"`
+	Selected int    `json:"selected"`
+}
+
+type TorrentFilter string
+
+const (
+	TorrentFilterActive TorrentFilter = "active"
+)
+
+type TorrentOptions struct {
+	Offset int
+	Page   int
+	Limit  int
+	Filter TorrentFilter
+}
+
+type AddTorrent struct {
+	Id  string `json:"id"`
+	Uri string `json:"uri"`
+}
+
+// GetTorrents returns the torrents of the account
+func (c *Client) GetTorrents(options *TorrentOptions) ([]Torrent, error) {
+	q := url.Values{}
+
+	if options != nil {
+		if options.Offset != 0 {
+			q.Set("offset", strconv.Itoa(options.Offset))
+		}
+		if options.Page != 0 {
+			q.Set("page", strconv.Itoa(options.Page))
+		}
+		if options.Limit != 0 {
+			q.Set("limit", strconv.Itoa(options.Limit))
+		}
+		if options.Filter != "" {
+			q.Set("filter", string(options.Filter))
+		}
+	}
+
+	path := "/torrents"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	req, err := c.newRequest(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var torrents []Torrent
+	if err := c.do(req, &torrents); err != nil {
+		return nil, err
+	}
+
+	return torrents, nil
+}
+
+// GetTorrent returns all the information on a torrent
+func (c *Client) GetTorrent(id string) (*Torrent, error) {
+	req, err := c.newRequest(http.MethodGet, "/torrents/info/"+url.PathEscape(id), "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var torrent Torrent
+	if err := c.do(req, &torrent); err != nil {
+		return nil, err
+	}
+
+	return &torrent, nil
+}
+
+// AddTorrent uploads a .torrent file
+func (c *Client) AddTorrent(file io.Reader) (*AddTorrent, error) {
+	req, err := c.newRequest(http.MethodPut, "/torrents/addTorrent", "application/x-bittorrent", file)
+	if err != nil {
+		return nil, err
+	}
+
+	var add AddTorrent
+	if err := c.do(req, &add); err != nil {
+		return nil, err
+	}
+
+	return &add, nil
+}
+
+// AddMagnet adds a magnet link
+func (c *Client) AddMagnet(magnet string) (*AddTorrent, error) {
+	body := url.Values{}
+	body.Set("magnet", magnet)
+
+	req, err := c.newRequest(http.MethodPost, "/torrents/addMagnet", "application/x-www-form-urlencoded", strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	var add AddTorrent
+	if err := c.do(req, &add); err != nil {
+		return nil, err
+	}
+
+	return &add, nil
+}
+
+// SelectFiles starts the torrent with the given file ids, all of them if none are given
+func (c *Client) SelectFiles(id string, files ...int) error {
+	selection := "all"
+	if len(files) > 0 {
+		ids := make([]string, len(files))
+		for i, f := range files {
+			ids[i] = strconv.Itoa(f)
+		}
+		selection = strings.Join(ids, ",")
+	}
+
+	body := url.Values{}
+	body.Set("files", selection)
+
+	req, err := c.newRequest(http.MethodPost, "/torrents/selectFiles/"+url.PathEscape(id), "application/x-www-form-urlencoded", strings.NewReader(body.Encode()))
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
+
+// DeleteTorrent removes a torrent from the account
+func (c *Client) DeleteTorrent(id string) error {
+	req, err := c.newRequest(http.MethodDelete, "/torrents/delete/"+url.PathEscape(id), "", nil)
+	if err != nil {
+		return err
+	}
+
+	return c.do(req, nil)
+}
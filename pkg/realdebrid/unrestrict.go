@@ -0,0 +1,40 @@
+package realdebrid
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Link struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	Link     string `json:"link"`
+	Host     string `json:"host"`
+	Download string `json:"download"`
+
+	Chunks   int64 `json:"chunks"`
+	Crc      int64 `json:"crc"`
+	FileSize int64 `json:"filesize"`
+
+	Streamable int `json:"streamable"`
+}
+
+// Unrestrict returns the direct download link of a hoster or torrent link
+func (c *Client) Unrestrict(link string) (*Link, error) {
+	body := url.Values{}
+	body.Set("link", link)
+
+	req, err := c.newRequest(http.MethodPost, "/unrestrict/link", "application/x-www-form-urlencoded", strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	var l Link
+	if err := c.do(req, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
@@ -0,0 +1,72 @@
+package realdebrid
+
+import "net/http"
+
+type UserType string
+
+const (
+	UserTypeFree    UserType = "free"
+	UserTypePremium UserType = "premium"
+)
+
+type User struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	// Fidelity points
+	Points int    `json:"points"`
+	Locale string `json:"locale"`
+	Avatar string `json:"avatar"`
+	// "premium" or "free"
+	Type UserType `json:"type"`
+	// Seconds left as a premium user
+	Premium    int    `json:"premium"`
+	Expiration string `json:"expiration"`
+}
+
+// GetUser returns the account the token belongs to
+func (c *Client) GetUser() (*User, error) {
+	req, err := c.newRequest(http.MethodGet, "/user", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := c.do(req, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+type Traffic struct {
+	// Bytes or links left for the period
+	Left int64 `json:"left"`
+	// Bytes downloaded in the period
+	Bytes int64 `json:"bytes"`
+	// Links unrestricted in the period
+	Links int `json:"links"`
+	// Limit of the host, in bytes or links depending on Type
+	Limit int64 `json:"limit"`
+	// "links", "gigabytes" or "bytes"
+	Type string `json:"type"`
+	// Additional traffic bought
+	Extra int64 `json:"extra"`
+	// "daily", "weekly" or "monthly"
+	Reset string `json:"reset"`
+}
+
+// GetTraffic returns the traffic left on the limited hosts, by host
+func (c *Client) GetTraffic() (map[string]Traffic, error) {
+	req, err := c.newRequest(http.MethodGet, "/traffic", "", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	traffic := make(map[string]Traffic)
+	if err := c.do(req, &traffic); err != nil {
+		return nil, err
+	}
+
+	return traffic, nil
+}